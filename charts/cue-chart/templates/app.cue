@@ -0,0 +1,46 @@
+package chart
+
+_labels: {
+	"app.kubernetes.io/name":       chart.name
+	"app.kubernetes.io/instance":   release.name
+	"app.kubernetes.io/version":    chart.version
+	"app.kubernetes.io/managed-by": "Helm"
+}
+
+_selector: "app.kubernetes.io/name": chart.name
+
+_metadata: {
+	name:      release.name
+	namespace: release.namespace
+	labels:    _labels
+}
+
+manifests: deployment: {
+	apiVersion: "apps/v1"
+	kind:       "Deployment"
+	metadata:   _metadata
+	spec: {
+		replicas: values.replicaCount
+		selector: matchLabels: _selector
+		template: {
+			metadata: labels: _selector
+			spec: containers: [{
+				name:            chart.name
+				image:           "\(values.image.repository):\(values.image.tag)"
+				imagePullPolicy: values.image.pullPolicy
+				ports: [{containerPort: 80, protocol: "TCP"}]
+			}]
+		}
+	}
+}
+
+manifests: service: {
+	apiVersion: "v1"
+	kind:       "Service"
+	metadata:   _metadata
+	spec: {
+		type: values.service.type
+		ports: [{port: values.service.port, targetPort: 80, protocol: "TCP", name: "http"}]
+		selector: _selector
+	}
+}
@@ -0,0 +1,16 @@
+package chart
+
+// #Values is the schema for values.yaml. Fields with defaults are optional
+// in values.yaml; any field not listed here is rejected.
+#Values: {
+	replicaCount: int & >=0 & <=20 | *1
+	image: {
+		repository: string & !=""
+		tag:        string | *"latest"
+		pullPolicy: "Always" | *"IfNotPresent" | "Never"
+	}
+	service: {
+		type: *"ClusterIP" | "NodePort" | "LoadBalancer"
+		port: int & >0 & <65536 | *80
+	}
+}
@@ -0,0 +1,16 @@
+// Helpers shared by the chart's templates.
+{
+  // labels returns the labels common to every object of the release.
+  labels(chart):: {
+    'app.kubernetes.io/name': chart.name,
+    'app.kubernetes.io/version': chart.version,
+    'app.kubernetes.io/managed-by': 'Helm',
+  },
+
+  // metadata returns object metadata for a release-scoped object.
+  metadata(release, chart, suffix=''):: {
+    name: release.name + suffix,
+    namespace: release.namespace,
+    labels: $.labels(chart),
+  },
+}
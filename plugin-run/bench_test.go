@@ -0,0 +1,415 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"text/tabwriter"
+	"time"
+
+	"github.com/tetratelabs/wazero"
+	"sigs.k8s.io/yaml"
+
+	"github.com/scottrigby/ref-hip-chart-defined-plugins/plugin-run/host"
+)
+
+var toolchain = flag.String("toolchain", string(host.ToolchainGo), "Toolchain building the plugins benchmarked: go or tinygo")
+
+// largeChartCopies is how many times each template is repeated to make the
+// large variant of an example chart.
+const largeChartCopies = 100
+
+// renderCase is a plugin and one of the charts using it, in its small
+// (as-is) and large (templates repeated largeChartCopies times) variants.
+type renderCase struct {
+	plugin *host.Plugin
+	chart  string
+	small  *host.InputMessageRenderV1
+	large  *host.InputMessageRenderV1
+}
+
+// renderCases returns a case for every plugin of every example chart, with
+// plugins built by tc. Each plugin gets the chart's templates directly rather
+// than the templates modified by the plugins before it, but it does get the
+// files they rendered and the values they passed on.
+func renderCases(tb testing.TB, tc host.Toolchain) []renderCase {
+	tb.Helper()
+	pluginsDir := buildPlugins(tb, tc)
+	ctx := context.Background()
+	cache := wazero.NewCompilationCache()
+	defer cache.Close(ctx)
+	runtime := &host.Runtime{CompilationCache: cache, HostConfig: testHostConfig(tb)}
+
+	charts, err := filepath.Glob(filepath.Join("..", "charts", "*", "Chart.yaml"))
+	if err != nil {
+		tb.Fatal(err)
+	}
+	var cases []renderCase
+	for _, chartFile := range charts {
+		chart, err := host.LoadChart(filepath.Dir(chartFile))
+		if err != nil {
+			tb.Fatal(err)
+		}
+		stages, err := host.ChartStages(chart, pluginsDir)
+		if err != nil {
+			tb.Fatal(err)
+		}
+		large := scaleChart(chart, largeChartCopies)
+		for i, stage := range stages {
+			config, err := stage.Plugin.ResolveConfig(stage.ChartConfig)
+			if err != nil {
+				tb.Fatal(err)
+			}
+			c := renderCase{plugin: stage.Plugin, chart: chart.Metadata.Name}
+			opts := host.RenderOptions{ReleaseName: "my-release"}
+			if c.small, err = host.NewInput(chart, opts, config, host.Patterns(config)); err != nil {
+				tb.Fatal(err)
+			}
+			if c.large, err = host.NewInput(large, opts, config, host.Patterns(config)); err != nil {
+				tb.Fatal(err)
+			}
+			if i > 0 {
+				setRenderedBefore(tb, runtime, c.small, chart, stages[:i], opts)
+				setRenderedBefore(tb, runtime, c.large, large, stages[:i], opts)
+			}
+			cases = append(cases, c)
+		}
+	}
+	return cases
+}
+
+// setRenderedBefore sets the renderedFiles of input to the files rendered by
+// running stages on c, and its values to the values they passed on, if any.
+func setRenderedBefore(tb testing.TB, runtime *host.Runtime, input *host.InputMessageRenderV1, c *host.Chart, stages []host.Stage, opts host.RenderOptions) {
+	tb.Helper()
+	res, err := runtime.RunPipeline(context.Background(), c, stages, opts)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	input.RenderedFiles = res.RenderedFiles
+	if res.Values != nil {
+		input.Values = res.Values
+	}
+}
+
+// scaleChart returns a copy of c with each template, except partials and
+// Jsonnet libraries, repeated n times under distinct names.
+func scaleChart(c *host.Chart, n int) *host.Chart {
+	scaled := *c
+	scaled.Templates = nil
+	for _, f := range c.Templates {
+		dir, base := filepath.Split(f.Name)
+		if strings.HasPrefix(base, "_") || strings.HasSuffix(base, ".libsonnet") {
+			scaled.Templates = append(scaled.Templates, f)
+			continue
+		}
+		for i := 0; i < n; i++ {
+			scaled.Templates = append(scaled.Templates, host.SourceFile{
+				Name: fmt.Sprintf("%scopy%03d-%s", dir, i, base),
+				Data: f.Data,
+			})
+		}
+	}
+	return &scaled
+}
+
+// plugins returns the distinct plugins of cases, by name.
+func plugins(cases []renderCase) []*host.Plugin {
+	seen := map[string]*host.Plugin{}
+	for _, c := range cases {
+		seen[c.plugin.Metadata.Name] = c.plugin
+	}
+	list := make([]*host.Plugin, 0, len(seen))
+	for _, p := range seen {
+		list = append(list, p)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Metadata.Name < list[j].Metadata.Name })
+	return list
+}
+
+// benchCompile compiles p b.N times. A nil cache compiles from scratch every
+// time, as Helm does without $HELM_CACHE_HOME/wazero-build.
+func benchCompile(b *testing.B, p *host.Plugin, cache wazero.CompilationCache) {
+	ctx := context.Background()
+	runtime := &host.Runtime{CompilationCache: cache}
+	b.ReportMetric(float64(len(p.Wasm)), "wasm-bytes")
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		compiled, err := runtime.Compile(ctx, p)
+		if err != nil {
+			b.Fatal(err)
+		}
+		compiled.Close(ctx)
+	}
+}
+
+// warmCache returns a compilation cache already holding p.
+func warmCache(b *testing.B, p *host.Plugin) wazero.CompilationCache {
+	ctx := context.Background()
+	cache := wazero.NewCompilationCache()
+	b.Cleanup(func() { cache.Close(ctx) })
+	compiled, err := (&host.Runtime{CompilationCache: cache}).Compile(ctx, p)
+	if err != nil {
+		b.Fatal(err)
+	}
+	compiled.Close(ctx)
+	return cache
+}
+
+// benchRender renders input with an already compiled plugin b.N times, which
+// includes instantiating the module for every render as Helm does.
+func benchRender(b *testing.B, p *host.Plugin, input *host.InputMessageRenderV1) {
+	ctx := context.Background()
+	compiled, err := (&host.Runtime{HostConfig: testHostConfig(b)}).Compile(ctx, p)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer compiled.Close(ctx)
+
+	b.ReportMetric(float64(len(input.SourceFiles)), "files")
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		res, err := compiled.Render(ctx, input)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if err := res.Err(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkCompile(b *testing.B) {
+	for _, p := range plugins(renderCases(b, host.Toolchain(*toolchain))) {
+		b.Run(p.Metadata.Name+"/cold", func(b *testing.B) {
+			benchCompile(b, p, nil)
+		})
+		b.Run(p.Metadata.Name+"/warm", func(b *testing.B) {
+			benchCompile(b, p, warmCache(b, p))
+		})
+	}
+}
+
+func BenchmarkRender(b *testing.B) {
+	for _, c := range renderCases(b, host.Toolchain(*toolchain)) {
+		b.Run(c.plugin.Metadata.Name+"/"+c.chart+"/small", func(b *testing.B) {
+			benchRender(b, c.plugin, c.small)
+		})
+		b.Run(c.plugin.Metadata.Name+"/"+c.chart+"/large", func(b *testing.B) {
+			benchRender(b, c.plugin, c.large)
+		})
+	}
+}
+
+// budgetFile is testdata/budgets.yaml. Every plugin must have an entry in
+// each map, so that a new plugin cannot go unbudgeted.
+type budgetFile struct {
+	// WasmSize is the largest plugin.wasm allowed, in bytes, by toolchain
+	// and plugin. Toolchains without budgets are only reported.
+	WasmSize map[host.Toolchain]map[string]int64 `json:"wasmSize"`
+	// ColdCompile is the longest a compile without a cache may take, by
+	// plugin.
+	ColdCompile map[string]duration `json:"coldCompile"`
+	// RenderLarge is the longest a render of the large variant of any chart
+	// may take, by plugin.
+	RenderLarge map[string]duration `json:"renderLarge"`
+	// MinWarmSpeedup is how many times faster than a cold compile a compile
+	// from a warm cache must be.
+	MinWarmSpeedup float64 `json:"minWarmSpeedup"`
+}
+
+// duration is a time.Duration written as a string, e.g. "250ms".
+type duration time.Duration
+
+func (d *duration) UnmarshalJSON(data []byte) error {
+	s := strings.Trim(string(data), `"`)
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = duration(v)
+	return nil
+}
+
+func readBudgets(t *testing.T) *budgetFile {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", "budgets.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var budgets budgetFile
+	if err := yaml.UnmarshalStrict(data, &budgets); err != nil {
+		t.Fatalf("testdata/budgets.yaml: %v", err)
+	}
+	return &budgets
+}
+
+// TestSizeBudgets prints the plugin.wasm size of every plugin built with each
+// available toolchain and fails when a size exceeds its budget in
+// testdata/budgets.yaml. It only runs with -budgets, as it builds every
+// plugin; see 'make bench-budgets'.
+func TestSizeBudgets(t *testing.T) {
+	if !*budgets {
+		t.Skip("run with -budgets")
+	}
+	budgets := readBudgets(t)
+
+	toolchains := []host.Toolchain{host.ToolchainGo, host.ToolchainTinyGo}
+	sizes := map[host.Toolchain]map[string]int64{}
+	for _, tc := range toolchains {
+		if !tc.Available() {
+			t.Logf("%s not found, skipping its builds", tc)
+			continue
+		}
+		sizes[tc] = wasmSizes(t, tc, budgets.WasmSize[tc] != nil)
+	}
+
+	var names []string
+	for name := range sizes[host.ToolchainGo] {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var report strings.Builder
+	w := tabwriter.NewWriter(&report, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintf(w, "plugin\t")
+	for _, tc := range toolchains {
+		fmt.Fprintf(w, "%s\tbudget\t", tc)
+	}
+	fmt.Fprintln(w)
+	for _, name := range names {
+		fmt.Fprintf(w, "%s\t", name)
+		for _, tc := range toolchains {
+			fmt.Fprintf(w, "%s\t%s\t", formatSize(sizes[tc], name), formatSize(budgets.WasmSize[tc], name))
+		}
+		fmt.Fprintln(w)
+	}
+	w.Flush()
+	t.Logf("plugin.wasm sizes:\n%s", report.String())
+
+	for tc, limits := range budgets.WasmSize {
+		for name, size := range sizes[tc] {
+			limit, ok := limits[name]
+			switch {
+			case !ok:
+				t.Errorf("no %s wasmSize budget for %s", tc, name)
+			case size > limit:
+				t.Errorf("%s build of %s is %d bytes, budget is %d", tc, name, size, limit)
+			}
+		}
+	}
+}
+
+// wasmSizes returns the plugin.wasm size of every plugin built with tc, by
+// plugin. A plugin that fails to build is a test failure if the toolchain is
+// budgeted, and is left out of the report otherwise.
+func wasmSizes(t *testing.T, tc host.Toolchain, budgeted bool) map[string]int64 {
+	t.Helper()
+	sizes := map[string]int64{}
+	if budgeted {
+		dir := buildPlugins(t, tc)
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, entry := range entries {
+			info, err := os.Stat(filepath.Join(dir, entry.Name(), "plugin.wasm"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			sizes[entry.Name()] = info.Size()
+		}
+		return sizes
+	}
+
+	src := filepath.Join("..", "plugins")
+	entries, err := os.ReadDir(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dst := t.TempDir()
+	for _, entry := range entries {
+		if _, err := os.Stat(filepath.Join(src, entry.Name(), "go.mod")); err != nil {
+			continue
+		}
+		out := filepath.Join(dst, entry.Name())
+		if err := host.BuildPluginWith(tc, filepath.Join(src, entry.Name()), out); err != nil {
+			t.Logf("%s build of %s failed: %v", tc, entry.Name(), err)
+			continue
+		}
+		info, err := os.Stat(filepath.Join(out, "plugin.wasm"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		sizes[entry.Name()] = info.Size()
+	}
+	return sizes
+}
+
+// TestTimingBudgets fails when a plugin's cold compile time, warm cache
+// speedup or large-chart render time exceeds its budget in
+// testdata/budgets.yaml. It only runs with -timings, as timings depend on the
+// machine; see 'make bench-timings'.
+func TestTimingBudgets(t *testing.T) {
+	if !*timings {
+		t.Skip("run with -timings")
+	}
+	budgets := readBudgets(t)
+
+	cases := renderCases(t, host.Toolchain(*toolchain))
+	for _, p := range plugins(cases) {
+		name := p.Metadata.Name
+		t.Run("compile/"+name, func(t *testing.T) {
+			limit, ok := budgets.ColdCompile[name]
+			if !ok {
+				t.Fatalf("no coldCompile budget for %s", name)
+			}
+			cold := testing.Benchmark(func(b *testing.B) { benchCompile(b, p, nil) })
+			warm := testing.Benchmark(func(b *testing.B) { benchCompile(b, p, warmCache(b, p)) })
+			if cold.N == 0 || warm.N == 0 {
+				t.Fatalf("compile failed; run 'go test -bench BenchmarkCompile/%s' for the error", name)
+			}
+			speedup := float64(cold.NsPerOp()) / float64(warm.NsPerOp())
+			t.Logf("cold %v, warm %v (%.1fx)", time.Duration(cold.NsPerOp()), time.Duration(warm.NsPerOp()), speedup)
+			if got := time.Duration(cold.NsPerOp()); got > time.Duration(limit) {
+				t.Errorf("cold compile takes %v, budget is %v", got, time.Duration(limit))
+			}
+			if speedup < budgets.MinWarmSpeedup {
+				t.Errorf("warm compile is %.1fx faster than cold, budget is at least %.1fx", speedup, budgets.MinWarmSpeedup)
+			}
+		})
+	}
+
+	for _, c := range cases {
+		c := c
+		name := c.plugin.Metadata.Name
+		t.Run("render/"+name+"/"+c.chart, func(t *testing.T) {
+			limit, ok := budgets.RenderLarge[name]
+			if !ok {
+				t.Fatalf("no renderLarge budget for %s", name)
+			}
+			res := testing.Benchmark(func(b *testing.B) { benchRender(b, c.plugin, c.large) })
+			if res.N == 0 {
+				t.Fatalf("render failed; run 'go test -bench BenchmarkRender/%s/%s/large' for the error", name, c.chart)
+			}
+			got := time.Duration(res.NsPerOp())
+			t.Logf("%d files in %v", len(c.large.SourceFiles), got)
+			if got > time.Duration(limit) {
+				t.Errorf("rendering the large chart takes %v, budget is %v", got, time.Duration(limit))
+			}
+		})
+	}
+}
+
+func formatSize(sizes map[string]int64, name string) string {
+	size, ok := sizes[name]
+	if !ok {
+		return "-"
+	}
+	return fmt.Sprintf("%.2f MiB", float64(size)/(1<<20))
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/scottrigby/ref-hip-chart-defined-plugins/plugin-run/conformance"
+	"github.com/scottrigby/ref-hip-chart-defined-plugins/plugin-run/host"
+)
+
+// runConformance implements "plugin-run conformance", returning the exit
+// code.
+func runConformance(args []string) int {
+	fs := flag.NewFlagSet("conformance", flag.ExitOnError)
+	chartDir := fs.String("chart", "", "Sample chart to render with each plugin")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: plugin-run conformance [-chart DIR] PLUGIN...\n\n")
+		fmt.Fprintf(os.Stderr, "Run the render/v1 conformance checks against each PLUGIN, a plugin\n")
+		fmt.Fprintf(os.Stderr, "directory holding plugin.yaml and plugin.wasm, or a bare .wasm file.\n\nFlags:\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+
+	var opts conformance.Options
+	if *chartDir != "" {
+		chart, err := host.LoadChart(*chartDir)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: failed to load chart %s: %v\n", *chartDir, err)
+			return 1
+		}
+		opts.Chart = chart
+	}
+
+	code := 0
+	for i, target := range fs.Args() {
+		if i > 0 {
+			fmt.Println()
+		}
+		var p *host.Plugin
+		var err error
+		if strings.HasSuffix(target, ".wasm") {
+			p, err = host.LoadWasm(target)
+		} else {
+			p, err = host.LoadPlugin(target)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			code = 1
+			continue
+		}
+
+		report, err := conformance.Run(context.Background(), p, opts)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			code = 1
+			continue
+		}
+		report.Write(os.Stdout)
+		if !report.Passed() {
+			code = 1
+		}
+	}
+	return code
+}
@@ -0,0 +1,79 @@
+// Package configschema checks plugin config against the configSchema a plugin
+// declares in its plugin.yaml. plugin-run uses it to validate a chart's
+// config before invoking a plugin, and plugin-validate to check every plugin
+// and chart in the repository.
+package configschema
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+
+	"github.com/santhosh-tekuri/jsonschema/v6"
+)
+
+// Compile compiles a JSON Schema document declared in file.
+func Compile(file string, doc map[string]interface{}) (*jsonschema.Schema, error) {
+	inst, err := toJSONValue(doc)
+	if err != nil {
+		return nil, err
+	}
+	abs, err := filepath.Abs(file)
+	if err != nil {
+		return nil, err
+	}
+	url := "file://" + filepath.ToSlash(abs)
+	c := jsonschema.NewCompiler()
+	if err := c.AddResource(url, inst); err != nil {
+		return nil, err
+	}
+	return c.Compile(url)
+}
+
+// Validate validates config against schema and returns one message per
+// violation, each prefixed with the JSON pointer of the offending value. A
+// nil config is validated as an empty object.
+func Validate(schema *jsonschema.Schema, config map[string]interface{}) []string {
+	if config == nil {
+		config = map[string]interface{}{}
+	}
+	inst, err := toJSONValue(config)
+	if err != nil {
+		return []string{": " + err.Error()}
+	}
+
+	err = schema.Validate(inst)
+	if err == nil {
+		return nil
+	}
+	var verr *jsonschema.ValidationError
+	if !errors.As(err, &verr) {
+		return []string{": " + err.Error()}
+	}
+
+	var msgs []string
+	for _, unit := range verr.BasicOutput().Errors {
+		if unit.Error == nil || len(unit.Errors) > 0 {
+			continue
+		}
+		msgs = append(msgs, fmt.Sprintf("%s: %s", unit.InstanceLocation, unit.Error))
+	}
+	if len(msgs) == 0 {
+		msgs = append(msgs, ": "+verr.Error())
+	}
+	sort.Strings(msgs)
+	return msgs
+}
+
+// toJSONValue converts a decoded YAML value into the representation expected
+// by the jsonschema package.
+func toJSONValue(v interface{}) (interface{}, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return jsonschema.UnmarshalJSON(bytes.NewReader(data))
+}
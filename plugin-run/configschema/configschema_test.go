@@ -0,0 +1,61 @@
+package configschema
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	schema, err := Compile("plugin.yaml", map[string]interface{}{
+		"type":                 "object",
+		"additionalProperties": false,
+		"required":             []interface{}{"patterns"},
+		"properties": map[string]interface{}{
+			"patterns": map[string]interface{}{
+				"type":  "array",
+				"items": map[string]interface{}{"type": "string"},
+			},
+			"count": map[string]interface{}{"type": "integer", "minimum": 1},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		config map[string]interface{}
+		want   []string
+	}{
+		{
+			name:   "valid",
+			config: map[string]interface{}{"patterns": []interface{}{"templates/*.yaml"}, "count": 2},
+		},
+		{
+			name: "nil config is an empty object",
+			want: []string{": missing property 'patterns'"},
+		},
+		{
+			name:   "pointers to nested values",
+			config: map[string]interface{}{"patterns": []interface{}{"a", true}, "count": 0},
+			want: []string{
+				"/count: minimum: got 0, want 1",
+				"/patterns/1: got boolean, want string",
+			},
+		},
+		{
+			name:   "additional property",
+			config: map[string]interface{}{"patterns": []interface{}{}, "other": 1},
+			want:   []string{": additional properties 'other' not allowed"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Validate(schema, tt.config)
+			if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+				t.Errorf("Validate() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,446 @@
+// Package conformance checks that a render/v1 plugin follows the protocol
+// Helm relies on, whatever language or template engine it implements:
+//
+//   - it always writes a valid JSON output message, even when it fails;
+//   - it returns a non-zero exit code exactly when it reports errors;
+//   - it only names rendered and modified files under templates/;
+//   - it accepts base64-encoded binary data in SourceFile.Data;
+//   - it ignores input fields it does not know, and its output does not
+//     depend on anything but its input.
+//
+// Run checks a plugin and returns a Report; TestPlugin runs the same checks
+// as subtests so a plugin's own Go tests can include them.
+package conformance
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/scottrigby/ref-hip-chart-defined-plugins/plugin-run/host"
+)
+
+// Check is a single conformance check.
+type Check struct {
+	Name        string
+	Description string
+	Run         func(ctx context.Context, t *Target) error
+}
+
+// Target is a compiled plugin under test.
+type Target struct {
+	Plugin   *host.Plugin
+	Compiled *host.CompiledPlugin
+	// Config is the plugin's own config, passed as input config.
+	Config map[string]interface{}
+	// Chart, if set, is rendered by the sample-chart check.
+	Chart *host.Chart
+}
+
+// Checks is the standard battery run against every plugin.
+var Checks = []Check{
+	{
+		Name:        "empty-source-files",
+		Description: "renders an input without source files successfully",
+		Run:         checkEmptySourceFiles,
+	},
+	{
+		Name:        "empty-input",
+		Description: "fails with errors, not a trap, when given no input",
+		Run:         failsOn([]byte{}),
+	},
+	{
+		Name:        "malformed-json",
+		Description: "fails with errors when the input is not JSON",
+		Run:         failsOn([]byte(`{"release": {"name": `)),
+	},
+	{
+		Name:        "wrong-types",
+		Description: "fails with errors when input fields have the wrong type",
+		Run:         failsOn([]byte(`{"sourceFiles": {"name": 42}}`)),
+	},
+	{
+		Name:        "binary-source-data",
+		Description: "accepts base64-encoded binary data in a source file",
+		Run:         checkBinarySourceData,
+	},
+	{
+		Name:        "unknown-fields",
+		Description: "ignores input fields it does not know",
+		Run:         checkUnknownFields,
+	},
+	{
+		Name:        "deterministic",
+		Description: "writes the same output for the same input",
+		Run:         checkDeterministic,
+	},
+	{
+		Name:        "sample-chart",
+		Description: "renders the sample chart following the protocol",
+		Run:         checkSampleChart,
+	},
+}
+
+// errSkipped is returned by checks that do not apply to a plugin.
+var errSkipped = errors.New("skipped")
+
+// skip reports a check as not applicable, with a reason.
+func skip(format string, args ...interface{}) error {
+	return fmt.Errorf("%w: "+format, append([]interface{}{errSkipped}, args...)...)
+}
+
+// Result is the outcome of one check.
+type Result struct {
+	Check Check
+	// Err is nil if the check passed.
+	Err error
+}
+
+// Skipped reports whether the check did not apply to the plugin.
+func (r Result) Skipped() bool { return errors.Is(r.Err, errSkipped) }
+
+// Report is the outcome of running Checks against a plugin.
+type Report struct {
+	Plugin  string
+	Version string
+	Results []Result
+}
+
+// Passed reports whether no check failed.
+func (r *Report) Passed() bool {
+	for _, res := range r.Results {
+		if res.Err != nil && !res.Skipped() {
+			return false
+		}
+	}
+	return true
+}
+
+// Write prints the report, one line per check.
+func (r *Report) Write(w io.Writer) {
+	name := r.Plugin
+	if r.Version != "" {
+		name += " " + r.Version
+	}
+	fmt.Fprintf(w, "Conformance: %s\n", name)
+
+	var passed, failed, skipped int
+	for _, res := range r.Results {
+		switch {
+		case res.Err == nil:
+			passed++
+			fmt.Fprintf(w, "  PASS  %-20s %s\n", res.Check.Name, res.Check.Description)
+		case res.Skipped():
+			skipped++
+			fmt.Fprintf(w, "  SKIP  %-20s %v\n", res.Check.Name, res.Err)
+		default:
+			failed++
+			fmt.Fprintf(w, "  FAIL  %-20s %v\n", res.Check.Name, res.Err)
+		}
+	}
+	fmt.Fprintf(w, "%d passed, %d failed, %d skipped\n", passed, failed, skipped)
+}
+
+// Options configure a conformance run.
+type Options struct {
+	Runtime *host.Runtime
+	// Chart, if set, is rendered by the sample-chart check with the plugin's
+	// config for it.
+	Chart *host.Chart
+}
+
+// Run compiles p and runs Checks against it.
+func Run(ctx context.Context, p *host.Plugin, opts Options) (*Report, error) {
+	target, err := newTarget(ctx, p, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer target.Compiled.Close(ctx)
+
+	report := &Report{Plugin: p.Metadata.Name, Version: p.Metadata.Version}
+	for _, check := range Checks {
+		report.Results = append(report.Results, Result{Check: check, Err: check.Run(ctx, target)})
+	}
+	return report, nil
+}
+
+// TestPlugin runs Checks against the plugin in dir (holding plugin.yaml and
+// plugin.wasm) as subtests of t.
+func TestPlugin(t *testing.T, dir string) {
+	t.Helper()
+	p, err := host.LoadPlugin(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	target, err := newTarget(ctx, p, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer target.Compiled.Close(ctx)
+
+	for _, check := range Checks {
+		t.Run(check.Name, func(t *testing.T) {
+			err := check.Run(ctx, target)
+			switch {
+			case err == nil:
+			case errors.Is(err, errSkipped):
+				t.Skip(err)
+			default:
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func newTarget(ctx context.Context, p *host.Plugin, opts Options) (*Target, error) {
+	runtime := opts.Runtime
+	if runtime == nil {
+		runtime = &host.Runtime{}
+	}
+	config, err := p.ResolveConfig(nil)
+	if err != nil {
+		return nil, err
+	}
+	compiled, err := runtime.Compile(ctx, p)
+	if err != nil {
+		return nil, err
+	}
+	return &Target{Plugin: p, Compiled: compiled, Config: config, Chart: opts.Chart}, nil
+}
+
+// invoke runs the plugin on raw input and checks the protocol rules every
+// output must follow.
+func (t *Target) invoke(ctx context.Context, input []byte) (*host.Result, error) {
+	res, err := t.Compiled.Invoke(ctx, input)
+	if err != nil {
+		return nil, fmt.Errorf("plugin did not complete: %w", err)
+	}
+	if err := checkOutput(res); err != nil {
+		return res, err
+	}
+	return res, nil
+}
+
+// checkOutput verifies the rules every output message must follow.
+func checkOutput(res *host.Result) error {
+	if !json.Valid(res.Raw) {
+		return fmt.Errorf("output is not valid JSON: %q", truncate(res.Raw))
+	}
+	if res.Output == nil {
+		return fmt.Errorf("output is not an output message: %v", res.DecodeError)
+	}
+
+	out := res.Output
+	switch {
+	case res.ExitCode != 0 && len(out.Errors) == 0:
+		return fmt.Errorf("exit code %d without errors in the output", res.ExitCode)
+	case res.ExitCode == 0 && len(out.Errors) > 0:
+		return fmt.Errorf("exit code 0 with errors in the output: %s", strings.Join(out.Errors, "; "))
+	}
+
+	for name := range out.RenderedFiles {
+		if err := checkName(name); err != nil {
+			return fmt.Errorf("renderedFiles: %w", err)
+		}
+	}
+	for _, f := range out.ModifiedSourceFiles {
+		if err := checkName(f.Name); err != nil {
+			return fmt.Errorf("modifiedSourceFiles: %w", err)
+		}
+	}
+	for _, name := range out.RemovedRenderedFiles {
+		if err := checkName(name); err != nil {
+			return fmt.Errorf("removedRenderedFiles: %w", err)
+		}
+		if _, ok := out.RenderedFiles[name]; ok {
+			return fmt.Errorf("removedRenderedFiles: %s is also rendered; render it alone to replace it", name)
+		}
+	}
+	return nil
+}
+
+// checkName verifies a file name is a clean relative path under templates/.
+func checkName(name string) error {
+	if !strings.HasPrefix(name, "templates/") || path.Clean(name) != name {
+		return fmt.Errorf("file name %q is not a clean path under templates/", name)
+	}
+	return nil
+}
+
+// succeeds runs input and requires the plugin to report success.
+func (t *Target) succeeds(ctx context.Context, input []byte) (*host.Result, error) {
+	res, err := t.invoke(ctx, input)
+	if err != nil {
+		return nil, err
+	}
+	if res.ExitCode != 0 {
+		return nil, fmt.Errorf("plugin failed: %s", strings.Join(res.Output.Errors, "; "))
+	}
+	return res, nil
+}
+
+func failsOn(input []byte) func(context.Context, *Target) error {
+	return func(ctx context.Context, t *Target) error {
+		res, err := t.invoke(ctx, input)
+		if err != nil {
+			return err
+		}
+		if res.ExitCode == 0 {
+			return errors.New("plugin reported success")
+		}
+		return nil
+	}
+}
+
+func checkEmptySourceFiles(ctx context.Context, t *Target) error {
+	_, err := t.succeeds(ctx, t.input(nil))
+	return err
+}
+
+func checkBinarySourceData(ctx context.Context, t *Target) error {
+	name, err := t.sampleName()
+	if err != nil {
+		return err
+	}
+	data := make([]byte, 256)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	// The plugin may reject the content, but must do so per the protocol.
+	_, err = t.invoke(ctx, t.input([]host.SourceFile{{Name: name, Data: data}}))
+	return err
+}
+
+func checkUnknownFields(ctx context.Context, t *Target) error {
+	var input map[string]interface{}
+	if err := json.Unmarshal(t.input(nil), &input); err != nil {
+		return err
+	}
+	input["conformanceUnknownField"] = map[string]interface{}{"nested": []interface{}{1, "two"}}
+	input["release"].(map[string]interface{})["conformanceUnknownField"] = true
+	data, err := json.Marshal(input)
+	if err != nil {
+		return err
+	}
+	_, err = t.succeeds(ctx, data)
+	return err
+}
+
+func checkDeterministic(ctx context.Context, t *Target) error {
+	var files []host.SourceFile
+	if name, err := t.sampleName(); err == nil {
+		files = append(files, host.SourceFile{Name: name, Data: []byte("# conformance\n")})
+	}
+	input := t.input(files)
+
+	first, err := t.invoke(ctx, input)
+	if err != nil {
+		return err
+	}
+	second, err := t.invoke(ctx, input)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(first.Raw, second.Raw) || first.ExitCode != second.ExitCode {
+		return errors.New("two runs with the same input wrote different output")
+	}
+	return nil
+}
+
+func checkSampleChart(ctx context.Context, t *Target) error {
+	if t.Chart == nil {
+		return skip("no sample chart given")
+	}
+	var chartConfig map[string]interface{}
+	if spec := t.Chart.PluginSpec(t.Plugin.Metadata.Name); spec != nil {
+		chartConfig = spec.Config
+	}
+	config, err := t.Plugin.ResolveConfig(chartConfig)
+	if err != nil {
+		return err
+	}
+	input, err := host.NewInput(t.Chart, host.RenderOptions{ReleaseName: "conformance"}, config, host.Patterns(config))
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(input)
+	if err != nil {
+		return err
+	}
+	res, err := t.succeeds(ctx, data)
+	if err != nil {
+		return err
+	}
+	if len(input.SourceFiles) > 0 && len(res.Output.RenderedFiles) == 0 && len(res.Output.ModifiedSourceFiles) == 0 {
+		return errors.New("plugin received source files but produced nothing")
+	}
+	return nil
+}
+
+// input returns a well-formed input message with the given source files.
+func (t *Target) input(files []host.SourceFile) []byte {
+	chart := &host.Chart{
+		Metadata:  host.ChartMetadata{Name: "conformance", Version: "0.1.0"},
+		Values:    map[string]interface{}{},
+		Templates: files,
+	}
+	in, err := host.NewInput(chart, host.RenderOptions{ReleaseName: "conformance"}, t.Config, nil)
+	if err != nil {
+		panic(err)
+	}
+	in.SourceFiles = files
+	if in.SourceFiles == nil {
+		in.SourceFiles = []host.SourceFile{}
+	}
+	in.Files = []host.SourceFile{}
+	data, err := json.Marshal(in)
+	if err != nil {
+		panic(err)
+	}
+	return data
+}
+
+// sampleName returns a template name matching the plugin's first pattern.
+func (t *Target) sampleName() (string, error) {
+	patterns := host.Patterns(t.Config)
+	if len(patterns) == 0 {
+		return "templates/conformance.yaml", nil
+	}
+	name := sampleFromPattern(patterns[0])
+	matched, err := host.MatchFiles(patterns[:1], []host.SourceFile{{Name: name}})
+	if err != nil || len(matched) == 0 {
+		return "", skip("cannot derive a file name from pattern %q", patterns[0])
+	}
+	return name, nil
+}
+
+// sampleFromPattern turns a glob pattern into a name it is likely to match.
+func sampleFromPattern(pattern string) string {
+	r := strings.NewReplacer("**/", "", "**", "conformance", "*", "conformance", "?", "x")
+	name := r.Replace(pattern)
+	// Take the first alternative of {a,b} groups.
+	for {
+		open := strings.IndexByte(name, '{')
+		end := strings.IndexByte(name, '}')
+		if open < 0 || end < open {
+			return name
+		}
+		alt, _, _ := strings.Cut(name[open+1:end], ",")
+		name = name[:open] + alt + name[end+1:]
+	}
+}
+
+func truncate(b []byte) string {
+	const max = 200
+	if len(b) > max {
+		return string(b[:max]) + "..."
+	}
+	return string(b)
+}
@@ -0,0 +1,142 @@
+package conformance
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/scottrigby/ref-hip-chart-defined-plugins/plugin-run/host"
+)
+
+func TestCheckOutput(t *testing.T) {
+	tests := []struct {
+		name     string
+		raw      string
+		exitCode uint32
+		wantErr  string
+	}{
+		{
+			name: "success",
+			raw:  `{"renderedFiles": {"templates/a.yaml": "kind: ConfigMap"}}`,
+		},
+		{
+			name:     "failure with errors",
+			raw:      `{"renderedFiles": {}, "errors": ["boom"]}`,
+			exitCode: 1,
+		},
+		{
+			name:    "invalid JSON",
+			raw:     `{"renderedFiles": `,
+			wantErr: "not valid JSON",
+		},
+		{
+			name:    "trailing data",
+			raw:     `{} {}`,
+			wantErr: "not valid JSON",
+		},
+		{
+			name:     "non-zero exit without errors",
+			raw:      `{"renderedFiles": {}}`,
+			exitCode: 1,
+			wantErr:  "exit code 1 without errors",
+		},
+		{
+			name:    "errors with zero exit",
+			raw:     `{"errors": ["boom"]}`,
+			wantErr: "exit code 0 with errors",
+		},
+		{
+			name:    "rendered file outside templates",
+			raw:     `{"renderedFiles": {"summary.yaml": ""}}`,
+			wantErr: `renderedFiles: file name "summary.yaml"`,
+		},
+		{
+			name:    "rendered file escaping templates",
+			raw:     `{"renderedFiles": {"templates/../x.yaml": ""}}`,
+			wantErr: "not a clean path",
+		},
+		{
+			name:    "modified source file outside templates",
+			raw:     `{"modifiedSourceFiles": [{"name": "/etc/passwd", "data": ""}]}`,
+			wantErr: "modifiedSourceFiles",
+		},
+		{
+			name: "removed rendered file",
+			raw:  `{"renderedFiles": {"templates/a.yaml": ""}, "removedRenderedFiles": ["templates/b.yaml"]}`,
+		},
+		{
+			name:    "removed rendered file outside templates",
+			raw:     `{"removedRenderedFiles": ["b.yaml"]}`,
+			wantErr: "removedRenderedFiles",
+		},
+		{
+			name:    "rendered file also removed",
+			raw:     `{"renderedFiles": {"templates/a.yaml": ""}, "removedRenderedFiles": ["templates/a.yaml"]}`,
+			wantErr: "templates/a.yaml is also rendered",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := &host.Result{ExitCode: tt.exitCode, Raw: []byte(tt.raw)}
+			var out host.OutputMessageRenderV1
+			if err := json.Unmarshal(res.Raw, &out); err == nil {
+				res.Output = &out
+			}
+
+			err := checkOutput(res)
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Errorf("unexpected error: %v", err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Errorf("error = %v, want it to contain %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSampleFromPattern(t *testing.T) {
+	tests := []struct {
+		pattern string
+		want    string
+	}{
+		{"templates/*.yaml", "templates/conformance.yaml"},
+		{"templates/**/*.pkl", "templates/conformance.pkl"},
+		{"templates/*.{yml,yaml}", "templates/conformance.yml"},
+		{"templates/file?.test", "templates/filex.test"},
+	}
+	for _, tt := range tests {
+		if got := sampleFromPattern(tt.pattern); got != tt.want {
+			t.Errorf("sampleFromPattern(%q) = %q, want %q", tt.pattern, got, tt.want)
+		}
+	}
+}
+
+// TestReferencePlugins builds every plugin in this repository and runs the
+// conformance checks against it.
+func TestReferencePlugins(t *testing.T) {
+	if testing.Short() {
+		t.Skip("builds Wasm plugins")
+	}
+
+	pluginsDir := filepath.Join("..", "..", "plugins")
+	entries, err := os.ReadDir(pluginsDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		src := filepath.Join(pluginsDir, entry.Name())
+		if _, err := os.Stat(filepath.Join(src, "go.mod")); err != nil {
+			continue
+		}
+		t.Run(entry.Name(), func(t *testing.T) {
+			dir := t.TempDir()
+			if err := host.BuildPlugin(src, dir); err != nil {
+				t.Fatal(err)
+			}
+			TestPlugin(t, dir)
+		})
+	}
+}
@@ -0,0 +1,28 @@
+module github.com/scottrigby/ref-hip-chart-defined-plugins/plugin-run
+
+go 1.24.0
+
+require (
+	github.com/extism/go-sdk v1.7.1
+	github.com/gobwas/glob v0.2.3
+	github.com/santhosh-tekuri/jsonschema/v6 v6.0.3
+	github.com/scottrigby/ref-hip-chart-defined-plugins/renderv1 v0.0.0
+	github.com/tetratelabs/wazero v1.11.0
+	sigs.k8s.io/yaml v1.6.0
+)
+
+require (
+	github.com/dylibso/observe-sdk/go v0.0.0-20240819160327-2d926c5d788a // indirect
+	github.com/ianlancetaylor/demangle v0.0.0-20240805132620-81f5be970eca // indirect
+	github.com/tetratelabs/wabin v0.0.0-20230304001439-f6f874872834 // indirect
+	go.opentelemetry.io/proto/otlp v1.3.1 // indirect
+	go.yaml.in/yaml/v2 v2.4.2 // indirect
+	go.yaml.in/yaml/v3 v3.0.4 // indirect
+	golang.org/x/sys v0.38.0 // indirect
+	golang.org/x/text v0.17.0 // indirect
+	google.golang.org/protobuf v1.34.2 // indirect
+)
+
+// renderv1 holds the render/v1 types shared with the plugins, in this
+// repository.
+replace github.com/scottrigby/ref-hip-chart-defined-plugins/renderv1 => ../renderv1
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/tetratelabs/wazero"
+
+	"github.com/scottrigby/ref-hip-chart-defined-plugins/plugin-run/host"
+)
+
+// goldenOverrides are applied by the "values" case to every chart, limited
+// to the top-level keys the chart's values.yaml sets, so that charts checking
+// their values against a schema accept them.
+var goldenOverrides = []string{
+	"replicas=5,replicaCount=5,image.repository=httpd,image.tag=2.4",
+	"greeting=Hello from overrides,testValue=overridden",
+}
+
+// goldenCases are the renders recorded for every chart.
+var goldenCases = []struct {
+	name        string
+	releaseName string
+	namespace   string
+	set         []string
+}{
+	{name: "default", releaseName: "my-release", namespace: "default"},
+	{name: "values", releaseName: "my-release", namespace: "default", set: goldenOverrides},
+	{name: "namespace", releaseName: "custom-name", namespace: "custom-ns"},
+}
+
+// goldenSkip lists the cases not recorded for a chart because its plugins
+// ignore what the case changes, so they would render the default output.
+var goldenSkip = map[string][]string{
+	// echo-render copies templates verbatim, configured by Chart.yaml only
+	"echo-chart": {"values"},
+	// sourcefiles-modifier and test-processor read neither values nor release
+	"sequential-plugins-test": {"values", "namespace"},
+}
+
+// TestGolden renders every example chart with its plugins, built from
+// plugins/, and compares the output with testdata/golden/<chart>/<case>.yaml.
+// Run with -update to record new output.
+func TestGolden(t *testing.T) {
+	if testing.Short() {
+		t.Skip("builds Wasm plugins")
+	}
+
+	pluginsDir := buildPlugins(t, host.ToolchainGo)
+	cache := wazero.NewCompilationCache()
+	defer cache.Close(context.Background())
+	runtime := &host.Runtime{CompilationCache: cache, HostConfig: testHostConfig(t)}
+
+	charts, err := filepath.Glob(filepath.Join("..", "charts", "*", "Chart.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(charts) == 0 {
+		t.Fatal("no charts found")
+	}
+
+	for _, chartFile := range charts {
+		chartDir := filepath.Dir(chartFile)
+		chart, err := host.LoadChart(chartDir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		stages, err := host.ChartStages(chart, pluginsDir)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var defaultOutput []byte
+		for _, tc := range goldenCases {
+			if slices.Contains(goldenSkip[filepath.Base(chartDir)], tc.name) {
+				continue
+			}
+			t.Run(filepath.Base(chartDir)+"/"+tc.name, func(t *testing.T) {
+				set := map[string]interface{}{}
+				for _, s := range tc.set {
+					if err := host.ParseSet(s, set); err != nil {
+						t.Fatal(err)
+					}
+				}
+				values := map[string]interface{}{}
+				for k, v := range set {
+					if _, ok := chart.Values[k]; ok {
+						values[k] = v
+					}
+				}
+				res, err := runtime.RunPipeline(context.Background(), chart, stages, host.RenderOptions{
+					ReleaseName: tc.releaseName,
+					Namespace:   tc.namespace,
+					Values:      values,
+				})
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				var got bytes.Buffer
+				writeManifests(&got, chart.Metadata.Name, res.RenderedFiles)
+				if tc.name == "default" {
+					defaultOutput = got.Bytes()
+				} else if bytes.Equal(got.Bytes(), defaultOutput) {
+					t.Errorf("renders the same as the default case; add overrides this chart uses or skip the case in goldenSkip")
+				}
+				checkGolden(t, filepath.Join("testdata", "golden", filepath.Base(chartDir), tc.name+".yaml"), got.Bytes())
+			})
+		}
+	}
+}
+
+func checkGolden(t *testing.T, file string, got []byte) {
+	t.Helper()
+	if *update {
+		if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(file, got, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+
+	test, _, _ := strings.Cut(t.Name(), "/")
+	want, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatalf("%v (run 'go test -run %s -update' to create it)", err, test)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("output differs from %s (run 'go test -run %s -update' if the change is intended):\n%s", file, test, lineDiff(string(want), string(got)))
+	}
+}
+
+// lineDiff returns the lines of got that differ from want, marked with - and
+// +, from the first difference on.
+func lineDiff(want, got string) string {
+	w := strings.Split(want, "\n")
+	g := strings.Split(got, "\n")
+	first := 0
+	for first < len(w) && first < len(g) && w[first] == g[first] {
+		first++
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "first difference at line %d\n", first+1)
+	for i := first; i < len(w) && i < first+20; i++ {
+		fmt.Fprintf(&b, "- %s\n", w[i])
+	}
+	for i := first; i < len(g) && i < first+20; i++ {
+		fmt.Fprintf(&b, "+ %s\n", g[i])
+	}
+	return b.String()
+}
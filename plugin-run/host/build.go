@@ -0,0 +1,74 @@
+package host
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+)
+
+// Toolchain is a compiler that can build a Go plugin into plugin.wasm.
+type Toolchain string
+
+const (
+	// ToolchainGo builds with the standard Go compiler, as 'make build-plugin'
+	// and the CI and release workflows do.
+	ToolchainGo Toolchain = "go"
+	// ToolchainTinyGo builds with TinyGo.
+	ToolchainTinyGo Toolchain = "tinygo"
+)
+
+// Available reports whether the toolchain's compiler is on PATH.
+func (tc Toolchain) Available() bool {
+	_, err := exec.LookPath(string(tc))
+	return err == nil
+}
+
+// command returns the build command writing wasm.
+func (tc Toolchain) command(wasm string) (*exec.Cmd, error) {
+	switch tc {
+	case ToolchainGo:
+		cmd := exec.Command("go", "build", "-buildmode=c-shared", "-o", wasm, ".")
+		cmd.Env = append(os.Environ(), "GOOS=wasip1", "GOARCH=wasm")
+		return cmd, nil
+	case ToolchainTinyGo:
+		return exec.Command("tinygo", "build", "-o", wasm, "-target=wasip1", "."), nil
+	}
+	return nil, fmt.Errorf("unknown toolchain %q", tc)
+}
+
+// BuildPlugin builds the Go plugin source in src into dst with the standard Go
+// compiler. See BuildPluginWith.
+func BuildPlugin(src, dst string) error {
+	return BuildPluginWith(ToolchainGo, src, dst)
+}
+
+// BuildPluginWith builds the Go plugin source in src into dst with tc, writing
+// dst/plugin.wasm and copying src/plugin.yaml, so that dst can be loaded with
+// LoadPlugin.
+func BuildPluginWith(tc Toolchain, src, dst string) error {
+	metadata, err := os.ReadFile(filepath.Join(src, "plugin.yaml"))
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dst, 0o755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(dst, "plugin.yaml"), metadata, 0o644); err != nil {
+		return err
+	}
+
+	wasm, err := filepath.Abs(filepath.Join(dst, "plugin.wasm"))
+	if err != nil {
+		return err
+	}
+	cmd, err := tc.command(wasm)
+	if err != nil {
+		return err
+	}
+	cmd.Dir = src
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to build %s with %s: %w\n%s", src, tc, err, out)
+	}
+	return nil
+}
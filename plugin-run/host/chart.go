@@ -0,0 +1,150 @@
+package host
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/gobwas/glob"
+	"sigs.k8s.io/yaml"
+)
+
+// ChartMetadata is the subset of Chart.yaml used to render a chart.
+type ChartMetadata struct {
+	APIVersion  string            `json:"apiVersion"`
+	Name        string            `json:"name"`
+	Version     string            `json:"version"`
+	AppVersion  string            `json:"appVersion,omitempty"`
+	Description string            `json:"description,omitempty"`
+	Type        string            `json:"type,omitempty"`
+	Plugins     []ChartPluginSpec `json:"plugins,omitempty"`
+}
+
+// ChartPluginSpec is a plugins entry in Chart.yaml.
+type ChartPluginSpec struct {
+	Name       string                 `json:"name"`
+	Type       string                 `json:"type"`
+	Repository string                 `json:"repository"`
+	Version    string                 `json:"version"`
+	Config     map[string]interface{} `json:"config,omitempty"`
+}
+
+// Chart is a chart directory loaded from disk.
+type Chart struct {
+	Dir      string
+	Metadata ChartMetadata
+	// Values are the chart's default values from values.yaml.
+	Values map[string]interface{}
+	// Templates are the files under templates/.
+	Templates []SourceFile
+	// Files are the remaining chart files, as exposed to templates by Helm.
+	Files []SourceFile
+}
+
+// chartSpecialFiles are read by Helm itself and not exposed as chart Files.
+// values.schema.json is exposed: the plugin pipeline does not enforce it, so
+// a plugin such as values-schema validates the values against it.
+var chartSpecialFiles = map[string]bool{
+	"Chart.yaml":  true,
+	"Chart.lock":  true,
+	"values.yaml": true,
+}
+
+// LoadChart loads a chart directory. Subcharts under charts/ are not loaded.
+func LoadChart(dir string) (*Chart, error) {
+	c := &Chart{Dir: dir, Values: map[string]interface{}{}}
+
+	data, err := os.ReadFile(filepath.Join(dir, "Chart.yaml"))
+	if err != nil {
+		return nil, err
+	}
+	if err := yaml.Unmarshal(data, &c.Metadata); err != nil {
+		return nil, fmt.Errorf("failed to parse Chart.yaml: %w", err)
+	}
+
+	if data, err := os.ReadFile(filepath.Join(dir, "values.yaml")); err == nil {
+		if err := yaml.Unmarshal(data, &c.Values); err != nil {
+			return nil, fmt.Errorf("failed to parse values.yaml: %w", err)
+		}
+		if c.Values == nil {
+			c.Values = map[string]interface{}{}
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	err = filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		name := filepath.ToSlash(rel)
+		if d.IsDir() {
+			if name == "charts" {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if chartSpecialFiles[name] {
+			return nil
+		}
+		data, err := os.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		f := SourceFile{Name: name, Data: data}
+		if strings.HasPrefix(name, "templates/") {
+			c.Templates = append(c.Templates, f)
+		} else {
+			c.Files = append(c.Files, f)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// PluginSpec returns the chart's plugins entry for the named plugin, if any.
+func (c *Chart) PluginSpec(name string) *ChartPluginSpec {
+	for i := range c.Metadata.Plugins {
+		if c.Metadata.Plugins[i].Name == name {
+			return &c.Metadata.Plugins[i]
+		}
+	}
+	return nil
+}
+
+// MatchFiles returns the files whose names match any of the glob patterns,
+// in name order. "**" matches across directories; "*" does not.
+func MatchFiles(patterns []string, files []SourceFile) ([]SourceFile, error) {
+	globs := make([]glob.Glob, 0, len(patterns))
+	for _, pattern := range patterns {
+		g, err := glob.Compile(pattern, '/')
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+		}
+		globs = append(globs, g)
+	}
+
+	var matched []SourceFile
+	for _, f := range files {
+		for _, g := range globs {
+			if g.Match(path.Clean(f.Name)) {
+				matched = append(matched, f)
+				break
+			}
+		}
+	}
+	sort.Slice(matched, func(i, j int) bool { return matched[i].Name < matched[j].Name })
+	return matched, nil
+}
@@ -0,0 +1,61 @@
+package host
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMatchFiles(t *testing.T) {
+	files := []SourceFile{
+		{Name: "templates/service.yaml"},
+		{Name: "templates/_helpers.tpl"},
+		{Name: "templates/deployment.yaml"},
+		{Name: "templates/nested/config.yaml"},
+		{Name: "templates/./odd.yaml"},
+		{Name: "templates/notes.txt"},
+	}
+
+	tests := []struct {
+		name     string
+		patterns []string
+		want     []string
+		wantErr  bool
+	}{
+		{
+			name: "no patterns",
+		},
+		{
+			name:     "star does not cross directories",
+			patterns: []string{"templates/*.yaml"},
+			want:     []string{"templates/./odd.yaml", "templates/deployment.yaml", "templates/service.yaml"},
+		},
+		{
+			name:     "double star crosses directories",
+			patterns: []string{"templates/**/*.yaml"},
+			want:     []string{"templates/nested/config.yaml"},
+		},
+		{
+			name:     "files matching several patterns once, in name order",
+			patterns: []string{"templates/*.yaml", "templates/_*.tpl", "templates/service.*"},
+			want:     []string{"templates/./odd.yaml", "templates/_helpers.tpl", "templates/deployment.yaml", "templates/service.yaml"},
+		},
+		{
+			name:     "invalid pattern",
+			patterns: []string{"templates/[.yaml"},
+			wantErr:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			matched, err := MatchFiles(tt.patterns, files)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("MatchFiles() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			got := strings.Join(fileNames(matched), ",")
+			if want := strings.Join(tt.want, ","); got != want {
+				t.Errorf("MatchFiles() = %s, want %s", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,5 @@
+// Package host runs render/v1 plugins outside of Helm. It loads a plugin's
+// plugin.yaml and plugin.wasm, builds an InputMessageRenderV1 from a chart
+// directory the way Helm does, and invokes the plugin through the Extism Go
+// SDK with the same runtime settings as Helm's extism/v1 runtime.
+package host
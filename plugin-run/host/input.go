@@ -0,0 +1,107 @@
+package host
+
+import (
+	"fmt"
+	"strings"
+)
+
+// RenderOptions are the release settings a chart is rendered with.
+type RenderOptions struct {
+	ReleaseName string
+	Namespace   string
+	// KubeVersion is the Kubernetes version reported in capabilities,
+	// e.g. "v1.35.0".
+	KubeVersion string
+	// Values are user-supplied values, coalesced over the chart's defaults.
+	Values map[string]interface{}
+}
+
+// DefaultKubeVersion is the Kubernetes version used when RenderOptions does
+// not set one.
+const DefaultKubeVersion = "v1.35.0"
+
+// helmVersion is reported to plugins as capabilities.helmVersion.
+const helmVersion = "v4.0.0"
+
+// NewInput builds the render/v1 input message Helm would pass a plugin for
+// this chart, with sourceFiles set to the chart templates matching
+// patterns.
+func NewInput(c *Chart, opts RenderOptions, config map[string]interface{}, patterns []string) (*InputMessageRenderV1, error) {
+	sourceFiles, err := MatchFiles(patterns, c.Templates)
+	if err != nil {
+		return nil, err
+	}
+
+	values := MergeValues(deepCopy(c.Values), deepCopy(opts.Values))
+
+	capabilities, err := newCapabilities(opts.KubeVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	namespace := opts.Namespace
+	if namespace == "" {
+		namespace = "default"
+	}
+
+	return &InputMessageRenderV1{
+		Release: ReleaseInfo{
+			Name:      opts.ReleaseName,
+			Namespace: namespace,
+			Revision:  1,
+			IsInstall: true,
+			Service:   "Helm",
+		},
+		Values: values,
+		Chart: ChartInfo{
+			Name:        c.Metadata.Name,
+			Version:     c.Metadata.Version,
+			AppVersion:  c.Metadata.AppVersion,
+			Description: c.Metadata.Description,
+			Type:        c.Metadata.Type,
+			IsRoot:      true,
+		},
+		Subcharts:    map[string]interface{}{},
+		Files:        c.Files,
+		Capabilities: capabilities,
+		SourceFiles:  sourceFiles,
+		Config:       config,
+	}, nil
+}
+
+func newCapabilities(kubeVersion string) (CapabilitiesInfo, error) {
+	if kubeVersion == "" {
+		kubeVersion = DefaultKubeVersion
+	}
+	if !strings.HasPrefix(kubeVersion, "v") {
+		kubeVersion = "v" + kubeVersion
+	}
+	parts := strings.SplitN(strings.TrimPrefix(kubeVersion, "v"), ".", 3)
+	if len(parts) < 2 {
+		return CapabilitiesInfo{}, fmt.Errorf("invalid Kubernetes version %q", kubeVersion)
+	}
+	return CapabilitiesInfo{
+		KubeVersion: KubeVersionInfo{
+			Version: kubeVersion,
+			Major:   parts[0],
+			Minor:   parts[1],
+		},
+		APIVersions: []string{"v1", "apps/v1", "batch/v1", "networking.k8s.io/v1", "policy/v1", "rbac.authorization.k8s.io/v1"},
+		HelmVersion: helmVersion,
+	}, nil
+}
+
+// deepCopy copies nested values maps so merging does not modify the source.
+func deepCopy(m map[string]interface{}) map[string]interface{} {
+	if m == nil {
+		return nil
+	}
+	out := make(map[string]interface{}, len(m))
+	for k, v := range m {
+		if nested, ok := v.(map[string]interface{}); ok {
+			v = deepCopy(nested)
+		}
+		out[k] = v
+	}
+	return out
+}
@@ -0,0 +1,267 @@
+package host
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"path/filepath"
+	"sort"
+)
+
+// Stage is one entry of a chart's plugins list: a plugin and the config the
+// chart sets for it.
+type Stage struct {
+	Plugin      *Plugin
+	ChartConfig map[string]interface{}
+}
+
+// ChartStages loads the plugins a chart lists in Chart.yaml, in order, from
+// pluginsDir/<name>. The plugin versions the chart references are not
+// checked: the local plugin is always used.
+func ChartStages(c *Chart, pluginsDir string) ([]Stage, error) {
+	stages := make([]Stage, 0, len(c.Metadata.Plugins))
+	for _, spec := range c.Metadata.Plugins {
+		p, err := LoadPlugin(filepath.Join(pluginsDir, spec.Name))
+		if err != nil {
+			return nil, err
+		}
+		stages = append(stages, Stage{Plugin: p, ChartConfig: spec.Config})
+	}
+	return stages, nil
+}
+
+// Rename records a source file passed on under a new name.
+type Rename struct {
+	From string
+	To   string
+}
+
+// SourceChanges describes how a plugin's modifiedSourceFiles changed the
+// files it received.
+type SourceChanges struct {
+	Added    []string
+	Removed  []string
+	Modified []string
+	Renamed  []Rename
+}
+
+// Empty reports whether the plugin left its source files unchanged.
+func (s SourceChanges) Empty() bool {
+	return len(s.Added) == 0 && len(s.Removed) == 0 && len(s.Modified) == 0 && len(s.Renamed) == 0
+}
+
+// Step is the trace of a single plugin run in a pipeline.
+type Step struct {
+	Plugin string
+	// SourceFiles are the names of the files the plugin received.
+	SourceFiles []string
+	// RenderedFiles are the names of the files the plugin rendered.
+	RenderedFiles []string
+	// RemovedRenderedFiles are the names of the files rendered by earlier
+	// plugins that the plugin removed.
+	RemovedRenderedFiles []string
+	// Changes is set when the plugin returned modifiedSourceFiles.
+	Changes *SourceChanges
+	// ModifiedValues reports whether the plugin replaced the values of the
+	// plugins after it.
+	ModifiedValues bool
+	Result         *Result
+}
+
+// PipelineResult is the outcome of running a chart's plugins in order.
+type PipelineResult struct {
+	// RenderedFiles are the files rendered by all plugins. A later plugin
+	// rendering a file of the same name replaces the earlier one.
+	RenderedFiles map[string]string
+	// Values are the modifiedValues of the last plugin replacing the values,
+	// or nil if none did.
+	Values map[string]interface{}
+	Steps  []Step
+}
+
+// RunPipeline runs stages in order the way Helm chains render plugins. Each
+// plugin receives the current chart templates matching its patterns and, as
+// renderedFiles, the files rendered by the plugins before it. A plugin's
+// renderedFiles replace earlier files of the same name, and its
+// removedRenderedFiles drop earlier files. When a plugin returns
+// modifiedSourceFiles, they replace the files it received for every later
+// plugin; templates the plugin did not receive are kept. Its modifiedValues
+// likewise replace the values of every later plugin. The pipeline stops at the
+// first plugin that fails, or that removes a file not rendered before it,
+// returning the steps run so far along with the error.
+func (r *Runtime) RunPipeline(ctx context.Context, c *Chart, stages []Stage, opts RenderOptions) (*PipelineResult, error) {
+	res := &PipelineResult{RenderedFiles: make(map[string]string)}
+	templates := c.Templates
+
+	for _, stage := range stages {
+		p := stage.Plugin
+		config, err := p.ResolveConfig(stage.ChartConfig)
+		if err != nil {
+			return res, err
+		}
+
+		chart := *c
+		chart.Templates = templates
+		input, err := NewInput(&chart, opts, config, Patterns(config))
+		if err != nil {
+			return res, err
+		}
+		if res.Values != nil {
+			input.Values = res.Values
+		}
+		if len(res.RenderedFiles) > 0 {
+			input.RenderedFiles = res.RenderedFiles
+		}
+
+		result, err := r.renderOnce(ctx, p, input)
+		if err != nil {
+			return res, err
+		}
+		step := Step{
+			Plugin:      p.Metadata.Name,
+			SourceFiles: fileNames(input.SourceFiles),
+			Result:      result,
+		}
+		if result.Output != nil {
+			step.RenderedFiles = sortedKeys(result.Output.RenderedFiles)
+		}
+		if err := result.Err(); err != nil {
+			res.Steps = append(res.Steps, step)
+			return res, fmt.Errorf("plugin %s: %w", p.Metadata.Name, err)
+		}
+
+		out := result.Output
+		step.RemovedRenderedFiles = append([]string(nil), out.RemovedRenderedFiles...)
+		sort.Strings(step.RemovedRenderedFiles)
+		if err := mergeRenderedFiles(res.RenderedFiles, out); err != nil {
+			res.Steps = append(res.Steps, step)
+			return res, fmt.Errorf("plugin %s: %w", p.Metadata.Name, err)
+		}
+		if out.ModifiedSourceFiles != nil {
+			changes := diffSourceFiles(input.SourceFiles, out.ModifiedSourceFiles)
+			step.Changes = &changes
+			templates = replaceFiles(templates, input.SourceFiles, out.ModifiedSourceFiles)
+		}
+		if out.ModifiedValues != nil {
+			step.ModifiedValues = true
+			res.Values = out.ModifiedValues
+		}
+		res.Steps = append(res.Steps, step)
+	}
+	return res, nil
+}
+
+func (r *Runtime) renderOnce(ctx context.Context, p *Plugin, input *InputMessageRenderV1) (*Result, error) {
+	compiled, err := r.Compile(ctx, p)
+	if err != nil {
+		return nil, err
+	}
+	defer compiled.Close(ctx)
+	return compiled.Render(ctx, input)
+}
+
+// mergeRenderedFiles drops the files out removes from rendered, the files
+// rendered by earlier plugins, and adds the files out renders. A file must
+// have been rendered earlier to be removed, and must not be both rendered
+// and removed.
+func mergeRenderedFiles(rendered map[string]string, out *OutputMessageRenderV1) error {
+	for _, name := range out.RemovedRenderedFiles {
+		if _, ok := out.RenderedFiles[name]; ok {
+			return fmt.Errorf("removedRenderedFiles: %s is also rendered", name)
+		}
+		if _, ok := rendered[name]; !ok {
+			return fmt.Errorf("removedRenderedFiles: %s was not rendered by an earlier plugin", name)
+		}
+	}
+	for _, name := range out.RemovedRenderedFiles {
+		delete(rendered, name)
+	}
+	for name, content := range out.RenderedFiles {
+		rendered[name] = content
+	}
+	return nil
+}
+
+// replaceFiles returns all with the files in received replaced by modified.
+func replaceFiles(all, received, modified []SourceFile) []SourceFile {
+	drop := make(map[string]bool, len(received))
+	for _, f := range received {
+		drop[f.Name] = true
+	}
+	out := make([]SourceFile, 0, len(all)+len(modified))
+	for _, f := range all {
+		if !drop[f.Name] {
+			out = append(out, f)
+		}
+	}
+	return append(out, modified...)
+}
+
+// diffSourceFiles compares the files a plugin received with the files it
+// passed on. A removed file whose content reappears unchanged under a new
+// name is reported as renamed.
+func diffSourceFiles(before, after []SourceFile) SourceChanges {
+	old := make(map[string][]byte, len(before))
+	for _, f := range before {
+		old[f.Name] = f.Data
+	}
+	kept := make(map[string]bool, len(after))
+
+	var changes SourceChanges
+	var added []SourceFile
+	for _, f := range after {
+		data, ok := old[f.Name]
+		switch {
+		case !ok:
+			added = append(added, f)
+		case !bytes.Equal(data, f.Data):
+			changes.Modified = append(changes.Modified, f.Name)
+		}
+		kept[f.Name] = true
+	}
+
+	var removed []string
+	for _, f := range before {
+		if !kept[f.Name] {
+			removed = append(removed, f.Name)
+		}
+	}
+
+	for _, f := range added {
+		renamed := false
+		for i, name := range removed {
+			if bytes.Equal(old[name], f.Data) {
+				changes.Renamed = append(changes.Renamed, Rename{From: name, To: f.Name})
+				removed = append(removed[:i], removed[i+1:]...)
+				renamed = true
+				break
+			}
+		}
+		if !renamed {
+			changes.Added = append(changes.Added, f.Name)
+		}
+	}
+	changes.Removed = removed
+
+	sort.Strings(changes.Added)
+	sort.Strings(changes.Removed)
+	sort.Strings(changes.Modified)
+	return changes
+}
+
+func fileNames(files []SourceFile) []string {
+	names := make([]string, 0, len(files))
+	for _, f := range files {
+		names = append(names, f.Name)
+	}
+	return names
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
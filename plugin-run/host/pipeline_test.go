@@ -0,0 +1,119 @@
+package host
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestDiffSourceFiles(t *testing.T) {
+	a := SourceFile{Name: "templates/a.yaml", Data: []byte("a")}
+	b := SourceFile{Name: "templates/b.yaml", Data: []byte("b")}
+	c := SourceFile{Name: "templates/c.yaml", Data: []byte("c")}
+
+	tests := []struct {
+		name   string
+		before []SourceFile
+		after  []SourceFile
+		want   SourceChanges
+	}{
+		{
+			name:   "unchanged",
+			before: []SourceFile{a, b},
+			after:  []SourceFile{b, a},
+		},
+		{
+			name:   "added, removed and modified",
+			before: []SourceFile{a, b},
+			after:  []SourceFile{{Name: a.Name, Data: []byte("a2")}, c},
+			want: SourceChanges{
+				Added:    []string{c.Name},
+				Removed:  []string{b.Name},
+				Modified: []string{a.Name},
+			},
+		},
+		{
+			name:   "renamed",
+			before: []SourceFile{a, b},
+			after:  []SourceFile{a, {Name: "templates/b.renamed", Data: b.Data}},
+			want:   SourceChanges{Renamed: []Rename{{From: b.Name, To: "templates/b.renamed"}}},
+		},
+		{
+			name:   "one rename per removed file",
+			before: []SourceFile{a},
+			after:  []SourceFile{{Name: "templates/x.yaml", Data: a.Data}, {Name: "templates/y.yaml", Data: a.Data}},
+			want: SourceChanges{
+				Added:   []string{"templates/y.yaml"},
+				Renamed: []Rename{{From: a.Name, To: "templates/x.yaml"}},
+			},
+		},
+		{
+			name:   "all removed",
+			before: []SourceFile{b, a},
+			want:   SourceChanges{Removed: []string{a.Name, b.Name}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Compare formatted, so nil and empty lists are equal
+			got := diffSourceFiles(tt.before, tt.after)
+			if fmt.Sprintf("%+v", got) != fmt.Sprintf("%+v", tt.want) {
+				t.Errorf("diffSourceFiles() = %+v, want %+v", got, tt.want)
+			}
+			if got.Empty() != reflect.DeepEqual(tt.want, SourceChanges{}) {
+				t.Errorf("Empty() = %v for %+v", got.Empty(), got)
+			}
+		})
+	}
+}
+
+func TestMergeRenderedFiles(t *testing.T) {
+	tests := []struct {
+		name    string
+		out     OutputMessageRenderV1
+		want    map[string]string
+		wantErr string
+	}{
+		{
+			name: "rendered, replaced and removed",
+			out: OutputMessageRenderV1{
+				RenderedFiles:        map[string]string{"templates/b.yaml": "b2", "templates/c.yaml": "c"},
+				RemovedRenderedFiles: []string{"templates/a.yaml"},
+			},
+			want: map[string]string{"templates/b.yaml": "b2", "templates/c.yaml": "c"},
+		},
+		{
+			name:    "removed but not rendered earlier",
+			out:     OutputMessageRenderV1{RemovedRenderedFiles: []string{"templates/c.yaml"}},
+			wantErr: "removedRenderedFiles: templates/c.yaml was not rendered by an earlier plugin",
+		},
+		{
+			name: "both rendered and removed",
+			out: OutputMessageRenderV1{
+				RenderedFiles:        map[string]string{"templates/a.yaml": "a2"},
+				RemovedRenderedFiles: []string{"templates/a.yaml"},
+			},
+			wantErr: "removedRenderedFiles: templates/a.yaml is also rendered",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rendered := map[string]string{"templates/a.yaml": "a", "templates/b.yaml": "b"}
+			err := mergeRenderedFiles(rendered, &tt.out)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("mergeRenderedFiles() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(rendered, tt.want) {
+				t.Errorf("rendered = %v, want %v", rendered, tt.want)
+			}
+		})
+	}
+}
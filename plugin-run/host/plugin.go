@@ -0,0 +1,266 @@
+package host
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	extism "github.com/extism/go-sdk"
+	"github.com/tetratelabs/wazero"
+	"sigs.k8s.io/yaml"
+
+	"github.com/scottrigby/ref-hip-chart-defined-plugins/plugin-run/configschema"
+)
+
+// EntryFuncName is the plugin export Helm calls.
+const EntryFuncName = "helm_plugin_main"
+
+// PluginMetadata is the subset of plugin.yaml used to run a plugin.
+type PluginMetadata struct {
+	APIVersion   string                 `json:"apiVersion"`
+	Name         string                 `json:"name"`
+	Version      string                 `json:"version"`
+	Type         string                 `json:"type"`
+	Runtime      string                 `json:"runtime"`
+	Config       map[string]interface{} `json:"config"`
+	ConfigSchema map[string]interface{} `json:"configSchema"`
+	HostConfig   []HostConfigKey        `json:"hostConfig,omitempty"`
+}
+
+// HostConfigKey is a hostConfig entry in plugin.yaml: a value the plugin
+// needs from the host rather than from the chart, such as a decryption key.
+type HostConfigKey struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+}
+
+// Plugin is a render/v1 plugin loaded from a plugin directory.
+type Plugin struct {
+	Dir      string
+	Metadata PluginMetadata
+	Wasm     []byte
+}
+
+// LoadPlugin loads plugin.yaml and plugin.wasm from dir.
+func LoadPlugin(dir string) (*Plugin, error) {
+	p := &Plugin{Dir: dir}
+
+	data, err := os.ReadFile(filepath.Join(dir, "plugin.yaml"))
+	if err != nil {
+		return nil, err
+	}
+	if err := yaml.Unmarshal(data, &p.Metadata); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", filepath.Join(dir, "plugin.yaml"), err)
+	}
+	if p.Metadata.Type != "render/v1" {
+		return nil, fmt.Errorf("plugin %s has type %q, want render/v1", p.Metadata.Name, p.Metadata.Type)
+	}
+
+	p.Wasm, err = os.ReadFile(filepath.Join(dir, "plugin.wasm"))
+	if err != nil {
+		return nil, fmt.Errorf("wasm binary missing for plugin %s (run 'make build-plugin PLUGIN=%s'): %w",
+			p.Metadata.Name, filepath.Base(dir), err)
+	}
+	return p, nil
+}
+
+// LoadWasm loads a bare plugin.wasm without plugin.yaml, for running plugins
+// whose metadata is not at hand. The plugin is named after the file and has
+// no config.
+func LoadWasm(file string) (*Plugin, error) {
+	wasm, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	name := strings.TrimSuffix(filepath.Base(file), filepath.Ext(file))
+	return &Plugin{
+		Dir:      filepath.Dir(file),
+		Metadata: PluginMetadata{Name: name, Type: "render/v1", Runtime: "extism/v1"},
+		Wasm:     wasm,
+	}, nil
+}
+
+// ResolveConfig merges chartConfig over the plugin's own config, after
+// validating chartConfig against the plugin's configSchema, as Helm does.
+func (p *Plugin) ResolveConfig(chartConfig map[string]interface{}) (map[string]interface{}, error) {
+	if len(chartConfig) > 0 {
+		if p.Metadata.ConfigSchema == nil {
+			return nil, fmt.Errorf("plugin %s does not accept chart configuration (no configSchema)", p.Metadata.Name)
+		}
+		schema, err := configschema.Compile(filepath.Join(p.Dir, "plugin.yaml"), p.Metadata.ConfigSchema)
+		if err != nil {
+			return nil, fmt.Errorf("plugin %s: invalid configSchema: %w", p.Metadata.Name, err)
+		}
+		if msgs := configschema.Validate(schema, chartConfig); len(msgs) > 0 {
+			return nil, fmt.Errorf("invalid config for plugin %s: config%s", p.Metadata.Name, strings.Join(msgs, "; config"))
+		}
+	}
+
+	config := make(map[string]interface{})
+	for k, v := range p.Metadata.Config {
+		config[k] = v
+	}
+	for k, v := range chartConfig {
+		config[k] = v
+	}
+	return config, nil
+}
+
+// Patterns returns the file patterns from a resolved plugin config.
+func Patterns(config map[string]interface{}) []string {
+	list, _ := config["patterns"].([]interface{})
+	patterns := make([]string, 0, len(list))
+	for _, item := range list {
+		if s, ok := item.(string); ok {
+			patterns = append(patterns, s)
+		}
+	}
+	return patterns
+}
+
+// Runtime compiles and invokes plugins with the settings Helm's extism/v1
+// runtime uses. A nil CompilationCache compiles every plugin from scratch.
+type Runtime struct {
+	CompilationCache wazero.CompilationCache
+
+	// Logger receives log messages written by plugins, if set.
+	Logger func(plugin string, level extism.LogLevel, msg string)
+
+	// HostConfig holds values supplied by the host, such as decryption keys,
+	// by name. A plugin receives only the keys its plugin.yaml lists in
+	// hostConfig, through the Extism plugin config rather than the input
+	// message, so they never reach other plugins or the rendered chart.
+	HostConfig map[string]string
+}
+
+// CompiledPlugin is a plugin compiled by a Runtime, ready to be invoked.
+type CompiledPlugin struct {
+	plugin   *Plugin
+	compiled *extism.CompiledPlugin
+	runtime  *Runtime
+}
+
+// Result is the outcome of a single plugin invocation.
+type Result struct {
+	// ExitCode is the value returned by the plugin's entry function.
+	ExitCode uint32
+	// Raw is the plugin output exactly as written.
+	Raw []byte
+	// Output is Raw decoded, or nil if Raw is not a valid output message.
+	Output *OutputMessageRenderV1
+	// DecodeError explains why Output is nil.
+	DecodeError error
+}
+
+// Compile compiles p's Wasm module.
+func (r *Runtime) Compile(ctx context.Context, p *Plugin) (*CompiledPlugin, error) {
+	manifest := extism.Manifest{
+		Wasm: []extism.Wasm{
+			extism.WasmData{Data: p.Wasm, Name: p.Metadata.Name},
+		},
+		AllowedHosts: []string{},
+		AllowedPaths: map[string]string{},
+		Config:       r.hostConfig(p),
+	}
+
+	rc := wazero.NewRuntimeConfigCompiler().WithCloseOnContextDone(true)
+	if r.CompilationCache != nil {
+		rc = rc.WithCompilationCache(r.CompilationCache)
+	}
+
+	compiled, err := extism.NewCompiledPlugin(ctx, manifest, extism.PluginConfig{
+		RuntimeConfig:             rc,
+		EnableWasi:                true,
+		EnableHttpResponseHeaders: true,
+	}, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compile plugin %s: %w", p.Metadata.Name, err)
+	}
+	return &CompiledPlugin{plugin: p, compiled: compiled, runtime: r}, nil
+}
+
+// hostConfig returns the values of r.HostConfig that p declares in its
+// plugin.yaml.
+func (r *Runtime) hostConfig(p *Plugin) map[string]string {
+	config := make(map[string]string)
+	for _, key := range p.Metadata.HostConfig {
+		if v, ok := r.HostConfig[key.Name]; ok {
+			config[key.Name] = v
+		}
+	}
+	return config
+}
+
+// Close releases the compiled module.
+func (c *CompiledPlugin) Close(ctx context.Context) error {
+	return c.compiled.Close(ctx)
+}
+
+// Invoke calls the plugin entry function with raw input bytes. An error is
+// returned if the plugin could not be run to completion, e.g. it trapped, or
+// if it reported an error through Extism rather than in its output message;
+// a non-zero exit code is reported in the Result.
+func (c *CompiledPlugin) Invoke(ctx context.Context, input []byte) (*Result, error) {
+	instance, err := c.compiled.Instance(ctx, extism.PluginInstanceConfig{
+		ModuleConfig: wazero.NewModuleConfig().WithSysWalltime(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to instantiate plugin %s: %w", c.plugin.Metadata.Name, err)
+	}
+	defer instance.Close(ctx)
+
+	if logger := c.runtime.Logger; logger != nil {
+		name := c.plugin.Metadata.Name
+		instance.SetLogger(func(level extism.LogLevel, msg string) {
+			logger(name, level, msg)
+		})
+	}
+
+	exitCode, raw, err := instance.CallWithContext(ctx, EntryFuncName, input)
+	if err != nil {
+		return nil, fmt.Errorf("plugin %s failed: %w", c.plugin.Metadata.Name, err)
+	}
+
+	res := &Result{ExitCode: exitCode, Raw: raw}
+	var out OutputMessageRenderV1
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	if err := dec.Decode(&out); err != nil {
+		res.DecodeError = fmt.Errorf("plugin %s wrote invalid output: %w", c.plugin.Metadata.Name, err)
+	} else {
+		res.Output = &out
+	}
+	return res, nil
+}
+
+// Render invokes the plugin with a render/v1 input message.
+func (c *CompiledPlugin) Render(ctx context.Context, input *InputMessageRenderV1) (*Result, error) {
+	data, err := json.Marshal(input)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal plugin input: %w", err)
+	}
+	return c.Invoke(ctx, data)
+}
+
+// Err summarises a failed render as an error, or returns nil if the plugin
+// succeeded without reporting errors.
+func (r *Result) Err() error {
+	if r.Output == nil {
+		return r.DecodeError
+	}
+	if r.ExitCode == 0 && len(r.Output.Errors) == 0 {
+		return nil
+	}
+	msg := "plugin reported errors"
+	if r.ExitCode != 0 {
+		msg = fmt.Sprintf("plugin exited with code %d", r.ExitCode)
+	}
+	if len(r.Output.Errors) > 0 {
+		return errors.New(msg + ": " + strings.Join(r.Output.Errors, "; "))
+	}
+	return errors.New(msg)
+}
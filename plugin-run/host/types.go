@@ -0,0 +1,77 @@
+package host
+
+import "github.com/scottrigby/ref-hip-chart-defined-plugins/renderv1"
+
+// ReleaseInfo contains release metadata passed to render plugins.
+type ReleaseInfo struct {
+	Name      string `json:"name"`
+	Namespace string `json:"namespace"`
+	Revision  int    `json:"revision"`
+	IsInstall bool   `json:"isInstall"`
+	IsUpgrade bool   `json:"isUpgrade"`
+	Service   string `json:"service"`
+}
+
+// ChartInfo contains chart metadata passed to render plugins.
+type ChartInfo struct {
+	Name        string `json:"name"`
+	Version     string `json:"version"`
+	AppVersion  string `json:"appVersion,omitempty"`
+	Description string `json:"description,omitempty"`
+	Type        string `json:"type,omitempty"`
+	IsRoot      bool   `json:"isRoot"`
+}
+
+// CapabilitiesInfo contains Kubernetes cluster capabilities.
+type CapabilitiesInfo struct {
+	KubeVersion KubeVersionInfo `json:"kubeVersion"`
+	APIVersions []string        `json:"apiVersions"`
+	HelmVersion string          `json:"helmVersion"`
+}
+
+// KubeVersionInfo contains Kubernetes version information.
+type KubeVersionInfo struct {
+	Version string `json:"version"`
+	Major   string `json:"major"`
+	Minor   string `json:"minor"`
+}
+
+// SourceFile represents a file in the chart.
+type SourceFile struct {
+	Name string `json:"name"`
+	Data []byte `json:"data"`
+}
+
+// InputMessageRenderV1 is the input message for render/v1 plugins.
+type InputMessageRenderV1 struct {
+	Release      ReleaseInfo            `json:"release"`
+	Values       map[string]interface{} `json:"values"`
+	Chart        ChartInfo              `json:"chart"`
+	Subcharts    map[string]interface{} `json:"subcharts"`
+	Files        []SourceFile           `json:"files"`
+	Capabilities CapabilitiesInfo       `json:"capabilities"`
+	SourceFiles  []SourceFile           `json:"sourceFiles"`
+	Config       map[string]interface{} `json:"config,omitempty"`
+	// RenderedFiles are the files rendered by the plugins before this one in
+	// the chart's plugins list, so a plugin can post-process them.
+	RenderedFiles map[string]string `json:"renderedFiles,omitempty"`
+}
+
+// OutputMessageRenderV1 is the output message from render/v1 plugins.
+type OutputMessageRenderV1 struct {
+	RenderedFiles       map[string]string  `json:"renderedFiles"`
+	Documents           []RenderedDocument `json:"documents,omitempty"`
+	ModifiedSourceFiles []SourceFile       `json:"modifiedSourceFiles,omitempty"`
+	// RemovedRenderedFiles names files rendered by the plugins before this
+	// one that are dropped from the release. A plugin replaces such a file
+	// by rendering one of the same name instead.
+	RemovedRenderedFiles []string `json:"removedRenderedFiles,omitempty"`
+	// ModifiedValues replace the values passed to the plugins after this
+	// one, e.g. with values decrypted from chart files.
+	ModifiedValues map[string]interface{} `json:"modifiedValues,omitempty"`
+	Errors         []string               `json:"errors,omitempty"`
+}
+
+// RenderedDocument is a single YAML document split out of a rendered file,
+// as defined by the renderv1 package the plugins share.
+type RenderedDocument = renderv1.RenderedDocument
@@ -0,0 +1,87 @@
+package host
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"sigs.k8s.io/yaml"
+)
+
+// ReadValuesFile reads a YAML values file.
+func ReadValuesFile(file string) (map[string]interface{}, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	values := map[string]interface{}{}
+	if err := yaml.Unmarshal(data, &values); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", file, err)
+	}
+	return values, nil
+}
+
+// MergeValues merges src into dst the way Helm coalesces user values over
+// chart defaults: maps are merged recursively, any other src value replaces
+// the dst value, and a nil src value deletes the key. dst is modified and
+// returned.
+func MergeValues(dst, src map[string]interface{}) map[string]interface{} {
+	if dst == nil {
+		dst = map[string]interface{}{}
+	}
+	for k, v := range src {
+		if v == nil {
+			delete(dst, k)
+			continue
+		}
+		srcMap, srcIsMap := v.(map[string]interface{})
+		dstMap, dstIsMap := dst[k].(map[string]interface{})
+		if srcIsMap && dstIsMap {
+			dst[k] = MergeValues(dstMap, srcMap)
+			continue
+		}
+		dst[k] = v
+	}
+	return dst
+}
+
+// ParseSet parses a --set argument such as "image.tag=2.4,replicas=5" into
+// values. As with Helm, integers, booleans and null are typed; anything else
+// is a string. List indexes are not supported.
+func ParseSet(s string, values map[string]interface{}) error {
+	for _, assignment := range strings.Split(s, ",") {
+		key, raw, ok := strings.Cut(assignment, "=")
+		if !ok || key == "" {
+			return fmt.Errorf("invalid --set assignment %q: expected key=value", assignment)
+		}
+
+		parts := strings.Split(key, ".")
+		m := values
+		for _, part := range parts[:len(parts)-1] {
+			next, ok := m[part].(map[string]interface{})
+			if !ok {
+				next = map[string]interface{}{}
+				m[part] = next
+			}
+			m = next
+		}
+		m[parts[len(parts)-1]] = typedValue(raw)
+	}
+	return nil
+}
+
+func typedValue(raw string) interface{} {
+	switch raw {
+	case "true":
+		return true
+	case "false":
+		return false
+	case "null":
+		return nil
+	}
+	if i, err := strconv.ParseInt(raw, 10, 64); err == nil {
+		return i
+	}
+	return raw
+}
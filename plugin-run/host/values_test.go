@@ -0,0 +1,118 @@
+package host
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMergeValues(t *testing.T) {
+	tests := []struct {
+		name string
+		dst  map[string]interface{}
+		src  map[string]interface{}
+		want map[string]interface{}
+	}{
+		{
+			name: "nil dst",
+			src:  map[string]interface{}{"a": 1},
+			want: map[string]interface{}{"a": 1},
+		},
+		{
+			name: "nil src",
+			dst:  map[string]interface{}{"a": 1},
+			want: map[string]interface{}{"a": 1},
+		},
+		{
+			name: "maps merge recursively",
+			dst:  map[string]interface{}{"image": map[string]interface{}{"repository": "nginx", "tag": "1.26"}},
+			src:  map[string]interface{}{"image": map[string]interface{}{"tag": "1.27"}},
+			want: map[string]interface{}{"image": map[string]interface{}{"repository": "nginx", "tag": "1.27"}},
+		},
+		{
+			name: "other values replace",
+			dst:  map[string]interface{}{"list": []interface{}{1, 2}, "map": map[string]interface{}{"a": 1}, "n": 1},
+			src:  map[string]interface{}{"list": []interface{}{3}, "map": "flat", "n": map[string]interface{}{"b": 2}},
+			want: map[string]interface{}{"list": []interface{}{3}, "map": "flat", "n": map[string]interface{}{"b": 2}},
+		},
+		{
+			name: "null deletes",
+			dst:  map[string]interface{}{"a": 1, "nested": map[string]interface{}{"b": 2, "c": 3}},
+			src:  map[string]interface{}{"a": nil, "nested": map[string]interface{}{"b": nil}, "missing": nil},
+			want: map[string]interface{}{"nested": map[string]interface{}{"c": 3}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := MergeValues(tt.dst, tt.src); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("MergeValues() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseSet(t *testing.T) {
+	tests := []struct {
+		name    string
+		set     string
+		values  map[string]interface{}
+		want    map[string]interface{}
+		wantErr bool
+	}{
+		{
+			name: "typed values",
+			set:  "replicas=5,enabled=true,off=false,gone=null,tag=1.27,name=web",
+			want: map[string]interface{}{
+				"replicas": int64(5), "enabled": true, "off": false, "gone": nil, "tag": "1.27", "name": "web",
+			},
+		},
+		{
+			name:   "nested keys keep siblings",
+			set:    "image.tag=2.4",
+			values: map[string]interface{}{"image": map[string]interface{}{"repository": "httpd"}},
+			want:   map[string]interface{}{"image": map[string]interface{}{"repository": "httpd", "tag": "2.4"}},
+		},
+		{
+			name:   "nested key replaces a scalar",
+			set:    "image.tag=2.4",
+			values: map[string]interface{}{"image": "httpd"},
+			want:   map[string]interface{}{"image": map[string]interface{}{"tag": "2.4"}},
+		},
+		{
+			name: "value holding an equals sign",
+			set:  "args=a=b",
+			want: map[string]interface{}{"args": "a=b"},
+		},
+		{
+			name: "empty value",
+			set:  "name=",
+			want: map[string]interface{}{"name": ""},
+		},
+		{
+			name:    "missing equals sign",
+			set:     "replicas",
+			wantErr: true,
+		},
+		{
+			name:    "missing key",
+			set:     "=5",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			values := tt.values
+			if values == nil {
+				values = map[string]interface{}{}
+			}
+			err := ParseSet(tt.set, values)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseSet() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(values, tt.want) {
+				t.Errorf("ParseSet() values = %v, want %v", values, tt.want)
+			}
+		})
+	}
+}
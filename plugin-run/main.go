@@ -0,0 +1,302 @@
+// plugin-run renders a chart with render/v1 plugins without Helm. It loads
+// each plugin's plugin.wasm through the Extism Go SDK, builds the input
+// message from the chart directory, values files and --set overrides the way
+// Helm does, and prints the rendered files or the errors the plugins report.
+//
+// With -plugin, the chart is rendered by that plugin alone. Otherwise the
+// plugins listed in the chart's Chart.yaml are loaded from -plugins-dir and
+// run in order, passing each plugin's modifiedSourceFiles on to the next;
+// -trace prints what every step received and changed.
+//
+// -host-config-file supplies values, such as decryption keys, that plugins
+// declare in their plugin.yaml hostConfig. They are read from files, not the
+// command line, and passed only to the plugins declaring them.
+//
+// "plugin-run conformance PLUGIN..." checks plugins against the render/v1
+// protocol instead; see package conformance.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	extism "github.com/extism/go-sdk"
+
+	"github.com/scottrigby/ref-hip-chart-defined-plugins/plugin-run/host"
+)
+
+// stringList is a repeatable string flag.
+type stringList []string
+
+func (s *stringList) String() string { return strings.Join(*s, ",") }
+
+func (s *stringList) Set(v string) error {
+	*s = append(*s, v)
+	return nil
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: plugin-run [flags] CHART\n\n")
+	fmt.Fprintf(os.Stderr, "Render CHART with the plugins in its Chart.yaml, or with the single plugin given by -plugin.\n\nFlags:\n")
+	flag.PrintDefaults()
+}
+
+func main() {
+	if len(os.Args) > 1 && os.Args[1] == "conformance" {
+		os.Exit(runConformance(os.Args[2:]))
+	}
+
+	var valueFiles, setValues, hostConfigFiles stringList
+	pluginDir := flag.String("plugin", "", "Render with only this plugin directory, containing plugin.yaml and plugin.wasm")
+	pluginsDir := flag.String("plugins-dir", "../plugins", "Directory holding the plugins listed in Chart.yaml")
+	flag.Var(&valueFiles, "f", "Values file (can be repeated)")
+	flag.Var(&setValues, "set", "Set values, e.g. key1=val1,key2.sub=val2 (can be repeated)")
+	flag.Var(&hostConfigFiles, "host-config-file", "Pass the contents of a file as a plugin's hostConfig value, e.g. sops_age_key=keys.txt (can be repeated)")
+	releaseName := flag.String("name", "release-name", "Release name")
+	namespace := flag.String("namespace", "default", "Release namespace")
+	kubeVersion := flag.String("kube-version", host.DefaultKubeVersion, "Kubernetes version reported in capabilities")
+	rawJSON := flag.Bool("json", false, "Print the plugin output message as JSON")
+	debug := flag.Bool("debug", false, "Print plugin log messages to stderr")
+	trace := flag.Bool("trace", false, "Print the files each plugin received, rendered and changed to stderr")
+	flag.Usage = usage
+	args := parseInterspersed(os.Args[1:])
+
+	if len(args) != 1 {
+		usage()
+		os.Exit(2)
+	}
+
+	values := map[string]interface{}{}
+	for _, file := range valueFiles {
+		v, err := host.ReadValuesFile(file)
+		if err != nil {
+			fatal(err)
+		}
+		values = host.MergeValues(values, v)
+	}
+	for _, s := range setValues {
+		if err := host.ParseSet(s, values); err != nil {
+			fatal(err)
+		}
+	}
+
+	hostConfig, err := readHostConfig(hostConfigFiles)
+	if err != nil {
+		fatal(err)
+	}
+
+	cfg := config{
+		chartDir:   args[0],
+		pluginDir:  *pluginDir,
+		pluginsDir: *pluginsDir,
+		render: host.RenderOptions{
+			ReleaseName: *releaseName,
+			Namespace:   *namespace,
+			KubeVersion: *kubeVersion,
+			Values:      values,
+		},
+		hostConfig: hostConfig,
+		rawJSON:    *rawJSON,
+		debug:      *debug,
+		trace:      *trace,
+	}
+	if err := run(cfg); err != nil {
+		fatal(err)
+	}
+}
+
+// config holds the parsed command line.
+type config struct {
+	chartDir   string
+	pluginDir  string
+	pluginsDir string
+	render     host.RenderOptions
+	hostConfig map[string]string
+	rawJSON    bool
+	debug      bool
+	trace      bool
+}
+
+// readHostConfig reads the NAME=FILE arguments of -host-config-file into
+// host config values keyed by name.
+func readHostConfig(args []string) (map[string]string, error) {
+	config := make(map[string]string, len(args))
+	for _, arg := range args {
+		name, file, ok := strings.Cut(arg, "=")
+		if !ok || name == "" || file == "" {
+			return nil, fmt.Errorf("invalid -host-config-file %q, want NAME=FILE", arg)
+		}
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		config[name] = string(data)
+	}
+	return config, nil
+}
+
+// parseInterspersed parses flags given before or after positional
+// arguments, as helm template accepts them, and returns the positional ones.
+func parseInterspersed(args []string) []string {
+	var positional []string
+	for {
+		flag.CommandLine.Parse(args)
+		args = flag.Args()
+		if len(args) == 0 {
+			return positional
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+func run(cfg config) error {
+	ctx := context.Background()
+
+	chart, err := host.LoadChart(cfg.chartDir)
+	if err != nil {
+		return fmt.Errorf("failed to load chart %s: %w", cfg.chartDir, err)
+	}
+
+	stages, err := loadStages(chart, cfg)
+	if err != nil {
+		return err
+	}
+
+	// Warnings, such as policy violations that do not fail the render, are
+	// always shown; errors are already part of the error returned, and
+	// -debug shows every log message. Extism drops plugin log messages below
+	// its global level, which is off by default.
+	if cfg.debug {
+		extism.SetLogLevel(extism.LogLevelDebug)
+	} else {
+		extism.SetLogLevel(extism.LogLevelWarn)
+	}
+	runtime := &host.Runtime{
+		Logger: func(plugin string, level extism.LogLevel, msg string) {
+			if cfg.debug || level == extism.LogLevelWarn {
+				fmt.Fprintf(os.Stderr, "[%s] %s: %s\n", plugin, level, msg)
+			}
+		},
+		HostConfig: cfg.hostConfig,
+	}
+
+	res, err := runtime.RunPipeline(ctx, chart, stages, cfg.render)
+	if cfg.trace {
+		printTrace(res.Steps)
+	}
+
+	switch {
+	case cfg.rawJSON && cfg.pluginDir != "" && len(res.Steps) == 1:
+		printJSON(res.Steps[0].Result)
+	case cfg.rawJSON:
+		printJSON(&host.Result{Output: &host.OutputMessageRenderV1{RenderedFiles: res.RenderedFiles}})
+	case err == nil:
+		writeManifests(os.Stdout, chart.Metadata.Name, res.RenderedFiles)
+	}
+	return err
+}
+
+// loadStages returns the plugin given by -plugin, with the chart's config for
+// it if the chart lists it, or else every plugin the chart lists.
+func loadStages(chart *host.Chart, cfg config) ([]host.Stage, error) {
+	if cfg.pluginDir == "" {
+		if len(chart.Metadata.Plugins) == 0 {
+			return nil, fmt.Errorf("chart %s lists no plugins; use -plugin to choose one", chart.Metadata.Name)
+		}
+		return host.ChartStages(chart, cfg.pluginsDir)
+	}
+
+	plugin, err := host.LoadPlugin(cfg.pluginDir)
+	if err != nil {
+		return nil, err
+	}
+	stage := host.Stage{Plugin: plugin}
+	if spec := chart.PluginSpec(plugin.Metadata.Name); spec != nil {
+		stage.ChartConfig = spec.Config
+	}
+	return []host.Stage{stage}, nil
+}
+
+// printTrace prints each pipeline step to stderr.
+func printTrace(steps []host.Step) {
+	for i, step := range steps {
+		status := "ok"
+		if err := step.Result.Err(); err != nil {
+			status = "failed"
+		}
+		fmt.Fprintf(os.Stderr, "Step %d: %s (%s)\n", i+1, step.Plugin, status)
+		printNames("received", step.SourceFiles)
+		printNames("rendered", step.RenderedFiles)
+		if len(step.RemovedRenderedFiles) > 0 {
+			printNames("deleted", step.RemovedRenderedFiles)
+		}
+		if step.ModifiedValues {
+			fmt.Fprintf(os.Stderr, "  values replaced for later plugins\n")
+		}
+
+		c := step.Changes
+		if c == nil || c.Empty() {
+			fmt.Fprintf(os.Stderr, "  source files passed on unchanged\n")
+			continue
+		}
+		printNames("added", c.Added)
+		printNames("removed", c.Removed)
+		printNames("modified", c.Modified)
+		for _, r := range c.Renamed {
+			fmt.Fprintf(os.Stderr, "  renamed:  %s -> %s\n", r.From, r.To)
+		}
+	}
+}
+
+func printNames(label string, names []string) {
+	if len(names) == 0 {
+		fmt.Fprintf(os.Stderr, "  %-9s (none)\n", label+":")
+		return
+	}
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %-9s %s\n", label+":", name)
+	}
+}
+
+// writeManifests writes rendered files the way helm template prints them.
+func writeManifests(w io.Writer, chartName string, files map[string]string) {
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		content := strings.TrimSpace(files[name])
+		if content == "" {
+			continue
+		}
+		fmt.Fprintf(w, "---\n# Source: %s\n%s\n", filepath.ToSlash(filepath.Join(chartName, name)), content)
+	}
+}
+
+func printJSON(res *host.Result) {
+	if res.Output == nil {
+		os.Stdout.Write(res.Raw)
+		fmt.Println()
+		return
+	}
+	data, err := json.MarshalIndent(res.Output, "", "  ")
+	if err != nil {
+		fatal(err)
+	}
+	fmt.Println(string(data))
+}
+
+func fatal(err error) {
+	fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+	os.Exit(1)
+}
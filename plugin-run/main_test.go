@@ -0,0 +1,78 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/scottrigby/ref-hip-chart-defined-plugins/plugin-run/host"
+)
+
+var (
+	update  = flag.Bool("update", false, "Update the golden files in testdata/golden and the fuzz seeds in testdata/seeds")
+	budgets = flag.Bool("budgets", false, "Check plugin sizes against testdata/budgets.yaml")
+	timings = flag.Bool("timings", false, "Check plugin compile and render times against testdata/budgets.yaml")
+)
+
+// buildRoot holds the plugins built by buildPlugins, one directory per
+// toolchain, for the lifetime of the test binary.
+var (
+	buildRoot string
+	buildMu   sync.Mutex
+	built     = map[host.Toolchain]string{}
+)
+
+func TestMain(m *testing.M) {
+	flag.Parse()
+	dir, err := os.MkdirTemp("", "plugin-run-test-")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	buildRoot = dir
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// buildPlugins builds every plugin in plugins/ with tc, once per test binary,
+// and returns the directory holding them, laid out like plugins/.
+func buildPlugins(tb testing.TB, tc host.Toolchain) string {
+	tb.Helper()
+	buildMu.Lock()
+	defer buildMu.Unlock()
+	if dir, ok := built[tc]; ok {
+		return dir
+	}
+
+	src := filepath.Join("..", "plugins")
+	entries, err := os.ReadDir(src)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	dst := filepath.Join(buildRoot, string(tc))
+	for _, entry := range entries {
+		if _, err := os.Stat(filepath.Join(src, entry.Name(), "go.mod")); err != nil {
+			continue
+		}
+		if err := host.BuildPluginWith(tc, filepath.Join(src, entry.Name()), filepath.Join(dst, entry.Name())); err != nil {
+			tb.Fatal(err)
+		}
+	}
+	built[tc] = dst
+	return dst
+}
+
+// testHostConfig returns the host config the tests pass plugins: the test
+// age identity decrypting the SOPS files of charts/sops-decrypt-chart.
+func testHostConfig(tb testing.TB) map[string]string {
+	tb.Helper()
+	key, err := os.ReadFile(filepath.Join("testdata", "age-key.txt"))
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return map[string]string{"sops_age_key": string(key)}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"testing"
+
+	"github.com/scottrigby/ref-hip-chart-defined-plugins/plugin-run/host"
+)
+
+// seedPatterns select every chart template as a source file.
+var seedPatterns = []string{"templates/*", "templates/**/*"}
+
+// TestFuzzSeeds records, for every example chart, the render/v1 input
+// plugin-run passes a plugin, with every template both as a source file and
+// as a rendered file, in testdata/seeds/<chart>.json. The plugins' fuzz
+// targets share these as their seed corpus, adding their own config.
+// Run with -update after changing a chart.
+func TestFuzzSeeds(t *testing.T) {
+	charts, err := filepath.Glob(filepath.Join("..", "charts", "*", "Chart.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(charts) == 0 {
+		t.Fatal("no charts found")
+	}
+
+	for _, chartFile := range charts {
+		chartDir := filepath.Dir(chartFile)
+		t.Run(filepath.Base(chartDir), func(t *testing.T) {
+			chart, err := host.LoadChart(chartDir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			input, err := host.NewInput(chart, host.RenderOptions{ReleaseName: "fuzz", Namespace: "default"}, nil, seedPatterns)
+			if err != nil {
+				t.Fatal(err)
+			}
+			input.RenderedFiles = map[string]string{}
+			for _, f := range input.SourceFiles {
+				input.RenderedFiles[f.Name] = string(f.Data)
+			}
+
+			got, err := json.MarshalIndent(input, "", "  ")
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkGolden(t, filepath.Join("testdata", "seeds", filepath.Base(chartDir)+".json"), append(got, '\n'))
+		})
+	}
+}
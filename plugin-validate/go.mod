@@ -0,0 +1,17 @@
+module github.com/scottrigby/ref-hip-chart-defined-plugins/plugin-validate
+
+go 1.24.0
+
+require (
+	github.com/santhosh-tekuri/jsonschema/v6 v6.0.3
+	github.com/scottrigby/ref-hip-chart-defined-plugins/plugin-run v0.0.0
+	sigs.k8s.io/yaml v1.6.0
+)
+
+require (
+	go.yaml.in/yaml/v2 v2.4.2 // indirect
+	golang.org/x/text v0.17.0 // indirect
+)
+
+// plugin-run shares its configSchema validation, in this repository.
+replace github.com/scottrigby/ref-hip-chart-defined-plugins/plugin-run => ../plugin-run
@@ -0,0 +1,287 @@
+// plugin-validate checks the plugins and charts in this repository: every
+// plugin.yaml must carry the required metadata, uniquely named hostConfig
+// entries and a valid configSchema that its own config conforms to, and every
+// chart's plugin entries must match the version and type of the plugin they
+// name, with config conforming to its configSchema.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+
+	"github.com/santhosh-tekuri/jsonschema/v6"
+	"sigs.k8s.io/yaml"
+
+	"github.com/scottrigby/ref-hip-chart-defined-plugins/plugin-run/configschema"
+)
+
+// PluginMetadata is the subset of plugin.yaml checked by this tool.
+type PluginMetadata struct {
+	APIVersion   string                 `json:"apiVersion"`
+	Name         string                 `json:"name"`
+	Version      string                 `json:"version"`
+	Type         string                 `json:"type"`
+	Runtime      string                 `json:"runtime"`
+	Config       map[string]interface{} `json:"config"`
+	ConfigSchema map[string]interface{} `json:"configSchema"`
+	HostConfig   []HostConfigKey        `json:"hostConfig"`
+}
+
+// HostConfigKey is a hostConfig entry in plugin.yaml, naming a value the
+// host passes the plugin outside the chart.
+type HostConfigKey struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+// hostConfigName matches valid hostConfig names.
+var hostConfigName = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+
+// ChartMetadata is the subset of Chart.yaml checked by this tool.
+type ChartMetadata struct {
+	APIVersion string            `json:"apiVersion"`
+	Name       string            `json:"name"`
+	Version    string            `json:"version"`
+	Plugins    []ChartPluginSpec `json:"plugins"`
+}
+
+// ChartPluginSpec is a plugins entry in Chart.yaml.
+type ChartPluginSpec struct {
+	Name       string                 `json:"name"`
+	Type       string                 `json:"type"`
+	Repository string                 `json:"repository"`
+	Version    string                 `json:"version"`
+	Config     map[string]interface{} `json:"config"`
+}
+
+// Plugin is a plugin directory with its parsed metadata and compiled schema.
+type Plugin struct {
+	Dir      string
+	Metadata PluginMetadata
+	Schema   *jsonschema.Schema
+}
+
+// Problem is a single validation failure in a file.
+type Problem struct {
+	File    string
+	Message string
+}
+
+// Validator collects problems found while validating a repository.
+type Validator struct {
+	Root        string
+	RequireWasm bool
+	Problems    []Problem
+}
+
+func (v *Validator) report(file, format string, args ...interface{}) {
+	rel, err := filepath.Rel(v.Root, file)
+	if err != nil {
+		rel = file
+	}
+	v.Problems = append(v.Problems, Problem{File: rel, Message: fmt.Sprintf(format, args...)})
+}
+
+// field is a named metadata value that must not be empty.
+type field struct {
+	name  string
+	value string
+}
+
+func (v *Validator) requireFields(file, prefix string, fields []field) {
+	for _, f := range fields {
+		if f.value == "" {
+			v.report(file, "%smissing %s", prefix, f.name)
+		}
+	}
+}
+
+// validatePlugins validates every plugin directory and returns the plugins
+// that loaded, keyed by name.
+func (v *Validator) validatePlugins() map[string]*Plugin {
+	plugins := make(map[string]*Plugin)
+
+	dirs, err := subdirectories(filepath.Join(v.Root, "plugins"))
+	if err != nil {
+		v.report(filepath.Join(v.Root, "plugins"), "%v", err)
+		return plugins
+	}
+
+	for _, dir := range dirs {
+		if p := v.validatePlugin(dir); p != nil {
+			plugins[p.Metadata.Name] = p
+		}
+	}
+	return plugins
+}
+
+func (v *Validator) validatePlugin(dir string) *Plugin {
+	file := filepath.Join(dir, "plugin.yaml")
+	p := &Plugin{Dir: dir}
+	if err := readYAML(file, &p.Metadata); err != nil {
+		v.report(file, "%v", err)
+		return nil
+	}
+	md := p.Metadata
+
+	v.requireFields(file, "", []field{
+		{"apiVersion", md.APIVersion},
+		{"name", md.Name},
+		{"version", md.Version},
+		{"type", md.Type},
+		{"runtime", md.Runtime},
+	})
+	if md.Name != "" && md.Name != filepath.Base(dir) {
+		v.report(file, "name %q does not match directory %q", md.Name, filepath.Base(dir))
+	}
+
+	if v.RequireWasm && md.Runtime == "extism/v1" {
+		if _, err := os.Stat(filepath.Join(dir, "plugin.wasm")); err != nil {
+			v.report(file, "missing plugin.wasm for extism/v1 plugin %s", md.Name)
+		}
+	}
+
+	seen := make(map[string]bool)
+	for i, key := range md.HostConfig {
+		switch {
+		case !hostConfigName.MatchString(key.Name):
+			v.report(file, "hostConfig[%d]: invalid name %q", i, key.Name)
+		case seen[key.Name]:
+			v.report(file, "hostConfig[%d]: duplicate name %q", i, key.Name)
+		}
+		seen[key.Name] = true
+	}
+
+	if md.ConfigSchema == nil {
+		v.report(file, "missing configSchema")
+		return p
+	}
+	schema, err := configschema.Compile(file, md.ConfigSchema)
+	if err != nil {
+		v.report(file, "invalid configSchema: %v", err)
+		return p
+	}
+	p.Schema = schema
+
+	for _, msg := range configschema.Validate(schema, md.Config) {
+		v.report(file, "config%s", msg)
+	}
+	return p
+}
+
+// validateCharts validates every chart directory, checking plugin config
+// against the schemas of the given plugins, and returns the number of charts.
+func (v *Validator) validateCharts(plugins map[string]*Plugin) int {
+	dirs, err := subdirectories(filepath.Join(v.Root, "charts"))
+	if err != nil {
+		v.report(filepath.Join(v.Root, "charts"), "%v", err)
+		return 0
+	}
+
+	for _, dir := range dirs {
+		v.validateChart(dir, plugins)
+	}
+	return len(dirs)
+}
+
+func (v *Validator) validateChart(dir string, plugins map[string]*Plugin) {
+	file := filepath.Join(dir, "Chart.yaml")
+	var md ChartMetadata
+	if err := readYAML(file, &md); err != nil {
+		v.report(file, "%v", err)
+		return
+	}
+
+	v.requireFields(file, "", []field{
+		{"apiVersion", md.APIVersion},
+		{"name", md.Name},
+		{"version", md.Version},
+	})
+
+	for i, spec := range md.Plugins {
+		v.requireFields(file, fmt.Sprintf("plugins[%d]: ", i), []field{
+			{"name", spec.Name},
+			{"type", spec.Type},
+			{"repository", spec.Repository},
+			{"version", spec.Version},
+		})
+
+		p, ok := plugins[spec.Name]
+		if !ok {
+			v.report(file, "plugins[%d]: unknown plugin %q", i, spec.Name)
+			continue
+		}
+		if spec.Type != "" && spec.Type != p.Metadata.Type {
+			v.report(file, "plugins[%d]: type %q does not match plugin type %q", i, spec.Type, p.Metadata.Type)
+		}
+		if spec.Version != "" && p.Metadata.Version != "" && spec.Version != p.Metadata.Version {
+			v.report(file, "plugins[%d]: version %s does not match plugin version %s", i, spec.Version, p.Metadata.Version)
+		}
+		if spec.Config == nil || p.Schema == nil {
+			continue
+		}
+		for _, msg := range configschema.Validate(p.Schema, spec.Config) {
+			v.report(file, "plugins[%d].config%s", i, msg)
+		}
+	}
+}
+
+func readYAML(file string, out interface{}) error {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	if err := yaml.Unmarshal(data, out); err != nil {
+		return fmt.Errorf("failed to parse: %w", err)
+	}
+	return nil
+}
+
+func subdirectories(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var dirs []string
+	for _, entry := range entries {
+		if entry.IsDir() {
+			dirs = append(dirs, filepath.Join(dir, entry.Name()))
+		}
+	}
+	return dirs, nil
+}
+
+func main() {
+	root := flag.String("root", "..", "Repository root containing plugins/ and charts/")
+	requireWasm := flag.Bool("require-wasm", false, "Require plugin.wasm for extism/v1 plugins")
+	flag.Parse()
+
+	absRoot, err := filepath.Abs(*root)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	v := &Validator{Root: absRoot, RequireWasm: *requireWasm}
+	plugins := v.validatePlugins()
+	charts := v.validateCharts(plugins)
+
+	// Emit GitHub Actions annotations when running in CI
+	annotate := os.Getenv("GITHUB_ACTIONS") == "true"
+	for _, p := range v.Problems {
+		if annotate {
+			fmt.Printf("::error file=%s::%s\n", p.File, p.Message)
+		} else {
+			fmt.Printf("%s: %s\n", p.File, p.Message)
+		}
+	}
+
+	if len(v.Problems) > 0 {
+		fmt.Fprintf(os.Stderr, "%d problem(s) found\n", len(v.Problems))
+		os.Exit(1)
+	}
+	fmt.Printf("Validated %d plugins and %d charts\n", len(plugins), charts)
+}
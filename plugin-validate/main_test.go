@@ -0,0 +1,200 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testSchema is a configSchema shared by the tests below.
+const testSchema = `
+configSchema:
+  type: object
+  additionalProperties: false
+  properties:
+    patterns:
+      type: array
+      items:
+        type: string
+    delimiters:
+      type: object
+      properties:
+        left:
+          type: string
+`
+
+// writeFile writes data to name under dir, creating its directory.
+func writeFile(t *testing.T, dir, name, data string) string {
+	t.Helper()
+	file := filepath.Join(dir, name)
+	if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(file, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+// checkProblems fails the test unless the problems are exactly want, each
+// given as "file: message" with file relative to the validator root.
+func checkProblems(t *testing.T, problems []Problem, want []string) {
+	t.Helper()
+	var got []string
+	for _, p := range problems {
+		got = append(got, filepath.ToSlash(p.File)+": "+p.Message)
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("problems:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestValidatePlugin(t *testing.T) {
+	tests := []struct {
+		name string
+		dir  string
+		yaml string
+		// wantSchema reports whether the plugin's schema should compile.
+		wantSchema bool
+		want       []string
+	}{
+		{
+			name:       "valid",
+			dir:        "echo",
+			yaml:       "apiVersion: v1\nname: echo\nversion: 0.1.0\ntype: render/v1\nruntime: extism/v1\nconfig:\n  patterns: []\n" + testSchema,
+			wantSchema: true,
+		},
+		{
+			name:       "missing fields",
+			dir:        "echo",
+			yaml:       "apiVersion: v1\nname: echo\ntype: render/v1\n" + testSchema,
+			wantSchema: true,
+			want: []string{
+				"plugins/echo/plugin.yaml: missing version",
+				"plugins/echo/plugin.yaml: missing runtime",
+			},
+		},
+		{
+			name:       "name does not match directory",
+			dir:        "echo-render",
+			yaml:       "apiVersion: v1\nname: echo\nversion: 0.1.0\ntype: render/v1\nruntime: extism/v1\n" + testSchema,
+			wantSchema: true,
+			want:       []string{`plugins/echo-render/plugin.yaml: name "echo" does not match directory "echo-render"`},
+		},
+		{
+			name: "invalid and duplicate hostConfig names",
+			dir:  "echo",
+			yaml: "apiVersion: v1\nname: echo\nversion: 0.1.0\ntype: render/v1\nruntime: extism/v1\n" +
+				"hostConfig:\n  - name: api_key\n  - name: Api-Key\n  - name: api_key\n" + testSchema,
+			wantSchema: true,
+			want: []string{
+				`plugins/echo/plugin.yaml: hostConfig[1]: invalid name "Api-Key"`,
+				`plugins/echo/plugin.yaml: hostConfig[2]: duplicate name "api_key"`,
+			},
+		},
+		{
+			name: "missing configSchema",
+			dir:  "echo",
+			yaml: "apiVersion: v1\nname: echo\nversion: 0.1.0\ntype: render/v1\nruntime: extism/v1\n",
+			want: []string{"plugins/echo/plugin.yaml: missing configSchema"},
+		},
+		{
+			name:       "config violating its own schema",
+			dir:        "echo",
+			yaml:       "apiVersion: v1\nname: echo\nversion: 0.1.0\ntype: render/v1\nruntime: extism/v1\nconfig:\n  patterns: [1]\n" + testSchema,
+			wantSchema: true,
+			want:       []string{"plugins/echo/plugin.yaml: config/patterns/0: got number, want string"},
+		},
+		{
+			name: "unparsable",
+			dir:  "echo",
+			yaml: "name: [",
+			want: []string{"plugins/echo/plugin.yaml: failed to parse: error converting YAML to JSON: yaml: line 1: did not find expected node content"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := t.TempDir()
+			writeFile(t, root, filepath.Join("plugins", tt.dir, "plugin.yaml"), tt.yaml)
+
+			v := &Validator{Root: root}
+			p := v.validatePlugin(filepath.Join(root, "plugins", tt.dir))
+			checkProblems(t, v.Problems, tt.want)
+			if got := p != nil && p.Schema != nil; got != tt.wantSchema {
+				t.Errorf("compiled schema = %v, want %v", got, tt.wantSchema)
+			}
+		})
+	}
+}
+
+func TestValidateChart(t *testing.T) {
+	// The echo plugin every chart below refers to
+	root := t.TempDir()
+	writeFile(t, root, "plugins/echo/plugin.yaml",
+		"apiVersion: v1\nname: echo\nversion: 0.1.0\ntype: render/v1\nruntime: extism/v1\n"+testSchema)
+	v := &Validator{Root: root}
+	echo := v.validatePlugin(filepath.Join(root, "plugins", "echo"))
+	checkProblems(t, v.Problems, nil)
+	plugins := map[string]*Plugin{"echo": echo}
+
+	const header = "apiVersion: v3\nname: chart\nversion: 1.0.0\n"
+	const entry = "  - name: echo\n    type: render/v1\n    repository: oci://example.com/echo\n    version: 0.1.0\n"
+	tests := []struct {
+		name  string
+		chart string
+		want  []string
+	}{
+		{
+			name:  "valid",
+			chart: header + "plugins:\n" + entry + "    config:\n      delimiters:\n        left: \"<%\"\n",
+		},
+		{
+			name:  "without plugins",
+			chart: header,
+		},
+		{
+			name:  "missing fields",
+			chart: "apiVersion: v3\nname: chart\nplugins:\n  - name: echo\n    type: render/v1\n",
+			want: []string{
+				"charts/chart/Chart.yaml: missing version",
+				"charts/chart/Chart.yaml: plugins[0]: missing repository",
+				"charts/chart/Chart.yaml: plugins[0]: missing version",
+			},
+		},
+		{
+			name:  "unknown plugin",
+			chart: header + "plugins:\n  - name: other\n    type: render/v1\n    repository: oci://example.com/other\n    version: 0.1.0\n",
+			want:  []string{`charts/chart/Chart.yaml: plugins[0]: unknown plugin "other"`},
+		},
+		{
+			name:  "type mismatch",
+			chart: header + "plugins:\n  - name: echo\n    type: render/v2\n    repository: oci://example.com/echo\n    version: 0.1.0\n",
+			want:  []string{`charts/chart/Chart.yaml: plugins[0]: type "render/v2" does not match plugin type "render/v1"`},
+		},
+		{
+			name:  "version mismatch",
+			chart: header + "plugins:\n  - name: echo\n    type: render/v1\n    repository: oci://example.com/echo\n    version: 0.0.9\n",
+			want:  []string{"charts/chart/Chart.yaml: plugins[0]: version 0.0.9 does not match plugin version 0.1.0"},
+		},
+		{
+			name:  "config violating the plugin's schema",
+			chart: header + "plugins:\n" + entry + "    config:\n      delimiters:\n        left: 1\n      extra: true\n",
+			want: []string{
+				"charts/chart/Chart.yaml: plugins[0].config/delimiters/left: got number, want string",
+				"charts/chart/Chart.yaml: plugins[0].config: additional properties 'extra' not allowed",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			writeFile(t, root, "charts/chart/Chart.yaml", tt.chart)
+
+			v := &Validator{Root: root}
+			v.validateChart(filepath.Join(root, "charts", "chart"), plugins)
+			checkProblems(t, v.Problems, tt.want)
+		})
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// chartSeeds returns the example chart inputs recorded by plugin-run in
+// plugin-run/testdata/seeds, one per chart, each passed to configure first
+// if it is not nil.
+func chartSeeds(tb testing.TB, configure func(*InputMessageRenderV1)) [][]byte {
+	files, err := filepath.Glob(filepath.Join("..", "..", "plugin-run", "testdata", "seeds", "*.json"))
+	if err != nil || len(files) == 0 {
+		tb.Fatalf("no seeds in plugin-run/testdata/seeds: %v", err)
+	}
+
+	var seeds [][]byte
+	for _, file := range files {
+		var input InputMessageRenderV1
+		data, err := os.ReadFile(file)
+		if err == nil {
+			err = json.Unmarshal(data, &input)
+		}
+		if err != nil {
+			tb.Fatalf("%s: %v", file, err)
+		}
+		if configure != nil {
+			configure(&input)
+		}
+
+		seed, err := json.Marshal(input)
+		if err != nil {
+			tb.Fatal(err)
+		}
+		seeds = append(seeds, seed)
+	}
+	return seeds
+}
+
+func FuzzRender(f *testing.F) {
+	// Common labels and annotations overwrite those the templates set
+	for _, seed := range chartSeeds(f, func(input *InputMessageRenderV1) {
+		input.Config = PluginConfig{
+			CommonLabels:      map[string]string{"app.kubernetes.io/name": "fuzz", "team": "fuzz"},
+			CommonAnnotations: map[string]string{"example.com/note": "fuzz"},
+			Overwrite:         true,
+		}
+	}) {
+		f.Add(seed)
+	}
+	f.Add([]byte(``))
+	f.Add([]byte(`{}`))
+	f.Add([]byte(`{"renderedFiles": {"templates/a.yaml": "kind: CronJob\nmetadata: {name: a, labels: {x: 1}}\nspec: {jobTemplate: {spec: {selector: {matchExpressions: [{key: x}]}, template: {metadata: {labels: {x: [2]}}}}}}\n---\nkind: ReplicationController\nspec: {selector: {x: y}, template: null}\n"}, "config": {"commonLabels": {"x": "z"}, "overwrite": true}}`))
+
+	f.Fuzz(func(t *testing.T, input []byte) {
+		output, code := render(input)
+
+		data, err := json.Marshal(output)
+		if err != nil || !json.Valid(data) {
+			t.Fatalf("output is not valid JSON: %v", err)
+		}
+		if (code != 0) != (len(output.Errors) > 0) {
+			t.Fatalf("exit code %d with errors %q", code, output.Errors)
+		}
+	})
+}
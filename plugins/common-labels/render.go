@@ -0,0 +1,466 @@
+// Package main implements a render/v1 plugin that adds common labels and
+// annotations to rendered manifests.
+//
+// The plugin runs after the plugins rendering a chart's templates and
+// receives the files they rendered as renderedFiles. It adds the standard
+// app.kubernetes.io and helm.sh/chart labels, derived from the release and
+// chart, and the commonLabels and commonAnnotations in its config to every
+// rendered Kubernetes object, and to the Pod templates of workloads. Unlike
+// Kustomize's commonLabels, selectors are never changed: they are immutable
+// once a workload is created, and a label added to both a selector and its
+// Pod template would make upgrades of existing releases fail. For the same
+// reason a Pod template label a selector matches is not overwritten. The
+// files holding changed objects are returned as renderedFiles, replacing the
+// originals; the plugin renders no templates of its own.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/scottrigby/ref-hip-chart-defined-plugins/renderv1"
+	"go.yaml.in/yaml/v3"
+)
+
+// ReleaseInfo contains release metadata passed to render plugins.
+type ReleaseInfo struct {
+	Name      string `json:"name"`
+	Namespace string `json:"namespace"`
+	Revision  int    `json:"revision"`
+	IsInstall bool   `json:"isInstall"`
+	IsUpgrade bool   `json:"isUpgrade"`
+	Service   string `json:"service"`
+}
+
+// ChartInfo contains chart metadata passed to render plugins.
+type ChartInfo struct {
+	Name        string `json:"name"`
+	Version     string `json:"version"`
+	AppVersion  string `json:"appVersion,omitempty"`
+	Description string `json:"description,omitempty"`
+	Type        string `json:"type,omitempty"`
+	IsRoot      bool   `json:"isRoot"`
+}
+
+// SourceFile represents a file in the chart.
+type SourceFile struct {
+	Name string `json:"name"`
+	Data []byte `json:"data"`
+}
+
+// InputMessageRenderV1 is the input message for render/v1 plugins.
+type InputMessageRenderV1 struct {
+	Release     ReleaseInfo  `json:"release"`
+	Chart       ChartInfo    `json:"chart"`
+	SourceFiles []SourceFile `json:"sourceFiles"`
+	// RenderedFiles are the files rendered by the plugins before this one.
+	RenderedFiles map[string]string `json:"renderedFiles"`
+	Config        PluginConfig      `json:"config"`
+}
+
+// PluginConfig is the chart's configuration for this plugin, validated by
+// Helm against the configSchema in plugin.yaml.
+type PluginConfig struct {
+	// StandardLabels adds the labels returned by standardLabels. Nil means
+	// true.
+	StandardLabels    *bool             `json:"standardLabels"`
+	CommonLabels      map[string]string `json:"commonLabels"`
+	CommonAnnotations map[string]string `json:"commonAnnotations"`
+	// Overwrite replaces labels and annotations the templates already set,
+	// except Pod template labels matched by a selector.
+	Overwrite bool `json:"overwrite"`
+}
+
+// OutputMessageRenderV1 is the output message from render/v1 plugins.
+type OutputMessageRenderV1 struct {
+	RenderedFiles map[string]string `json:"renderedFiles"`
+	Errors        []string          `json:"errors,omitempty"`
+}
+
+// podTemplatePaths are the paths to the Pod templates, and other object
+// templates, in the objects of each kind holding them. The selector of a
+// template, if any, is the selector field next to it.
+var podTemplatePaths = map[string][][]string{
+	"PodTemplate":           {{"template"}},
+	"ReplicationController": {{"spec", "template"}},
+	"ReplicaSet":            {{"spec", "template"}},
+	"Deployment":            {{"spec", "template"}},
+	"StatefulSet":           {{"spec", "template"}},
+	"DaemonSet":             {{"spec", "template"}},
+	"Job":                   {{"spec", "template"}},
+	"CronJob":               {{"spec", "jobTemplate"}, {"spec", "jobTemplate", "spec", "template"}},
+}
+
+// render adds the labels and annotations to the rendered files of a raw
+// render/v1 input message and returns the output message along with the exit
+// code the plugin returns to Helm.
+func render(inputBytes []byte) (OutputMessageRenderV1, uint32) {
+	if len(inputBytes) == 0 {
+		return errorOutput("no input provided")
+	}
+
+	var input InputMessageRenderV1
+	if err := json.Unmarshal(inputBytes, &input); err != nil {
+		return errorOutput(fmt.Sprintf("failed to parse input: %v", err))
+	}
+
+	var labels []entry
+	if input.Config.StandardLabels == nil || *input.Config.StandardLabels {
+		labels = standardLabels(input.Release, input.Chart)
+	}
+	common, errs := sortedEntries("commonLabels", input.Config.CommonLabels, true)
+	labels = append(labels, common...)
+	annotations, annotationErrs := sortedEntries("commonAnnotations", input.Config.CommonAnnotations, false)
+	errs = append(errs, annotationErrs...)
+	if errs != nil {
+		return OutputMessageRenderV1{RenderedFiles: make(map[string]string), Errors: errs}, 1
+	}
+	logf("Adding %d labels and %d annotations to %d rendered files", len(labels), len(annotations), len(input.RenderedFiles))
+	if len(labels) == 0 && len(annotations) == 0 {
+		return OutputMessageRenderV1{RenderedFiles: make(map[string]string)}, 0
+	}
+
+	manifests, errs := parseManifests(input.RenderedFiles)
+	if errs != nil {
+		return OutputMessageRenderV1{RenderedFiles: make(map[string]string), Errors: errs}, 1
+	}
+
+	output := OutputMessageRenderV1{
+		RenderedFiles: make(map[string]string),
+	}
+	for _, m := range manifests {
+		for _, doc := range m.docs {
+			if err := doc.apply(labels, annotations, input.Config.Overwrite); err != nil {
+				output.Errors = append(output.Errors, fmt.Sprintf("%s: %s: %v", m.name, doc.id, err))
+			}
+		}
+	}
+	if len(output.Errors) > 0 {
+		return output, 1
+	}
+
+	for _, m := range manifests {
+		content, modified, err := m.encode()
+		if err != nil {
+			output.Errors = append(output.Errors, fmt.Sprintf("%s: %v", m.name, err))
+			continue
+		}
+		if modified {
+			output.RenderedFiles[m.name] = content
+		}
+	}
+
+	// Helm treats a non-zero exit code as the signal that Errors is set
+	if len(output.Errors) > 0 {
+		return output, 1
+	}
+	return output, 0
+}
+
+// entry is a label or annotation added to rendered objects.
+type entry struct {
+	key, value string
+}
+
+// standardLabels returns the labels Kubernetes recommends and helm create
+// adds, with the same values, in the order they are added. Labels whose
+// value would be empty are left out.
+func standardLabels(release ReleaseInfo, chart ChartInfo) []entry {
+	var labels []entry
+	if chart.Name != "" {
+		labels = append(labels, entry{"app.kubernetes.io/name", truncate(chart.Name)})
+	}
+	if release.Name != "" {
+		labels = append(labels, entry{"app.kubernetes.io/instance", release.Name})
+	}
+	if chart.AppVersion != "" {
+		labels = append(labels, entry{"app.kubernetes.io/version", chart.AppVersion})
+	}
+	service := release.Service
+	if service == "" {
+		service = "Helm"
+	}
+	labels = append(labels, entry{"app.kubernetes.io/managed-by", service})
+	if chart.Name != "" {
+		labels = append(labels, entry{"helm.sh/chart", truncate(chart.Name + "-" + strings.ReplaceAll(chart.Version, "+", "_"))})
+	}
+	return labels
+}
+
+// truncate shortens s to the 63 characters a label value may hold, as
+// helm create's templates do.
+func truncate(s string) string {
+	if len(s) > 63 {
+		s = strings.TrimSuffix(s[:63], "-")
+	}
+	return s
+}
+
+var (
+	labelName   = regexp.MustCompile(`^[A-Za-z0-9]([-A-Za-z0-9_.]*[A-Za-z0-9])?$`)
+	labelPrefix = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?(\.[a-z0-9]([-a-z0-9]*[a-z0-9])?)*$`)
+)
+
+// sortedEntries checks the keys of m, and if isLabel its values, against the
+// Kubernetes syntax of labels and annotations, and returns its entries by
+// key.
+func sortedEntries(field string, m map[string]string, isLabel bool) ([]entry, []string) {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var entries []entry
+	var errs []string
+	for _, k := range keys {
+		if err := checkKey(k); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %q: %v", field, k, err))
+			continue
+		}
+		if v := m[k]; isLabel && v != "" && (len(v) > 63 || !labelName.MatchString(v)) {
+			errs = append(errs, fmt.Sprintf("%s: %q: invalid value %q: at most 63 alphanumeric characters, '-', '_' or '.', starting and ending with an alphanumeric character", field, k, v))
+			continue
+		}
+		entries = append(entries, entry{k, m[k]})
+	}
+	return entries, errs
+}
+
+// checkKey checks a label or annotation key: a name, optionally prefixed by
+// a DNS subdomain and a slash.
+func checkKey(k string) error {
+	prefix, name := "", k
+	if i := strings.LastIndex(k, "/"); i >= 0 {
+		prefix, name = k[:i], k[i+1:]
+		if prefix == "" || len(prefix) > 253 || !labelPrefix.MatchString(prefix) {
+			return fmt.Errorf("invalid prefix %q: a lowercase DNS subdomain", prefix)
+		}
+	}
+	if len(name) > 63 || !labelName.MatchString(name) {
+		return fmt.Errorf("invalid name %q: at most 63 alphanumeric characters, '-', '_' or '.', starting and ending with an alphanumeric character", name)
+	}
+	return nil
+}
+
+// manifest is a rendered file split into YAML documents.
+type manifest struct {
+	name  string
+	parts []renderv1.RenderedDocument
+	docs  []*document
+}
+
+// document is a Kubernetes object in a manifest.
+type document struct {
+	// part is the index of the document in manifest.parts.
+	part int
+	// id is the object's kind and name, for messages.
+	id       string
+	kind     string
+	root     *yaml.Node
+	modified bool
+}
+
+// parseManifests splits the rendered YAML files into documents, in file name
+// order, and parses the Kubernetes objects among them. Documents that are
+// not objects are kept as they are, and empty ones dropped.
+func parseManifests(rendered map[string]string) ([]*manifest, []string) {
+	names := make([]string, 0, len(rendered))
+	for name := range rendered {
+		switch path.Ext(name) {
+		case ".yaml", ".yml", ".json":
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	var manifests []*manifest
+	var errs []string
+	for _, name := range names {
+		m := &manifest{name: name, parts: renderv1.SplitDocuments(name, rendered[name])}
+		for i, part := range m.parts {
+			var doc yaml.Node
+			if err := yaml.Unmarshal([]byte(part.Content), &doc); err != nil {
+				errs = append(errs, fmt.Sprintf("%s: document %d is not valid YAML: %v", name, i, err))
+				continue
+			}
+			if len(doc.Content) == 0 || doc.Content[0].Kind != yaml.MappingNode || renderv1.ScalarValue(doc.Content[0], "kind") == "" {
+				continue
+			}
+			root := doc.Content[0]
+			kind := renderv1.ScalarValue(root, "kind")
+			m.docs = append(m.docs, &document{
+				part: i,
+				id:   kind + "/" + renderv1.ScalarValue(renderv1.MappingValue(root, "metadata"), "name"),
+				kind: kind,
+				root: root,
+			})
+		}
+		manifests = append(manifests, m)
+	}
+	return manifests, errs
+}
+
+// apply adds labels and annotations to the object's metadata and to the
+// metadata of its templates. Selectors are left as they are, and a template
+// label its selector matches is kept even when overwrite is set.
+func (d *document) apply(labels, annotations []entry, overwrite bool) error {
+	if err := d.set(d.root, "metadata", labels, annotations, overwrite, nil); err != nil {
+		return err
+	}
+	for _, p := range podTemplatePaths[d.kind] {
+		parent := d.root
+		for _, key := range p[:len(p)-1] {
+			parent = renderv1.MappingValue(parent, key)
+		}
+		template := renderv1.MappingValue(parent, p[len(p)-1])
+		if template == nil || template.Kind != yaml.MappingNode {
+			continue
+		}
+		where := strings.Join(p, ".") + ".metadata"
+		if err := d.set(template, where, labels, annotations, overwrite, selectorLabels(renderv1.MappingValue(parent, "selector"))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// set adds labels and annotations to the metadata of obj, described by where
+// in messages. Labels in keep are not overwritten.
+func (d *document) set(obj *yaml.Node, where string, labels, annotations []entry, overwrite bool, keep map[string]bool) error {
+	metadata, err := childMapping(obj, "metadata", "spec")
+	if err != nil {
+		return err
+	}
+	for _, field := range []struct {
+		name    string
+		entries []entry
+	}{{"labels", labels}, {"annotations", annotations}} {
+		if len(field.entries) == 0 {
+			continue
+		}
+		m, err := childMapping(metadata, field.name, "")
+		if err != nil {
+			return fmt.Errorf("%s.%v", where, err)
+		}
+		for _, e := range field.entries {
+			value := renderv1.MappingValue(m, e.key)
+			switch {
+			case value == nil:
+				m.Content = append(m.Content,
+					&yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: e.key},
+					&yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: e.value},
+				)
+			case !overwrite || value.Kind == yaml.ScalarNode && value.Value == e.value:
+				continue
+			case field.name == "labels" && keep[e.key]:
+				warnf("%s: %s.labels: %s is not overwritten: the selector matches it", d.id, where, e.key)
+				continue
+			default:
+				*value = yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: e.value}
+			}
+			d.modified = true
+		}
+	}
+	return nil
+}
+
+// selectorLabels returns the labels a selector matches on, whether a label
+// selector or, as in ReplicationControllers, a plain map of labels.
+func selectorLabels(selector *yaml.Node) map[string]bool {
+	if selector == nil || selector.Kind != yaml.MappingNode {
+		return nil
+	}
+	labels := make(map[string]bool)
+	matchLabels, matchExpressions := renderv1.MappingValue(selector, "matchLabels"), renderv1.MappingValue(selector, "matchExpressions")
+	if matchLabels == nil && matchExpressions == nil {
+		matchLabels = selector
+	}
+	if matchLabels != nil && matchLabels.Kind == yaml.MappingNode {
+		for i := 0; i+1 < len(matchLabels.Content); i += 2 {
+			labels[matchLabels.Content[i].Value] = true
+		}
+	}
+	if matchExpressions != nil && matchExpressions.Kind == yaml.SequenceNode {
+		for _, expr := range matchExpressions.Content {
+			if key := renderv1.ScalarValue(expr, "key"); key != "" {
+				labels[key] = true
+			}
+		}
+	}
+	return labels
+}
+
+// encode returns the manifest with its modified documents re-encoded, and
+// whether any document changed.
+func (m *manifest) encode() (string, bool, error) {
+	parts := append([]renderv1.RenderedDocument(nil), m.parts...)
+	modified := false
+	for _, doc := range m.docs {
+		if !doc.modified {
+			continue
+		}
+		var buf bytes.Buffer
+		enc := yaml.NewEncoder(&buf)
+		enc.SetIndent(2)
+		if err := enc.Encode(doc.root); err != nil {
+			return "", false, fmt.Errorf("document %d: %v", doc.part, err)
+		}
+		enc.Close()
+		parts[doc.part].Content = buf.String()
+		modified = true
+	}
+	if !modified {
+		return "", false, nil
+	}
+	return renderv1.JoinDocuments(parts), true, nil
+}
+
+// childMapping returns the mapping under key in m, adding an empty one if the
+// key is missing or null. A key added goes before the key before if m has
+// it, and last otherwise.
+func childMapping(m *yaml.Node, key, before string) (*yaml.Node, error) {
+	value := renderv1.MappingValue(m, key)
+	switch {
+	case value == nil:
+		value = &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+		i := len(m.Content)
+		for j := 0; j+1 < len(m.Content); j += 2 {
+			if m.Content[j].Value == before {
+				i = j
+				break
+			}
+		}
+		m.Content = append(m.Content[:i], append([]*yaml.Node{{Kind: yaml.ScalarNode, Tag: "!!str", Value: key}, value}, m.Content[i:]...)...)
+	case value.Kind == yaml.ScalarNode && value.Tag == "!!null":
+		*value = yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+	case value.Kind != yaml.MappingNode:
+		return nil, fmt.Errorf("%s is not a mapping", key)
+	}
+	return value, nil
+}
+
+// errorOutput returns an output message reporting msg, with a failing exit
+// code.
+func errorOutput(msg string) (OutputMessageRenderV1, uint32) {
+	return OutputMessageRenderV1{
+		RenderedFiles: make(map[string]string),
+		Errors:        []string{msg},
+	}, 1
+}
+
+// logf logs a debug message. main.go sends it to Helm when built as a
+// plugin; natively, e.g. in tests, it is discarded.
+var logf = func(format string, args ...interface{}) {}
+
+// warnf logs a warning, such as a label that overwrite leaves unchanged.
+// main.go sends it to Helm when built as a plugin; natively it is discarded.
+var warnf = func(format string, args ...interface{}) {}
+
+// main is empty: Helm calls the helm_plugin_main export defined in main.go.
+func main() {}
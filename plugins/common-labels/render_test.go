@@ -0,0 +1,329 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+const deployment = `# Source: chart/templates/deployment.yaml
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+  labels:
+    team: web
+spec:
+  selector:
+    matchLabels:
+      app.kubernetes.io/name: chart
+      tier: frontend
+  template:
+    metadata:
+      labels:
+        app.kubernetes.io/name: chart
+        tier: frontend
+    spec:
+      containers:
+        - name: web
+          image: nginx
+`
+
+const cronJob = `apiVersion: batch/v1
+kind: CronJob
+metadata:
+  name: backup
+spec:
+  schedule: "@daily"
+  jobTemplate:
+    spec:
+      template:
+        spec:
+          containers:
+            - name: backup
+              image: busybox
+`
+
+const service = `apiVersion: v1
+kind: Service
+metadata:
+  name: web
+spec:
+  selector:
+    app.kubernetes.io/name: chart
+`
+
+func TestRender(t *testing.T) {
+	rendered := map[string]string{
+		"templates/deployment.yaml": deployment,
+		"templates/service.yaml":    service,
+		"templates/NOTES.txt":       "kind: Deployment",
+	}
+	release := ReleaseInfo{Name: "my-release", Namespace: "default", Service: "Helm"}
+	chart := ChartInfo{Name: "chart", Version: "1.0.0+build.1", AppVersion: "2.3"}
+	off := false
+
+	tests := []struct {
+		name     string
+		rendered map[string]string
+		chart    *ChartInfo
+		config   PluginConfig
+		raw      string
+		// want holds the expected rendered files; files not listed must not
+		// be rendered.
+		want         map[string]string
+		wantWarnings []string
+		wantErrors   []string
+	}{
+		{
+			name:     "standard labels",
+			rendered: rendered,
+			want: map[string]string{
+				"templates/deployment.yaml": `# Source: chart/templates/deployment.yaml
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+  labels:
+    team: web
+    app.kubernetes.io/name: chart
+    app.kubernetes.io/instance: my-release
+    app.kubernetes.io/version: "2.3"
+    app.kubernetes.io/managed-by: Helm
+    helm.sh/chart: chart-1.0.0_build.1
+spec:
+  selector:
+    matchLabels:
+      app.kubernetes.io/name: chart
+      tier: frontend
+  template:
+    metadata:
+      labels:
+        app.kubernetes.io/name: chart
+        tier: frontend
+        app.kubernetes.io/instance: my-release
+        app.kubernetes.io/version: "2.3"
+        app.kubernetes.io/managed-by: Helm
+        helm.sh/chart: chart-1.0.0_build.1
+    spec:
+      containers:
+        - name: web
+          image: nginx
+`,
+				"templates/service.yaml": `apiVersion: v1
+kind: Service
+metadata:
+  name: web
+  labels:
+    app.kubernetes.io/name: chart
+    app.kubernetes.io/instance: my-release
+    app.kubernetes.io/version: "2.3"
+    app.kubernetes.io/managed-by: Helm
+    helm.sh/chart: chart-1.0.0_build.1
+spec:
+  selector:
+    app.kubernetes.io/name: chart
+`,
+			},
+		},
+		{
+			name:     "common labels and annotations",
+			rendered: map[string]string{"templates/all.yaml": "---\n" + cronJob + "---\n# comment only\n"},
+			config: PluginConfig{
+				StandardLabels:    &off,
+				CommonLabels:      map[string]string{"team": "platform", "example.com/cost-center": "42"},
+				CommonAnnotations: map[string]string{"example.com/owner": "platform@example.com"},
+			},
+			// Empty documents are dropped, as renderv1.JoinDocuments does
+			want: map[string]string{
+				"templates/all.yaml": `apiVersion: batch/v1
+kind: CronJob
+metadata:
+  name: backup
+  labels:
+    example.com/cost-center: "42"
+    team: platform
+  annotations:
+    example.com/owner: platform@example.com
+spec:
+  schedule: "@daily"
+  jobTemplate:
+    metadata:
+      labels:
+        example.com/cost-center: "42"
+        team: platform
+      annotations:
+        example.com/owner: platform@example.com
+    spec:
+      template:
+        metadata:
+          labels:
+            example.com/cost-center: "42"
+            team: platform
+          annotations:
+            example.com/owner: platform@example.com
+        spec:
+          containers:
+            - name: backup
+              image: busybox
+`,
+			},
+		},
+		{
+			name:     "existing labels are kept",
+			rendered: map[string]string{"templates/deployment.yaml": deployment},
+			config: PluginConfig{
+				StandardLabels: &off,
+				CommonLabels:   map[string]string{"team": "platform", "tier": "backend"},
+			},
+			want: map[string]string{"templates/deployment.yaml": strings.NewReplacer(
+				"    team: web\n", "    team: web\n    tier: backend\n",
+				"        tier: frontend\n", "        tier: frontend\n        team: platform\n",
+			).Replace(deployment)},
+		},
+		{
+			name:     "overwrite keeps selected template labels",
+			rendered: map[string]string{"templates/deployment.yaml": deployment},
+			config: PluginConfig{
+				StandardLabels: &off,
+				CommonLabels:   map[string]string{"team": "platform", "tier": "backend"},
+				Overwrite:      true,
+			},
+			want: map[string]string{"templates/deployment.yaml": strings.NewReplacer(
+				"    team: web\n", "    team: platform\n    tier: backend\n",
+				"        tier: frontend\n", "        tier: frontend\n        team: platform\n",
+			).Replace(deployment)},
+			wantWarnings: []string{
+				"Deployment/web: spec.template.metadata.labels: tier is not overwritten: the selector matches it",
+			},
+		},
+		{
+			name:     "nothing to add",
+			rendered: map[string]string{"templates/service.yaml": strings.Replace(service, "  name: web\n", "  name: web\n  labels:\n    team: web\n", 1)},
+			config:   PluginConfig{StandardLabels: &off, CommonLabels: map[string]string{"team": "web"}, Overwrite: true},
+		},
+		{
+			name:     "no labels",
+			rendered: map[string]string{"templates/bad.yaml": "kind: [\n"},
+			chart:    &ChartInfo{},
+			config:   PluginConfig{StandardLabels: &off},
+		},
+		{
+			name:     "long chart name",
+			rendered: map[string]string{"templates/service.yaml": service},
+			chart:    &ChartInfo{Name: strings.Repeat("a", 62) + "-b", Version: "1.0.0"},
+			config:   PluginConfig{CommonLabels: map[string]string{"x": ""}},
+			want: map[string]string{"templates/service.yaml": strings.Replace(service, "  name: web\n", "  name: web\n  labels:\n"+
+				"    app.kubernetes.io/name: "+strings.Repeat("a", 62)+"\n"+
+				"    app.kubernetes.io/instance: my-release\n"+
+				"    app.kubernetes.io/managed-by: Helm\n"+
+				"    helm.sh/chart: "+strings.Repeat("a", 62)+"\n"+
+				"    x: \"\"\n", 1)},
+		},
+		{
+			name:     "invalid labels and annotations",
+			rendered: rendered,
+			config: PluginConfig{
+				CommonLabels: map[string]string{
+					"bad key":          "x",
+					"Example.com/team": "x",
+					"/team":            "x",
+					"team":             "not valid",
+					"long":             strings.Repeat("a", 64),
+				},
+				CommonAnnotations: map[string]string{"note": "any value: is fine", "-note": "x"},
+			},
+			wantErrors: []string{
+				`commonLabels: "/team": invalid prefix ""`,
+				`commonLabels: "Example.com/team": invalid prefix "Example.com"`,
+				`commonLabels: "bad key": invalid name "bad key"`,
+				`commonLabels: "long": invalid value`,
+				`commonLabels: "team": invalid value "not valid"`,
+				`commonAnnotations: "-note": invalid name "-note"`,
+			},
+		},
+		{
+			name:       "metadata not a mapping",
+			rendered:   map[string]string{"templates/a.yaml": "kind: A\nmetadata: [a]\n", "templates/b.yaml": "kind: Job\nmetadata: {name: b, labels: []}\n"},
+			wantErrors: []string{"templates/a.yaml: A/: metadata is not a mapping", "templates/b.yaml: Job/b: metadata.labels is not a mapping"},
+		},
+		{
+			name:       "invalid rendered YAML",
+			rendered:   map[string]string{"templates/bad.yaml": "kind: [\n"},
+			wantErrors: []string{"templates/bad.yaml: document 0 is not valid YAML"},
+		},
+		{
+			name:       "wrong types",
+			raw:        `{"config": {"commonLabels": ["team"]}}`,
+			wantErrors: []string{"failed to parse input"},
+		},
+		{
+			name:       "empty input",
+			raw:        ``,
+			wantErrors: []string{"no input provided"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			input := []byte(tt.raw)
+			if tt.rendered != nil {
+				c := chart
+				if tt.chart != nil {
+					c = *tt.chart
+				}
+				var err error
+				input, err = json.Marshal(InputMessageRenderV1{
+					Release:       release,
+					Chart:         c,
+					RenderedFiles: tt.rendered,
+					Config:        tt.config,
+				})
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			var warnings []string
+			warnf = func(format string, args ...interface{}) {
+				warnings = append(warnings, fmt.Sprintf(format, args...))
+			}
+			defer func() { warnf = func(string, ...interface{}) {} }()
+
+			output, code := render(input)
+			if (code != 0) != (len(tt.wantErrors) > 0) {
+				t.Errorf("exit code = %d, errors %q", code, output.Errors)
+			}
+			if output.RenderedFiles == nil {
+				t.Error("renderedFiles is nil")
+			}
+
+			if got, want := strings.Join(warnings, "\n"), strings.Join(tt.wantWarnings, "\n"); got != want {
+				t.Errorf("warnings:\n%s\nwant:\n%s", got, want)
+			}
+
+			errs := strings.Join(output.Errors, "\n")
+			if len(tt.wantErrors) == 0 && errs != "" {
+				t.Errorf("unexpected errors: %s", errs)
+			}
+			for _, want := range tt.wantErrors {
+				if !strings.Contains(errs, want) {
+					t.Errorf("errors %q do not contain %q", errs, want)
+				}
+			}
+			if tt.wantErrors != nil {
+				return
+			}
+
+			if len(output.RenderedFiles) != len(tt.want) {
+				t.Errorf("rendered %d files, want %d: %v", len(output.RenderedFiles), len(tt.want), output.RenderedFiles)
+			}
+			for name, want := range tt.want {
+				if got := output.RenderedFiles[name]; got != want {
+					t.Errorf("%s:\n%s\nwant:\n%s", name, got, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// chartSeeds returns the example chart inputs recorded by plugin-run in
+// plugin-run/testdata/seeds, one per chart, each passed to configure first
+// if it is not nil.
+func chartSeeds(tb testing.TB, configure func(*InputMessageRenderV1)) [][]byte {
+	files, err := filepath.Glob(filepath.Join("..", "..", "plugin-run", "testdata", "seeds", "*.json"))
+	if err != nil || len(files) == 0 {
+		tb.Fatalf("no seeds in plugin-run/testdata/seeds: %v", err)
+	}
+
+	var seeds [][]byte
+	for _, file := range files {
+		var input InputMessageRenderV1
+		data, err := os.ReadFile(file)
+		if err == nil {
+			err = json.Unmarshal(data, &input)
+		}
+		if err != nil {
+			tb.Fatalf("%s: %v", file, err)
+		}
+		if configure != nil {
+			configure(&input)
+		}
+
+		seed, err := json.Marshal(input)
+		if err != nil {
+			tb.Fatal(err)
+		}
+		seeds = append(seeds, seed)
+	}
+	return seeds
+}
+
+func FuzzRender(f *testing.F) {
+	for _, seed := range chartSeeds(f, func(input *InputMessageRenderV1) {
+		input.Config = PluginConfig{ValuesKey: "generators", SpecFile: "templates/generators.yaml"}
+	}) {
+		f.Add(seed)
+	}
+	f.Add([]byte(``))
+	f.Add([]byte(`{}`))
+	f.Add([]byte(`{"values": {"g": {"configMapGenerator": [{"name": "a", "literals": ["k=v"], "files": ["f", "x=f"], "envs": ["f"]}]}}, "files": [{"name": "f", "data": "YT0xCg=="}], "config": {"valuesKey": "g"}}`))
+	f.Add([]byte(`{"sourceFiles": [{"name": "s", "data": "c2VjcmV0R2VuZXJhdG9yOiBbe25hbWU6IGJ9XQo="}, {"name": "t", "data": "c2VjcmV0OgogIHNlY3JldE5hbWU6IGIK"}], "config": {"specFile": "s"}}`))
+
+	f.Add([]byte(`{"renderedFiles": {"r": "x:\n  configMap:\n    name: a\n"}, "values": {"g": {"configMapGenerator": [{"name": "a"}]}}, "config": {"valuesKey": "g"}}`))
+
+	f.Fuzz(func(t *testing.T, input []byte) {
+		output, code := render(input)
+
+		data, err := json.Marshal(output)
+		if err != nil || !json.Valid(data) {
+			t.Fatalf("output is not valid JSON: %v", err)
+		}
+		if (code != 0) != (len(output.Errors) > 0) {
+			t.Fatalf("exit code %d with errors %q", code, output.Errors)
+		}
+		// Generated objects are bounded by maxOutputSize, which JSON escaping
+		// inflates at most 6x; rewritten source and rendered files grow only
+		// by hash suffixes, at most 11 bytes per reference.
+		if len(data) > 6*maxOutputSize+16*len(input)+1024 {
+			t.Fatalf("output of %d bytes for %d bytes of input", len(data), len(input))
+		}
+	})
+}
@@ -0,0 +1,8 @@
+module github.com/scottrigby/ref-hip-chart-defined-plugins/plugins/configmap-generator
+
+go 1.24.3
+
+require (
+	github.com/extism/go-pdk v1.1.3
+	go.yaml.in/yaml/v3 v3.0.4
+)
@@ -0,0 +1,39 @@
+//go:build wasip1
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/extism/go-pdk"
+)
+
+func init() {
+	logf = func(format string, args ...interface{}) {
+		pdk.Log(pdk.LogDebug, fmt.Sprintf(format, args...))
+	}
+}
+
+//go:wasmexport helm_plugin_main
+func HelmPluginMain() uint32 {
+	pdk.Log(pdk.LogDebug, "configmap-generator plugin starting")
+
+	output, code := render(pdk.Input())
+
+	// Marshal and return the output
+	outputBytes, err := json.Marshal(output)
+	if err != nil {
+		output, code = errorOutput(fmt.Sprintf("failed to marshal output: %v", err))
+		outputBytes, _ = json.Marshal(output)
+	}
+	if code != 0 {
+		for _, msg := range output.Errors {
+			pdk.Log(pdk.LogError, msg)
+		}
+	}
+
+	pdk.Output(outputBytes)
+	pdk.Log(pdk.LogDebug, "configmap-generator plugin completed")
+	return code
+}
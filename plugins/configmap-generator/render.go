@@ -0,0 +1,525 @@
+// Package main implements a render/v1 plugin that generates ConfigMaps and
+// Secrets from chart files, like Kustomize's configMapGenerator and
+// secretGenerator.
+//
+// Generators are read from the chart's values, under config.valuesKey, and
+// from the source file config.specFile. Each builds one object from literal
+// KEY=VALUE pairs, chart files and env files, named with a suffix hashed from
+// its content so that workloads roll when it changes. The plugin renders the
+// objects and passes the templates it received on to the next plugin, with
+// references to the generated objects' names rewritten to the hashed names.
+// Only literal names are rewritten in templates; names built by template
+// expressions are resolved in the files rendered before this plugin, whose
+// references are rewritten too.
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"go.yaml.in/yaml/v3"
+)
+
+// maxOutputSize bounds the total size of the generated objects, since a
+// chart file can be used by any number of generators.
+const maxOutputSize = 16 << 20
+
+// SourceFile represents a file in the chart.
+type SourceFile struct {
+	Name string `json:"name"`
+	Data []byte `json:"data"`
+}
+
+// ReleaseInfo contains release metadata passed to render plugins.
+type ReleaseInfo struct {
+	Name      string `json:"name"`
+	Namespace string `json:"namespace"`
+}
+
+// InputMessageRenderV1 is the input message for render/v1 plugins.
+type InputMessageRenderV1 struct {
+	Release     ReleaseInfo            `json:"release"`
+	Values      map[string]interface{} `json:"values"`
+	Files       []SourceFile           `json:"files"`
+	SourceFiles []SourceFile           `json:"sourceFiles"`
+	Config      PluginConfig           `json:"config"`
+	// RenderedFiles are the files rendered by the plugins before this one.
+	RenderedFiles map[string]string `json:"renderedFiles"`
+}
+
+// PluginConfig is the chart's configuration for this plugin, validated by
+// Helm against the configSchema in plugin.yaml.
+type PluginConfig struct {
+	// ValuesKey is the top-level values key holding generators.
+	ValuesKey string `json:"valuesKey"`
+	// SpecFile is the source file holding generators. It is consumed: the
+	// plugins after this one do not receive it.
+	SpecFile string `json:"specFile"`
+	// HashSuffix appends a content hash to generated names unless a
+	// generator disables it. Nil means true.
+	HashSuffix *bool `json:"hashSuffix"`
+}
+
+// OutputMessageRenderV1 is the output message from render/v1 plugins.
+// ModifiedSourceFiles is always written, even when empty, since an empty list
+// removes every file the plugin received while a missing one keeps them.
+type OutputMessageRenderV1 struct {
+	RenderedFiles       map[string]string `json:"renderedFiles"`
+	ModifiedSourceFiles []SourceFile      `json:"modifiedSourceFiles"`
+	Errors              []string          `json:"errors,omitempty"`
+}
+
+// Spec lists generators, as in a kustomization.yaml.
+type Spec struct {
+	ConfigMapGenerator []Generator `json:"configMapGenerator" yaml:"configMapGenerator"`
+	SecretGenerator    []Generator `json:"secretGenerator" yaml:"secretGenerator"`
+}
+
+// Generator describes one ConfigMap or Secret.
+type Generator struct {
+	Name string `json:"name" yaml:"name"`
+	// Type is the Secret type; Opaque if empty. Not used for ConfigMaps.
+	Type string `json:"type" yaml:"type"`
+	// Literals are KEY=VALUE pairs.
+	Literals []string `json:"literals" yaml:"literals"`
+	// Files are chart file paths, stored under their base name, or
+	// KEY=PATH to choose the key.
+	Files []string `json:"files" yaml:"files"`
+	// Envs are chart files of KEY=VALUE lines.
+	Envs    []string         `json:"envs" yaml:"envs"`
+	Options GeneratorOptions `json:"options" yaml:"options"`
+}
+
+// GeneratorOptions are optional settings of a generator.
+type GeneratorOptions struct {
+	Labels                map[string]string `json:"labels" yaml:"labels"`
+	Annotations           map[string]string `json:"annotations" yaml:"annotations"`
+	DisableNameSuffixHash bool              `json:"disableNameSuffixHash" yaml:"disableNameSuffixHash"`
+}
+
+// object is a generated ConfigMap or Secret.
+type object struct {
+	APIVersion string            `yaml:"apiVersion"`
+	Kind       string            `yaml:"kind"`
+	Metadata   objectMeta        `yaml:"metadata"`
+	Type       string            `yaml:"type,omitempty"`
+	Data       map[string]string `yaml:"data,omitempty"`
+	BinaryData map[string]string `yaml:"binaryData,omitempty"`
+}
+
+type objectMeta struct {
+	Name        string            `yaml:"name"`
+	Namespace   string            `yaml:"namespace,omitempty"`
+	Labels      map[string]string `yaml:"labels,omitempty"`
+	Annotations map[string]string `yaml:"annotations,omitempty"`
+}
+
+// keyPattern matches valid ConfigMap and Secret keys.
+var keyPattern = regexp.MustCompile(`^[-._a-zA-Z0-9]+$`)
+
+// namePattern matches valid object names (DNS subdomains).
+var namePattern = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?(\.[a-z0-9]([-a-z0-9]*[a-z0-9])?)*$`)
+
+// render generates the objects described by the raw input message and
+// returns the output message along with the exit code the plugin returns to
+// Helm.
+func render(inputBytes []byte) (OutputMessageRenderV1, uint32) {
+	if len(inputBytes) == 0 {
+		return errorOutput("no input provided")
+	}
+
+	var input InputMessageRenderV1
+	if err := json.Unmarshal(inputBytes, &input); err != nil {
+		return errorOutput(fmt.Sprintf("failed to parse input: %v", err))
+	}
+
+	spec, sources, err := readSpec(&input)
+	if err != nil {
+		return errorOutput(err.Error())
+	}
+
+	files := make(map[string][]byte, len(input.Files))
+	for _, f := range input.Files {
+		files[path.Clean(f.Name)] = f.Data
+	}
+	hashSuffix := input.Config.HashSuffix == nil || *input.Config.HashSuffix
+
+	output := OutputMessageRenderV1{
+		RenderedFiles:       make(map[string]string),
+		ModifiedSourceFiles: make([]SourceFile, 0, len(input.SourceFiles)),
+	}
+	// renames maps each generated object's kind and name to its final name.
+	renames := map[string]map[string]string{"ConfigMap": {}, "Secret": {}}
+	size := 0
+
+	kinds := []struct {
+		kind       string
+		generators []Generator
+	}{
+		{"ConfigMap", spec.ConfigMapGenerator},
+		{"Secret", spec.SecretGenerator},
+	}
+	for _, k := range kinds {
+		for i, g := range k.generators {
+			obj, err := generate(k.kind, g, files, maxOutputSize-size)
+			if err != nil {
+				output.Errors = append(output.Errors, fmt.Sprintf("%s %s from %s: %v", k.kind, g.Name, sources[k.kind][i], err))
+				continue
+			}
+			if _, ok := renames[k.kind][g.Name]; ok {
+				output.Errors = append(output.Errors, fmt.Sprintf("%s %s is generated more than once", k.kind, g.Name))
+				continue
+			}
+			if hashSuffix && !g.Options.DisableNameSuffixHash {
+				obj.Metadata.Name += "-" + contentHash(obj)
+			}
+			obj.Metadata.Namespace = input.Release.Namespace
+			renames[k.kind][g.Name] = obj.Metadata.Name
+
+			content, err := marshal(obj)
+			if err != nil {
+				output.Errors = append(output.Errors, fmt.Sprintf("%s %s: %v", k.kind, g.Name, err))
+				continue
+			}
+			if size += len(content); size > maxOutputSize {
+				return errorOutput(fmt.Sprintf("generated objects exceed %d bytes", maxOutputSize))
+			}
+			name := fmt.Sprintf("templates/generated/%s-%s.yaml", strings.ToLower(k.kind), g.Name)
+			output.RenderedFiles[name] = content
+			logf("Generated %s %s as %s", k.kind, g.Name, obj.Metadata.Name)
+		}
+	}
+	if len(output.Errors) > 0 {
+		output.ModifiedSourceFiles = nil
+		return output, 1
+	}
+
+	for _, f := range input.SourceFiles {
+		if f.Name == input.Config.SpecFile {
+			continue
+		}
+		if data, ok := rewriteReferences(string(f.Data), renames); ok {
+			logf("Rewrote references in %s", f.Name)
+			f.Data = []byte(data)
+		}
+		output.ModifiedSourceFiles = append(output.ModifiedSourceFiles, f)
+	}
+
+	// Rendered files that refer to generated objects are rendered again
+	// under the same names, replacing the earlier ones.
+	rendered := make([]string, 0, len(input.RenderedFiles))
+	for name := range input.RenderedFiles {
+		rendered = append(rendered, name)
+	}
+	sort.Strings(rendered)
+	for _, name := range rendered {
+		if _, ok := output.RenderedFiles[name]; ok {
+			continue
+		}
+		if data, ok := rewriteReferences(input.RenderedFiles[name], renames); ok {
+			logf("Rewrote references in rendered file %s", name)
+			output.RenderedFiles[name] = data
+		}
+	}
+	return output, 0
+}
+
+// readSpec returns the generators from the values and the spec file, and
+// where each came from, by kind.
+func readSpec(input *InputMessageRenderV1) (*Spec, map[string][]string, error) {
+	var spec Spec
+	sources := map[string][]string{}
+	add := func(s Spec, source string) {
+		spec.ConfigMapGenerator = append(spec.ConfigMapGenerator, s.ConfigMapGenerator...)
+		spec.SecretGenerator = append(spec.SecretGenerator, s.SecretGenerator...)
+		for range s.ConfigMapGenerator {
+			sources["ConfigMap"] = append(sources["ConfigMap"], source)
+		}
+		for range s.SecretGenerator {
+			sources["Secret"] = append(sources["Secret"], source)
+		}
+	}
+
+	if key := input.Config.ValuesKey; key != "" {
+		if v, ok := input.Values[key]; ok && v != nil {
+			data, err := json.Marshal(v)
+			if err != nil {
+				return nil, nil, err
+			}
+			var s Spec
+			dec := json.NewDecoder(bytes.NewReader(data))
+			dec.DisallowUnknownFields()
+			if err := dec.Decode(&s); err != nil {
+				return nil, nil, fmt.Errorf("invalid generators in values.%s: %v", key, err)
+			}
+			add(s, "values."+key)
+		}
+	}
+
+	for _, f := range input.SourceFiles {
+		if input.Config.SpecFile == "" || f.Name != input.Config.SpecFile {
+			continue
+		}
+		var s Spec
+		dec := yaml.NewDecoder(bytes.NewReader(f.Data))
+		dec.KnownFields(true)
+		if err := dec.Decode(&s); err != nil && err != io.EOF {
+			return nil, nil, fmt.Errorf("invalid generators in %s: %v", f.Name, err)
+		}
+		add(s, f.Name)
+	}
+	return &spec, sources, nil
+}
+
+// generate builds the object for a generator, without a hash suffix, failing
+// if its data exceeds limit bytes.
+func generate(kind string, g Generator, files map[string][]byte, limit int) (*object, error) {
+	if !namePattern.MatchString(g.Name) || len(g.Name) > 242 {
+		return nil, fmt.Errorf("invalid name %q", g.Name)
+	}
+	obj := &object{
+		APIVersion: "v1",
+		Kind:       kind,
+		Metadata: objectMeta{
+			Name:        g.Name,
+			Labels:      g.Options.Labels,
+			Annotations: g.Options.Annotations,
+		},
+	}
+	if kind == "Secret" {
+		obj.Type = g.Type
+		if obj.Type == "" {
+			obj.Type = "Opaque"
+		}
+	} else if g.Type != "" {
+		return nil, fmt.Errorf("type is only valid for Secrets")
+	}
+
+	data := map[string][]byte{}
+	size := 0
+	set := func(key string, value []byte) error {
+		if !keyPattern.MatchString(key) || len(key) > 253 {
+			return fmt.Errorf("invalid key %q", key)
+		}
+		if _, ok := data[key]; ok {
+			return fmt.Errorf("key %s is set more than once", key)
+		}
+		if size += len(key) + len(value); size > limit {
+			return fmt.Errorf("generated objects exceed %d bytes", maxOutputSize)
+		}
+		data[key] = value
+		return nil
+	}
+
+	for _, l := range g.Literals {
+		key, value, ok := strings.Cut(l, "=")
+		if !ok {
+			return nil, fmt.Errorf("literal %q is not KEY=VALUE", l)
+		}
+		if err := set(key, []byte(unquote(value))); err != nil {
+			return nil, err
+		}
+	}
+	for _, src := range g.Files {
+		key, name, ok := strings.Cut(src, "=")
+		if !ok {
+			key, name = path.Base(src), src
+		}
+		content, err := chartFile(files, name)
+		if err != nil {
+			return nil, err
+		}
+		if err := set(key, content); err != nil {
+			return nil, err
+		}
+	}
+	for _, name := range g.Envs {
+		content, err := chartFile(files, name)
+		if err != nil {
+			return nil, err
+		}
+		for i, line := range strings.Split(string(content), "\n") {
+			line = strings.TrimSpace(line)
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			key, value, ok := strings.Cut(line, "=")
+			if !ok {
+				return nil, fmt.Errorf("%s:%d: %q is not KEY=VALUE", name, i+1, line)
+			}
+			if err := set(key, []byte(value)); err != nil {
+				return nil, fmt.Errorf("%s:%d: %v", name, i+1, err)
+			}
+		}
+	}
+
+	for key, value := range data {
+		switch {
+		case kind == "Secret":
+			if obj.Data == nil {
+				obj.Data = map[string]string{}
+			}
+			obj.Data[key] = base64.StdEncoding.EncodeToString(value)
+		case utf8.Valid(value):
+			if obj.Data == nil {
+				obj.Data = map[string]string{}
+			}
+			obj.Data[key] = string(value)
+		default:
+			if obj.BinaryData == nil {
+				obj.BinaryData = map[string]string{}
+			}
+			obj.BinaryData[key] = base64.StdEncoding.EncodeToString(value)
+		}
+	}
+	return obj, nil
+}
+
+// chartFile returns the content of the chart file name.
+func chartFile(files map[string][]byte, name string) ([]byte, error) {
+	content, ok := files[path.Clean(name)]
+	if !ok {
+		return nil, fmt.Errorf("chart file %s not found", name)
+	}
+	return content, nil
+}
+
+// unquote removes one pair of matching quotes around a literal value.
+func unquote(s string) string {
+	if len(s) >= 2 && (s[0] == '"' || s[0] == '\'') && s[len(s)-1] == s[0] {
+		return s[1 : len(s)-1]
+	}
+	return s
+}
+
+// contentHash returns the name suffix for obj, computed like Kustomize's from
+// its kind, name, type and data, so it changes whenever the data does.
+func contentHash(obj *object) string {
+	data, _ := json.Marshal(map[string]interface{}{
+		"kind":       obj.Kind,
+		"name":       obj.Metadata.Name,
+		"type":       obj.Type,
+		"data":       obj.Data,
+		"binaryData": obj.BinaryData,
+	})
+	sum := sha256.Sum256(data)
+	// Like Kustomize, avoid characters that could spell words.
+	return strings.NewReplacer("0", "g", "1", "h", "3", "k", "a", "m", "e", "t").Replace(hex.EncodeToString(sum[:])[:10])
+}
+
+// marshal returns obj as a YAML document.
+func marshal(obj *object) (string, error) {
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(obj); err != nil {
+		return "", err
+	}
+	if err := enc.Close(); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// referenceFields are the fields naming a ConfigMap or Secret, by kind, as
+// parent key and key.
+var referenceFields = map[[2]string]string{
+	{"configMapRef", "name"}:     "ConfigMap",
+	{"configMapKeyRef", "name"}:  "ConfigMap",
+	{"configMap", "name"}:        "ConfigMap",
+	{"secretRef", "name"}:        "Secret",
+	{"secretKeyRef", "name"}:     "Secret",
+	{"secret", "secretName"}:     "Secret",
+	{"imagePullSecrets", "name"}: "Secret",
+}
+
+// keyLine matches a "key: value" line in block style YAML, with an optional
+// list item dash.
+var keyLine = regexp.MustCompile(`^( *)(- +)?([A-Za-z_][\w.-]*):(?:[ \t]+(.*))?$`)
+
+// rewriteReferences replaces literal references to generated objects in a
+// template with their final names, and reports whether any were replaced.
+// Templates need not be valid YAML: lines are tracked by indentation, and
+// lines that do not look like block style keys are left alone.
+func rewriteReferences(content string, renames map[string]map[string]string) (string, bool) {
+	type key struct {
+		indent int
+		name   string
+	}
+	var parents []key
+	changed := false
+
+	lines := strings.Split(content, "\n")
+	for i, line := range lines {
+		if strings.HasPrefix(line, "---") {
+			parents = parents[:0]
+			continue
+		}
+		m := keyLine.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		indent := len(m[1])
+		// A list item may be written at its key's own indent, as in
+		// "imagePullSecrets:\n- name: reg", so it is that key's child.
+		for len(parents) > 0 && (parents[len(parents)-1].indent > indent ||
+			parents[len(parents)-1].indent == indent && m[2] == "") {
+			parents = parents[:len(parents)-1]
+		}
+		if m[2] != "" {
+			indent += len(m[2])
+		}
+		name, value := m[3], m[4]
+		if i := strings.Index(value, " #"); i >= 0 {
+			value = value[:i]
+		}
+		value = strings.TrimRight(value, " \t")
+		if value == "" || strings.HasPrefix(value, "#") {
+			parents = append(parents, key{indent, name})
+			continue
+		}
+		if len(parents) == 0 {
+			continue
+		}
+		kind, ok := referenceFields[[2]string{parents[len(parents)-1].name, name}]
+		if !ok {
+			continue
+		}
+		ref, quote := value, ""
+		if len(ref) >= 2 && (ref[0] == '"' || ref[0] == '\'') && ref[len(ref)-1] == ref[0] {
+			ref, quote = ref[1:len(ref)-1], ref[:1]
+		}
+		if newName, ok := renames[kind][ref]; ok && newName != ref {
+			start := len(line) - len(m[4])
+			lines[i] = line[:start] + quote + newName + quote + line[start+len(value):]
+			changed = true
+		}
+	}
+	return strings.Join(lines, "\n"), changed
+}
+
+// errorOutput returns an output message reporting msg, with a failing exit
+// code.
+func errorOutput(msg string) (OutputMessageRenderV1, uint32) {
+	return OutputMessageRenderV1{
+		RenderedFiles: make(map[string]string),
+		Errors:        []string{msg},
+	}, 1
+}
+
+// logf logs a debug message. main.go sends it to Helm when built as a
+// plugin; natively, e.g. in tests, it is discarded.
+var logf = func(format string, args ...interface{}) {}
+
+// main is empty: Helm calls the helm_plugin_main export defined in main.go.
+func main() {}
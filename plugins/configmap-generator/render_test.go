@@ -0,0 +1,353 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestRender(t *testing.T) {
+	file := func(name, data string) SourceFile {
+		return SourceFile{Name: name, Data: []byte(data)}
+	}
+	chartFiles := []SourceFile{
+		file("config/app.properties", "color=blue\nsize=10\n"),
+		file("config/app.env", "# settings\nLOG_LEVEL=debug\n\nMODE=fast=yes\n"),
+		file("config/logo.png", "\x89PNG\x00\xff"),
+		file("config/bad.env", "OK=1\nnot a setting\n"),
+	}
+	deployment := file("templates/deployment.yaml", `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: app-config
+spec:
+  template:
+    spec:
+      imagePullSecrets:
+        - name: registry
+      containers:
+        - name: app
+          envFrom:
+            - configMapRef:
+                name: app-config
+            - secretRef:
+                name: "creds" # generated
+          env:
+            - name: COLOR
+              valueFrom:
+                configMapKeyRef:
+                  name: {{ .Values.other }}
+                  key: color
+      volumes:
+        - name: config
+          configMap:
+            name: app-config
+        - name: creds
+          secret:
+            secretName: 'creds'
+`)
+	rewritten := strings.NewReplacer(
+		"                name: app-config", "                name: app-config-HASH",
+		"            name: app-config", "            name: app-config-HASH",
+		`name: "creds"`, `name: "creds-HASH"`,
+		`secretName: 'creds'`, `secretName: 'creds-HASH'`,
+	)
+
+	tests := []struct {
+		name   string
+		values map[string]interface{}
+		files  []SourceFile
+		config PluginConfig
+		// rendered are the files rendered by the plugins before this one.
+		rendered map[string]string
+		raw      string
+		// wantRendered are lines each rendered file must contain.
+		wantRendered map[string][]string
+		// wantFiles are the expected modifiedSourceFiles, in order.
+		wantFiles  []SourceFile
+		wantErrors []string
+	}{
+		{
+			name: "generates from values and rewrites references",
+			values: map[string]interface{}{"generators": map[string]interface{}{
+				"configMapGenerator": []interface{}{map[string]interface{}{
+					"name":     "app-config",
+					"literals": []interface{}{"GREETING=hello", `QUOTED="a b"`},
+					"files":    []interface{}{"config/app.properties", "logo=config/logo.png"},
+					"envs":     []interface{}{"config/app.env"},
+				}},
+				"secretGenerator": []interface{}{map[string]interface{}{
+					"name":     "creds",
+					"literals": []interface{}{"password=s3cret"},
+					"options":  map[string]interface{}{"labels": map[string]interface{}{"team": "a"}},
+				}},
+			}},
+			files: []SourceFile{deployment},
+			wantRendered: map[string][]string{
+				"templates/generated/configmap-app-config.yaml": {
+					"kind: ConfigMap",
+					"  name: app-config-",
+					"  namespace: prod",
+					"  GREETING: hello",
+					"  QUOTED: a b",
+					"  LOG_LEVEL: debug",
+					"  MODE: fast=yes",
+					"  app.properties: |\n    color=blue\n    size=10\n",
+					"binaryData:\n  logo: iVBORwD/\n",
+				},
+				"templates/generated/secret-creds.yaml": {
+					"kind: Secret",
+					"  name: creds-",
+					"    team: a",
+					"type: Opaque",
+					"  password: czNjcmV0\n",
+				},
+			},
+			wantFiles: []SourceFile{file("templates/deployment.yaml", rewritten.Replace(string(deployment.Data)))},
+		},
+		{
+			name: "generates from the spec file and consumes it",
+			files: []SourceFile{
+				file("templates/generators.yaml", "configMapGenerator:\n  - name: plain\n    literals: [a=1]\n    options:\n      disableNameSuffixHash: true\n"),
+				file("templates/cm.yaml", "configMap:\n  name: plain\n"),
+			},
+			wantRendered: map[string][]string{
+				"templates/generated/configmap-plain.yaml": {"  name: plain\n", "data:\n  a: \"1\"\n"},
+			},
+			wantFiles: []SourceFile{file("templates/cm.yaml", "configMap:\n  name: plain\n")},
+		},
+		{
+			name:   "hash suffix disabled by config",
+			values: map[string]interface{}{"generators": map[string]interface{}{"configMapGenerator": []interface{}{map[string]interface{}{"name": "x", "literals": []interface{}{"a=1"}}}}},
+			config: PluginConfig{ValuesKey: "generators", HashSuffix: new(bool)},
+			wantRendered: map[string][]string{
+				"templates/generated/configmap-x.yaml": {"  name: x\n"},
+			},
+			wantFiles: []SourceFile{},
+		},
+		{
+			name:       "missing chart file",
+			values:     map[string]interface{}{"generators": map[string]interface{}{"configMapGenerator": []interface{}{map[string]interface{}{"name": "x", "files": []interface{}{"config/missing.txt"}}}}},
+			wantErrors: []string{"ConfigMap x from values.generators: chart file config/missing.txt not found"},
+		},
+		{
+			name:       "invalid literal",
+			values:     map[string]interface{}{"generators": map[string]interface{}{"secretGenerator": []interface{}{map[string]interface{}{"name": "x", "literals": []interface{}{"novalue"}}}}},
+			wantErrors: []string{`Secret x from values.generators: literal "novalue" is not KEY=VALUE`},
+		},
+		{
+			name:       "invalid env file line",
+			values:     map[string]interface{}{"generators": map[string]interface{}{"configMapGenerator": []interface{}{map[string]interface{}{"name": "x", "envs": []interface{}{"config/bad.env"}}}}},
+			wantErrors: []string{`config/bad.env:2: "not a setting" is not KEY=VALUE`},
+		},
+		{
+			name:       "duplicate key",
+			values:     map[string]interface{}{"generators": map[string]interface{}{"configMapGenerator": []interface{}{map[string]interface{}{"name": "x", "literals": []interface{}{"color=red"}, "envs": []interface{}{"config/app.properties"}}}}},
+			wantErrors: []string{"config/app.properties:1: key color is set more than once"},
+		},
+		{
+			name:       "invalid name",
+			values:     map[string]interface{}{"generators": map[string]interface{}{"configMapGenerator": []interface{}{map[string]interface{}{"name": "Bad_Name"}}}},
+			wantErrors: []string{`invalid name "Bad_Name"`},
+		},
+		{
+			name:       "type on a ConfigMap",
+			values:     map[string]interface{}{"generators": map[string]interface{}{"configMapGenerator": []interface{}{map[string]interface{}{"name": "x", "type": "Opaque"}}}},
+			wantErrors: []string{"type is only valid for Secrets"},
+		},
+		{
+			name:       "generated twice",
+			values:     map[string]interface{}{"generators": map[string]interface{}{"configMapGenerator": []interface{}{map[string]interface{}{"name": "x"}}}},
+			files:      []SourceFile{file("templates/generators.yaml", "configMapGenerator: [{name: x}]\n")},
+			wantErrors: []string{"ConfigMap x is generated more than once"},
+		},
+		{
+			name:       "unknown field in values",
+			values:     map[string]interface{}{"generators": map[string]interface{}{"configMapGenerators": []interface{}{}}},
+			wantErrors: []string{`invalid generators in values.generators: json: unknown field "configMapGenerators"`},
+		},
+		{
+			name:       "unknown field in spec file",
+			files:      []SourceFile{file("templates/generators.yaml", "configMapGenerator: [{name: x, literal: [a=1]}]\n")},
+			wantErrors: []string{"invalid generators in templates/generators.yaml", "field literal not found"},
+		},
+		{
+			name:   "rewrites references in files rendered before it",
+			values: map[string]interface{}{"generators": map[string]interface{}{"configMapGenerator": []interface{}{map[string]interface{}{"name": "app-config", "literals": []interface{}{"a=1"}}}}},
+			rendered: map[string]string{
+				"templates/deployment.yaml": "kind: Deployment\nmetadata:\n  name: web\nspec:\n  volumes:\n    - configMap:\n        name: app-config\n",
+				"templates/service.yaml":    "kind: Service\nmetadata:\n  name: app-config\n",
+			},
+			wantRendered: map[string][]string{
+				"templates/generated/configmap-app-config.yaml": {"  name: app-config-"},
+				// service.yaml names no generated object, so it is not
+				// rendered again
+				"templates/deployment.yaml": {"  name: web\n", "        name: app-config-"},
+			},
+			wantFiles: []SourceFile{},
+		},
+		{
+			name:   "leaves templated references in source files",
+			values: map[string]interface{}{"generators": map[string]interface{}{"configMapGenerator": []interface{}{map[string]interface{}{"name": "app-config", "literals": []interface{}{"a=1"}}}}},
+			files:  []SourceFile{file("templates/cm.yaml", "configMap:\n  name: {{ .Values.configName }}\n")},
+			wantRendered: map[string][]string{
+				"templates/generated/configmap-app-config.yaml": {"  name: app-config-"},
+			},
+			wantFiles: []SourceFile{file("templates/cm.yaml", "configMap:\n  name: {{ .Values.configName }}\n")},
+		},
+		{
+			name:      "no generators passes files through",
+			files:     []SourceFile{deployment},
+			wantFiles: []SourceFile{deployment},
+		},
+		{
+			name:       "malformed JSON",
+			raw:        `{"values": []}`,
+			wantErrors: []string{"failed to parse input"},
+		},
+		{
+			name:       "empty input",
+			raw:        ``,
+			wantErrors: []string{"no input provided"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			input := []byte(tt.raw)
+			if tt.raw == "" && tt.wantErrors == nil || tt.values != nil || tt.files != nil || tt.rendered != nil {
+				config := tt.config
+				if config == (PluginConfig{}) {
+					config = PluginConfig{ValuesKey: "generators", SpecFile: "templates/generators.yaml"}
+				}
+				var err error
+				input, err = json.Marshal(InputMessageRenderV1{
+					Release:       ReleaseInfo{Name: "web", Namespace: "prod"},
+					Values:        tt.values,
+					Files:         chartFiles,
+					SourceFiles:   tt.files,
+					Config:        config,
+					RenderedFiles: tt.rendered,
+				})
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			output, code := render(input)
+			if wantCode := uint32(len(tt.wantErrors)); (code != 0) != (wantCode != 0) {
+				t.Errorf("exit code = %d, errors %q", code, output.Errors)
+			}
+
+			if tt.wantErrors == nil && len(output.RenderedFiles) != len(tt.wantRendered) {
+				t.Errorf("rendered %d files, want %d: %v", len(output.RenderedFiles), len(tt.wantRendered), output.RenderedFiles)
+			}
+			hashes := map[string]string{}
+			for name, lines := range tt.wantRendered {
+				got, ok := output.RenderedFiles[name]
+				if !ok {
+					t.Errorf("%s not rendered", name)
+					continue
+				}
+				for _, want := range lines {
+					if !strings.Contains(got, want) {
+						t.Errorf("%s does not contain %q:\n%s", name, want, got)
+					}
+				}
+				if i := strings.Index(got, "  name: "); i >= 0 {
+					full := strings.SplitN(got[i+len("  name: "):], "\n", 2)[0]
+					if i := strings.LastIndex(full, "-"); i > 0 {
+						hashes[full[:i]] = full[i+1:]
+					}
+				}
+			}
+
+			if tt.wantErrors == nil && len(output.ModifiedSourceFiles) != len(tt.wantFiles) {
+				t.Fatalf("got %d modified source files, want %d", len(output.ModifiedSourceFiles), len(tt.wantFiles))
+			}
+			for i, want := range tt.wantFiles {
+				got := output.ModifiedSourceFiles[i]
+				wantData := string(want.Data)
+				for base, hash := range hashes {
+					wantData = strings.ReplaceAll(wantData, base+"-HASH", base+"-"+hash)
+				}
+				if got.Name != want.Name || string(got.Data) != wantData {
+					t.Errorf("modifiedSourceFiles[%d] = %s:\n%s\nwant %s:\n%s", i, got.Name, got.Data, want.Name, wantData)
+				}
+			}
+
+			errs := strings.Join(output.Errors, "\n")
+			if len(tt.wantErrors) == 0 && errs != "" {
+				t.Errorf("unexpected errors: %s", errs)
+			}
+			for _, want := range tt.wantErrors {
+				if !strings.Contains(errs, want) {
+					t.Errorf("errors %q do not contain %q", errs, want)
+				}
+			}
+		})
+	}
+}
+
+func TestContentHash(t *testing.T) {
+	obj := func(value string) *object {
+		return &object{Kind: "ConfigMap", Metadata: objectMeta{Name: "x"}, Data: map[string]string{"a": value}}
+	}
+	first, again, changed := contentHash(obj("1")), contentHash(obj("1")), contentHash(obj("2"))
+	if len(first) != 10 || strings.ContainsAny(first, "013ae") {
+		t.Errorf("hash %q is not 10 characters without 0, 1, 3, a or e", first)
+	}
+	if first != again {
+		t.Errorf("hash is not stable: %s, %s", first, again)
+	}
+	if first == changed {
+		t.Errorf("hash %s does not change with the data", first)
+	}
+}
+
+func TestRewriteReferences(t *testing.T) {
+	renames := map[string]map[string]string{
+		"ConfigMap": {"app-config": "app-config-h"},
+		"Secret":    {"reg": "reg-h", "creds": "creds-h"},
+	}
+	tests := []struct {
+		name, content, want string
+	}{
+		{
+			name:    "compact imagePullSecrets",
+			content: "spec:\n  imagePullSecrets:\n  - name: reg\n  - name: other\n  containers:\n  - name: reg\n",
+			want:    "spec:\n  imagePullSecrets:\n  - name: reg-h\n  - name: other\n  containers:\n  - name: reg\n",
+		},
+		{
+			name:    "compact envFrom",
+			content: "envFrom:\n- configMapRef:\n    name: app-config\n- secretRef:\n    name: creds\n",
+			want:    "envFrom:\n- configMapRef:\n    name: app-config-h\n- secretRef:\n    name: creds-h\n",
+		},
+		{
+			name:    "compact volumes",
+			content: "volumes:\n- name: config\n  configMap:\n    name: app-config\n- name: creds\n  secret:\n    secretName: creds\nname: app-config\n",
+			want:    "volumes:\n- name: config\n  configMap:\n    name: app-config-h\n- name: creds\n  secret:\n    secretName: creds-h\nname: app-config\n",
+		},
+		{
+			name:    "indented volumes",
+			content: "volumes:\n  - name: config\n    configMap:\n      name: \"app-config\" # generated\n",
+			want:    "volumes:\n  - name: config\n    configMap:\n      name: \"app-config-h\" # generated\n",
+		},
+		{
+			name:    "sibling after a list",
+			content: "configMap:\n  items:\n  - key: a\n  name: app-config\n",
+			want:    "configMap:\n  items:\n  - key: a\n  name: app-config-h\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, changed := rewriteReferences(tt.content, renames)
+			if got != tt.want {
+				t.Errorf("rewriteReferences() =\n%s\nwant\n%s", got, tt.want)
+			}
+			if changed != (tt.content != tt.want) {
+				t.Errorf("changed = %v", changed)
+			}
+		})
+	}
+}
@@ -0,0 +1,356 @@
+// Package main implements a render/v1 plugin for CUE.
+//
+// The templates/*.cue source files are loaded as one CUE package, together
+// with a generated file declaring the fields values, release, chart and
+// capabilities from the input message. The chart's values are unified with
+// the schema the templates define, #Values by default, so values that do not
+// match it fail the render with the CUE positions of the conflict. Each field
+// of the manifests struct, which must then be concrete, is exported as a YAML
+// file named after the field.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"cuelang.org/go/cue"
+	"cuelang.org/go/cue/ast"
+	"cuelang.org/go/cue/build"
+	"cuelang.org/go/cue/cuecontext"
+	"cuelang.org/go/cue/errors"
+	"cuelang.org/go/cue/parser"
+	cueyaml "cuelang.org/go/encoding/yaml"
+)
+
+// maxRenderedSize bounds the output of a single manifests field.
+const maxRenderedSize = 16 << 20
+
+// inputFile is the name of the generated file declaring the input fields, as
+// it appears in error positions.
+const inputFile = "<input>"
+
+// ReleaseInfo contains release metadata passed to render plugins.
+type ReleaseInfo struct {
+	Name      string `json:"name"`
+	Namespace string `json:"namespace"`
+	Revision  int    `json:"revision"`
+	IsInstall bool   `json:"isInstall"`
+	IsUpgrade bool   `json:"isUpgrade"`
+	Service   string `json:"service"`
+}
+
+// ChartInfo contains chart metadata passed to render plugins.
+type ChartInfo struct {
+	Name        string `json:"name"`
+	Version     string `json:"version"`
+	AppVersion  string `json:"appVersion,omitempty"`
+	Description string `json:"description,omitempty"`
+	Type        string `json:"type,omitempty"`
+	IsRoot      bool   `json:"isRoot"`
+}
+
+// CapabilitiesInfo contains Kubernetes cluster capabilities.
+type CapabilitiesInfo struct {
+	KubeVersion map[string]interface{} `json:"kubeVersion"`
+	APIVersions []string               `json:"apiVersions"`
+	HelmVersion string                 `json:"helmVersion"`
+}
+
+// SourceFile represents a file in the chart.
+type SourceFile struct {
+	Name string `json:"name"`
+	Data []byte `json:"data"`
+}
+
+// InputMessageRenderV1 is the input message for render/v1 plugins.
+type InputMessageRenderV1 struct {
+	Release      ReleaseInfo            `json:"release"`
+	Values       map[string]interface{} `json:"values"`
+	Chart        ChartInfo              `json:"chart"`
+	Capabilities CapabilitiesInfo       `json:"capabilities"`
+	SourceFiles  []SourceFile           `json:"sourceFiles"`
+	Config       PluginConfig           `json:"config"`
+}
+
+// PluginConfig is the chart's configuration for this plugin, validated by
+// Helm against the configSchema in plugin.yaml.
+type PluginConfig struct {
+	// ValuesSchema is the path of the definition the values are unified
+	// with. Empty means #Values.
+	ValuesSchema string `json:"valuesSchema"`
+	// Manifests is the path of the struct whose fields are exported. Empty
+	// means manifests.
+	Manifests string `json:"manifests"`
+}
+
+// OutputMessageRenderV1 is the output message from render/v1 plugins.
+type OutputMessageRenderV1 struct {
+	RenderedFiles map[string]string `json:"renderedFiles"`
+	Errors        []string          `json:"errors,omitempty"`
+}
+
+// fileLabel matches the manifests field names that are used as file names.
+var fileLabel = regexp.MustCompile(`^[A-Za-z0-9][-A-Za-z0-9_.]*$`)
+
+// render evaluates a raw render/v1 input message and returns the output
+// message along with the exit code the plugin returns to Helm.
+func render(inputBytes []byte) (OutputMessageRenderV1, uint32) {
+	if len(inputBytes) == 0 {
+		return errorOutput("no input provided")
+	}
+
+	var input InputMessageRenderV1
+	if err := json.Unmarshal(inputBytes, &input); err != nil {
+		return errorOutput(fmt.Sprintf("failed to parse input: %v", err))
+	}
+
+	logf("Received %d source files", len(input.SourceFiles))
+
+	schemaPath, manifestsPath := input.Config.ValuesSchema, input.Config.Manifests
+	if schemaPath == "" {
+		schemaPath = "#Values"
+	}
+	if manifestsPath == "" {
+		manifestsPath = "manifests"
+	}
+
+	inst, errs := loadInstance(&input)
+	if errs != nil {
+		return errorsOutput(errs)
+	}
+	if inst == nil {
+		return OutputMessageRenderV1{RenderedFiles: make(map[string]string)}, 0
+	}
+
+	ctx := cuecontext.New()
+	v := ctx.BuildInstance(inst)
+	if err := v.Err(); err != nil {
+		return errorsOutput(cueErrors(err))
+	}
+
+	schema := v.LookupPath(cue.ParsePath(schemaPath))
+	if !schema.Exists() {
+		return errorOutput(fmt.Sprintf("the templates do not define the values schema %s", schemaPath))
+	}
+	v = v.FillPath(cue.ParsePath("values"), schema)
+	if err := v.LookupPath(cue.ParsePath("values")).Validate(cue.Concrete(true)); err != nil {
+		return errorsOutput(cueErrors(err))
+	}
+
+	manifests := v.LookupPath(cue.ParsePath(manifestsPath))
+	if !manifests.Exists() {
+		return errorOutput(fmt.Sprintf("the templates do not define %s", manifestsPath))
+	}
+	if err := manifests.Validate(cue.Concrete(true)); err != nil {
+		return errorsOutput(cueErrors(err))
+	}
+	fields, err := manifests.Fields()
+	if err != nil {
+		return errorsOutput(cueErrors(err))
+	}
+
+	output := OutputMessageRenderV1{
+		RenderedFiles: make(map[string]string),
+	}
+	for fields.Next() {
+		label := fields.Selector().Unquoted()
+		at := manifestsPath + "." + fields.Selector().String()
+		if !fileLabel.MatchString(label) {
+			output.Errors = append(output.Errors, fmt.Sprintf("%s: field name is not a valid file name", at))
+			continue
+		}
+		logf("Exporting %s", at)
+		rendered, err := export(fields.Value(), at)
+		if err != nil {
+			output.Errors = append(output.Errors, err.Error())
+			continue
+		}
+		if rendered == "" {
+			continue
+		}
+		output.RenderedFiles["templates/"+label+".yaml"] = rendered
+	}
+
+	// Helm treats a non-zero exit code as the signal that Errors is set
+	if len(output.Errors) > 0 {
+		return output, 1
+	}
+	return output, 0
+}
+
+// loadInstance parses the CUE source files into one build instance, adding
+// the generated input file. It returns nil if there are no CUE files.
+func loadInstance(input *InputMessageRenderV1) (*build.Instance, []string) {
+	var files []*ast.File
+	var errs []string
+	pkg := ""
+	for _, sf := range input.SourceFiles {
+		if !strings.HasSuffix(sf.Name, ".cue") {
+			continue
+		}
+		f, err := parser.ParseFile(sf.Name, sf.Data, parser.ParseComments)
+		if err != nil {
+			errs = append(errs, cueErrors(err)...)
+			continue
+		}
+		name := f.PackageName()
+		if len(files) > 0 && name != pkg {
+			errs = append(errs, fmt.Sprintf("%s: package %q differs from package %q of %s", sf.Name, name, pkg, files[0].Filename))
+			continue
+		}
+		pkg = name
+		files = append(files, f)
+	}
+	if errs != nil {
+		return nil, errs
+	}
+	if len(files) == 0 {
+		return nil, nil
+	}
+
+	// Files without a package clause would each be evaluated on their own,
+	// unable to refer to each other or to the input fields, so they are
+	// put in one package.
+	if pkg == "" {
+		pkg = "chart"
+		for _, f := range files {
+			f.Decls = append([]ast.Decl{&ast.Package{Name: ast.NewIdent(pkg)}}, f.Decls...)
+		}
+	}
+
+	src, err := inputSource(pkg, input)
+	if err != nil {
+		return nil, []string{err.Error()}
+	}
+	f, err := parser.ParseFile(inputFile, src)
+	if err != nil {
+		return nil, cueErrors(err)
+	}
+
+	inst := build.NewContext().NewInstance("templates", nil)
+	for _, f := range append(files, f) {
+		if err := inst.AddSyntax(f); err != nil {
+			return nil, cueErrors(err)
+		}
+	}
+	return inst, nil
+}
+
+// inputSource returns the CUE source of the generated input file in package
+// pkg. Values are indented JSON, one field per line, so that error positions
+// in it point at the offending value.
+func inputSource(pkg string, input *InputMessageRenderV1) ([]byte, error) {
+	values := input.Values
+	if values == nil {
+		values = map[string]interface{}{}
+	}
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "package %s\n\n", pkg)
+	for _, field := range []struct {
+		name  string
+		value interface{}
+	}{
+		{"values", values},
+		{"release", input.Release},
+		{"chart", input.Chart},
+		{"capabilities", input.Capabilities},
+	} {
+		data, err := json.MarshalIndent(field.value, "", "  ")
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode %s: %v", field.name, err)
+		}
+		fmt.Fprintf(&b, "%s: %s\n", field.name, data)
+	}
+	return b.Bytes(), nil
+}
+
+// export returns the YAML documents for a manifests field, which must be a
+// Kubernetes object (a struct with a kind) or a list of them. at is the
+// field's path, for error messages.
+func export(v cue.Value, at string) (string, error) {
+	var items []cue.Value
+	switch v.Kind() {
+	case cue.StructKind:
+		items = []cue.Value{v}
+	case cue.ListKind:
+		list, err := v.List()
+		if err != nil {
+			return "", fmt.Errorf("%s: %v", at, err)
+		}
+		for list.Next() {
+			items = append(items, list.Value())
+		}
+	default:
+		return "", fmt.Errorf("%s: is not a Kubernetes object or a list of them", at)
+	}
+
+	var b strings.Builder
+	for i, item := range items {
+		if item.Kind() != cue.StructKind || !item.LookupPath(cue.ParsePath("kind")).Exists() {
+			return "", fmt.Errorf("%s: is not a Kubernetes object or a list of them", at)
+		}
+		data, err := cueyaml.Encode(item)
+		if err != nil {
+			return "", fmt.Errorf("%s: %s", at, strings.Join(cueErrors(err), "; "))
+		}
+		if i > 0 {
+			b.WriteString("---\n")
+		}
+		b.Write(data)
+		if b.Len() > maxRenderedSize {
+			return "", fmt.Errorf("%s: rendered output exceeds %d bytes", at, maxRenderedSize)
+		}
+	}
+	return b.String(), nil
+}
+
+// cueErrors returns one message per error in err, each followed by the
+// positions CUE reports for it, e.g.
+// "values.replicas: conflicting values "3" and int (<input>:7:15, templates/schema.cue:4:12)".
+func cueErrors(err error) []string {
+	var msgs []string
+	for _, e := range errors.Errors(err) {
+		msg := errors.String(e)
+		var positions []string
+		for _, pos := range errors.Positions(e) {
+			if pos.IsValid() {
+				positions = append(positions, pos.String())
+			}
+		}
+		sort.Strings(positions)
+		if len(positions) > 0 {
+			msg += " (" + strings.Join(positions, ", ") + ")"
+		}
+		msgs = append(msgs, msg)
+	}
+	if len(msgs) == 0 {
+		msgs = append(msgs, err.Error())
+	}
+	return msgs
+}
+
+// errorOutput returns an output message reporting msg, with a failing exit
+// code.
+func errorOutput(msg string) (OutputMessageRenderV1, uint32) {
+	return errorsOutput([]string{msg})
+}
+
+// errorsOutput returns an output message reporting msgs, with a failing exit
+// code.
+func errorsOutput(msgs []string) (OutputMessageRenderV1, uint32) {
+	return OutputMessageRenderV1{
+		RenderedFiles: make(map[string]string),
+		Errors:        msgs,
+	}, 1
+}
+
+// logf logs a debug message. main.go sends it to Helm when built as a
+// plugin; natively, e.g. in tests, it is discarded.
+var logf = func(format string, args ...interface{}) {}
+
+// main is empty: Helm calls the helm_plugin_main export defined in main.go.
+func main() {}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+const schemaCUE = `package app
+
+#Values: {
+	replicas: int & >=1 | *1
+	image: {
+		repository: string
+		tag:        string | *"latest"
+	}
+	port?: int
+}
+`
+
+const appCUE = `package app
+
+manifests: deployment: {
+	apiVersion: "apps/v1"
+	kind:       "Deployment"
+	metadata: {
+		name:      release.name
+		namespace: release.namespace
+		labels: "helm.sh/chart": "\(chart.name)-\(chart.version)"
+	}
+	spec: {
+		replicas: values.replicas
+		template: spec: containers: [{
+			name:  "app"
+			image: "\(values.image.repository):\(values.image.tag)"
+		}]
+	}
+}
+`
+
+func TestRender(t *testing.T) {
+	file := func(name, data string) SourceFile {
+		return SourceFile{Name: name, Data: []byte(data)}
+	}
+	tests := []struct {
+		name       string
+		files      []SourceFile
+		values     map[string]interface{}
+		config     PluginConfig
+		raw        string
+		want       map[string]string
+		wantErrors []string
+	}{
+		{
+			name:   "unifies values with the schema and exports manifests",
+			files:  []SourceFile{file("templates/schema.cue", schemaCUE), file("templates/app.cue", appCUE)},
+			values: map[string]interface{}{"image": map[string]interface{}{"repository": "nginx"}},
+			want: map[string]string{"templates/deployment.yaml": `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+  namespace: prod
+  labels:
+    helm.sh/chart: demo-0.1.0
+spec:
+  replicas: 1
+  template:
+    spec:
+      containers:
+        - name: app
+          image: nginx:latest
+`},
+		},
+		{
+			name: "list of objects and custom paths",
+			files: []SourceFile{file("templates/a.cue", `
+#Schema: {names: [...string]}
+out: {
+	configmaps: [for n in values.names {apiVersion: "v1", kind: "ConfigMap", metadata: name: n}]
+	"empty-list": []
+}
+`)},
+			values: map[string]interface{}{"names": []interface{}{"a", "b"}},
+			config: PluginConfig{ValuesSchema: "#Schema", Manifests: "out"},
+			want: map[string]string{"templates/configmaps.yaml": `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: a
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: b
+`},
+		},
+		{
+			name:       "conflicting value",
+			files:      []SourceFile{file("templates/schema.cue", schemaCUE), file("templates/app.cue", appCUE)},
+			values:     map[string]interface{}{"replicas": "3", "image": map[string]interface{}{"repository": "nginx"}},
+			wantErrors: []string{`values.replicas: conflicting values "3" and int (mismatched types string and int) (<input>:7:15, templates/schema.cue:4:12)`},
+		},
+		{
+			name:       "constraint violation",
+			files:      []SourceFile{file("templates/schema.cue", schemaCUE), file("templates/app.cue", appCUE)},
+			values:     map[string]interface{}{"replicas": 0, "image": map[string]interface{}{"repository": "nginx"}},
+			wantErrors: []string{"values.replicas: invalid value 0 (out of bound >=1)", "templates/schema.cue:4:18"},
+		},
+		{
+			name:       "unknown value",
+			files:      []SourceFile{file("templates/schema.cue", schemaCUE), file("templates/app.cue", appCUE)},
+			values:     map[string]interface{}{"image": map[string]interface{}{"repository": "nginx"}, "replicaCount": 2},
+			wantErrors: []string{"values.replicaCount: field not allowed", "<input>:7:3"},
+		},
+		{
+			name:       "missing required value",
+			files:      []SourceFile{file("templates/schema.cue", schemaCUE), file("templates/app.cue", appCUE)},
+			wantErrors: []string{"values.image.repository: incomplete value string", "templates/schema.cue:6:15"},
+		},
+		{
+			name:       "no schema",
+			files:      []SourceFile{file("templates/app.cue", "manifests: {}\n")},
+			wantErrors: []string{"the templates do not define the values schema #Values"},
+		},
+		{
+			name:       "no manifests",
+			files:      []SourceFile{file("templates/schema.cue", "#Values: {...}\n")},
+			wantErrors: []string{"the templates do not define manifests"},
+		},
+		{
+			name:       "not an object",
+			files:      []SourceFile{file("templates/a.cue", "#Values: {...}\nmanifests: {x: 1, y: [{kind: \"A\"}, 2]}\n")},
+			wantErrors: []string{"manifests.x: is not a Kubernetes object or a list of them", "manifests.y: is not a Kubernetes object or a list of them"},
+		},
+		{
+			name:       "invalid file name",
+			files:      []SourceFile{file("templates/a.cue", "#Values: {...}\nmanifests: {\"../x\": {kind: \"A\"}}\n")},
+			wantErrors: []string{`manifests."../x": field name is not a valid file name`},
+		},
+		{
+			name:       "syntax error",
+			files:      []SourceFile{file("templates/a.cue", "#Values: {\n")},
+			wantErrors: []string{"expected '}', found 'EOF' (templates/a.cue:1:12)"},
+		},
+		{
+			name:       "mixed packages",
+			files:      []SourceFile{file("templates/a.cue", "package a\n"), file("templates/b.cue", "package b\n")},
+			wantErrors: []string{`templates/b.cue: package "b" differs from package "a" of templates/a.cue`},
+		},
+		{
+			name:  "non-CUE files are ignored",
+			files: []SourceFile{file("templates/notes.txt", "hello")},
+			want:  map[string]string{},
+		},
+		{
+			name:       "malformed JSON",
+			raw:        `{"values": []}`,
+			wantErrors: []string{"failed to parse input"},
+		},
+		{
+			name:       "empty input",
+			raw:        ``,
+			wantErrors: []string{"no input provided"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			input := []byte(tt.raw)
+			if tt.files != nil {
+				var err error
+				input, err = json.Marshal(InputMessageRenderV1{
+					Release:     ReleaseInfo{Name: "web", Namespace: "prod"},
+					Values:      tt.values,
+					Chart:       ChartInfo{Name: "demo", Version: "0.1.0"},
+					SourceFiles: tt.files,
+					Config:      tt.config,
+				})
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			output, code := render(input)
+			if (code != 0) != (len(tt.wantErrors) > 0) {
+				t.Errorf("exit code = %d, errors %q", code, output.Errors)
+			}
+			if tt.want != nil {
+				if len(output.RenderedFiles) != len(tt.want) {
+					t.Errorf("rendered %d files, want %d: %v", len(output.RenderedFiles), len(tt.want), output.RenderedFiles)
+				}
+				for name, want := range tt.want {
+					if got := output.RenderedFiles[name]; got != want {
+						t.Errorf("%s =\n%s\nwant\n%s", name, got, want)
+					}
+				}
+			}
+
+			errs := strings.Join(output.Errors, "\n")
+			if len(tt.wantErrors) == 0 && errs != "" {
+				t.Errorf("unexpected errors: %s", errs)
+			}
+			for _, want := range tt.wantErrors {
+				if !strings.Contains(errs, want) {
+					t.Errorf("errors %q do not contain %q", errs, want)
+				}
+			}
+		})
+	}
+}
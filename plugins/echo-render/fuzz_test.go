@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/scottrigby/ref-hip-chart-defined-plugins/renderv1"
+)
+
+// chartSeeds returns the example chart inputs recorded by plugin-run in
+// plugin-run/testdata/seeds, one per chart, each passed to configure first
+// if it is not nil.
+func chartSeeds(tb testing.TB, configure func(*InputMessage)) [][]byte {
+	files, err := filepath.Glob(filepath.Join("..", "..", "plugin-run", "testdata", "seeds", "*.json"))
+	if err != nil || len(files) == 0 {
+		tb.Fatalf("no seeds in plugin-run/testdata/seeds: %v", err)
+	}
+
+	var seeds [][]byte
+	for _, file := range files {
+		var input InputMessage
+		data, err := os.ReadFile(file)
+		if err == nil {
+			err = json.Unmarshal(data, &input)
+		}
+		if err != nil {
+			tb.Fatalf("%s: %v", file, err)
+		}
+		if configure != nil {
+			configure(&input)
+		}
+
+		seed, err := json.Marshal(input)
+		if err != nil {
+			tb.Fatal(err)
+		}
+		seeds = append(seeds, seed)
+	}
+	return seeds
+}
+
+// labelBytes bounds the JSON size of the labels render may add to the
+// documents in input.
+func labelBytes(data []byte) int {
+	var input InputMessage
+	if json.Unmarshal(data, &input) != nil || !input.Config.InjectLabels {
+		return 0
+	}
+	docs := 0
+	for _, sf := range input.SourceFiles {
+		docs += len(renderv1.SplitDocuments(sf.Name, string(sf.Data)))
+	}
+	perDoc := 256
+	for _, l := range releaseLabels(input.Release, input.Chart) {
+		perDoc += 2 * (len(l.key) + len(l.value))
+	}
+	return 6 * docs * perDoc
+}
+
+func FuzzRender(f *testing.F) {
+	for _, seed := range chartSeeds(f, func(input *InputMessage) {
+		input.Config = PluginConfig{InjectLabels: true}
+	}) {
+		f.Add(seed)
+	}
+	f.Add([]byte(``))
+	f.Add([]byte(`{}`))
+	f.Add([]byte(`{"release": {"name": 42}, "sourceFiles": [{"name": "a", "data": ""}]}`))
+	f.Add([]byte(`{"sourceFiles": [{"name": "", "data": null}, {"name": ".echo"}]}`))
+	f.Add([]byte(`{"config": {"injectLabels": true, "header": ["${source}", "${nope}"]}, "sourceFiles": [{"name": "a", "data": "a2luZDogeAptZXRhZGF0YTogNQo="}]}`))
+
+	f.Fuzz(func(t *testing.T, input []byte) {
+		output, code := render(input)
+
+		data, err := json.Marshal(output)
+		if err != nil || !json.Valid(data) {
+			t.Fatalf("output is not valid JSON: %v", err)
+		}
+		if (code != 0) != (len(output.Errors) > 0) {
+			t.Fatalf("exit code %d with errors %q", code, output.Errors)
+		}
+		// Each file is echoed once, with a bounded header and a few labels
+		// per document.
+		if len(data) > 8*len(input)+(1024+2*maxHeaderSize)*(len(output.RenderedFiles)+1)+labelBytes(input) {
+			t.Fatalf("output of %d bytes for %d bytes of input", len(data), len(input))
+		}
+	})
+}
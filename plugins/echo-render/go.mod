@@ -1,7 +1,15 @@
 module echo-render
 
-go 1.21.0
+go 1.24.0
 
 toolchain go1.24.3
 
 require github.com/extism/go-pdk v1.1.0
+
+require (
+	github.com/scottrigby/ref-hip-chart-defined-plugins/renderv1 v0.0.0
+	go.yaml.in/yaml/v3 v3.0.4
+)
+
+// renderv1 holds the render/v1 types shared with hosts, in this repository.
+replace github.com/scottrigby/ref-hip-chart-defined-plugins/renderv1 => ../../renderv1
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/scottrigby/ref-hip-chart-defined-plugins/renderv1"
+	"go.yaml.in/yaml/v3"
+)
+
+// ReleaseInfo contains release metadata passed to render plugins.
+type ReleaseInfo struct {
+	Name      string `json:"name"`
+	Namespace string `json:"namespace"`
+	Revision  int    `json:"revision"`
+	IsInstall bool   `json:"isInstall"`
+	IsUpgrade bool   `json:"isUpgrade"`
+	Service   string `json:"service"`
+}
+
+// ChartInfo contains chart metadata passed to render plugins.
+type ChartInfo struct {
+	Name       string `json:"name"`
+	Version    string `json:"version"`
+	AppVersion string `json:"appVersion,omitempty"`
+}
+
+// CapabilitiesInfo contains Kubernetes cluster capabilities.
+type CapabilitiesInfo struct {
+	KubeVersion map[string]interface{} `json:"kubeVersion"`
+	HelmVersion string                 `json:"helmVersion"`
+}
+
+// InputMessage represents the render/v1 input
+type InputMessage struct {
+	Release      ReleaseInfo            `json:"release"`
+	Values       map[string]interface{} `json:"values"`
+	Chart        ChartInfo              `json:"chart"`
+	Capabilities CapabilitiesInfo       `json:"capabilities"`
+	SourceFiles  []SourceFile           `json:"sourceFiles"`
+	Config       PluginConfig           `json:"config"`
+}
+
+// PluginConfig is the chart's configuration for this plugin, validated by
+// Helm against the configSchema in plugin.yaml.
+type PluginConfig struct {
+	// Header lists the comment lines written at the top of each rendered
+	// file, with placeholders expanded. Nil means defaultHeader; an empty
+	// list writes no header.
+	Header []string `json:"header"`
+	// InjectLabels adds release and chart labels to the metadata of every
+	// Kubernetes object.
+	InjectLabels bool `json:"injectLabels"`
+}
+
+// SourceFile represents a file to render
+type SourceFile struct {
+	Name string `json:"name"`
+	Data []byte `json:"data"`
+}
+
+// OutputMessage represents the render/v1 output
+type OutputMessage struct {
+	RenderedFiles map[string]string `json:"renderedFiles"`
+	Errors        []string          `json:"errors,omitempty"`
+}
+
+// maxHeaderSize bounds a rendered file's header after expansion, since the
+// header is repeated in every file.
+const maxHeaderSize = 4096
+
+// defaultHeader is the header written when the config does not set one.
+var defaultHeader = []string{"Rendered by echo-render plugin", "Release: ${release.name}"}
+
+// placeholder matches a ${name} reference in a header line.
+var placeholder = regexp.MustCompile(`\$\{([^}]*)\}`)
+
+// render processes the raw input and returns the output message along with
+// the exit code the plugin returns to Helm.
+func render(inputData []byte) (OutputMessage, uint32) {
+	if len(inputData) == 0 {
+		return errorOutput("no input provided")
+	}
+
+	var input InputMessage
+	if err := json.Unmarshal(inputData, &input); err != nil {
+		return errorOutput("failed to parse input: " + err.Error())
+	}
+
+	output := OutputMessage{
+		RenderedFiles: make(map[string]string),
+	}
+
+	header := input.Config.Header
+	if header == nil {
+		header = defaultHeader
+	}
+	var labels []label
+	if input.Config.InjectLabels {
+		labels = releaseLabels(input.Release, input.Chart)
+	}
+
+	sources := make(map[string]string)
+	for _, sf := range input.SourceFiles {
+		outName := outputName(sf.Name)
+		if other, ok := sources[outName]; ok {
+			output.Errors = append(output.Errors, fmt.Sprintf("%s and %s both render to %s", other, sf.Name, outName))
+			continue
+		}
+		sources[outName] = sf.Name
+
+		comments, err := expandHeader(header, &input, sf.Name)
+		if err != nil {
+			return errorOutput(err.Error())
+		}
+		body, err := processDocuments(sf.Name, string(sf.Data), labels)
+		if err != nil {
+			output.Errors = append(output.Errors, sf.Name+": "+err.Error())
+			continue
+		}
+		output.RenderedFiles[outName] = comments + body
+	}
+
+	if len(output.Errors) > 0 {
+		return output, 1
+	}
+	return output, 0
+}
+
+// outputName returns the rendered file name for a source file: a trailing
+// ".echo" is dropped, and ".yaml" is appended unless the name then ends in
+// ".yaml" or ".yml".
+func outputName(name string) string {
+	name = strings.TrimSuffix(name, ".echo")
+	if strings.HasSuffix(name, ".yaml") || strings.HasSuffix(name, ".yml") {
+		return name
+	}
+	return name + ".yaml"
+}
+
+// expandHeader returns the header lines as YAML comments, with placeholders
+// replaced by values from input.
+func expandHeader(lines []string, input *InputMessage, source string) (string, error) {
+	values := map[string]string{
+		"release.name":      input.Release.Name,
+		"release.namespace": input.Release.Namespace,
+		"chart.name":        input.Chart.Name,
+		"chart.version":     input.Chart.Version,
+		"chart.appVersion":  input.Chart.AppVersion,
+		"source":            source,
+	}
+	if v, ok := input.Capabilities.KubeVersion["version"].(string); ok {
+		values["capabilities.kubeVersion"] = v
+	}
+	if values["release.name"] == "" {
+		values["release.name"] = "unknown"
+	}
+
+	var b strings.Builder
+	for _, line := range lines {
+		var unknown string
+		expanded := placeholder.ReplaceAllStringFunc(line, func(ref string) string {
+			name := ref[2 : len(ref)-1]
+			v, ok := values[name]
+			if !ok && unknown == "" {
+				unknown = ref
+			}
+			return v
+		})
+		if unknown != "" {
+			return "", fmt.Errorf("header: unknown placeholder %s", unknown)
+		}
+		for _, l := range strings.Split(expanded, "\n") {
+			b.WriteString(strings.TrimRight("# "+l, " ") + "\n")
+		}
+		if b.Len() > maxHeaderSize {
+			return "", fmt.Errorf("header: expands to more than %d bytes", maxHeaderSize)
+		}
+	}
+	return b.String(), nil
+}
+
+// label is a metadata label added to rendered objects.
+type label struct {
+	key, value string
+}
+
+// releaseLabels returns the labels identifying the release and chart, in the
+// order they are added.
+func releaseLabels(release ReleaseInfo, chart ChartInfo) []label {
+	var labels []label
+	if release.Name != "" {
+		labels = append(labels, label{"app.kubernetes.io/instance", release.Name})
+	}
+	service := release.Service
+	if service == "" {
+		service = "Helm"
+	}
+	labels = append(labels, label{"app.kubernetes.io/managed-by", service})
+	if chart.Name != "" {
+		labels = append(labels, label{"helm.sh/chart", chart.Name + "-" + strings.ReplaceAll(chart.Version, "+", "_")})
+	}
+	return labels
+}
+
+// processDocuments checks that every document in the content of the file
+// name is a YAML mapping and adds labels to the Kubernetes objects among
+// them. Content is returned unchanged when there are no labels to add.
+func processDocuments(name, content string, labels []label) (string, error) {
+	parts := renderv1.SplitDocuments(name, content)
+	modified := false
+	for i, part := range parts {
+		var doc yaml.Node
+		if err := yaml.Unmarshal([]byte(part.Content), &doc); err != nil {
+			return "", fmt.Errorf("document %d is not valid YAML: %v", i, err)
+		}
+		if len(doc.Content) == 0 || doc.Content[0].Kind != yaml.MappingNode {
+			return "", fmt.Errorf("document %d is not a YAML mapping", i)
+		}
+		if len(labels) == 0 || renderv1.MappingValue(doc.Content[0], "kind") == nil {
+			continue
+		}
+		if err := addLabels(doc.Content[0], labels); err != nil {
+			return "", fmt.Errorf("document %d: %v", i, err)
+		}
+
+		var buf bytes.Buffer
+		enc := yaml.NewEncoder(&buf)
+		enc.SetIndent(2)
+		if err := enc.Encode(&doc); err != nil {
+			return "", fmt.Errorf("document %d: %v", i, err)
+		}
+		enc.Close()
+		parts[i].Content = buf.String()
+		modified = true
+	}
+
+	if !modified {
+		return content, nil
+	}
+	return renderv1.JoinDocuments(parts), nil
+}
+
+// addLabels sets each label in obj's metadata.labels that is not already
+// set, creating metadata and labels as needed.
+func addLabels(obj *yaml.Node, labels []label) error {
+	metadata, err := childMapping(obj, "metadata")
+	if err != nil {
+		return err
+	}
+	existing, err := childMapping(metadata, "labels")
+	if err != nil {
+		return fmt.Errorf("metadata.%v", err)
+	}
+	for _, l := range labels {
+		if renderv1.MappingValue(existing, l.key) != nil {
+			continue
+		}
+		existing.Content = append(existing.Content,
+			&yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: l.key},
+			&yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: l.value},
+		)
+	}
+	return nil
+}
+
+// childMapping returns the mapping under key in m, adding an empty one if the
+// key is missing or null.
+func childMapping(m *yaml.Node, key string) (*yaml.Node, error) {
+	value := renderv1.MappingValue(m, key)
+	switch {
+	case value == nil:
+		value = &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+		m.Content = append(m.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key}, value)
+	case value.Kind == yaml.ScalarNode && value.Tag == "!!null":
+		*value = yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+	case value.Kind != yaml.MappingNode:
+		return nil, fmt.Errorf("%s is not a mapping", key)
+	}
+	return value, nil
+}
+
+func errorOutput(msg string) (OutputMessage, uint32) {
+	return OutputMessage{
+		RenderedFiles: make(map[string]string),
+		Errors:        []string{msg},
+	}, 1
+}
+
+// main is empty: Helm calls the helm_plugin_main export defined in main.go.
+func main() {}
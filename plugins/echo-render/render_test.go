@@ -0,0 +1,203 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// inputJSON returns an input message for release "web" of chart "app"
+// 1.0.0+build with the given config and source files, given as name/content
+// pairs.
+func inputJSON(t *testing.T, config map[string]interface{}, files ...string) string {
+	t.Helper()
+	var sourceFiles []map[string]string
+	for i := 0; i+1 < len(files); i += 2 {
+		sourceFiles = append(sourceFiles, map[string]string{
+			"name": files[i],
+			"data": base64.StdEncoding.EncodeToString([]byte(files[i+1])),
+		})
+	}
+	data, err := json.Marshal(map[string]interface{}{
+		"release":      map[string]interface{}{"name": "web", "namespace": "prod", "service": "Helm"},
+		"chart":        map[string]interface{}{"name": "app", "version": "1.0.0+build", "appVersion": "2.1"},
+		"capabilities": map[string]interface{}{"kubeVersion": map[string]interface{}{"version": "v1.35.0"}},
+		"sourceFiles":  sourceFiles,
+		"config":       config,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+const defaultHeaderText = "# Rendered by echo-render plugin\n# Release: web\n"
+
+func TestRender(t *testing.T) {
+	noHeader := map[string]interface{}{"header": []string{}}
+	labels := map[string]interface{}{"header": []string{}, "injectLabels": true}
+
+	tests := []struct {
+		name       string
+		input      string
+		wantCode   uint32
+		want       map[string]string
+		wantErrors []string
+	}{
+		{
+			name:  "echoes files with a header",
+			input: `{"release": {"name": "web"}, "sourceFiles": [{"name": "templates/cm.echo", "data": "a2V5OiB2YWx1ZQo="}]}`,
+			want: map[string]string{
+				"templates/cm.yaml": "# Rendered by echo-render plugin\n# Release: web\nkey: value\n",
+			},
+		},
+		{
+			name:  "unknown release name",
+			input: `{"sourceFiles": [{"name": "templates/cm.echo", "data": ""}]}`,
+			want: map[string]string{
+				"templates/cm.yaml": "# Rendered by echo-render plugin\n# Release: unknown\n",
+			},
+		},
+		{
+			name:  "names without the .echo extension",
+			input: `{"release": {"name": "web"}, "sourceFiles": [{"name": "a", "data": ""}, {"name": "templates/cm.txt", "data": ""}]}`,
+			want: map[string]string{
+				"a.yaml":                "# Rendered by echo-render plugin\n# Release: web\n",
+				"templates/cm.txt.yaml": "# Rendered by echo-render plugin\n# Release: web\n",
+			},
+		},
+		{
+			name:  "names already ending in .yaml or .yml",
+			input: inputJSON(t, nil, "templates/a.yaml.echo", "", "templates/b.yml", "", "templates/c.echo.echo", ""),
+			want: map[string]string{
+				"templates/a.yaml":      defaultHeaderText,
+				"templates/b.yml":       defaultHeaderText,
+				"templates/c.echo.yaml": defaultHeaderText,
+			},
+		},
+		{
+			name:       "two files rendering to the same name",
+			input:      inputJSON(t, nil, "templates/a.echo", "", "templates/a.yaml.echo", ""),
+			wantCode:   1,
+			want:       map[string]string{"templates/a.yaml": defaultHeaderText},
+			wantErrors: []string{"templates/a.echo and templates/a.yaml.echo both render to templates/a.yaml"},
+		},
+		{
+			name: "custom header",
+			input: inputJSON(t, map[string]interface{}{"header": []string{
+				"Source: ${source}",
+				"",
+				"${chart.name} ${chart.version} (${chart.appVersion}) in ${release.namespace} on ${capabilities.kubeVersion}",
+			}}, "templates/cm.echo", "a: 1\n"),
+			want: map[string]string{
+				"templates/cm.yaml": "# Source: templates/cm.echo\n#\n# app 1.0.0+build (2.1) in prod on v1.35.0\na: 1\n",
+			},
+		},
+		{
+			name:  "empty header",
+			input: inputJSON(t, noHeader, "templates/cm.echo", "a: 1\n"),
+			want:  map[string]string{"templates/cm.yaml": "a: 1\n"},
+		},
+		{
+			name:       "unknown header placeholder",
+			input:      inputJSON(t, map[string]interface{}{"header": []string{"${values.x}"}}, "templates/cm.echo", "a: 1\n"),
+			wantCode:   1,
+			want:       map[string]string{},
+			wantErrors: []string{"header: unknown placeholder ${values.x}"},
+		},
+		{
+			name:       "header too large",
+			input:      inputJSON(t, map[string]interface{}{"header": []string{strings.Repeat("${source}", 500)}}, "templates/cm.echo", ""),
+			wantCode:   1,
+			want:       map[string]string{},
+			wantErrors: []string{"header: expands to more than 4096 bytes"},
+		},
+		{
+			name:  "passes documents through unchanged",
+			input: inputJSON(t, noHeader, "templates/all.echo", "# c\na:   1 # kept\n---  \n\n---\nb: [1, 2]\n"),
+			want:  map[string]string{"templates/all.yaml": "# c\na:   1 # kept\n---  \n\n---\nb: [1, 2]\n"},
+		},
+		{
+			name:       "invalid YAML",
+			input:      inputJSON(t, noHeader, "templates/ok.echo", "a: 1\n", "templates/bad.echo", "a: 1\n---\nb: [\n"),
+			wantCode:   1,
+			want:       map[string]string{"templates/ok.yaml": "a: 1\n"},
+			wantErrors: []string{"templates/bad.echo: document 1 is not valid YAML"},
+		},
+		{
+			name:       "plain text",
+			input:      inputJSON(t, noHeader, "templates/notes.echo", "Thank you for installing\n"),
+			wantCode:   1,
+			want:       map[string]string{},
+			wantErrors: []string{"templates/notes.echo: document 0 is not a YAML mapping"},
+		},
+		{
+			name: "injects labels into Kubernetes objects",
+			input: inputJSON(t, labels, "templates/all.echo",
+				"apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: a\n"+
+					"---\n# settings\nsettings: {a: 1}\n"+
+					"---\nkind: Service\nmetadata:\n  labels:\n    app.kubernetes.io/instance: mine\n"+
+					"---\nkind: Secret\nmetadata:\n  labels:\n"),
+			want: map[string]string{"templates/all.yaml": "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: a\n  labels:\n" +
+				"    app.kubernetes.io/instance: web\n    app.kubernetes.io/managed-by: Helm\n    helm.sh/chart: app-1.0.0_build\n" +
+				"---\n# settings\nsettings: {a: 1}\n" +
+				"---\nkind: Service\nmetadata:\n  labels:\n" +
+				"    app.kubernetes.io/instance: mine\n    app.kubernetes.io/managed-by: Helm\n    helm.sh/chart: app-1.0.0_build\n" +
+				"---\nkind: Secret\nmetadata:\n  labels:\n" +
+				"    app.kubernetes.io/instance: web\n    app.kubernetes.io/managed-by: Helm\n    helm.sh/chart: app-1.0.0_build\n"},
+		},
+		{
+			name:       "labels that are not a mapping",
+			input:      inputJSON(t, labels, "templates/cm.echo", "kind: ConfigMap\nmetadata:\n  labels: [a]\n"),
+			wantCode:   1,
+			want:       map[string]string{},
+			wantErrors: []string{"templates/cm.echo: document 0: metadata.labels is not a mapping"},
+		},
+		{
+			name:  "no source files",
+			input: `{}`,
+			want:  map[string]string{},
+		},
+		{
+			name:       "empty input",
+			input:      ``,
+			wantCode:   1,
+			want:       map[string]string{},
+			wantErrors: []string{"no input provided"},
+		},
+		{
+			name:       "malformed JSON",
+			input:      `{"sourceFiles": "templates/cm.echo"}`,
+			wantCode:   1,
+			want:       map[string]string{},
+			wantErrors: []string{"failed to parse input"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			output, code := render([]byte(tt.input))
+			if code != tt.wantCode {
+				t.Errorf("exit code = %d, want %d", code, tt.wantCode)
+			}
+			if len(output.RenderedFiles) != len(tt.want) {
+				t.Errorf("rendered %v, want %v", output.RenderedFiles, tt.want)
+			}
+			for name, want := range tt.want {
+				if got := output.RenderedFiles[name]; got != want {
+					t.Errorf("renderedFiles[%q] = %q, want %q", name, got, want)
+				}
+			}
+			errs := strings.Join(output.Errors, "\n")
+			if len(tt.wantErrors) == 0 && errs != "" {
+				t.Errorf("unexpected errors: %s", errs)
+			}
+			for _, want := range tt.wantErrors {
+				if !strings.Contains(errs, want) {
+					t.Errorf("errors %q do not contain %q", errs, want)
+				}
+			}
+		})
+	}
+}
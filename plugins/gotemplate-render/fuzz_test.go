@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// chartSeeds returns the example chart inputs recorded by plugin-run in
+// plugin-run/testdata/seeds, one per chart, each passed to configure first
+// if it is not nil.
+func chartSeeds(tb testing.TB, configure func(*InputMessageRenderV1)) [][]byte {
+	files, err := filepath.Glob(filepath.Join("..", "..", "plugin-run", "testdata", "seeds", "*.json"))
+	if err != nil || len(files) == 0 {
+		tb.Fatalf("no seeds in plugin-run/testdata/seeds: %v", err)
+	}
+
+	var seeds [][]byte
+	for _, file := range files {
+		var input InputMessageRenderV1
+		data, err := os.ReadFile(file)
+		if err == nil {
+			err = json.Unmarshal(data, &input)
+		}
+		if err != nil {
+			tb.Fatalf("%s: %v", file, err)
+		}
+		if configure != nil {
+			configure(&input)
+		}
+
+		seed, err := json.Marshal(input)
+		if err != nil {
+			tb.Fatal(err)
+		}
+		seeds = append(seeds, seed)
+	}
+	return seeds
+}
+
+func FuzzRender(f *testing.F) {
+	for _, seed := range chartSeeds(f, nil) {
+		f.Add(seed)
+	}
+	f.Add([]byte(``))
+	f.Add([]byte(`{}`))
+	f.Add([]byte(`{"sourceFiles": [{"name": "a", "data": "e3sgLlZhbHVlcy54IH19"}], "values": {"x": [1, 2]}}`))
+	f.Add([]byte(`{"sourceFiles": [{"name": "templates/_h.tpl", "data": "e3sgZGVmaW5lICJhIiB9fXt7IGluY2x1ZGUgImEiIC4gfX17eyBlbmQgfX0="}]}`))
+
+	f.Fuzz(func(t *testing.T, input []byte) {
+		output, code := render(input)
+
+		data, err := json.Marshal(output)
+		if err != nil || !json.Valid(data) {
+			t.Fatalf("output is not valid JSON: %v", err)
+		}
+		if (code != 0) != (len(output.Errors) > 0) {
+			t.Fatalf("exit code %d with errors %q", code, output.Errors)
+		}
+		for name, content := range output.RenderedFiles {
+			if len(content) > maxRenderedSize {
+				t.Fatalf("%s: rendered %d bytes, limit is %d", name, len(content), maxRenderedSize)
+			}
+		}
+	})
+}
+
+func TestRenderLimits(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		wantErr  string
+	}{
+		{name: "repeat", template: `{{ repeat "x" 99999999999 }}`, wantErr: "exceeds"},
+		{name: "negative repeat", template: `{{ repeat "x" -1 }}`, wantErr: "negative count"},
+		{name: "indent", template: `{{ indent 99999999999 "x" }}`, wantErr: "exceeds"},
+		{name: "negative nindent", template: `{{ nindent -2 "x" }}`, wantErr: "negative count"},
+		{name: "output", template: `{{ range $i := .Values.l }}{{ range $.Values.l }}{{ repeat "x" 100000 }}{{ end }}{{ end }}`, wantErr: "exceeds"},
+	}
+
+	list := make([]interface{}, 20)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			input, err := json.Marshal(InputMessageRenderV1{
+				Values:      map[string]interface{}{"l": list},
+				SourceFiles: []SourceFile{{Name: "templates/a.yaml", Data: []byte(tt.template)}},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			output, code := render(input)
+			if code == 0 || !strings.Contains(strings.Join(output.Errors, "\n"), tt.wantErr) {
+				t.Errorf("render() = %d %q, want an error containing %q", code, output.Errors, tt.wantErr)
+			}
+		})
+	}
+}
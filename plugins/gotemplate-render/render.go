@@ -0,0 +1,599 @@
+// Package main implements a render/v1 plugin for Go templates.
+// This is a reference implementation that demonstrates using gotemplate
+// as a render/v1 plugin for chart-defined plugins in Helm 4.
+//
+// Note: This is a simplified implementation. A full implementation would
+// need to include all Sprig functions and Helm-specific template functions.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/scottrigby/ref-hip-chart-defined-plugins/renderv1"
+)
+
+// recursionMaxNums limits how deeply include may recurse into the same
+// named template, matching Helm.
+const recursionMaxNums = 1000
+
+// maxRenderedSize bounds the output of a single template, and of any string
+// a template function builds, so a template cannot exhaust the plugin's
+// memory by looping over large values or repeating strings.
+const maxRenderedSize = 16 << 20
+
+// errRenderedSize is returned when output would exceed maxRenderedSize.
+var errRenderedSize = fmt.Errorf("rendered output exceeds %d bytes", maxRenderedSize)
+
+// limitedBuffer is a bytes.Buffer that refuses to grow past maxRenderedSize.
+type limitedBuffer struct {
+	bytes.Buffer
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if b.Len()+len(p) > maxRenderedSize {
+		return 0, errRenderedSize
+	}
+	return b.Buffer.Write(p)
+}
+
+func (b *limitedBuffer) WriteString(s string) (int, error) {
+	return b.Write([]byte(s))
+}
+
+// ReleaseInfo contains release metadata passed to render plugins.
+type ReleaseInfo struct {
+	Name      string `json:"name"`
+	Namespace string `json:"namespace"`
+	Revision  int    `json:"revision"`
+	IsInstall bool   `json:"isInstall"`
+	IsUpgrade bool   `json:"isUpgrade"`
+	Service   string `json:"service"`
+}
+
+// ChartInfo contains chart metadata passed to render plugins.
+type ChartInfo struct {
+	Name        string `json:"name"`
+	Version     string `json:"version"`
+	AppVersion  string `json:"appVersion,omitempty"`
+	Description string `json:"description,omitempty"`
+	Type        string `json:"type,omitempty"`
+	IsRoot      bool   `json:"isRoot"`
+}
+
+// CapabilitiesInfo contains Kubernetes cluster capabilities.
+type CapabilitiesInfo struct {
+	KubeVersion map[string]interface{} `json:"kubeVersion"`
+	APIVersions []string               `json:"apiVersions"`
+	HelmVersion string                 `json:"helmVersion"`
+}
+
+// SourceFile represents a file in the chart.
+type SourceFile struct {
+	Name string `json:"name"`
+	Data []byte `json:"data"`
+}
+
+// InputMessageRenderV1 is the input message for render/v1 plugins.
+type InputMessageRenderV1 struct {
+	Release      ReleaseInfo            `json:"release"`
+	Values       map[string]interface{} `json:"values"`
+	Chart        ChartInfo              `json:"chart"`
+	Subcharts    map[string]interface{} `json:"subcharts"`
+	Files        []SourceFile           `json:"files"`
+	Capabilities CapabilitiesInfo       `json:"capabilities"`
+	SourceFiles  []SourceFile           `json:"sourceFiles"`
+	Config       PluginConfig           `json:"config"`
+}
+
+// PluginConfig is the chart's configuration for this plugin, validated by
+// Helm against the configSchema in plugin.yaml.
+type PluginConfig struct {
+	// Strict makes referencing a missing map key a render error.
+	Strict bool `json:"strict,omitempty"`
+	// Documents returns each YAML document of the rendered files as an
+	// entry of the output's Documents as well.
+	Documents bool `json:"documents,omitempty"`
+}
+
+// OutputMessageRenderV1 is the output message from render/v1 plugins.
+type OutputMessageRenderV1 struct {
+	RenderedFiles       map[string]string           `json:"renderedFiles"`
+	Documents           []renderv1.RenderedDocument `json:"documents,omitempty"`
+	ModifiedSourceFiles []SourceFile                `json:"modifiedSourceFiles,omitempty"`
+	Errors              []string                    `json:"errors,omitempty"`
+}
+
+// TemplateData holds all data available to templates.
+type TemplateData struct {
+	Release      ReleaseInfo
+	Values       map[string]interface{}
+	Chart        ChartInfo
+	Subcharts    map[string]interface{}
+	Files        *Files
+	Capabilities CapabilitiesInfo
+	Template     TemplateInfo
+}
+
+// TemplateInfo contains info about the current template.
+type TemplateInfo struct {
+	Name     string
+	BasePath string
+}
+
+// Files provides access to non-template files.
+type Files struct {
+	files map[string][]byte
+}
+
+// Get returns the content of a file.
+func (f *Files) Get(name string) string {
+	if data, ok := f.files[name]; ok {
+		return string(data)
+	}
+	return ""
+}
+
+// GetBytes returns the content of a file as bytes.
+func (f *Files) GetBytes(name string) []byte {
+	return f.files[name]
+}
+
+// Glob returns files matching a pattern.
+func (f *Files) Glob(pattern string) map[string][]byte {
+	result := make(map[string][]byte)
+	for name, data := range f.files {
+		matched, err := path.Match(pattern, name)
+		if err == nil && matched {
+			result[name] = data
+		}
+	}
+	return result
+}
+
+// AsConfig returns files as YAML-formatted config data.
+func (f *Files) AsConfig() map[string]string {
+	result := make(map[string]string)
+	for name, data := range f.files {
+		result[path.Base(name)] = string(data)
+	}
+	return result
+}
+
+// AsSecrets returns files as base64-encoded secrets.
+func (f *Files) AsSecrets() map[string]string {
+	result := make(map[string]string)
+	for name, data := range f.files {
+		// In a real implementation, this would base64 encode
+		result[path.Base(name)] = string(data)
+	}
+	return result
+}
+
+// Lines returns file content as a slice of lines.
+func (f *Files) Lines(name string) []string {
+	if data, ok := f.files[name]; ok {
+		return strings.Split(string(data), "\n")
+	}
+	return nil
+}
+
+// render renders a raw render/v1 input message and returns the output
+// message along with the exit code the plugin returns to Helm.
+func render(inputBytes []byte) (OutputMessageRenderV1, uint32) {
+	// Parse the input message
+	var input InputMessageRenderV1
+	if err := json.Unmarshal(inputBytes, &input); err != nil {
+		return errorOutput(fmt.Sprintf("failed to parse input: %v", err))
+	}
+
+	logf("Received %d source files", len(input.SourceFiles))
+
+	output := OutputMessageRenderV1{
+		RenderedFiles: make(map[string]string),
+	}
+
+	// Build files map for template access
+	filesMap := make(map[string][]byte)
+	for _, f := range input.Files {
+		filesMap[f.Name] = f.Data
+	}
+	files := &Files{files: filesMap}
+
+	// Parse every template into one shared set so that a named template
+	// defined in any file can be used from any other file, as in Helm.
+	masterTmpl := template.New("gotpl")
+	masterTmpl.Funcs(funcMap(masterTmpl))
+	if input.Config.Strict {
+		masterTmpl.Option("missingkey=error")
+	}
+
+	parsed, errs := parseTemplates(masterTmpl, input.SourceFiles)
+	for _, err := range errs {
+		output.Errors = append(output.Errors, err.Error())
+	}
+
+	// Render every non-partial template from the shared set. Helm has
+	// already selected SourceFiles using the plugin's patterns (or the
+	// chart's override of them), so no file extensions are assumed here.
+	for _, file := range input.SourceFiles {
+		baseName := path.Base(file.Name)
+
+		// Partials only contribute definitions
+		if strings.HasPrefix(baseName, "_") {
+			continue
+		}
+
+		// Files that failed to parse have already been reported
+		if !parsed[file.Name] {
+			continue
+		}
+
+		logf("Rendering template: %s", file.Name)
+
+		// Build template data
+		data := TemplateData{
+			Release:      input.Release,
+			Values:       input.Values,
+			Chart:        input.Chart,
+			Subcharts:    input.Subcharts,
+			Files:        files,
+			Capabilities: input.Capabilities,
+			Template: TemplateInfo{
+				Name:     file.Name,
+				BasePath: path.Dir(file.Name),
+			},
+		}
+
+		// Execute the template
+		var buf limitedBuffer
+		if err := masterTmpl.ExecuteTemplate(&buf, file.Name, data); err != nil {
+			output.Errors = append(output.Errors,
+				fmt.Sprintf("render error in %s: %v", file.Name, err))
+			continue
+		}
+
+		rendered := buf.String()
+
+		// Drop empty documents, and files left without any
+		docs := renderv1.SplitDocuments(file.Name, rendered)
+		if len(docs) == 0 {
+			continue
+		}
+
+		output.RenderedFiles[file.Name] = renderv1.JoinDocuments(docs)
+		if input.Config.Documents {
+			output.Documents = append(output.Documents, docs...)
+		}
+	}
+
+	// Helm treats a non-zero exit code as the signal that Errors is set
+	if len(output.Errors) > 0 {
+		return output, 1
+	}
+	return output, 0
+}
+
+// parseTemplates parses every source file into the shared template set t and
+// reports which files parsed successfully.
+//
+// Files are added in Helm's order: deepest path first, so that definitions in
+// a parent chart override same-named definitions from its subcharts. A name
+// defined by two files of the same chart is reported as an error, since which
+// definition wins would otherwise depend on file names, and so is a define
+// named after a file, which would replace that file's own template.
+func parseTemplates(t *template.Template, files []SourceFile) (map[string]bool, []error) {
+	var errs []error
+
+	// Parse each file on its own first to learn which names it defines
+	funcs := funcMap(t)
+	sets := make(map[string]*template.Template)
+	definedBy := make(map[string][]string)
+	fileNames := make(map[string]bool)
+	for _, file := range files {
+		fileNames[file.Name] = true
+	}
+	for _, file := range files {
+		set, err := template.New(file.Name).Funcs(funcs).Parse(string(file.Data))
+		if err != nil {
+			errs = append(errs, fmt.Errorf("parse error in %s: %v", file.Name, err))
+			continue
+		}
+		sets[file.Name] = set
+		for _, tpl := range set.Templates() {
+			if tpl.Name() != file.Name {
+				definedBy[tpl.Name()] = append(definedBy[tpl.Name()], file.Name)
+			}
+		}
+	}
+
+	for _, name := range sortedKeys(definedBy) {
+		seen := make(map[string]string)
+		for _, file := range definedBy[name] {
+			if fileNames[name] {
+				errs = append(errs, fmt.Errorf("template %q is defined in both %s and %s", name, name, file))
+				continue
+			}
+			scope := chartScope(file)
+			if prev, ok := seen[scope]; ok {
+				errs = append(errs, fmt.Errorf("template %q is defined in both %s and %s", name, prev, file))
+				continue
+			}
+			seen[scope] = file
+		}
+	}
+
+	// Add the parsed trees to the shared set in Helm's override order
+	parsed := make(map[string]bool)
+	for _, name := range sortTemplates(sets) {
+		ok := true
+		for _, tpl := range sets[name].Templates() {
+			// A file's own template wins over a define reported above
+			if tpl.Tree == nil || (tpl.Name() != name && fileNames[tpl.Name()]) {
+				continue
+			}
+			if _, err := t.AddParseTree(tpl.Name(), tpl.Tree); err != nil {
+				errs = append(errs, fmt.Errorf("parse error in %s: %v", name, err))
+				ok = false
+			}
+		}
+		// A file is parsed only once all of its trees were added
+		if ok {
+			parsed[name] = true
+		}
+	}
+
+	return parsed, errs
+}
+
+// sortTemplates returns template names ordered the way Helm parses them:
+// by path depth, deepest first, then in reverse lexical order.
+func sortTemplates(sets map[string]*template.Template) []string {
+	names := make([]string, 0, len(sets))
+	for name := range sets {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		di, dj := strings.Count(names[i], "/"), strings.Count(names[j], "/")
+		if di == dj {
+			return names[i] > names[j]
+		}
+		return di > dj
+	})
+	return names
+}
+
+// chartScope returns the path prefix identifying the chart a template belongs
+// to, e.g. "" for templates/foo.yaml and "charts/sub/" for
+// charts/sub/templates/foo.yaml.
+func chartScope(name string) string {
+	if i := strings.LastIndex(name, "templates/"); i >= 0 {
+		return name[:i]
+	}
+	return path.Dir(name) + "/"
+}
+
+func sortedKeys(m map[string][]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// funcMap returns the template functions available in gotemplate.
+// This is a simplified set - a full implementation would include all Sprig functions.
+// The include function executes named templates from t.
+func funcMap(t *template.Template) template.FuncMap {
+	includedNames := make(map[string]int)
+
+	return template.FuncMap{
+		// String functions
+		"upper":      strings.ToUpper,
+		"lower":      strings.ToLower,
+		"title":      strings.Title,
+		"trim":       strings.TrimSpace,
+		"trimPrefix": strings.TrimPrefix,
+		"trimSuffix": strings.TrimSuffix,
+		"contains":   strings.Contains,
+		"hasPrefix":  strings.HasPrefix,
+		"hasSuffix":  strings.HasSuffix,
+		"replace":    strings.ReplaceAll,
+		"repeat":     repeat,
+		"join":       strings.Join,
+		"split":      strings.Split,
+
+		// Default values
+		"default": func(def interface{}, val interface{}) interface{} {
+			if val == nil || val == "" {
+				return def
+			}
+			return val
+		},
+
+		// Required value
+		"required": func(msg string, val interface{}) (interface{}, error) {
+			if val == nil || val == "" {
+				return nil, errors.New(msg)
+			}
+			return val, nil
+		},
+
+		// Conditional
+		"ternary": func(trueVal, falseVal interface{}, cond bool) interface{} {
+			if cond {
+				return trueVal
+			}
+			return falseVal
+		},
+
+		// Empty check
+		"empty": func(val interface{}) bool {
+			if val == nil {
+				return true
+			}
+			switch v := val.(type) {
+			case string:
+				return v == ""
+			case []interface{}:
+				return len(v) == 0
+			case map[string]interface{}:
+				return len(v) == 0
+			}
+			return false
+		},
+
+		// Coalesce returns first non-empty value
+		"coalesce": func(vals ...interface{}) interface{} {
+			for _, v := range vals {
+				if v != nil && v != "" {
+					return v
+				}
+			}
+			return nil
+		},
+
+		// Quote wraps a string in quotes
+		"quote": func(s string) string {
+			return fmt.Sprintf("%q", s)
+		},
+
+		// Squote wraps a string in single quotes
+		"squote": func(s string) string {
+			return fmt.Sprintf("'%s'", s)
+		},
+
+		// Printf
+		"printf": fmt.Sprintf,
+
+		// Fail explicitly fails rendering
+		"fail": func(msg string) (string, error) {
+			return "", errors.New(msg)
+		},
+
+		// Include executes a named template and returns the result as a string
+		"include": func(name string, data interface{}) (string, error) {
+			if includedNames[name] > recursionMaxNums {
+				return "", fmt.Errorf("rendering template has a nested reference name: %s", name)
+			}
+			includedNames[name]++
+			defer func() { includedNames[name]-- }()
+
+			var buf limitedBuffer
+			err := t.ExecuteTemplate(&buf, name, data)
+			return buf.String(), err
+		},
+
+		// tpl is a placeholder
+		"tpl": func(tpl string, data interface{}) (string, error) {
+			return "", fmt.Errorf("tpl not fully implemented in plugin")
+		},
+
+		// toYaml converts a value to YAML
+		"toYaml": func(v interface{}) string {
+			// Simplified - real implementation would use yaml.Marshal
+			data, err := json.MarshalIndent(v, "", "  ")
+			if err != nil {
+				return ""
+			}
+			return string(data)
+		},
+
+		// toJson converts a value to JSON
+		"toJson": func(v interface{}) string {
+			data, err := json.Marshal(v)
+			if err != nil {
+				return ""
+			}
+			return string(data)
+		},
+
+		// toPrettyJson converts a value to formatted JSON
+		"toPrettyJson": func(v interface{}) string {
+			data, err := json.MarshalIndent(v, "", "  ")
+			if err != nil {
+				return ""
+			}
+			return string(data)
+		},
+
+		// Indent adds indentation to each line
+		"indent": indent,
+
+		// Nindent is indent with a newline prefix
+		"nindent": func(spaces int, s string) (string, error) {
+			indented, err := indent(spaces, s)
+			return "\n" + indented, err
+		},
+
+		// List creates a list
+		"list": func(items ...interface{}) []interface{} {
+			return items
+		},
+
+		// Dict creates a dictionary
+		"dict": func(vals ...interface{}) map[string]interface{} {
+			result := make(map[string]interface{})
+			for i := 0; i < len(vals)-1; i += 2 {
+				key, ok := vals[i].(string)
+				if ok {
+					result[key] = vals[i+1]
+				}
+			}
+			return result
+		},
+	}
+}
+
+// repeat is strings.Repeat, failing instead of building a string larger than
+// maxRenderedSize.
+func repeat(s string, count int) (string, error) {
+	if count < 0 {
+		return "", fmt.Errorf("repeat: negative count %d", count)
+	}
+	if len(s) > 0 && count > maxRenderedSize/len(s) {
+		return "", errRenderedSize
+	}
+	return strings.Repeat(s, count), nil
+}
+
+// indent prefixes each non-empty line of s with spaces spaces.
+func indent(spaces int, s string) (string, error) {
+	prefix, err := repeat(" ", spaces)
+	if err != nil {
+		return "", err
+	}
+	lines := strings.Split(s, "\n")
+	if len(prefix) > 0 && len(lines) > maxRenderedSize/len(prefix) {
+		return "", errRenderedSize
+	}
+	for i, line := range lines {
+		if line != "" {
+			lines[i] = prefix + line
+		}
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+// errorOutput returns an output message reporting msg, with a failing exit
+// code.
+func errorOutput(msg string) (OutputMessageRenderV1, uint32) {
+	return OutputMessageRenderV1{
+		RenderedFiles: make(map[string]string),
+		Errors:        []string{msg},
+	}, 1
+}
+
+// logf logs a debug message. main.go sends it to Helm when built as a
+// plugin; natively, e.g. in tests, it is discarded.
+var logf = func(format string, args ...interface{}) {}
+
+// main is empty: Helm calls the helm_plugin_main export defined in main.go.
+func main() {}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+	"testing"
+	"text/template"
+)
+
+func marshalInput(t *testing.T, input InputMessageRenderV1) []byte {
+	t.Helper()
+	data, err := json.Marshal(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func sourceFiles(files map[string]string) []SourceFile {
+	var out []SourceFile
+	for name, data := range files {
+		out = append(out, SourceFile{Name: name, Data: []byte(data)})
+	}
+	return out
+}
+
+func TestRender(t *testing.T) {
+	tests := []struct {
+		name   string
+		input  []byte
+		files  map[string]string
+		values map[string]interface{}
+		strict bool
+		// documents sets the documents config; wantDocuments counts the
+		// documents returned.
+		documents     bool
+		wantDocuments int
+		wantCode      uint32
+		// want maps rendered file names to content they must contain.
+		want map[string]string
+		// wantErrors are substrings of the expected errors, in order.
+		wantErrors []string
+	}{
+		{
+			name: "renders templates with values and release",
+			files: map[string]string{
+				"templates/cm.yaml": "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: {{ .Release.Name }}\ndata:\n  tag: {{ .Values.image.tag | quote }}\n",
+			},
+			values: map[string]interface{}{"image": map[string]interface{}{"tag": "1.2"}},
+			want:   map[string]string{"templates/cm.yaml": "name: my-release\ndata:\n  tag: \"1.2\""},
+		},
+		{
+			name: "includes named templates from partials",
+			files: map[string]string{
+				"templates/_helpers.tpl": `{{- define "app.name" -}}{{ .Chart.Name }}-app{{- end -}}`,
+				"templates/svc.yaml":     "kind: Service\nmetadata:\n  name: {{ include \"app.name\" . }}\n",
+			},
+			want: map[string]string{"templates/svc.yaml": "name: mychart-app"},
+		},
+		{
+			name: "skips output without documents",
+			files: map[string]string{
+				"templates/off.yaml": "{{- if .Values.enabled }}\nkind: ConfigMap\n{{- end }}\n# comment only\n",
+			},
+			want: map[string]string{},
+		},
+		{
+			name: "drops empty documents",
+			files: map[string]string{
+				"templates/two.yaml": "kind: A\n---\n{{- if .Values.enabled }}\nkind: Off\n{{- end }}\n---\nkind: B\n",
+			},
+			want: map[string]string{"templates/two.yaml": "kind: A\n---\nkind: B\n"},
+		},
+		{
+			name: "returns documents when configured",
+			files: map[string]string{
+				"templates/two.yaml": "kind: A\n---\n# comment only\n---\nkind: B\n",
+				"templates/one.yaml": "kind: C\n",
+			},
+			documents:     true,
+			wantDocuments: 3,
+			want:          map[string]string{"templates/two.yaml": "kind: A", "templates/one.yaml": "kind: C"},
+		},
+		{
+			name:  "empty input",
+			input: []byte(`{}`),
+			want:  map[string]string{},
+		},
+		{
+			name:       "malformed JSON",
+			input:      []byte(`{"sourceFiles": [`),
+			wantCode:   1,
+			want:       map[string]string{},
+			wantErrors: []string{"failed to parse input"},
+		},
+		{
+			name: "reports fail",
+			files: map[string]string{
+				"templates/bad.yaml": `{{ fail "boom" }}`,
+			},
+			wantCode:   1,
+			want:       map[string]string{},
+			wantErrors: []string{"render error in templates/bad.yaml", "boom"},
+		},
+		{
+			name: "reports parse errors and renders other files",
+			files: map[string]string{
+				"templates/broken.yaml": "{{ if }}",
+				"templates/ok.yaml":     "kind: ConfigMap\n",
+			},
+			wantCode:   1,
+			want:       map[string]string{"templates/ok.yaml": "kind: ConfigMap"},
+			wantErrors: []string{"templates/broken.yaml"},
+		},
+		{
+			name: "reports duplicate definitions in one chart",
+			files: map[string]string{
+				"templates/_a.tpl": `{{ define "dup" }}a{{ end }}`,
+				"templates/_b.tpl": `{{ define "dup" }}b{{ end }}`,
+			},
+			wantCode:   1,
+			want:       map[string]string{},
+			wantErrors: []string{`template "dup" is defined in both templates/_a.tpl and templates/_b.tpl`},
+		},
+		{
+			name: "includes named templates defined in rendered templates",
+			files: map[string]string{
+				"templates/deployment.yaml": "{{- define \"app.labels\" }}app: {{ .Release.Name }}{{ end -}}\nkind: Deployment\nmetadata:\n  labels:\n    {{- include \"app.labels\" . | nindent 4 }}\n",
+				"templates/svc.yaml":        "kind: Service\nspec:\n  selector:\n    {{- include \"app.labels\" . | nindent 4 }}\n",
+			},
+			want: map[string]string{
+				"templates/deployment.yaml": "labels:\n    app: my-release",
+				"templates/svc.yaml":        "selector:\n    app: my-release",
+			},
+		},
+		{
+			name: "parent definitions override subchart definitions",
+			files: map[string]string{
+				"templates/_helpers.tpl":            `{{ define "sub.name" }}from-parent{{ end }}`,
+				"charts/sub/templates/_helpers.tpl": `{{ define "sub.name" }}from-sub{{ end }}`,
+				"charts/sub/templates/svc.yaml":     "kind: Service\nmetadata:\n  name: {{ include \"sub.name\" . }}\n",
+			},
+			want: map[string]string{"charts/sub/templates/svc.yaml": "name: from-parent"},
+		},
+		{
+			name: "reports definitions named after a file",
+			files: map[string]string{
+				"templates/a.yaml": `{{ define "templates/b.yaml" }}kind: Replaced{{ end }}kind: A`,
+				"templates/b.yaml": "kind: B\n",
+			},
+			wantCode:   1,
+			want:       map[string]string{"templates/a.yaml": "kind: A", "templates/b.yaml": "kind: B"},
+			wantErrors: []string{`template "templates/b.yaml" is defined in both templates/b.yaml and templates/a.yaml`},
+		},
+		{
+			name: "strict reports missing keys",
+			files: map[string]string{
+				"templates/cm.yaml": "kind: ConfigMap\ndata:\n  v: {{ .Values.missing }}\n",
+			},
+			strict:     true,
+			wantCode:   1,
+			want:       map[string]string{},
+			wantErrors: []string{"render error in templates/cm.yaml", "missing"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			input := tt.input
+			if input == nil {
+				input = marshalInput(t, InputMessageRenderV1{
+					Release:     ReleaseInfo{Name: "my-release", Namespace: "default"},
+					Chart:       ChartInfo{Name: "mychart", Version: "1.0.0"},
+					Values:      tt.values,
+					SourceFiles: sourceFiles(tt.files),
+					Config:      PluginConfig{Strict: tt.strict, Documents: tt.documents},
+				})
+			}
+
+			output, code := render(input)
+			if code != tt.wantCode {
+				t.Errorf("exit code = %d, want %d", code, tt.wantCode)
+			}
+			if len(output.Documents) != tt.wantDocuments {
+				t.Errorf("returned %d documents, want %d: %+v", len(output.Documents), tt.wantDocuments, output.Documents)
+			}
+			if len(output.RenderedFiles) != len(tt.want) {
+				t.Errorf("rendered %d files, want %d: %v", len(output.RenderedFiles), len(tt.want), output.RenderedFiles)
+			}
+			for name, substr := range tt.want {
+				if got, ok := output.RenderedFiles[name]; !ok || !strings.Contains(got, substr) {
+					t.Errorf("renderedFiles[%q] = %q, want it to contain %q", name, got, substr)
+				}
+			}
+			errs := strings.Join(output.Errors, "\n")
+			if len(tt.wantErrors) == 0 && errs != "" {
+				t.Errorf("unexpected errors: %s", errs)
+			}
+			for _, want := range tt.wantErrors {
+				if !strings.Contains(errs, want) {
+					t.Errorf("errors %q do not contain %q", errs, want)
+				}
+			}
+		})
+	}
+}
+
+func TestSortTemplates(t *testing.T) {
+	tests := []struct {
+		name  string
+		names []string
+		want  []string
+	}{
+		{
+			name: "empty",
+		},
+		{
+			name:  "deepest first, then reverse lexical",
+			names: []string{"templates/a.yaml", "charts/sub/templates/_h.tpl", "templates/b.yaml"},
+			want:  []string{"charts/sub/templates/_h.tpl", "templates/b.yaml", "templates/a.yaml"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sets := make(map[string]*template.Template)
+			for _, name := range tt.names {
+				sets[name] = nil
+			}
+			got := sortTemplates(sets)
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("sortTemplates() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseTemplates(t *testing.T) {
+	tests := []struct {
+		name       string
+		files      []SourceFile
+		wantParsed []string
+		wantErrors int
+	}{
+		{
+			name: "all parsed",
+			files: []SourceFile{
+				{Name: "templates/_helpers.tpl", Data: []byte(`{{ define "name" }}web{{ end }}`)},
+				{Name: "templates/a.yaml", Data: []byte(`name: {{ template "name" }}`)},
+			},
+			wantParsed: []string{"templates/_helpers.tpl", "templates/a.yaml"},
+		},
+		{
+			name: "a file failing to parse is not parsed",
+			files: []SourceFile{
+				{Name: "templates/a.yaml", Data: []byte(`name: a`)},
+				{Name: "templates/b.yaml", Data: []byte(`name: {{ .Values.name`)},
+			},
+			wantParsed: []string{"templates/a.yaml"},
+			wantErrors: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parsed, errs := parseTemplates(template.New("gotpl"), tt.files)
+			if len(errs) != tt.wantErrors {
+				t.Errorf("parseTemplates() errors = %v, want %d", errs, tt.wantErrors)
+			}
+			var got []string
+			for name := range parsed {
+				got = append(got, name)
+			}
+			sort.Strings(got)
+			if strings.Join(got, ",") != strings.Join(tt.wantParsed, ",") {
+				t.Errorf("parseTemplates() parsed = %v, want %v", got, tt.wantParsed)
+			}
+		})
+	}
+}
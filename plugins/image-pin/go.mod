@@ -0,0 +1,12 @@
+module github.com/scottrigby/ref-hip-chart-defined-plugins/plugins/image-pin
+
+go 1.24.3
+
+require (
+	github.com/extism/go-pdk v1.1.3
+	github.com/scottrigby/ref-hip-chart-defined-plugins/renderv1 v0.0.0
+	go.yaml.in/yaml/v3 v3.0.4
+)
+
+// renderv1 holds the render/v1 types shared with hosts, in this repository.
+replace github.com/scottrigby/ref-hip-chart-defined-plugins/renderv1 => ../../renderv1
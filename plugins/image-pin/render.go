@@ -0,0 +1,445 @@
+// Package main implements a render/v1 plugin that pins the container images
+// of rendered manifests to digests.
+//
+// The plugin runs after the plugins rendering a chart's templates and
+// receives the files they rendered as renderedFiles. It reads a lock file
+// from the chart's files, mapping image references such as nginx:1.27 to
+// their digests, and rewrites the image of every container in the rendered
+// Pods and workloads to the digest the lock file records, as in
+// nginx@sha256:.... Nothing is looked up in a registry, so renders are
+// reproducible and work offline. Images missing from the lock file are
+// reported as warnings or, with failOnUnpinned, as errors failing the render.
+// The files holding pinned images are returned as renderedFiles, replacing
+// the originals; the plugin renders no templates of its own.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/scottrigby/ref-hip-chart-defined-plugins/renderv1"
+	"go.yaml.in/yaml/v3"
+)
+
+// defaultLockFile is the lock file read when the config does not set
+// lockFile. Unlike a lockFile the chart sets, it may be missing, leaving every
+// image unpinned.
+const defaultLockFile = "images.lock"
+
+// SourceFile represents a file in the chart.
+type SourceFile struct {
+	Name string `json:"name"`
+	Data []byte `json:"data"`
+}
+
+// InputMessageRenderV1 is the input message for render/v1 plugins.
+type InputMessageRenderV1 struct {
+	Files       []SourceFile `json:"files"`
+	SourceFiles []SourceFile `json:"sourceFiles"`
+	// RenderedFiles are the files rendered by the plugins before this one.
+	RenderedFiles map[string]string `json:"renderedFiles"`
+	Config        PluginConfig      `json:"config"`
+}
+
+// PluginConfig is the chart's configuration for this plugin, validated by
+// Helm against the configSchema in plugin.yaml.
+type PluginConfig struct {
+	// LockFile is the chart file holding the image digests, defaultLockFile
+	// when empty.
+	LockFile string `json:"lockFile"`
+	// KeepTag keeps the tag of pinned images, as in nginx:1.27@sha256:...,
+	// for readability. The digest alone decides the image pulled.
+	KeepTag        bool `json:"keepTag"`
+	FailOnUnpinned bool `json:"failOnUnpinned"`
+}
+
+// LockFile is the content of the lock file.
+type LockFile struct {
+	Images []LockedImage `yaml:"images"`
+}
+
+// LockedImage records the digest of an image reference.
+type LockedImage struct {
+	// Image is the reference as written in manifests, with or without a
+	// registry and tag: nginx:1.27 and docker.io/library/nginx:1.27 are the
+	// same image.
+	Image  string `yaml:"image"`
+	Digest string `yaml:"digest"`
+}
+
+// OutputMessageRenderV1 is the output message from render/v1 plugins.
+type OutputMessageRenderV1 struct {
+	RenderedFiles map[string]string `json:"renderedFiles"`
+	Errors        []string          `json:"errors,omitempty"`
+}
+
+// podSpecPaths are the paths to the Pod spec in the objects of each kind
+// holding one.
+var podSpecPaths = map[string][]string{
+	"Pod":                   {"spec"},
+	"PodTemplate":           {"template", "spec"},
+	"ReplicationController": {"spec", "template", "spec"},
+	"ReplicaSet":            {"spec", "template", "spec"},
+	"Deployment":            {"spec", "template", "spec"},
+	"StatefulSet":           {"spec", "template", "spec"},
+	"DaemonSet":             {"spec", "template", "spec"},
+	"Job":                   {"spec", "template", "spec"},
+	"CronJob":               {"spec", "jobTemplate", "spec", "template", "spec"},
+}
+
+// containerLists are the fields of a Pod spec listing containers.
+var containerLists = []string{"initContainers", "containers", "ephemeralContainers"}
+
+// render pins the images in the rendered files of a raw render/v1 input
+// message and returns the output message along with the exit code the plugin
+// returns to Helm.
+func render(inputBytes []byte) (OutputMessageRenderV1, uint32) {
+	if len(inputBytes) == 0 {
+		return errorOutput("no input provided")
+	}
+
+	var input InputMessageRenderV1
+	if err := json.Unmarshal(inputBytes, &input); err != nil {
+		return errorOutput(fmt.Sprintf("failed to parse input: %v", err))
+	}
+
+	lockName := input.Config.LockFile
+	if lockName == "" {
+		lockName = defaultLockFile
+	}
+	digests, errs := loadLockFile(lockName, input.Files, input.Config.LockFile == "")
+	if errs != nil {
+		return OutputMessageRenderV1{RenderedFiles: make(map[string]string), Errors: errs}, 1
+	}
+	logf("Pinning images in %d rendered files with %d digests from %s", len(input.RenderedFiles), len(digests), lockName)
+
+	manifests, errs := parseManifests(input.RenderedFiles)
+	if errs != nil {
+		return OutputMessageRenderV1{RenderedFiles: make(map[string]string), Errors: errs}, 1
+	}
+
+	output := OutputMessageRenderV1{
+		RenderedFiles: make(map[string]string),
+	}
+	var unpinned []string
+	pinned := 0
+	for _, m := range manifests {
+		for _, doc := range m.docs {
+			for _, c := range doc.containers {
+				ref, err := parseReference(c.image.Value)
+				where := fmt.Sprintf("%s: %s container %s", m.name, doc.id, c.name)
+				switch {
+				case err != nil:
+					output.Errors = append(output.Errors, fmt.Sprintf("%s: %v", where, err))
+				case ref.digest != "":
+					// Already pinned, by the template or the values
+				case digests[ref.key()] == "":
+					unpinned = append(unpinned, fmt.Sprintf("%s: image %s has no digest in %s", where, c.image.Value, lockName))
+				default:
+					c.image.Value = ref.pin(digests[ref.key()], input.Config.KeepTag)
+					doc.modified = true
+					pinned++
+				}
+			}
+		}
+	}
+	if len(output.Errors) > 0 {
+		return output, 1
+	}
+	if input.Config.FailOnUnpinned && unpinned != nil {
+		output.Errors = unpinned
+		return output, 1
+	}
+	for _, msg := range unpinned {
+		warnf("%s", msg)
+	}
+	logf("%d images pinned, %d unpinned", pinned, len(unpinned))
+
+	for _, m := range manifests {
+		content, modified, err := m.encode()
+		if err != nil {
+			output.Errors = append(output.Errors, fmt.Sprintf("%s: %v", m.name, err))
+			continue
+		}
+		if modified {
+			output.RenderedFiles[m.name] = content
+		}
+	}
+
+	// Helm treats a non-zero exit code as the signal that Errors is set
+	if len(output.Errors) > 0 {
+		return output, 1
+	}
+	return output, 0
+}
+
+// digestPattern matches the digests the lock file may record.
+var digestPattern = regexp.MustCompile(`^(sha256:[0-9a-f]{64}|sha512:[0-9a-f]{128})$`)
+
+// loadLockFile reads the lock file name from files and returns its digests
+// keyed by normalized image reference. A missing lock file is an error unless
+// optional is set.
+func loadLockFile(name string, files []SourceFile, optional bool) (map[string]string, []string) {
+	var data []byte
+	found := false
+	for _, f := range files {
+		if path.Clean(f.Name) == path.Clean(name) {
+			data, found = f.Data, true
+			break
+		}
+	}
+	if !found && optional {
+		logf("No %s in the chart: images are not pinned", name)
+		return map[string]string{}, nil
+	}
+	if !found {
+		return nil, []string{fmt.Sprintf("%s: file not found in the chart", name)}
+	}
+
+	var lock LockFile
+	if err := yaml.Unmarshal(data, &lock); err != nil {
+		return nil, []string{fmt.Sprintf("%s: %v", name, err)}
+	}
+
+	digests := make(map[string]string, len(lock.Images))
+	var errs []string
+	for i, img := range lock.Images {
+		where := fmt.Sprintf("%s: images[%d]", name, i)
+		if img.Image != "" {
+			where += " (" + img.Image + ")"
+		}
+		ref, err := parseReference(img.Image)
+		switch {
+		case err != nil:
+			errs = append(errs, fmt.Sprintf("%s: %v", where, err))
+			continue
+		case ref.digest != "":
+			errs = append(errs, fmt.Sprintf("%s: image must not include a digest", where))
+			continue
+		case !digestPattern.MatchString(img.Digest):
+			errs = append(errs, fmt.Sprintf("%s: digest %q is not a sha256 or sha512 digest", where, img.Digest))
+			continue
+		}
+		if d, ok := digests[ref.key()]; ok && d != img.Digest {
+			errs = append(errs, fmt.Sprintf("%s: %s is locked to another digest earlier", where, ref.key()))
+			continue
+		}
+		digests[ref.key()] = img.Digest
+	}
+	return digests, errs
+}
+
+// reference is a parsed image reference.
+type reference struct {
+	// repository is the repository as written, e.g. nginx.
+	repository string
+	// domain and path are the normalized repository, e.g. docker.io and
+	// library/nginx.
+	domain, path string
+	tag, digest  string
+}
+
+var (
+	pathPattern   = regexp.MustCompile(`^[a-z0-9]+(?:(?:[._]|__|-+)[a-z0-9]+)*(?:/[a-z0-9]+(?:(?:[._]|__|-+)[a-z0-9]+)*)*$`)
+	domainPattern = regexp.MustCompile(`^(?:[a-zA-Z0-9](?:[a-zA-Z0-9-]*[a-zA-Z0-9])?)(?:\.[a-zA-Z0-9](?:[a-zA-Z0-9-]*[a-zA-Z0-9])?)*(?::[0-9]+)?$`)
+	tagPattern    = regexp.MustCompile(`^[\w][\w.-]{0,127}$`)
+	anyDigest     = regexp.MustCompile(`^[a-z0-9]+(?:[.+_-][a-z0-9]+)*:[a-zA-Z0-9=_-]+$`)
+)
+
+// parseReference parses an image reference, such as nginx:1.27 or
+// registry.example.com:5000/team/app@sha256:..., the way container runtimes
+// do: a first path component with a dot or a port, or localhost, is a
+// registry, and images without one are on Docker Hub.
+func parseReference(s string) (reference, error) {
+	var ref reference
+	name := s
+	if i := strings.Index(name, "@"); i >= 0 {
+		name, ref.digest = name[:i], name[i+1:]
+		if !anyDigest.MatchString(ref.digest) {
+			return reference{}, fmt.Errorf("invalid digest in image reference %q", s)
+		}
+	}
+	if i := strings.LastIndex(name, ":"); i > strings.LastIndex(name, "/") {
+		name, ref.tag = name[:i], name[i+1:]
+		if !tagPattern.MatchString(ref.tag) {
+			return reference{}, fmt.Errorf("invalid tag in image reference %q", s)
+		}
+	}
+	ref.repository = name
+
+	ref.domain, ref.path = "docker.io", name
+	if i := strings.Index(name, "/"); i >= 0 {
+		if first := name[:i]; strings.ContainsAny(first, ".:") || first == "localhost" {
+			if !domainPattern.MatchString(first) {
+				return reference{}, fmt.Errorf("invalid registry in image reference %q", s)
+			}
+			ref.domain, ref.path = first, name[i+1:]
+		}
+	}
+	if ref.domain == "index.docker.io" {
+		ref.domain = "docker.io"
+	}
+	if ref.domain == "docker.io" && !strings.Contains(ref.path, "/") {
+		ref.path = "library/" + ref.path
+	}
+	if !pathPattern.MatchString(ref.path) {
+		return reference{}, fmt.Errorf("invalid image reference %q", s)
+	}
+	return ref, nil
+}
+
+// key returns the normalized reference the lock file records digests for,
+// e.g. docker.io/library/nginx:1.27. References without a tag are latest.
+func (r reference) key() string {
+	tag := r.tag
+	if tag == "" {
+		tag = "latest"
+	}
+	return r.domain + "/" + r.path + ":" + tag
+}
+
+// pin returns the reference pinned to digest, written with the repository as
+// it was, and with its tag if keepTag is set.
+func (r reference) pin(digest string, keepTag bool) string {
+	if keepTag && r.tag != "" {
+		return r.repository + ":" + r.tag + "@" + digest
+	}
+	return r.repository + "@" + digest
+}
+
+// manifest is a rendered file split into YAML documents.
+type manifest struct {
+	name  string
+	parts []renderv1.RenderedDocument
+	docs  []*document
+}
+
+// document is a Kubernetes object with a Pod spec in a manifest.
+type document struct {
+	// part is the index of the document in manifest.parts.
+	part int
+	// id is the object's kind and name, for messages.
+	id         string
+	root       *yaml.Node
+	containers []container
+	modified   bool
+}
+
+// container is a container of a document's Pod spec.
+type container struct {
+	name string
+	// image is the scalar node holding the image, rewritten in place.
+	image *yaml.Node
+}
+
+// parseManifests splits the rendered YAML files into documents, in file name
+// order, and parses the Kubernetes objects with a Pod spec among them.
+// Other documents are kept as they are, and empty ones dropped.
+func parseManifests(rendered map[string]string) ([]*manifest, []string) {
+	names := make([]string, 0, len(rendered))
+	for name := range rendered {
+		switch path.Ext(name) {
+		case ".yaml", ".yml", ".json":
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	var manifests []*manifest
+	var errs []string
+	for _, name := range names {
+		m := &manifest{name: name, parts: renderv1.SplitDocuments(name, rendered[name])}
+		for i, part := range m.parts {
+			var doc yaml.Node
+			if err := yaml.Unmarshal([]byte(part.Content), &doc); err != nil {
+				errs = append(errs, fmt.Sprintf("%s: document %d is not valid YAML: %v", name, i, err))
+				continue
+			}
+			if len(doc.Content) == 0 || doc.Content[0].Kind != yaml.MappingNode {
+				continue
+			}
+			root := doc.Content[0]
+			kind := renderv1.ScalarValue(root, "kind")
+			specPath, ok := podSpecPaths[kind]
+			if !ok {
+				continue
+			}
+			spec := root
+			for _, key := range specPath {
+				spec = renderv1.MappingValue(spec, key)
+			}
+			d := &document{
+				part: i,
+				id:   kind + "/" + renderv1.ScalarValue(renderv1.MappingValue(root, "metadata"), "name"),
+				root: root,
+			}
+			for _, field := range containerLists {
+				list := renderv1.MappingValue(spec, field)
+				if list == nil || list.Kind != yaml.SequenceNode {
+					continue
+				}
+				for _, c := range list.Content {
+					if image := renderv1.MappingValue(c, "image"); image != nil && image.Kind == yaml.ScalarNode && image.Value != "" {
+						d.containers = append(d.containers, container{name: renderv1.ScalarValue(c, "name"), image: image})
+					}
+				}
+			}
+			if d.containers != nil {
+				m.docs = append(m.docs, d)
+			}
+		}
+		manifests = append(manifests, m)
+	}
+	return manifests, errs
+}
+
+// encode returns the manifest with its modified documents re-encoded, and
+// whether any document changed.
+func (m *manifest) encode() (string, bool, error) {
+	parts := append([]renderv1.RenderedDocument(nil), m.parts...)
+	modified := false
+	for _, doc := range m.docs {
+		if !doc.modified {
+			continue
+		}
+		var buf bytes.Buffer
+		enc := yaml.NewEncoder(&buf)
+		enc.SetIndent(2)
+		if err := enc.Encode(doc.root); err != nil {
+			return "", false, fmt.Errorf("document %d: %v", doc.part, err)
+		}
+		enc.Close()
+		parts[doc.part].Content = buf.String()
+		modified = true
+	}
+	if !modified {
+		return "", false, nil
+	}
+	return renderv1.JoinDocuments(parts), true, nil
+}
+
+// errorOutput returns an output message reporting msg, with a failing exit
+// code.
+func errorOutput(msg string) (OutputMessageRenderV1, uint32) {
+	return OutputMessageRenderV1{
+		RenderedFiles: make(map[string]string),
+		Errors:        []string{msg},
+	}, 1
+}
+
+// logf logs a debug message. main.go sends it to Helm when built as a
+// plugin; natively, e.g. in tests, it is discarded.
+var logf = func(format string, args ...interface{}) {}
+
+// warnf logs a warning, such as an image missing from the lock file when
+// failOnUnpinned is not set. main.go sends it to Helm when built as a
+// plugin; natively it is discarded.
+var warnf = func(format string, args ...interface{}) {}
+
+// main is empty: Helm calls the helm_plugin_main export defined in main.go.
+func main() {}
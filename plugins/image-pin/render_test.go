@@ -0,0 +1,329 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+const (
+	nginxDigest   = "sha256:0000000000000000000000000000000000000000000000000000000000000001"
+	busyboxDigest = "sha256:0000000000000000000000000000000000000000000000000000000000000002"
+	appDigest     = "sha256:0000000000000000000000000000000000000000000000000000000000000003"
+)
+
+const deployment = `# Source: chart/templates/deployment.yaml
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+spec:
+  template:
+    spec:
+      initContainers:
+        - name: init
+          image: busybox
+      containers:
+        - name: web
+          image: "nginx:1.27"
+        - name: app
+          image: registry.example.com:5000/team/app:v2
+`
+
+const cronJob = `apiVersion: batch/v1
+kind: CronJob
+metadata:
+  name: backup
+spec:
+  schedule: "@daily"
+  jobTemplate:
+    spec:
+      template:
+        spec:
+          containers:
+            - name: backup
+              image: docker.io/library/nginx:1.27
+`
+
+const service = `apiVersion: v1
+kind: Service
+metadata:
+  name: web
+spec:
+  ports:
+    - port: 80
+`
+
+var lockFile = SourceFile{Name: "images.lock", Data: []byte(`images:
+  - image: nginx:1.27
+    digest: ` + nginxDigest + `
+  - image: docker.io/library/busybox:latest
+    digest: ` + busyboxDigest + `
+  - image: registry.example.com:5000/team/app:v2
+    digest: ` + appDigest + `
+`)}
+
+func TestRender(t *testing.T) {
+	rendered := map[string]string{
+		"templates/deployment.yaml": deployment,
+		"templates/service.yaml":    service,
+	}
+
+	tests := []struct {
+		name     string
+		rendered map[string]string
+		files    []SourceFile
+		config   PluginConfig
+		raw      string
+		// want holds strings expected in each rendered file; files not
+		// listed must not be rendered.
+		want         map[string][]string
+		wantWarnings []string
+		wantErrors   []string
+	}{
+		{
+			name:     "pins every container",
+			rendered: rendered,
+			files:    []SourceFile{lockFile},
+			want: map[string][]string{"templates/deployment.yaml": {
+				"# Source: chart/templates/deployment.yaml\n",
+				"image: busybox@" + busyboxDigest + "\n",
+				`image: "nginx@` + nginxDigest + `"` + "\n",
+				"image: registry.example.com:5000/team/app@" + appDigest + "\n",
+			}},
+		},
+		{
+			name:     "keepTag",
+			rendered: rendered,
+			files:    []SourceFile{lockFile},
+			config:   PluginConfig{KeepTag: true},
+			want: map[string][]string{"templates/deployment.yaml": {
+				"image: busybox@" + busyboxDigest + "\n",
+				`image: "nginx:1.27@` + nginxDigest + `"` + "\n",
+				"image: registry.example.com:5000/team/app:v2@" + appDigest + "\n",
+			}},
+		},
+		{
+			name:     "normalized references and other documents",
+			rendered: map[string]string{"templates/all.yaml": "---\n" + service + "---\n" + cronJob + "---\n# comment only\n---\n- not an object\n"},
+			files:    []SourceFile{lockFile},
+			// Empty documents are dropped, as renderv1.JoinDocuments does
+			want: map[string][]string{"templates/all.yaml": {
+				service + "---\n",
+				"image: docker.io/library/nginx@" + nginxDigest + "\n---\n- not an object\n",
+			}},
+		},
+		{
+			name:     "metadata not a mapping",
+			rendered: map[string]string{"templates/cronjob.yaml": strings.Replace(cronJob, "metadata:\n  name: backup\n", "metadata: backup\n", 1)},
+			files:    []SourceFile{lockFile},
+			want: map[string][]string{"templates/cronjob.yaml": {
+				"image: docker.io/library/nginx@" + nginxDigest + "\n",
+			}},
+		},
+		{
+			name:     "already pinned",
+			rendered: map[string]string{"templates/pod.yaml": "kind: Pod\nmetadata: {name: p}\nspec:\n  containers:\n    - {name: c, image: 'nginx:1.26@" + busyboxDigest + "'}\n"},
+			files:    []SourceFile{lockFile},
+			config:   PluginConfig{FailOnUnpinned: true},
+		},
+		{
+			name:     "unpinned images are warnings",
+			rendered: map[string]string{"templates/deployment.yaml": strings.ReplaceAll(deployment, "nginx:1.27", "nginx:1.26")},
+			files:    []SourceFile{lockFile},
+			want: map[string][]string{"templates/deployment.yaml": {
+				"image: busybox@" + busyboxDigest + "\n",
+				`image: "nginx:1.26"` + "\n",
+			}},
+			wantWarnings: []string{
+				"templates/deployment.yaml: Deployment/web container web: image nginx:1.26 has no digest in images.lock",
+			},
+		},
+		{
+			name:     "failOnUnpinned",
+			rendered: map[string]string{"templates/deployment.yaml": strings.ReplaceAll(deployment, "nginx:1.27", "nginx:1.26")},
+			files:    []SourceFile{lockFile},
+			config:   PluginConfig{FailOnUnpinned: true},
+			wantErrors: []string{
+				"templates/deployment.yaml: Deployment/web container web: image nginx:1.26 has no digest in images.lock",
+			},
+		},
+		{
+			name:     "lockFile",
+			rendered: rendered,
+			files:    []SourceFile{{Name: "locks/images.yaml", Data: lockFile.Data}},
+			config:   PluginConfig{LockFile: "./locks/images.yaml"},
+			want: map[string][]string{"templates/deployment.yaml": {
+				`image: "nginx@` + nginxDigest + `"` + "\n",
+			}},
+		},
+		{
+			name:     "default lock file missing",
+			rendered: rendered,
+			wantWarnings: []string{
+				"templates/deployment.yaml: Deployment/web container init: image busybox has no digest in images.lock",
+				"templates/deployment.yaml: Deployment/web container web: image nginx:1.27 has no digest in images.lock",
+				"templates/deployment.yaml: Deployment/web container app: image registry.example.com:5000/team/app:v2 has no digest in images.lock",
+			},
+		},
+		{
+			name:       "lockFile missing",
+			rendered:   rendered,
+			config:     PluginConfig{LockFile: "images.lock"},
+			wantErrors: []string{"images.lock: file not found in the chart"},
+		},
+		{
+			name:     "invalid lock file",
+			rendered: rendered,
+			files: []SourceFile{{Name: "images.lock", Data: []byte(`images:
+  - image: nginx:1.27
+    digest: sha256:1234
+  - image: "nginx:1.27@` + nginxDigest + `"
+    digest: ` + nginxDigest + `
+  - image: Nginx
+    digest: ` + nginxDigest + `
+  - image: nginx:1.27
+    digest: ` + nginxDigest + `
+  - image: docker.io/library/nginx:1.27
+    digest: ` + busyboxDigest + `
+`)}},
+			wantErrors: []string{
+				`images.lock: images[0] (nginx:1.27): digest "sha256:1234" is not a sha256 or sha512 digest`,
+				"images.lock: images[1] (nginx:1.27@" + nginxDigest + "): image must not include a digest",
+				`images.lock: images[2] (Nginx): invalid image reference "Nginx"`,
+				"images.lock: images[4] (docker.io/library/nginx:1.27): docker.io/library/nginx:1.27 is locked to another digest earlier",
+			},
+		},
+		{
+			name:       "lock file not YAML",
+			rendered:   rendered,
+			files:      []SourceFile{{Name: "images.lock", Data: []byte("images: [\n")}},
+			wantErrors: []string{"images.lock: yaml:"},
+		},
+		{
+			name:       "invalid rendered image",
+			rendered:   map[string]string{"templates/pod.yaml": "kind: Pod\nmetadata: {name: p}\nspec:\n  containers:\n    - {name: c, image: 'nginx:'}\n"},
+			files:      []SourceFile{lockFile},
+			wantErrors: []string{`templates/pod.yaml: Pod/p container c: invalid tag in image reference "nginx:"`},
+		},
+		{
+			name:       "invalid rendered YAML",
+			rendered:   map[string]string{"templates/bad.yaml": "kind: [\n"},
+			files:      []SourceFile{lockFile},
+			wantErrors: []string{"templates/bad.yaml: document 0 is not valid YAML"},
+		},
+		{
+			name:       "wrong types",
+			raw:        `{"config": {"keepTag": "yes"}}`,
+			wantErrors: []string{"failed to parse input"},
+		},
+		{
+			name:       "empty input",
+			raw:        ``,
+			wantErrors: []string{"no input provided"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			input := []byte(tt.raw)
+			if tt.rendered != nil {
+				var err error
+				input, err = json.Marshal(InputMessageRenderV1{
+					Files:         tt.files,
+					RenderedFiles: tt.rendered,
+					Config:        tt.config,
+				})
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			var warnings []string
+			warnf = func(format string, args ...interface{}) {
+				warnings = append(warnings, fmt.Sprintf(format, args...))
+			}
+			defer func() { warnf = func(string, ...interface{}) {} }()
+
+			output, code := render(input)
+			if (code != 0) != (len(tt.wantErrors) > 0) {
+				t.Errorf("exit code = %d, errors %q", code, output.Errors)
+			}
+			if output.RenderedFiles == nil {
+				t.Error("renderedFiles is nil")
+			}
+
+			if got, want := strings.Join(warnings, "\n"), strings.Join(tt.wantWarnings, "\n"); got != want {
+				t.Errorf("warnings:\n%s\nwant:\n%s", got, want)
+			}
+
+			errs := strings.Join(output.Errors, "\n")
+			if len(tt.wantErrors) == 0 && errs != "" {
+				t.Errorf("unexpected errors: %s", errs)
+			}
+			for _, want := range tt.wantErrors {
+				if !strings.Contains(errs, want) {
+					t.Errorf("errors %q do not contain %q", errs, want)
+				}
+			}
+
+			if len(output.RenderedFiles) != len(tt.want) {
+				t.Errorf("rendered %d files, want %d: %v", len(output.RenderedFiles), len(tt.want), output.RenderedFiles)
+			}
+			for name, wants := range tt.want {
+				got, ok := output.RenderedFiles[name]
+				if !ok {
+					t.Errorf("%s not rendered", name)
+					continue
+				}
+				for _, want := range wants {
+					if !strings.Contains(got, want) {
+						t.Errorf("%s does not contain %q:\n%s", name, want, got)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestParseReference(t *testing.T) {
+	tests := []struct {
+		ref     string
+		wantKey string
+		wantErr bool
+	}{
+		{ref: "nginx", wantKey: "docker.io/library/nginx:latest"},
+		{ref: "nginx:1.27", wantKey: "docker.io/library/nginx:1.27"},
+		{ref: "library/nginx:1.27", wantKey: "docker.io/library/nginx:1.27"},
+		{ref: "index.docker.io/library/nginx:1.27", wantKey: "docker.io/library/nginx:1.27"},
+		{ref: "bitnami/redis:7.2", wantKey: "docker.io/bitnami/redis:7.2"},
+		{ref: "ghcr.io/org/app", wantKey: "ghcr.io/org/app:latest"},
+		{ref: "localhost/app:dev", wantKey: "localhost/app:dev"},
+		{ref: "localhost:5000/a/b/c:v1.0-rc.1", wantKey: "localhost:5000/a/b/c:v1.0-rc.1"},
+		{ref: "nginx:1.27@" + nginxDigest, wantKey: "docker.io/library/nginx:1.27"},
+		{ref: "", wantErr: true},
+		{ref: "Nginx", wantErr: true},
+		{ref: "nginx:", wantErr: true},
+		{ref: "nginx@sha256", wantErr: true},
+		{ref: "bad_host.:5000/app", wantErr: true},
+		{ref: "nginx:1.27 ", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		ref, err := parseReference(tt.ref)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("parseReference(%q) = %+v, want an error", tt.ref, ref)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseReference(%q): %v", tt.ref, err)
+			continue
+		}
+		if got := ref.key(); got != tt.wantKey {
+			t.Errorf("parseReference(%q).key() = %q, want %q", tt.ref, got, tt.wantKey)
+		}
+	}
+}
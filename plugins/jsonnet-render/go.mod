@@ -0,0 +1,19 @@
+module github.com/scottrigby/ref-hip-chart-defined-plugins/plugins/jsonnet-render
+
+go 1.24.3
+
+require (
+	github.com/extism/go-pdk v1.1.3
+	github.com/google/go-jsonnet v0.21.0
+	github.com/scottrigby/ref-hip-chart-defined-plugins/renderv1 v0.0.0
+	go.yaml.in/yaml/v3 v3.0.4
+)
+
+require (
+	golang.org/x/crypto v0.36.0 // indirect
+	golang.org/x/sys v0.31.0 // indirect
+	sigs.k8s.io/yaml v1.4.0 // indirect
+)
+
+// renderv1 holds the render/v1 types shared with hosts, in this repository.
+replace github.com/scottrigby/ref-hip-chart-defined-plugins/renderv1 => ../../renderv1
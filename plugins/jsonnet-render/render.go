@@ -0,0 +1,362 @@
+// Package main implements a render/v1 plugin for Jsonnet.
+//
+// Every templates/*.jsonnet source file is evaluated with the chart's values,
+// release, chart and capabilities available as external variables, e.g.
+// std.extVar("Values"). Imports are resolved from the source files and chart
+// files, so *.libsonnet libraries can be shared between templates. A template
+// evaluates to a Kubernetes object, an array of them, or an object whose
+// fields hold them, and is rendered as a YAML file of the same name with the
+// extension .yaml.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/google/go-jsonnet"
+	"github.com/scottrigby/ref-hip-chart-defined-plugins/renderv1"
+	"go.yaml.in/yaml/v3"
+)
+
+// maxRenderedSize bounds the output of a single template.
+const maxRenderedSize = 16 << 20
+
+// maxStack bounds the depth of Jsonnet evaluation, as go-jsonnet's default.
+const maxStack = 500
+
+// ReleaseInfo contains release metadata passed to render plugins.
+type ReleaseInfo struct {
+	Name      string `json:"name"`
+	Namespace string `json:"namespace"`
+	Revision  int    `json:"revision"`
+	IsInstall bool   `json:"isInstall"`
+	IsUpgrade bool   `json:"isUpgrade"`
+	Service   string `json:"service"`
+}
+
+// ChartInfo contains chart metadata passed to render plugins.
+type ChartInfo struct {
+	Name        string `json:"name"`
+	Version     string `json:"version"`
+	AppVersion  string `json:"appVersion,omitempty"`
+	Description string `json:"description,omitempty"`
+	Type        string `json:"type,omitempty"`
+	IsRoot      bool   `json:"isRoot"`
+}
+
+// CapabilitiesInfo contains Kubernetes cluster capabilities.
+type CapabilitiesInfo struct {
+	KubeVersion map[string]interface{} `json:"kubeVersion"`
+	APIVersions []string               `json:"apiVersions"`
+	HelmVersion string                 `json:"helmVersion"`
+}
+
+// SourceFile represents a file in the chart.
+type SourceFile struct {
+	Name string `json:"name"`
+	Data []byte `json:"data"`
+}
+
+// InputMessageRenderV1 is the input message for render/v1 plugins.
+type InputMessageRenderV1 struct {
+	Release      ReleaseInfo            `json:"release"`
+	Values       map[string]interface{} `json:"values"`
+	Chart        ChartInfo              `json:"chart"`
+	Files        []SourceFile           `json:"files"`
+	Capabilities CapabilitiesInfo       `json:"capabilities"`
+	SourceFiles  []SourceFile           `json:"sourceFiles"`
+	Config       PluginConfig           `json:"config"`
+}
+
+// PluginConfig is the chart's configuration for this plugin, validated by
+// Helm against the configSchema in plugin.yaml.
+type PluginConfig struct {
+	// LibraryPaths are chart directories searched for imports not found
+	// relative to the importing file, in order, like jsonnet's -J flag,
+	// before the chart's root.
+	LibraryPaths []string `json:"libraryPaths"`
+}
+
+// OutputMessageRenderV1 is the output message from render/v1 plugins.
+type OutputMessageRenderV1 struct {
+	RenderedFiles map[string]string `json:"renderedFiles"`
+	Errors        []string          `json:"errors,omitempty"`
+}
+
+// render evaluates a raw render/v1 input message and returns the output
+// message along with the exit code the plugin returns to Helm.
+func render(inputBytes []byte) (OutputMessageRenderV1, uint32) {
+	if len(inputBytes) == 0 {
+		return errorOutput("no input provided")
+	}
+
+	var input InputMessageRenderV1
+	if err := json.Unmarshal(inputBytes, &input); err != nil {
+		return errorOutput(fmt.Sprintf("failed to parse input: %v", err))
+	}
+
+	logf("Received %d source files", len(input.SourceFiles))
+
+	extVars, err := externalVariables(&input)
+	if err != nil {
+		return errorOutput(err.Error())
+	}
+
+	output := OutputMessageRenderV1{
+		RenderedFiles: make(map[string]string),
+	}
+	var templates []string
+	sources := make(map[string]string)
+	for _, file := range input.SourceFiles {
+		if !strings.HasSuffix(file.Name, ".jsonnet") {
+			continue
+		}
+		outName := outputName(file.Name)
+		if other, ok := sources[outName]; ok {
+			output.Errors = append(output.Errors, fmt.Sprintf("%s and %s both render to %s", other, file.Name, outName))
+			continue
+		}
+		sources[outName] = file.Name
+		templates = append(templates, file.Name)
+	}
+
+	vm := jsonnet.MakeVM()
+	vm.MaxStack = maxStack
+	vm.Importer(newImporter(input.SourceFiles, input.Files, input.Config.LibraryPaths))
+	for _, name := range extVarNames {
+		vm.ExtCode(name, extVars[name])
+	}
+	results, errs := evaluate(vm, templates)
+	output.Errors = append(output.Errors, errs...)
+
+	for _, name := range templates {
+		result, ok := results[name]
+		if !ok {
+			continue
+		}
+		rendered, err := manifests(result)
+		if err != nil {
+			output.Errors = append(output.Errors, fmt.Sprintf("%s: %v", name, err))
+			continue
+		}
+		if rendered == "" {
+			continue
+		}
+		output.RenderedFiles[outputName(name)] = rendered
+	}
+
+	// Helm treats a non-zero exit code as the signal that Errors is set
+	if len(output.Errors) > 0 {
+		return output, 1
+	}
+	return output, 0
+}
+
+// outputName returns the rendered file name for a template.
+func outputName(name string) string {
+	return strings.TrimSuffix(name, ".jsonnet") + ".yaml"
+}
+
+// evaluate evaluates each of templates, returning their results as JSON by
+// name. Setting up an evaluation costs far more than a typical template, so
+// all of them are evaluated together as the fields of one object; only if
+// that fails is each evaluated on its own, to report which failed.
+func evaluate(vm *jsonnet.VM, templates []string) (map[string]string, []string) {
+	if len(templates) == 0 {
+		return nil, nil
+	}
+	var snippet strings.Builder
+	snippet.WriteString("{\n")
+	for _, name := range templates {
+		quoted, _ := json.Marshal(name)
+		fmt.Fprintf(&snippet, "  %s: import %s,\n", quoted, quoted)
+	}
+	snippet.WriteString("}\n")
+
+	logf("Evaluating %d templates", len(templates))
+	results, err := vm.EvaluateAnonymousSnippetMulti("<templates>", snippet.String())
+	if err == nil {
+		return results, nil
+	}
+
+	results = make(map[string]string, len(templates))
+	var errs []string
+	for _, name := range templates {
+		logf("Evaluating template: %s", name)
+		result, err := vm.EvaluateFile(name)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("evaluation error in %s: %v", name, strings.TrimSpace(err.Error())))
+			continue
+		}
+		results[name] = result
+	}
+	return results, errs
+}
+
+// extVarNames are the external variables set for every template, in the
+// order they are set.
+var extVarNames = []string{"Values", "Release", "Chart", "Capabilities"}
+
+// externalVariables returns the Jsonnet code, as JSON, for each of
+// extVarNames.
+func externalVariables(input *InputMessageRenderV1) (map[string]string, error) {
+	values := input.Values
+	if values == nil {
+		values = map[string]interface{}{}
+	}
+	vars := map[string]interface{}{
+		"Values":       values,
+		"Release":      input.Release,
+		"Chart":        input.Chart,
+		"Capabilities": input.Capabilities,
+	}
+	code := make(map[string]string, len(vars))
+	for _, name := range extVarNames {
+		data, err := json.Marshal(vars[name])
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode %s: %v", name, err)
+		}
+		code[name] = string(data)
+	}
+	return code, nil
+}
+
+// importer resolves Jsonnet imports from the chart's source files and files.
+// An import is looked up relative to the importing file, then relative to
+// each library path, then relative to the chart's root. Contents are made once per file, since go-jsonnet
+// requires the same Contents for the same foundAt.
+type importer struct {
+	files        map[string]jsonnet.Contents
+	libraryPaths []string
+}
+
+func newImporter(sourceFiles, files []SourceFile, libraryPaths []string) *importer {
+	imp := &importer{
+		files:        make(map[string]jsonnet.Contents, len(sourceFiles)+len(files)),
+		libraryPaths: libraryPaths,
+	}
+	for _, list := range [][]SourceFile{files, sourceFiles} {
+		for _, f := range list {
+			imp.files[path.Clean(f.Name)] = jsonnet.MakeContentsRaw(f.Data)
+		}
+	}
+	return imp
+}
+
+// Import implements jsonnet.Importer.
+func (imp *importer) Import(importedFrom, importedPath string) (jsonnet.Contents, string, error) {
+	if path.IsAbs(importedPath) {
+		return jsonnet.Contents{}, "", fmt.Errorf("import %q: absolute paths are not allowed", importedPath)
+	}
+	dirs := append([]string{path.Dir(importedFrom)}, imp.libraryPaths...)
+	dirs = append(dirs, ".")
+	for _, dir := range dirs {
+		name := path.Join(dir, importedPath)
+		if contents, ok := imp.files[name]; ok {
+			return contents, name, nil
+		}
+	}
+	return jsonnet.Contents{}, "", fmt.Errorf("import %q not found in the chart", importedPath)
+}
+
+// manifests converts the JSON a template evaluated to into YAML documents.
+// The value must be a Kubernetes object (an object with a kind), null, or an
+// array or object of such values, nested to any depth; object fields are
+// rendered in key order.
+func manifests(result string) (string, error) {
+	var root yaml.Node
+	if err := yaml.Unmarshal([]byte(result), &root); err != nil {
+		return "", fmt.Errorf("failed to read evaluation result: %v", err)
+	}
+	if len(root.Content) == 0 {
+		return "", nil
+	}
+
+	var objects []*yaml.Node
+	if err := collectObjects(root.Content[0], "", &objects); err != nil {
+		return "", err
+	}
+
+	var docs []renderv1.RenderedDocument
+	size := 0
+	for _, obj := range objects {
+		blockStyle(obj)
+		var buf bytes.Buffer
+		enc := yaml.NewEncoder(&buf)
+		enc.SetIndent(2)
+		if err := enc.Encode(obj); err != nil {
+			return "", err
+		}
+		enc.Close()
+		if size += buf.Len(); size > maxRenderedSize {
+			return "", fmt.Errorf("rendered output exceeds %d bytes", maxRenderedSize)
+		}
+		docs = append(docs, renderv1.RenderedDocument{Index: len(docs), Content: buf.String()})
+	}
+	return renderv1.JoinDocuments(docs), nil
+}
+
+// collectObjects appends the Kubernetes objects in n to objects. at is the
+// path to n in the evaluation result, for error messages.
+func collectObjects(n *yaml.Node, at string, objects *[]*yaml.Node) error {
+	switch {
+	case n.Kind == yaml.ScalarNode && n.Tag == "!!null":
+		return nil
+	case n.Kind == yaml.SequenceNode:
+		for i, item := range n.Content {
+			if err := collectObjects(item, fmt.Sprintf("%s[%d]", at, i), objects); err != nil {
+				return err
+			}
+		}
+		return nil
+	case n.Kind == yaml.MappingNode:
+		if renderv1.MappingValue(n, "kind") != nil {
+			*objects = append(*objects, n)
+			return nil
+		}
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			if err := collectObjects(n.Content[i+1], at+"."+n.Content[i].Value, objects); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if at == "" {
+		at = "result"
+	} else {
+		at = strings.TrimPrefix(at, ".")
+	}
+	return fmt.Errorf("%s is not a Kubernetes object, array or object", at)
+}
+
+// blockStyle clears the flow and quoting styles parsing JSON leaves on n and
+// its children, so n is written as block YAML. Strings that would read back
+// as another type are still quoted by the encoder.
+func blockStyle(n *yaml.Node) {
+	n.Style = 0
+	if n.Kind == yaml.ScalarNode && n.Tag == "!!str" && strings.Contains(n.Value, "\n") {
+		n.Style = yaml.LiteralStyle
+	}
+	for _, c := range n.Content {
+		blockStyle(c)
+	}
+}
+
+// errorOutput returns an output message reporting msg, with a failing exit
+// code.
+func errorOutput(msg string) (OutputMessageRenderV1, uint32) {
+	return OutputMessageRenderV1{
+		RenderedFiles: make(map[string]string),
+		Errors:        []string{msg},
+	}, 1
+}
+
+// logf logs a debug message. main.go sends it to Helm when built as a
+// plugin; natively, e.g. in tests, it is discarded.
+var logf = func(format string, args ...interface{}) {}
+
+// main is empty: Helm calls the helm_plugin_main export defined in main.go.
+func main() {}
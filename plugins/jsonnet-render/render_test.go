@@ -0,0 +1,176 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestRender(t *testing.T) {
+	file := func(name, data string) SourceFile {
+		return SourceFile{Name: name, Data: []byte(data)}
+	}
+	tests := []struct {
+		name       string
+		files      []SourceFile
+		chartFiles []SourceFile
+		config     PluginConfig
+		raw        string
+		want       map[string]string
+		wantErrors []string
+	}{
+		{
+			name: "object with external variables",
+			files: []SourceFile{file("templates/cm.jsonnet", `{
+  apiVersion: 'v1',
+  kind: 'ConfigMap',
+  metadata: { name: std.extVar('Release').name, namespace: std.extVar('Release').namespace },
+  data: {
+    replicas: std.toString(std.extVar('Values').replicas),
+    chart: std.extVar('Chart').name,
+    kube: std.extVar('Capabilities').kubeVersion.version,
+    flag: 'true',
+    script: 'line 1\nline 2\n',
+  },
+}`)},
+			want: map[string]string{"templates/cm.yaml": `apiVersion: v1
+data:
+  chart: demo
+  flag: "true"
+  kube: v1.35.0
+  replicas: "3"
+  script: |
+    line 1
+    line 2
+kind: ConfigMap
+metadata:
+  name: web
+  namespace: prod
+`},
+		},
+		{
+			name: "array and object of objects with imports",
+			files: []SourceFile{
+				file("templates/lib/k.libsonnet", `{ cm(name): { apiVersion: 'v1', kind: 'ConfigMap', metadata: { name: name } } }`),
+				file("templates/app/all.jsonnet", `local k = import '../lib/k.libsonnet';
+local shared = import 'shared.libsonnet';
+{
+  b: [k.cm('b1'), null, [k.cm('b2')]],
+  a: k.cm(shared.name) + { data: { conf: importstr 'config/app.conf' } },
+}`),
+				file("templates/lib/shared.libsonnet", `{ name: 'a' }`),
+			},
+			chartFiles: []SourceFile{file("config/app.conf", "x=1")},
+			config:     PluginConfig{LibraryPaths: []string{"templates/lib", "missing"}},
+			want: map[string]string{"templates/app/all.yaml": `apiVersion: v1
+data:
+  conf: x=1
+kind: ConfigMap
+metadata:
+  name: a
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: b1
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: b2
+`},
+		},
+		{
+			name:  "null renders nothing",
+			files: []SourceFile{file("templates/empty.jsonnet", `if std.extVar('Values').enabled then {kind: 'X'} else null`)},
+			want:  map[string]string{},
+		},
+		{
+			name:       "scalar result",
+			files:      []SourceFile{file("templates/bad.jsonnet", `{ items: [{ kind: 'X' }, 'oops'] }`)},
+			wantErrors: []string{"templates/bad.jsonnet: items[1] is not a Kubernetes object, array or object"},
+		},
+		{
+			name:       "evaluation error",
+			files:      []SourceFile{file("templates/err.jsonnet", `error 'boom'`), file("templates/ok.jsonnet", `{kind: 'X'}`)},
+			want:       map[string]string{"templates/ok.yaml": "kind: X\n"},
+			wantErrors: []string{"evaluation error in templates/err.jsonnet", "boom"},
+		},
+		{
+			name:       "missing import",
+			files:      []SourceFile{file("templates/a.jsonnet", `import 'nope.libsonnet'`)},
+			wantErrors: []string{`import "nope.libsonnet" not found in the chart`},
+		},
+		{
+			name:       "absolute import",
+			files:      []SourceFile{file("templates/a.jsonnet", `importstr '/etc/passwd'`)},
+			wantErrors: []string{"absolute paths are not allowed"},
+		},
+		{
+			name:       "stack overflow",
+			files:      []SourceFile{file("templates/a.jsonnet", `local f(x) = f(x) + 1; f(0)`)},
+			wantErrors: []string{"max stack frames exceeded"},
+		},
+		{
+			name:       "output name collision",
+			files:      []SourceFile{file("templates/a.jsonnet", `null`), file("templates/a.jsonnet", `null`)},
+			wantErrors: []string{"templates/a.jsonnet and templates/a.jsonnet both render to templates/a.yaml"},
+		},
+		{
+			name:       "malformed JSON",
+			raw:        `{"values": []}`,
+			wantErrors: []string{"failed to parse input"},
+		},
+		{
+			name:       "empty input",
+			raw:        ``,
+			wantErrors: []string{"no input provided"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			input := []byte(tt.raw)
+			if tt.files != nil {
+				var err error
+				input, err = json.Marshal(InputMessageRenderV1{
+					Release:      ReleaseInfo{Name: "web", Namespace: "prod"},
+					Values:       map[string]interface{}{"replicas": 3, "enabled": false},
+					Chart:        ChartInfo{Name: "demo", Version: "0.1.0"},
+					Capabilities: CapabilitiesInfo{KubeVersion: map[string]interface{}{"version": "v1.35.0"}},
+					Files:        tt.chartFiles,
+					SourceFiles:  tt.files,
+					Config:       tt.config,
+				})
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			output, code := render(input)
+			if (code != 0) != (len(tt.wantErrors) > 0) {
+				t.Errorf("exit code = %d, errors %q", code, output.Errors)
+			}
+			if tt.want != nil {
+				if len(output.RenderedFiles) != len(tt.want) {
+					t.Errorf("rendered %d files, want %d: %v", len(output.RenderedFiles), len(tt.want), output.RenderedFiles)
+				}
+				for name, want := range tt.want {
+					if got := output.RenderedFiles[name]; got != want {
+						t.Errorf("%s =\n%s\nwant\n%s", name, got, want)
+					}
+				}
+			}
+
+			errs := strings.Join(output.Errors, "\n")
+			if len(tt.wantErrors) == 0 && errs != "" {
+				t.Errorf("unexpected errors: %s", errs)
+			}
+			for _, want := range tt.wantErrors {
+				if !strings.Contains(errs, want) {
+					t.Errorf("errors %q do not contain %q", errs, want)
+				}
+			}
+		})
+	}
+}
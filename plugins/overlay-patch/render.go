@@ -0,0 +1,1087 @@
+// Package main implements a render/v1 plugin that patches rendered
+// manifests, like Kustomize's patches.
+//
+// The plugin runs after the plugins rendering a chart's templates and
+// receives the files they rendered as renderedFiles. Each patch in its config,
+// read from a chart file or given inline, is a strategic merge patch or a
+// JSON 6902 patch, applied to every rendered Kubernetes object it targets. The
+// files holding patched objects are returned as renderedFiles, replacing the
+// originals; the plugin renders no templates of its own.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/scottrigby/ref-hip-chart-defined-plugins/renderv1"
+	"go.yaml.in/yaml/v3"
+)
+
+// maxRenderedSize bounds the output of a single patched file.
+const maxRenderedSize = 16 << 20
+
+// maxValues bounds the values in an object after a JSON 6902 copy, since
+// each copy can double it.
+const maxValues = 1 << 20
+
+// ReleaseInfo contains release metadata passed to render plugins.
+type ReleaseInfo struct {
+	Name      string `json:"name"`
+	Namespace string `json:"namespace"`
+	Revision  int    `json:"revision"`
+	IsInstall bool   `json:"isInstall"`
+	IsUpgrade bool   `json:"isUpgrade"`
+	Service   string `json:"service"`
+}
+
+// ChartInfo contains chart metadata passed to render plugins.
+type ChartInfo struct {
+	Name        string `json:"name"`
+	Version     string `json:"version"`
+	AppVersion  string `json:"appVersion,omitempty"`
+	Description string `json:"description,omitempty"`
+	Type        string `json:"type,omitempty"`
+	IsRoot      bool   `json:"isRoot"`
+}
+
+// SourceFile represents a file in the chart.
+type SourceFile struct {
+	Name string `json:"name"`
+	Data []byte `json:"data"`
+}
+
+// InputMessageRenderV1 is the input message for render/v1 plugins.
+type InputMessageRenderV1 struct {
+	Release     ReleaseInfo  `json:"release"`
+	Chart       ChartInfo    `json:"chart"`
+	Files       []SourceFile `json:"files"`
+	SourceFiles []SourceFile `json:"sourceFiles"`
+	// RenderedFiles are the files rendered by the plugins before this one.
+	RenderedFiles map[string]string `json:"renderedFiles"`
+	Config        PluginConfig      `json:"config"`
+}
+
+// PluginConfig is the chart's configuration for this plugin, validated by
+// Helm against the configSchema in plugin.yaml.
+type PluginConfig struct {
+	Patches []Patch `json:"patches"`
+}
+
+// Patch is an entry of the patches config: a strategic merge patch or JSON
+// 6902 patch, read from the chart file Path or given inline as Patch.
+type Patch struct {
+	Path  string `json:"path"`
+	Patch string `json:"patch"`
+	// Target selects the objects to patch. A strategic merge patch without
+	// a target patches the object with its own kind and name.
+	Target *Target `json:"target"`
+}
+
+// Target selects rendered objects. Each field set is a regular expression
+// that must match the whole value, except the selectors, which are
+// equality-based label selectors such as "app=web,tier!=cache".
+type Target struct {
+	Group              string `json:"group"`
+	Version            string `json:"version"`
+	Kind               string `json:"kind"`
+	Name               string `json:"name"`
+	Namespace          string `json:"namespace"`
+	LabelSelector      string `json:"labelSelector"`
+	AnnotationSelector string `json:"annotationSelector"`
+}
+
+// OutputMessageRenderV1 is the output message from render/v1 plugins.
+type OutputMessageRenderV1 struct {
+	RenderedFiles        map[string]string `json:"renderedFiles"`
+	RemovedRenderedFiles []string          `json:"removedRenderedFiles,omitempty"`
+	Errors               []string          `json:"errors,omitempty"`
+}
+
+// render applies the patches of a raw render/v1 input message and returns
+// the output message along with the exit code the plugin returns to Helm.
+func render(inputBytes []byte) (OutputMessageRenderV1, uint32) {
+	if len(inputBytes) == 0 {
+		return errorOutput("no input provided")
+	}
+
+	var input InputMessageRenderV1
+	if err := json.Unmarshal(inputBytes, &input); err != nil {
+		return errorOutput(fmt.Sprintf("failed to parse input: %v", err))
+	}
+
+	logf("Received %d rendered files and %d patches", len(input.RenderedFiles), len(input.Config.Patches))
+
+	files := make(map[string][]byte, len(input.Files))
+	for _, f := range input.Files {
+		files[path.Clean(f.Name)] = f.Data
+	}
+	var patches []*patch
+	var errs []string
+	for i, p := range input.Config.Patches {
+		loaded, err := loadPatch(i, p, files)
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		patches = append(patches, loaded)
+	}
+	if errs != nil {
+		return OutputMessageRenderV1{RenderedFiles: make(map[string]string), Errors: errs}, 1
+	}
+	if len(patches) == 0 {
+		return OutputMessageRenderV1{RenderedFiles: make(map[string]string)}, 0
+	}
+
+	manifests, errs := parseManifests(input.RenderedFiles)
+	if errs != nil {
+		return OutputMessageRenderV1{RenderedFiles: make(map[string]string), Errors: errs}, 1
+	}
+
+	output := OutputMessageRenderV1{
+		RenderedFiles: make(map[string]string),
+	}
+	for _, p := range patches {
+		matched := 0
+		for _, m := range manifests {
+			for _, doc := range m.docs {
+				ok, err := p.apply(doc)
+				if err != nil {
+					output.Errors = append(output.Errors, fmt.Sprintf("%s: %s in %s: %v", p.name, doc.id, m.name, err))
+					continue
+				}
+				if ok {
+					matched++
+				}
+			}
+		}
+		if matched == 0 {
+			logf("%s matches no rendered object", p.name)
+		}
+	}
+	if len(output.Errors) > 0 {
+		return output, 1
+	}
+
+	for _, m := range manifests {
+		content, modified, err := m.encode()
+		if err != nil {
+			output.Errors = append(output.Errors, fmt.Sprintf("%s: %v", m.name, err))
+			continue
+		}
+		switch {
+		case modified && content == "":
+			// Every object of the file was deleted
+			output.RemovedRenderedFiles = append(output.RemovedRenderedFiles, m.name)
+		case modified:
+			output.RenderedFiles[m.name] = content
+		}
+	}
+
+	// Helm treats a non-zero exit code as the signal that Errors is set
+	if len(output.Errors) > 0 {
+		return output, 1
+	}
+	return output, 0
+}
+
+// manifest is a rendered file split into YAML documents.
+type manifest struct {
+	name  string
+	parts []renderv1.RenderedDocument
+	docs  []*document
+}
+
+// document is a Kubernetes object in a manifest, parsed for patching.
+type document struct {
+	// part is the index of the document in manifest.parts.
+	part int
+	// id is the object's kind and name, for messages.
+	id       string
+	root     *yaml.Node
+	modified bool
+	deleted  bool
+}
+
+// parseManifests splits the rendered YAML files into documents, in file name
+// order, and parses the Kubernetes objects among them. Documents that are
+// not objects are kept as they are.
+func parseManifests(rendered map[string]string) ([]*manifest, []string) {
+	names := make([]string, 0, len(rendered))
+	for name := range rendered {
+		switch path.Ext(name) {
+		case ".yaml", ".yml", ".json":
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	var manifests []*manifest
+	var errs []string
+	for _, name := range names {
+		m := &manifest{name: name, parts: renderv1.SplitDocuments(name, rendered[name])}
+		for i, part := range m.parts {
+			var doc yaml.Node
+			if err := yaml.Unmarshal([]byte(part.Content), &doc); err != nil {
+				errs = append(errs, fmt.Sprintf("%s: document %d is not valid YAML: %v", name, i, err))
+				continue
+			}
+			if len(doc.Content) == 0 || doc.Content[0].Kind != yaml.MappingNode || renderv1.ScalarValue(doc.Content[0], "kind") == "" {
+				continue
+			}
+			root := doc.Content[0]
+			m.docs = append(m.docs, &document{
+				part: i,
+				id:   renderv1.ScalarValue(root, "kind") + "/" + renderv1.ScalarValue(renderv1.MappingValue(root, "metadata"), "name"),
+				root: root,
+			})
+		}
+		manifests = append(manifests, m)
+	}
+	return manifests, errs
+}
+
+// encode returns the manifest with its patched documents re-encoded and its
+// deleted documents removed, and whether any document changed.
+func (m *manifest) encode() (string, bool, error) {
+	parts := append([]renderv1.RenderedDocument(nil), m.parts...)
+	deleted := make(map[int]bool)
+	modified := false
+	for _, doc := range m.docs {
+		switch {
+		case doc.deleted:
+			deleted[doc.part] = true
+		case doc.modified:
+			var buf bytes.Buffer
+			enc := yaml.NewEncoder(&buf)
+			enc.SetIndent(2)
+			if err := enc.Encode(doc.root); err != nil {
+				return "", false, fmt.Errorf("document %d: %v", doc.part, err)
+			}
+			enc.Close()
+			parts[doc.part].Content = buf.String()
+		default:
+			continue
+		}
+		modified = true
+	}
+	if !modified {
+		return "", false, nil
+	}
+
+	kept := parts[:0]
+	for i, part := range parts {
+		if !deleted[i] {
+			kept = append(kept, part)
+		}
+	}
+	content := renderv1.JoinDocuments(kept)
+	if len(content) > maxRenderedSize {
+		return "", false, fmt.Errorf("patched output exceeds %d bytes", maxRenderedSize)
+	}
+	return content, true, nil
+}
+
+// patch is a loaded patches entry.
+type patch struct {
+	// name identifies the entry in messages, e.g.
+	// "patches[0] (patches/web.yaml)".
+	name   string
+	target *matcher
+	// merge holds the documents of a strategic merge patch, ops the
+	// operations of a JSON 6902 patch.
+	merge []*yaml.Node
+	ops   []operation
+}
+
+// loadPatch reads and parses the patches entry p at index i.
+func loadPatch(i int, p Patch, files map[string][]byte) (*patch, error) {
+	loaded := &patch{name: fmt.Sprintf("patches[%d]", i)}
+	if p.Path != "" {
+		loaded.name += " (" + p.Path + ")"
+	}
+	var data []byte
+	switch {
+	case p.Path != "" && p.Patch != "":
+		return nil, fmt.Errorf("%s: set either path or patch, not both", loaded.name)
+	case p.Path != "":
+		var ok bool
+		if data, ok = files[path.Clean(p.Path)]; !ok {
+			return nil, fmt.Errorf("%s: file not found in the chart", loaded.name)
+		}
+	case p.Patch != "":
+		data = []byte(p.Patch)
+	default:
+		return nil, fmt.Errorf("%s: set path or patch", loaded.name)
+	}
+
+	if p.Target != nil {
+		m, err := newMatcher(p.Target)
+		if err != nil {
+			return nil, fmt.Errorf("%s: target: %v", loaded.name, err)
+		}
+		loaded.target = m
+	}
+
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	for {
+		var doc yaml.Node
+		err := dec.Decode(&doc)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", loaded.name, err)
+		}
+		if len(doc.Content) == 0 {
+			continue
+		}
+		root := doc.Content[0]
+		if hasAlias(root) {
+			return nil, fmt.Errorf("%s: YAML aliases are not supported in patches", loaded.name)
+		}
+		switch {
+		case root.Kind == yaml.SequenceNode && loaded.merge == nil && loaded.ops == nil:
+			if loaded.target == nil {
+				return nil, fmt.Errorf("%s: a JSON 6902 patch needs a target", loaded.name)
+			}
+			ops, err := parseOperations(root)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", loaded.name, err)
+			}
+			loaded.ops = ops
+		case root.Kind == yaml.MappingNode && loaded.ops == nil:
+			if loaded.target == nil && (renderv1.ScalarValue(root, "kind") == "" || renderv1.ScalarValue(renderv1.MappingValue(root, "metadata"), "name") == "") {
+				return nil, fmt.Errorf("%s: a strategic merge patch needs kind and metadata.name, or a target", loaded.name)
+			}
+			loaded.merge = append(loaded.merge, root)
+		default:
+			return nil, fmt.Errorf("%s: a patch is a mapping (strategic merge), mappings in separate documents, or a list of JSON 6902 operations", loaded.name)
+		}
+	}
+	if loaded.merge == nil && loaded.ops == nil {
+		return nil, fmt.Errorf("%s: the patch is empty", loaded.name)
+	}
+	return loaded, nil
+}
+
+// apply applies p to doc if p targets it, reporting whether it did.
+func (p *patch) apply(doc *document) (bool, error) {
+	if doc.deleted {
+		return false, nil
+	}
+	if p.ops != nil {
+		if !p.target.matches(doc.root) {
+			return false, nil
+		}
+		for i, op := range p.ops {
+			if err := op.apply(doc.root); err != nil {
+				return false, fmt.Errorf("operation %d (%s %s): %v", i, op.op, op.path, err)
+			}
+		}
+		doc.modified = true
+		return true, nil
+	}
+
+	applied := false
+	for _, src := range p.merge {
+		if p.target != nil && !p.target.matches(doc.root) || p.target == nil && !sameObject(doc.root, src) {
+			continue
+		}
+		merged, err := mergeValue(doc.root, withoutIdentity(src), "")
+		if err != nil {
+			return false, err
+		}
+		if merged == nil {
+			doc.deleted = true
+			return true, nil
+		}
+		if merged != doc.root {
+			*doc.root = *merged
+		}
+		doc.modified = true
+		applied = true
+	}
+	return applied, nil
+}
+
+// sameObject reports whether obj is the object the strategic merge patch
+// src names by its kind, metadata.name and, if set, apiVersion and
+// metadata.namespace.
+func sameObject(obj, src *yaml.Node) bool {
+	objMeta, srcMeta := renderv1.MappingValue(obj, "metadata"), renderv1.MappingValue(src, "metadata")
+	if renderv1.ScalarValue(obj, "kind") != renderv1.ScalarValue(src, "kind") || renderv1.ScalarValue(objMeta, "name") != renderv1.ScalarValue(srcMeta, "name") {
+		return false
+	}
+	if v := renderv1.ScalarValue(src, "apiVersion"); v != "" && v != renderv1.ScalarValue(obj, "apiVersion") {
+		return false
+	}
+	if ns := renderv1.ScalarValue(srcMeta, "namespace"); ns != "" && ns != renderv1.ScalarValue(objMeta, "namespace") {
+		return false
+	}
+	return true
+}
+
+// withoutIdentity returns a copy of the strategic merge patch src without
+// the fields identifying the object, which a patch does not change.
+func withoutIdentity(src *yaml.Node) *yaml.Node {
+	out := *src
+	out.Content = nil
+	for i := 0; i+1 < len(src.Content); i += 2 {
+		key, value := src.Content[i], src.Content[i+1]
+		switch key.Value {
+		case "apiVersion", "kind":
+			continue
+		case "metadata":
+			if value.Kind == yaml.MappingNode {
+				meta := *value
+				meta.Content = nil
+				for j := 0; j+1 < len(value.Content); j += 2 {
+					if k := value.Content[j].Value; k != "name" && k != "namespace" {
+						meta.Content = append(meta.Content, value.Content[j], value.Content[j+1])
+					}
+				}
+				value = &meta
+			}
+		}
+		out.Content = append(out.Content, key, value)
+	}
+	return &out
+}
+
+// matcher is a compiled Target.
+type matcher struct {
+	group, version, kind, name, namespace *regexp.Regexp
+	labels, annotations                   []requirement
+}
+
+func newMatcher(t *Target) (*matcher, error) {
+	m := &matcher{}
+	for _, field := range []struct {
+		name, value string
+		re          **regexp.Regexp
+	}{
+		{"group", t.Group, &m.group},
+		{"version", t.Version, &m.version},
+		{"kind", t.Kind, &m.kind},
+		{"name", t.Name, &m.name},
+		{"namespace", t.Namespace, &m.namespace},
+	} {
+		if field.value == "" {
+			continue
+		}
+		re, err := regexp.Compile("^(?:" + field.value + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", field.name, err)
+		}
+		*field.re = re
+	}
+	var err error
+	if m.labels, err = parseSelector(t.LabelSelector); err != nil {
+		return nil, fmt.Errorf("labelSelector: %v", err)
+	}
+	if m.annotations, err = parseSelector(t.AnnotationSelector); err != nil {
+		return nil, fmt.Errorf("annotationSelector: %v", err)
+	}
+	return m, nil
+}
+
+// matches reports whether the object obj is selected by m.
+func (m *matcher) matches(obj *yaml.Node) bool {
+	group, version := "", renderv1.ScalarValue(obj, "apiVersion")
+	if i := strings.LastIndex(version, "/"); i >= 0 {
+		group, version = version[:i], version[i+1:]
+	}
+	meta := renderv1.MappingValue(obj, "metadata")
+	for _, field := range []struct {
+		re    *regexp.Regexp
+		value string
+	}{
+		{m.group, group},
+		{m.version, version},
+		{m.kind, renderv1.ScalarValue(obj, "kind")},
+		{m.name, renderv1.ScalarValue(meta, "name")},
+		{m.namespace, renderv1.ScalarValue(meta, "namespace")},
+	} {
+		if field.re != nil && !field.re.MatchString(field.value) {
+			return false
+		}
+	}
+	return selects(m.labels, renderv1.MappingValue(meta, "labels")) && selects(m.annotations, renderv1.MappingValue(meta, "annotations"))
+}
+
+// requirement is one term of an equality-based selector.
+type requirement struct {
+	key, value string
+	// op is "=", "!=", "exists" or "!exists".
+	op string
+}
+
+// parseSelector parses a comma-separated equality-based selector, e.g.
+// "app=web,tier!=cache,canary,!legacy".
+func parseSelector(s string) ([]requirement, error) {
+	if strings.TrimSpace(s) == "" {
+		return nil, nil
+	}
+	var reqs []requirement
+	for _, term := range strings.Split(s, ",") {
+		term = strings.TrimSpace(term)
+		var r requirement
+		switch {
+		case strings.Contains(term, "!="):
+			parts := strings.SplitN(term, "!=", 2)
+			r = requirement{key: parts[0], value: parts[1], op: "!="}
+		case strings.Contains(term, "="):
+			parts := strings.SplitN(strings.Replace(term, "==", "=", 1), "=", 2)
+			r = requirement{key: parts[0], value: parts[1], op: "="}
+		case strings.HasPrefix(term, "!"):
+			r = requirement{key: term[1:], op: "!exists"}
+		default:
+			r = requirement{key: term, op: "exists"}
+		}
+		r.key, r.value = strings.TrimSpace(r.key), strings.TrimSpace(r.value)
+		if r.key == "" || strings.ContainsAny(r.key, " ()!=") || strings.ContainsAny(r.value, " ()!=,") {
+			return nil, fmt.Errorf("invalid term %q; only key=value, key!=value, key and !key are supported", term)
+		}
+		reqs = append(reqs, r)
+	}
+	return reqs, nil
+}
+
+// selects reports whether the labels or annotations mapping m satisfies
+// every requirement.
+func selects(reqs []requirement, m *yaml.Node) bool {
+	for _, r := range reqs {
+		value := renderv1.MappingValue(m, r.key)
+		switch r.op {
+		case "=":
+			if value == nil || value.Value != r.value {
+				return false
+			}
+		case "!=":
+			if value != nil && value.Value == r.value {
+				return false
+			}
+		case "exists":
+			if value == nil {
+				return false
+			}
+		case "!exists":
+			if value != nil {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// mergeKeys are the keys identifying the items of the lists merged item by
+// item by strategic merge patches, by list field name. They are those of the
+// Kubernetes workload and Service APIs; other lists are replaced as a whole.
+// Ports are merged by containerPort in containers and by port elsewhere.
+var mergeKeys = map[string]string{
+	"containers":                "name",
+	"initContainers":            "name",
+	"ephemeralContainers":       "name",
+	"env":                       "name",
+	"volumes":                   "name",
+	"imagePullSecrets":          "name",
+	"resourceClaims":            "name",
+	"volumeMounts":              "mountPath",
+	"volumeDevices":             "devicePath",
+	"hostAliases":               "ip",
+	"topologySpreadConstraints": "topologyKey",
+	"ports":                     "port",
+}
+
+// mergeValue returns dst with the strategic merge patch src applied, or nil
+// if src deletes it. field is the name of the field holding dst. dst is
+// updated in place where possible.
+func mergeValue(dst, src *yaml.Node, field string) (*yaml.Node, error) {
+	switch src.Kind {
+	case yaml.MappingNode:
+		switch d := directive(src); d {
+		case "":
+		case "delete":
+			return nil, nil
+		case "replace":
+			return clean(src), nil
+		default:
+			return nil, fmt.Errorf("%s: unsupported $patch directive %q", fieldName(field), d)
+		}
+		if dst.Kind != yaml.MappingNode {
+			return clean(src), nil
+		}
+		for i := 0; i+1 < len(src.Content); i += 2 {
+			key, value := src.Content[i].Value, src.Content[i+1]
+			if key == "$patch" {
+				continue
+			}
+			if strings.HasPrefix(key, "$") {
+				return nil, fmt.Errorf("%s: unsupported directive %s", fieldName(field), key)
+			}
+			j := mappingIndex(dst, key)
+			if isNull(value) {
+				if j >= 0 {
+					dst.Content = append(dst.Content[:j], dst.Content[j+2:]...)
+				}
+				continue
+			}
+			if j < 0 {
+				if value.Kind == yaml.MappingNode && directive(value) == "delete" {
+					continue
+				}
+				dst.Content = append(dst.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key}, clean(value))
+				continue
+			}
+			merged, err := mergeValue(dst.Content[j+1], value, key)
+			if err != nil {
+				return nil, err
+			}
+			if merged == nil {
+				dst.Content = append(dst.Content[:j], dst.Content[j+2:]...)
+				continue
+			}
+			dst.Content[j+1] = merged
+		}
+		return dst, nil
+	case yaml.SequenceNode:
+		if dst.Kind != yaml.SequenceNode {
+			return clean(src), nil
+		}
+		return mergeList(dst, src, field)
+	}
+	return clean(src), nil
+}
+
+// mergeList merges the items of the list src into the list dst by the merge
+// key of field, or replaces dst if the field has none. An item
+// {"$patch": "replace"} replaces dst with the other items of src, and an item
+// with "$patch": "delete" removes the item with its key.
+func mergeList(dst, src *yaml.Node, field string) (*yaml.Node, error) {
+	for _, item := range src.Content {
+		if item.Kind == yaml.MappingNode && directive(item) == "replace" {
+			return clean(src), nil
+		}
+	}
+	key, ok := mergeKeys[field]
+	if !ok {
+		return clean(src), nil
+	}
+	if field == "ports" && (hasKey(dst.Content, "containerPort") || hasKey(src.Content, "containerPort")) {
+		key = "containerPort"
+	}
+
+	for i, item := range src.Content {
+		if item.Kind != yaml.MappingNode || renderv1.ScalarValue(item, key) == "" {
+			return nil, fmt.Errorf("%s[%d]: item has no %s to merge it by", field, i, key)
+		}
+		j := -1
+		for k, existing := range dst.Content {
+			if existing.Kind == yaml.MappingNode && renderv1.ScalarValue(existing, key) == renderv1.ScalarValue(item, key) {
+				j = k
+				break
+			}
+		}
+		if j < 0 {
+			if directive(item) != "delete" {
+				dst.Content = append(dst.Content, clean(item))
+			}
+			continue
+		}
+		merged, err := mergeValue(dst.Content[j], item, fmt.Sprintf("%s[%s=%s]", field, key, renderv1.ScalarValue(item, key)))
+		if err != nil {
+			return nil, err
+		}
+		if merged == nil {
+			dst.Content = append(dst.Content[:j], dst.Content[j+1:]...)
+			continue
+		}
+		dst.Content[j] = merged
+	}
+	return dst, nil
+}
+
+// hasKey reports whether any of the mappings in items has key.
+func hasKey(items []*yaml.Node, key string) bool {
+	for _, item := range items {
+		if item.Kind == yaml.MappingNode && renderv1.MappingValue(item, key) != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// directive returns the $patch directive of the mapping m, if any.
+func directive(m *yaml.Node) string {
+	return renderv1.ScalarValue(m, "$patch")
+}
+
+// clean returns a deep copy of the patch value n for adding to an object:
+// in block style, with aliases expanded, without comments, $patch directives, null mapping values
+// and items that delete, as a JSON merge patch adds values.
+func clean(n *yaml.Node) *yaml.Node {
+	if n.Kind == yaml.AliasNode && n.Alias != nil {
+		return clean(n.Alias)
+	}
+	out := *n
+	out.Style &^= yaml.FlowStyle
+	out.HeadComment, out.LineComment, out.FootComment = "", "", ""
+	out.Content = nil
+	switch n.Kind {
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			key, value := n.Content[i], n.Content[i+1]
+			if key.Value == "$patch" || isNull(value) || value.Kind == yaml.MappingNode && directive(value) == "delete" {
+				continue
+			}
+			out.Content = append(out.Content, clean(key), clean(value))
+		}
+	case yaml.SequenceNode:
+		for _, item := range n.Content {
+			if item.Kind == yaml.MappingNode && directive(item) != "" && len(item.Content) == 2 || directive(item) == "delete" {
+				continue
+			}
+			out.Content = append(out.Content, clean(item))
+		}
+	default:
+		for _, c := range n.Content {
+			out.Content = append(out.Content, clean(c))
+		}
+	}
+	return &out
+}
+
+// hasAlias reports whether n holds a YAML alias.
+func hasAlias(n *yaml.Node) bool {
+	if n.Kind == yaml.AliasNode {
+		return true
+	}
+	for _, c := range n.Content {
+		if hasAlias(c) {
+			return true
+		}
+	}
+	return false
+}
+
+// countValues returns the number of nodes in n, following aliases, counting
+// no further than limit.
+func countValues(n *yaml.Node, limit int) int {
+	count := 1
+	if n.Kind == yaml.AliasNode && n.Alias != nil {
+		return countValues(n.Alias, limit)
+	}
+	for _, c := range n.Content {
+		if count > limit {
+			break
+		}
+		count += countValues(c, limit-count)
+	}
+	return count
+}
+
+// fieldName returns field for messages, where the empty field is the
+// object itself.
+func fieldName(field string) string {
+	if field == "" {
+		return "object"
+	}
+	return field
+}
+
+// operation is a JSON 6902 patch operation.
+type operation struct {
+	op, path, from string
+	value          *yaml.Node
+}
+
+// parseOperations parses the list of JSON 6902 operations ops.
+func parseOperations(ops *yaml.Node) ([]operation, error) {
+	var parsed []operation
+	for i, n := range ops.Content {
+		if n.Kind != yaml.MappingNode {
+			return nil, fmt.Errorf("operation %d is not a mapping", i)
+		}
+		op := operation{
+			op:    renderv1.ScalarValue(n, "op"),
+			path:  renderv1.ScalarValue(n, "path"),
+			from:  renderv1.ScalarValue(n, "from"),
+			value: renderv1.MappingValue(n, "value"),
+		}
+		if renderv1.MappingValue(n, "path") == nil {
+			return nil, fmt.Errorf("operation %d has no path", i)
+		}
+		if err := checkPointer(op.path); err != nil {
+			return nil, fmt.Errorf("operation %d: path: %v", i, err)
+		}
+		switch op.op {
+		case "add", "replace", "test":
+			if op.value == nil {
+				return nil, fmt.Errorf("operation %d (%s) has no value", i, op.op)
+			}
+		case "remove":
+		case "move", "copy":
+			if renderv1.MappingValue(n, "from") == nil {
+				return nil, fmt.Errorf("operation %d (%s) has no from", i, op.op)
+			}
+			if err := checkPointer(op.from); err != nil {
+				return nil, fmt.Errorf("operation %d: from: %v", i, err)
+			}
+		default:
+			return nil, fmt.Errorf("operation %d: unknown op %q", i, op.op)
+		}
+		parsed = append(parsed, op)
+	}
+	return parsed, nil
+}
+
+// checkPointer checks that p is a JSON pointer.
+func checkPointer(p string) error {
+	if p != "" && !strings.HasPrefix(p, "/") {
+		return fmt.Errorf("%q is not a JSON pointer", p)
+	}
+	return nil
+}
+
+// apply applies op to the object root, in place.
+func (op operation) apply(root *yaml.Node) error {
+	switch op.op {
+	case "add":
+		return add(root, op.path, clean(op.value))
+	case "remove":
+		_, err := remove(root, op.path)
+		return err
+	case "replace":
+		return replace(root, op.path, clean(op.value))
+	case "move":
+		if op.path == op.from {
+			return nil
+		}
+		if strings.HasPrefix(op.path, op.from+"/") {
+			return fmt.Errorf("cannot move %s into itself", op.from)
+		}
+		value, err := remove(root, op.from)
+		if err != nil {
+			return err
+		}
+		return add(root, op.path, value)
+	case "copy":
+		value, err := get(root, op.from)
+		if err != nil {
+			return err
+		}
+		if countValues(root, maxValues)+countValues(value, maxValues) > maxValues {
+			return fmt.Errorf("the object would hold more than %d values", maxValues)
+		}
+		return add(root, op.path, clean(value))
+	case "test":
+		value, err := get(root, op.path)
+		if err != nil {
+			return err
+		}
+		var got, want interface{}
+		if err := value.Decode(&got); err != nil {
+			return err
+		}
+		if err := op.value.Decode(&want); err != nil {
+			return err
+		}
+		if !reflect.DeepEqual(got, want) {
+			return errors.New("test failed: the value is not the expected one")
+		}
+	}
+	return nil
+}
+
+// tokens returns the reference tokens of the JSON pointer p.
+func tokens(p string) []string {
+	if p == "" {
+		return nil
+	}
+	parts := strings.Split(p[1:], "/")
+	for i, part := range parts {
+		parts[i] = strings.ReplaceAll(strings.ReplaceAll(part, "~1", "/"), "~0", "~")
+	}
+	return parts
+}
+
+// get returns the value at the JSON pointer p in root.
+func get(root *yaml.Node, p string) (*yaml.Node, error) {
+	n := root
+	for _, tok := range tokens(p) {
+		child, err := childNode(n, tok)
+		if err != nil {
+			return nil, err
+		}
+		n = child
+	}
+	return n, nil
+}
+
+// parentOf returns the container of the value at the JSON pointer p in root
+// and the last token of p.
+func parentOf(root *yaml.Node, p string) (*yaml.Node, string, error) {
+	toks := tokens(p)
+	if len(toks) == 0 {
+		return nil, "", errors.New("the whole object cannot be added or removed")
+	}
+	parent := root
+	for _, tok := range toks[:len(toks)-1] {
+		child, err := childNode(parent, tok)
+		if err != nil {
+			return nil, "", err
+		}
+		parent = child
+	}
+	return parent, toks[len(toks)-1], nil
+}
+
+// childNode returns the value under tok in the mapping or list n.
+func childNode(n *yaml.Node, tok string) (*yaml.Node, error) {
+	switch n.Kind {
+	case yaml.MappingNode:
+		if v := renderv1.MappingValue(n, tok); v != nil {
+			return v, nil
+		}
+		return nil, fmt.Errorf("key %q not found", tok)
+	case yaml.SequenceNode:
+		i, err := index(tok, len(n.Content)-1)
+		if err != nil {
+			return nil, err
+		}
+		return n.Content[i], nil
+	}
+	return nil, fmt.Errorf("cannot look up %q in a scalar", tok)
+}
+
+// index parses the list index tok, which must be at most max.
+func index(tok string, max int) (int, error) {
+	i, err := strconv.Atoi(tok)
+	if err != nil || i < 0 || strconv.Itoa(i) != tok {
+		return 0, fmt.Errorf("%q is not a list index", tok)
+	}
+	if i > max {
+		return 0, fmt.Errorf("index %d is out of range", i)
+	}
+	return i, nil
+}
+
+// add adds value at the JSON pointer p in root, replacing a mapping value or
+// inserting a list item.
+func add(root *yaml.Node, p string, value *yaml.Node) error {
+	parent, tok, err := parentOf(root, p)
+	if err != nil {
+		return err
+	}
+	switch parent.Kind {
+	case yaml.MappingNode:
+		if j := mappingIndex(parent, tok); j >= 0 {
+			parent.Content[j+1] = value
+			return nil
+		}
+		parent.Content = append(parent.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: tok}, value)
+		return nil
+	case yaml.SequenceNode:
+		i := len(parent.Content)
+		if tok != "-" {
+			if i, err = index(tok, len(parent.Content)); err != nil {
+				return err
+			}
+		}
+		parent.Content = append(parent.Content[:i], append([]*yaml.Node{value}, parent.Content[i:]...)...)
+		return nil
+	}
+	return fmt.Errorf("cannot add %q to a scalar", tok)
+}
+
+// replace replaces the existing value at the JSON pointer p in root.
+func replace(root *yaml.Node, p string, value *yaml.Node) error {
+	parent, tok, err := parentOf(root, p)
+	if err != nil {
+		return err
+	}
+	switch parent.Kind {
+	case yaml.MappingNode:
+		j := mappingIndex(parent, tok)
+		if j < 0 {
+			return fmt.Errorf("key %q not found", tok)
+		}
+		parent.Content[j+1] = value
+		return nil
+	case yaml.SequenceNode:
+		i, err := index(tok, len(parent.Content)-1)
+		if err != nil {
+			return err
+		}
+		parent.Content[i] = value
+		return nil
+	}
+	return fmt.Errorf("cannot replace %q in a scalar", tok)
+}
+
+// remove removes and returns the value at the JSON pointer p in root.
+func remove(root *yaml.Node, p string) (*yaml.Node, error) {
+	parent, tok, err := parentOf(root, p)
+	if err != nil {
+		return nil, err
+	}
+	switch parent.Kind {
+	case yaml.MappingNode:
+		j := mappingIndex(parent, tok)
+		if j < 0 {
+			return nil, fmt.Errorf("key %q not found", tok)
+		}
+		value := parent.Content[j+1]
+		parent.Content = append(parent.Content[:j], parent.Content[j+2:]...)
+		return value, nil
+	case yaml.SequenceNode:
+		i, err := index(tok, len(parent.Content)-1)
+		if err != nil {
+			return nil, err
+		}
+		value := parent.Content[i]
+		parent.Content = append(parent.Content[:i], parent.Content[i+1:]...)
+		return value, nil
+	}
+	return nil, fmt.Errorf("cannot remove %q from a scalar", tok)
+}
+
+// mappingIndex returns the index in m.Content of key, or -1.
+func mappingIndex(m *yaml.Node, key string) int {
+	for i := 0; i+1 < len(m.Content); i += 2 {
+		if m.Content[i].Value == key {
+			return i
+		}
+	}
+	return -1
+}
+
+// isNull reports whether n is a YAML null.
+func isNull(n *yaml.Node) bool {
+	return n.Kind == yaml.ScalarNode && n.ShortTag() == "!!null"
+}
+
+// errorOutput returns an output message reporting msg, with a failing exit
+// code.
+func errorOutput(msg string) (OutputMessageRenderV1, uint32) {
+	return OutputMessageRenderV1{
+		RenderedFiles: make(map[string]string),
+		Errors:        []string{msg},
+	}, 1
+}
+
+// logf logs a debug message. main.go sends it to Helm when built as a
+// plugin; natively, e.g. in tests, it is discarded.
+var logf = func(format string, args ...interface{}) {}
+
+// main is empty: Helm calls the helm_plugin_main export defined in main.go.
+func main() {}
@@ -0,0 +1,343 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// rendered are the files rendered by an earlier plugin in the tests.
+var rendered = map[string]string{
+	"templates/deployment.yaml": `# Web server
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+  namespace: prod
+  labels:
+    app: web
+spec:
+  replicas: 1
+  template:
+    spec:
+      containers:
+        - name: web
+          image: nginx:1.24
+          env:
+            - name: MODE
+              value: prod
+            - name: DEBUG
+              value: "false"
+          ports:
+            - containerPort: 80
+        - name: sidecar
+          image: busybox
+`,
+	"templates/all.yaml": `apiVersion: v1
+kind: Service
+metadata:
+  name: web
+  labels:
+    app: web
+spec:
+  ports:
+    - port: 80
+      targetPort: 80
+---
+# Not patched: kept byte for byte
+apiVersion: v1
+kind:    ConfigMap
+metadata: {name: web-config, labels: {app: web}}
+data: {a: "1"}
+`,
+	"templates/NOTES.txt": "not: [yaml",
+}
+
+func TestRender(t *testing.T) {
+	file := func(name, data string) SourceFile {
+		return SourceFile{Name: name, Data: []byte(data)}
+	}
+	tests := []struct {
+		name    string
+		files   []SourceFile
+		patches []Patch
+		raw     string
+		want    map[string]string
+		// wantRemoved are the expected removedRenderedFiles, in order.
+		wantRemoved []string
+		wantErrors  []string
+	}{
+		{
+			name: "strategic merge by kind and name",
+			files: []SourceFile{file("patches/web.yaml", `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+  annotations:
+    team: platform
+spec:
+  replicas: 3
+  template:
+    spec:
+      containers:
+        - name: web
+          image: nginx:1.25
+          resources:
+            limits: {memory: 128Mi}
+          env:
+            - name: DEBUG
+              $patch: delete
+            - name: EXTRA
+              value: "yes"
+          ports:
+            - containerPort: 80
+              name: http
+        - name: metrics
+          image: exporter
+`)},
+			patches: []Patch{{Path: "patches/web.yaml"}},
+			want: map[string]string{"templates/deployment.yaml": `# Web server
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+  namespace: prod
+  labels:
+    app: web
+  annotations:
+    team: platform
+spec:
+  replicas: 3
+  template:
+    spec:
+      containers:
+        - name: web
+          image: nginx:1.25
+          env:
+            - name: MODE
+              value: prod
+            - name: EXTRA
+              value: "yes"
+          ports:
+            - containerPort: 80
+              name: http
+          resources:
+            limits:
+              memory: 128Mi
+        - name: sidecar
+          image: busybox
+        - name: metrics
+          image: exporter
+`},
+		},
+		{
+			name: "strategic merge with a target, null and replace",
+			patches: []Patch{{
+				Patch: `metadata:
+  labels:
+    app: null
+    tier: frontend
+spec:
+  ports:
+    - $patch: replace
+    - port: 443
+      targetPort: 8443
+`,
+				Target: &Target{Kind: "Serv.*", LabelSelector: "app=web,!tier"},
+			}},
+			want: map[string]string{"templates/all.yaml": `apiVersion: v1
+kind: Service
+metadata:
+  name: web
+  labels:
+    tier: frontend
+spec:
+  ports:
+    - port: 443
+      targetPort: 8443
+---
+# Not patched: kept byte for byte
+apiVersion: v1
+kind:    ConfigMap
+metadata: {name: web-config, labels: {app: web}}
+data: {a: "1"}
+`},
+		},
+		{
+			name: "JSON 6902 operations",
+			files: []SourceFile{file("patches/ops.json", `[
+  {"op": "test", "path": "/spec/replicas", "value": 1},
+  {"op": "replace", "path": "/spec/replicas", "value": 2},
+  {"op": "add", "path": "/metadata/annotations", "value": {}},
+  {"op": "add", "path": "/metadata/annotations/example.com~1owner", "value": "ops"},
+  {"op": "add", "path": "/spec/template/spec/containers/0/args", "value": ["-v"]},
+  {"op": "add", "path": "/spec/template/spec/containers/0/args/-", "value": "-x"},
+  {"op": "copy", "from": "/metadata/labels", "path": "/spec/template/metadata"},
+  {"op": "move", "from": "/spec/template/spec/containers/1", "path": "/spec/template/spec/containers/0"},
+  {"op": "remove", "path": "/spec/template/spec/containers/1/env"}
+]`)},
+			patches: []Patch{{Path: "patches/ops.json", Target: &Target{Group: "apps", Version: "v1", Kind: "Deployment", Name: "w.b", Namespace: "prod"}}},
+			want: map[string]string{"templates/deployment.yaml": `# Web server
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+  namespace: prod
+  labels:
+    app: web
+  annotations:
+    example.com/owner: "ops"
+spec:
+  replicas: 2
+  template:
+    spec:
+      containers:
+        - name: sidecar
+          image: busybox
+        - name: web
+          image: nginx:1.24
+          ports:
+            - containerPort: 80
+          args:
+            - "-v"
+            - "-x"
+    metadata:
+      app: web
+`},
+		},
+		{
+			name:    "delete an object",
+			patches: []Patch{{Patch: "kind: Service\nmetadata: {name: web}\n$patch: delete\n"}},
+			want: map[string]string{"templates/all.yaml": `# Not patched: kept byte for byte
+apiVersion: v1
+kind:    ConfigMap
+metadata: {name: web-config, labels: {app: web}}
+data: {a: "1"}
+`},
+		},
+		{
+			name: "delete every object of a file",
+			patches: []Patch{
+				{Patch: "$patch: delete", Target: &Target{Name: "web.*"}},
+			},
+			want:        map[string]string{},
+			wantRemoved: []string{"templates/all.yaml", "templates/deployment.yaml"},
+		},
+		{
+			name:    "unmatched patches change nothing",
+			patches: []Patch{{Patch: "kind: Deployment\nmetadata: {name: other}\nspec: {replicas: 2}\n"}, {Patch: "spec: {}", Target: &Target{Namespace: "staging"}}},
+			want:    map[string]string{},
+		},
+		{
+			name: "invalid patches",
+			patches: []Patch{
+				{Path: "patches/missing.yaml"},
+				{Path: "a.yaml", Patch: "a: 1"},
+				{},
+				{Patch: "- op: remove\n  path: /spec\n"},
+				{Patch: "spec: {replicas: 2}\n"},
+				{Patch: "spec: {}", Target: &Target{Name: "("}},
+				{Patch: "spec: {}", Target: &Target{LabelSelector: "app in (web)"}},
+				{Patch: "- op: jump\n  path: /a\n", Target: &Target{}},
+				{Patch: "- op: add\n  path: a\n  value: 1\n", Target: &Target{}},
+				{Patch: "just a string"},
+				{Patch: "kind: A\nmetadata: {name: &n a}\nspec: {x: *n}\n"},
+			},
+			wantErrors: []string{
+				"patches[0] (patches/missing.yaml): file not found in the chart",
+				"patches[1] (a.yaml): set either path or patch, not both",
+				"patches[2]: set path or patch",
+				"patches[3]: a JSON 6902 patch needs a target",
+				"patches[4]: a strategic merge patch needs kind and metadata.name, or a target",
+				"patches[5]: target: name: error parsing regexp",
+				`patches[6]: target: labelSelector: invalid term "app in (web)"`,
+				`patches[7]: operation 0: unknown op "jump"`,
+				`patches[8]: operation 0: path: "a" is not a JSON pointer`,
+				"patches[9]: a patch is a mapping",
+				"patches[10]: YAML aliases are not supported in patches",
+			},
+		},
+		{
+			name: "failing operations",
+			patches: []Patch{
+				{Patch: "- op: remove\n  path: /spec/missing\n", Target: &Target{Kind: "Deployment"}},
+				{Patch: "- op: test\n  path: /spec/replicas\n  value: 5\n", Target: &Target{Kind: "Deployment"}},
+				{Patch: "- op: add\n  path: /spec/template/spec/containers/7\n  value: {}\n", Target: &Target{Kind: "Deployment"}},
+				{Patch: "spec: {$retainKeys: [a]}", Target: &Target{Kind: "Service"}},
+				{Patch: "spec: {ports: [{targetPort: 1}]}", Target: &Target{Kind: "Service"}},
+				{Patch: "- {op: add, path: /x, value: [0]}\n" + strings.Repeat("- {op: copy, from: /x, path: /x/-}\n", 25), Target: &Target{Kind: "ConfigMap"}},
+			},
+			wantErrors: []string{
+				`patches[0]: Deployment/web in templates/deployment.yaml: operation 0 (remove /spec/missing): key "missing" not found`,
+				"patches[1]: Deployment/web in templates/deployment.yaml: operation 0 (test /spec/replicas): test failed",
+				"operation 0 (add /spec/template/spec/containers/7): index 7 is out of range",
+				"patches[3]: Service/web in templates/all.yaml: spec: unsupported directive $retainKeys",
+				"patches[4]: Service/web in templates/all.yaml: ports[0]: item has no port to merge it by",
+				"patches[5]: ConfigMap/web-config in templates/all.yaml: operation 19 (copy /x/-): the object would hold more than 1048576 values",
+			},
+		},
+		{
+			name: "no patches",
+			raw:  `{"renderedFiles": {"templates/a.yaml": "kind: A"}}`,
+			want: map[string]string{},
+		},
+		{
+			name:       "wrong types",
+			raw:        `{"renderedFiles": []}`,
+			wantErrors: []string{"failed to parse input"},
+		},
+		{
+			name:       "empty input",
+			raw:        ``,
+			wantErrors: []string{"no input provided"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			input := []byte(tt.raw)
+			if tt.patches != nil {
+				var err error
+				input, err = json.Marshal(InputMessageRenderV1{
+					Release:       ReleaseInfo{Name: "web", Namespace: "prod"},
+					Chart:         ChartInfo{Name: "demo", Version: "0.1.0"},
+					Files:         tt.files,
+					RenderedFiles: rendered,
+					Config:        PluginConfig{Patches: tt.patches},
+				})
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			output, code := render(input)
+			if (code != 0) != (len(tt.wantErrors) > 0) {
+				t.Errorf("exit code = %d, errors %q", code, output.Errors)
+			}
+			if tt.want != nil {
+				if len(output.RenderedFiles) != len(tt.want) {
+					t.Errorf("rendered %d files, want %d: %v", len(output.RenderedFiles), len(tt.want), output.RenderedFiles)
+				}
+				for name, want := range tt.want {
+					if got := output.RenderedFiles[name]; got != want {
+						t.Errorf("%s =\n%s\nwant\n%s", name, got, want)
+					}
+				}
+			}
+
+			if got := strings.Join(output.RemovedRenderedFiles, ","); got != strings.Join(tt.wantRemoved, ",") {
+				t.Errorf("removedRenderedFiles = %v, want %v", output.RemovedRenderedFiles, tt.wantRemoved)
+			}
+
+			errs := strings.Join(output.Errors, "\n")
+			if len(tt.wantErrors) == 0 && errs != "" {
+				t.Errorf("unexpected errors: %s", errs)
+			}
+			for _, want := range tt.wantErrors {
+				if !strings.Contains(errs, want) {
+					t.Errorf("errors %q do not contain %q", errs, want)
+				}
+			}
+		})
+	}
+}
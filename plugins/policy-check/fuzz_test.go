@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// chartSeeds returns the example chart inputs recorded by plugin-run in
+// plugin-run/testdata/seeds, one per chart, each passed to configure first
+// if it is not nil.
+func chartSeeds(tb testing.TB, configure func(*InputMessageRenderV1)) [][]byte {
+	files, err := filepath.Glob(filepath.Join("..", "..", "plugin-run", "testdata", "seeds", "*.json"))
+	if err != nil || len(files) == 0 {
+		tb.Fatalf("no seeds in plugin-run/testdata/seeds: %v", err)
+	}
+
+	var seeds [][]byte
+	for _, file := range files {
+		var input InputMessageRenderV1
+		data, err := os.ReadFile(file)
+		if err == nil {
+			err = json.Unmarshal(data, &input)
+		}
+		if err != nil {
+			tb.Fatalf("%s: %v", file, err)
+		}
+		if configure != nil {
+			configure(&input)
+		}
+
+		seed, err := json.Marshal(input)
+		if err != nil {
+			tb.Fatal(err)
+		}
+		seeds = append(seeds, seed)
+	}
+	return seeds
+}
+
+func FuzzRender(f *testing.F) {
+	// Policies checking every object and every Deployment
+	for _, seed := range chartSeeds(f, func(input *InputMessageRenderV1) {
+		input.Values = map[string]interface{}{
+			"policies": []interface{}{map[string]interface{}{"name": "named", "expression": `object.metadata.name != ""`}},
+		}
+		input.Config = PluginConfig{
+			Policies:  []Policy{noLatest, limitsRequired},
+			ValuesKey: "policies",
+		}
+	}) {
+		f.Add(seed)
+	}
+	f.Add([]byte(``))
+	f.Add([]byte(`{}`))
+	f.Add([]byte(`{"renderedFiles": {"templates/a.yaml": "kind: A\nmetadata: {name: a}\n1: [2020-01-01, {x: .5}]\n---\nkind: B\n"}, "files": [{"name": "p.yaml", "data": "LSB7bmFtZTogcCwgZXhwcmVzc2lvbjogJ29iamVjdC5raW5kLm1hdGNoZXMoIl5BIikgJiYgb2JqZWN0WyIxIl0uZXhpc3RzKHgsIHR5cGUoeCkgPT0gc3RyaW5nKSd9Cg=="}], "config": {"policyFiles": ["p.yaml"], "failOnViolation": true}}`))
+
+	f.Fuzz(func(t *testing.T, input []byte) {
+		output, code := render(input)
+
+		data, err := json.Marshal(output)
+		if err != nil || !json.Valid(data) {
+			t.Fatalf("output is not valid JSON: %v", err)
+		}
+		if (code != 0) != (len(output.Errors) > 0) {
+			t.Fatalf("exit code %d with errors %q", code, output.Errors)
+		}
+		if len(output.RenderedFiles) > 0 {
+			t.Fatalf("rendered %d files", len(output.RenderedFiles))
+		}
+	})
+}
@@ -0,0 +1,355 @@
+// Package main implements a render/v1 plugin that checks rendered manifests
+// against policies written as CEL expressions.
+//
+// The plugin runs after the plugins rendering a chart's templates and
+// receives the files they rendered as renderedFiles. Each policy, given in
+// its config, read from a chart file or supplied in the values, is a CEL
+// expression evaluated against every rendered Kubernetes object of the kinds
+// it matches, bound to the variable object, as in a Kubernetes
+// ValidatingAdmissionPolicy. An object violates the policy when the
+// expression is false or fails to evaluate. Violations are reported with the
+// file and kind/name of the object, as warnings or, with failOnViolation, as
+// errors failing the render. The plugin renders nothing itself, leaving the
+// rendered files unchanged.
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/common/types"
+	"github.com/google/cel-go/ext"
+	"github.com/scottrigby/ref-hip-chart-defined-plugins/renderv1"
+	"go.yaml.in/yaml/v3"
+)
+
+// costLimit bounds the work an expression does on a single object, so that a
+// policy cannot stall the render.
+const costLimit = 1_000_000
+
+// SourceFile represents a file in the chart.
+type SourceFile struct {
+	Name string `json:"name"`
+	Data []byte `json:"data"`
+}
+
+// InputMessageRenderV1 is the input message for render/v1 plugins.
+type InputMessageRenderV1 struct {
+	Values      map[string]interface{} `json:"values"`
+	Files       []SourceFile           `json:"files"`
+	SourceFiles []SourceFile           `json:"sourceFiles"`
+	// RenderedFiles are the files rendered by the plugins before this one.
+	RenderedFiles map[string]string `json:"renderedFiles"`
+	Config        PluginConfig      `json:"config"`
+}
+
+// PluginConfig is the chart's configuration for this plugin, validated by
+// Helm against the configSchema in plugin.yaml.
+type PluginConfig struct {
+	Policies []Policy `json:"policies"`
+	// PolicyFiles are chart files each holding a YAML list of policies.
+	PolicyFiles []string `json:"policyFiles"`
+	// ValuesKey is the top-level values key holding a list of policies, so
+	// that users can add their own.
+	ValuesKey       string `json:"valuesKey"`
+	FailOnViolation bool   `json:"failOnViolation"`
+}
+
+// Policy is a rule every rendered object of the kinds it matches must follow.
+type Policy struct {
+	Name string `json:"name" yaml:"name"`
+	// Expression is a CEL expression that is true when object follows the
+	// policy.
+	Expression string `json:"expression" yaml:"expression"`
+	// Message explains a violation. It defaults to the expression.
+	Message string `json:"message" yaml:"message"`
+	// Kinds are the kinds of the objects the policy applies to, all kinds
+	// when empty.
+	Kinds []string `json:"kinds" yaml:"kinds"`
+}
+
+// OutputMessageRenderV1 is the output message from render/v1 plugins.
+type OutputMessageRenderV1 struct {
+	RenderedFiles map[string]string `json:"renderedFiles"`
+	Errors        []string          `json:"errors,omitempty"`
+}
+
+// render checks the rendered files of a raw render/v1 input message against
+// the policies and returns the output message along with the exit code the
+// plugin returns to Helm.
+func render(inputBytes []byte) (OutputMessageRenderV1, uint32) {
+	if len(inputBytes) == 0 {
+		return errorOutput("no input provided")
+	}
+
+	var input InputMessageRenderV1
+	if err := json.Unmarshal(inputBytes, &input); err != nil {
+		return errorOutput(fmt.Sprintf("failed to parse input: %v", err))
+	}
+
+	policies, errs := loadPolicies(&input)
+	if errs != nil {
+		return OutputMessageRenderV1{RenderedFiles: make(map[string]string), Errors: errs}, 1
+	}
+	logf("Checking %d rendered files against %d policies", len(input.RenderedFiles), len(policies))
+	if len(policies) == 0 {
+		return OutputMessageRenderV1{RenderedFiles: make(map[string]string)}, 0
+	}
+
+	objects, errs := parseObjects(input.RenderedFiles)
+	if errs != nil {
+		return OutputMessageRenderV1{RenderedFiles: make(map[string]string), Errors: errs}, 1
+	}
+
+	var violations []string
+	for _, obj := range objects {
+		for _, p := range policies {
+			if msg, ok := p.check(obj); !ok {
+				violations = append(violations, fmt.Sprintf("%s: %s violates %s: %s", obj.file, obj.id, p.Name, msg))
+			}
+		}
+	}
+
+	if input.Config.FailOnViolation && violations != nil {
+		return OutputMessageRenderV1{RenderedFiles: make(map[string]string), Errors: violations}, 1
+	}
+	for _, v := range violations {
+		warnf("%s", v)
+	}
+	logf("%d objects checked, %d violations", len(objects), len(violations))
+	return OutputMessageRenderV1{RenderedFiles: make(map[string]string)}, 0
+}
+
+// policy is a Policy with its expression compiled.
+type policy struct {
+	Policy
+	program cel.Program
+}
+
+// loadPolicies returns the policies in the config, then those in each of the
+// policy files and then those in the values, compiled.
+func loadPolicies(input *InputMessageRenderV1) ([]*policy, []string) {
+	files := make(map[string][]byte, len(input.Files))
+	for _, f := range input.Files {
+		files[path.Clean(f.Name)] = f.Data
+	}
+
+	type source struct {
+		name     string
+		policies []Policy
+	}
+	sources := []source{{name: "config.policies", policies: input.Config.Policies}}
+	var errs []string
+	for _, name := range input.Config.PolicyFiles {
+		data, ok := files[path.Clean(name)]
+		if !ok {
+			errs = append(errs, fmt.Sprintf("%s: file not found in the chart", name))
+			continue
+		}
+		var list []Policy
+		if err := yaml.Unmarshal(data, &list); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: not a list of policies: %v", name, err))
+			continue
+		}
+		sources = append(sources, source{name: name, policies: list})
+	}
+	if key := input.Config.ValuesKey; key != "" && input.Values[key] != nil {
+		name := "values." + key
+		var list []Policy
+		data, err := json.Marshal(input.Values[key])
+		if err == nil {
+			err = json.Unmarshal(data, &list)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: not a list of policies: %v", name, err))
+		} else {
+			sources = append(sources, source{name: name, policies: list})
+		}
+	}
+	if errs != nil {
+		return nil, errs
+	}
+
+	env, err := cel.NewEnv(
+		cel.Variable("object", cel.DynType),
+		ext.Strings(),
+		ext.Lists(),
+		ext.Sets(),
+	)
+	if err != nil {
+		return nil, []string{fmt.Sprintf("failed to create the CEL environment: %v", err)}
+	}
+
+	var policies []*policy
+	for _, src := range sources {
+		for i, p := range src.policies {
+			where := fmt.Sprintf("%s[%d]", src.name, i)
+			if p.Name != "" {
+				where += " (" + p.Name + ")"
+			}
+			compiled, err := compile(env, p)
+			if err != nil {
+				errs = append(errs, fmt.Sprintf("%s: %v", where, err))
+				continue
+			}
+			policies = append(policies, compiled)
+		}
+	}
+	return policies, errs
+}
+
+// compile checks p and compiles its expression.
+func compile(env *cel.Env, p Policy) (*policy, error) {
+	switch {
+	case p.Name == "":
+		return nil, errors.New("name is required")
+	case strings.TrimSpace(p.Expression) == "":
+		return nil, errors.New("expression is required")
+	}
+
+	ast, iss := env.Compile(p.Expression)
+	if err := iss.Err(); err != nil {
+		return nil, fmt.Errorf("expression: %v", err)
+	}
+	if t := ast.OutputType(); !t.IsExactType(cel.BoolType) && !t.IsExactType(cel.DynType) {
+		return nil, fmt.Errorf("expression returns %s, want bool", t)
+	}
+	program, err := env.Program(ast, cel.CostLimit(costLimit))
+	if err != nil {
+		return nil, fmt.Errorf("expression: %v", err)
+	}
+	return &policy{Policy: p, program: program}, nil
+}
+
+// check evaluates p against obj, returning why obj violates p if it does.
+func (p *policy) check(obj *object) (string, bool) {
+	if len(p.Kinds) > 0 && !contains(p.Kinds, obj.kind) {
+		return "", true
+	}
+
+	out, _, err := p.program.Eval(map[string]any{"object": obj.value})
+	if err != nil {
+		return fmt.Sprintf("failed to evaluate: %v", err), false
+	}
+	pass, ok := out.(types.Bool)
+	if !ok {
+		return fmt.Sprintf("expression returned %s, want bool", out.Type()), false
+	}
+	if pass {
+		return "", true
+	}
+	if p.Message != "" {
+		return p.Message, false
+	}
+	return "failed expression: " + strings.TrimSpace(p.Expression), false
+}
+
+// object is a Kubernetes object in a rendered file.
+type object struct {
+	file string
+	kind string
+	// id is the object's kind and name, for messages.
+	id    string
+	value map[string]any
+}
+
+// parseObjects splits the rendered YAML files into documents, in file name
+// order, and returns the Kubernetes objects among them.
+func parseObjects(rendered map[string]string) ([]*object, []string) {
+	names := make([]string, 0, len(rendered))
+	for name := range rendered {
+		switch path.Ext(name) {
+		case ".yaml", ".yml", ".json":
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	var objects []*object
+	var errs []string
+	for _, name := range names {
+		for i, part := range renderv1.SplitDocuments(name, rendered[name]) {
+			var doc any
+			if err := yaml.Unmarshal([]byte(part.Content), &doc); err != nil {
+				errs = append(errs, fmt.Sprintf("%s: document %d is not valid YAML: %v", name, i, err))
+				continue
+			}
+			m, ok := normalize(doc).(map[string]any)
+			if !ok {
+				continue
+			}
+			kind, _ := m["kind"].(string)
+			if kind == "" {
+				continue
+			}
+			objName := ""
+			if meta, ok := m["metadata"].(map[string]any); ok {
+				objName, _ = meta["name"].(string)
+			}
+			objects = append(objects, &object{file: name, kind: kind, id: kind + "/" + objName, value: m})
+		}
+	}
+	return objects, errs
+}
+
+// normalize returns v, as decoded from YAML, with the types CEL maps to its
+// own: maps keyed by strings and timestamps as strings, as they are in JSON.
+func normalize(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		for k, e := range v {
+			v[k] = normalize(e)
+		}
+		return v
+	case map[any]any:
+		m := make(map[string]any, len(v))
+		for k, e := range v {
+			m[fmt.Sprint(k)] = normalize(e)
+		}
+		return m
+	case []any:
+		for i, e := range v {
+			v[i] = normalize(e)
+		}
+		return v
+	case time.Time:
+		return v.Format(time.RFC3339Nano)
+	default:
+		return v
+	}
+}
+
+func contains(list []string, s string) bool {
+	for _, e := range list {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
+
+// errorOutput returns an output message reporting msg, with a failing exit
+// code.
+func errorOutput(msg string) (OutputMessageRenderV1, uint32) {
+	return OutputMessageRenderV1{
+		RenderedFiles: make(map[string]string),
+		Errors:        []string{msg},
+	}, 1
+}
+
+// logf logs a debug message. main.go sends it to Helm when built as a
+// plugin; natively, e.g. in tests, it is discarded.
+var logf = func(format string, args ...interface{}) {}
+
+// warnf logs a warning, such as a violation that does not fail the render.
+// main.go sends it to Helm when built as a plugin; natively it is discarded.
+var warnf = func(format string, args ...interface{}) {}
+
+// main is empty: Helm calls the helm_plugin_main export defined in main.go.
+func main() {}
@@ -0,0 +1,283 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+const deployment = `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+spec:
+  replicas: 2
+  template:
+    spec:
+      containers:
+        - name: web
+          image: nginx:1.27
+          resources:
+            limits:
+              memory: 128Mi
+        - name: sidecar
+          image: busybox:latest
+`
+
+const service = `apiVersion: v1
+kind: Service
+metadata:
+  name: web
+spec:
+  ports:
+    - port: 80
+`
+
+// noLatest and limitsRequired are the policies the examples in the request
+// describe.
+var (
+	noLatest = Policy{
+		Name:       "no-latest-tag",
+		Kinds:      []string{"Deployment"},
+		Expression: `object.spec.template.spec.containers.all(c, c.image.contains(':') && !c.image.endsWith(':latest'))`,
+		Message:    "images must be pinned to a tag other than latest",
+	}
+	limitsRequired = Policy{
+		Name:       "limits-required",
+		Kinds:      []string{"Deployment"},
+		Expression: `object.spec.template.spec.containers.all(c, has(c.resources) && has(c.resources.limits))`,
+	}
+)
+
+func TestRender(t *testing.T) {
+	rendered := map[string]string{
+		"templates/deployment.yaml": deployment,
+		"templates/service.yaml":    service,
+		"templates/NOTES.txt":       "kind: Deployment",
+	}
+
+	tests := []struct {
+		name     string
+		rendered map[string]string
+		files    []SourceFile
+		values   map[string]interface{}
+		config   PluginConfig
+		raw      string
+		// wantWarnings are the expected warnings, in order.
+		wantWarnings []string
+		// wantViolations are the expected errors, in order, when the render
+		// fails on violations.
+		wantViolations []string
+		wantErrors     []string
+	}{
+		{
+			name:     "violations are warnings",
+			rendered: rendered,
+			config:   PluginConfig{Policies: []Policy{noLatest, limitsRequired}},
+			wantWarnings: []string{
+				"templates/deployment.yaml: Deployment/web violates no-latest-tag: images must be pinned to a tag other than latest",
+				"templates/deployment.yaml: Deployment/web violates limits-required: failed expression: object.spec.template.spec.containers.all(c, has(c.resources) && has(c.resources.limits))",
+			},
+		},
+		{
+			name:     "failOnViolation",
+			rendered: rendered,
+			config:   PluginConfig{Policies: []Policy{noLatest}, FailOnViolation: true},
+			wantViolations: []string{
+				"templates/deployment.yaml: Deployment/web violates no-latest-tag: images must be pinned to a tag other than latest",
+			},
+		},
+		{
+			name:     "no violations",
+			rendered: rendered,
+			config: PluginConfig{
+				Policies:        []Policy{{Name: "named", Expression: `object.metadata.name != ""`}},
+				FailOnViolation: true,
+			},
+		},
+		{
+			name:     "every kind by default",
+			rendered: map[string]string{"templates/all.yaml": deployment + "---\n" + service + "---\n# comment only\n"},
+			config:   PluginConfig{Policies: []Policy{{Name: "deployments-only", Expression: `object.kind == "Deployment"`}}},
+			wantWarnings: []string{
+				`templates/all.yaml: Service/web violates deployments-only: failed expression: object.kind == "Deployment"`,
+			},
+		},
+		{
+			name:     "evaluation error is a violation",
+			rendered: rendered,
+			config:   PluginConfig{Policies: []Policy{{Name: "has-selector", Kinds: []string{"Service"}, Expression: `size(object.spec.selector) > 0`}}},
+			wantWarnings: []string{
+				"templates/service.yaml: Service/web violates has-selector: failed to evaluate: no such key: selector",
+			},
+		},
+		{
+			name:     "numbers",
+			rendered: rendered,
+			config: PluginConfig{Policies: []Policy{
+				{Name: "int", Kinds: []string{"Deployment"}, Expression: `object.spec.replicas >= 2 && object.spec.replicas == 2`},
+				{Name: "double", Kinds: []string{"Deployment"}, Expression: `object.spec.replicas < 1.5`},
+			}},
+			wantWarnings: []string{
+				"templates/deployment.yaml: Deployment/web violates double: failed expression: object.spec.replicas < 1.5",
+			},
+		},
+		{
+			name:     "policy files and values",
+			rendered: rendered,
+			files: []SourceFile{{Name: "policies/services.yaml", Data: []byte(`
+- name: cluster-ip
+  kinds: [Service]
+  expression: has(object.spec.type) && object.spec.type == "ClusterIP"
+  message: services must be ClusterIP
+`)}},
+			values: map[string]interface{}{
+				"policies": []interface{}{
+					map[string]interface{}{"name": "team-label", "expression": `has(object.metadata.labels) && "team" in object.metadata.labels`},
+				},
+			},
+			config: PluginConfig{
+				PolicyFiles:     []string{"./policies/services.yaml"},
+				ValuesKey:       "policies",
+				FailOnViolation: true,
+			},
+			wantViolations: []string{
+				`templates/deployment.yaml: Deployment/web violates team-label: failed expression: has(object.metadata.labels) && "team" in object.metadata.labels`,
+				"templates/service.yaml: Service/web violates cluster-ip: services must be ClusterIP",
+				`templates/service.yaml: Service/web violates team-label: failed expression: has(object.metadata.labels) && "team" in object.metadata.labels`,
+			},
+		},
+		{
+			name:     "values key unset in values",
+			rendered: rendered,
+			config:   PluginConfig{ValuesKey: "policies", FailOnViolation: true},
+		},
+		{
+			name:       "policy file missing",
+			rendered:   rendered,
+			config:     PluginConfig{PolicyFiles: []string{"policies/missing.yaml"}},
+			wantErrors: []string{"policies/missing.yaml: file not found in the chart"},
+		},
+		{
+			name:       "policy file not a list",
+			rendered:   rendered,
+			files:      []SourceFile{{Name: "policies.yaml", Data: []byte("name: one\n")}},
+			config:     PluginConfig{PolicyFiles: []string{"policies.yaml"}},
+			wantErrors: []string{"policies.yaml: not a list of policies"},
+		},
+		{
+			name:       "values not a list",
+			rendered:   rendered,
+			values:     map[string]interface{}{"policies": "none"},
+			config:     PluginConfig{ValuesKey: "policies"},
+			wantErrors: []string{"values.policies: not a list of policies"},
+		},
+		{
+			name:     "invalid policies",
+			rendered: rendered,
+			config: PluginConfig{Policies: []Policy{
+				{Expression: "true"},
+				{Name: "empty"},
+				{Name: "syntax", Expression: "object.kind =="},
+				{Name: "string", Expression: `"yes"`},
+				{Name: "undeclared", Expression: "obj.kind == 'Pod'"},
+			}},
+			wantErrors: []string{
+				"config.policies[0]: name is required",
+				"config.policies[1] (empty): expression is required",
+				"config.policies[2] (syntax): expression: ERROR",
+				"config.policies[3] (string): expression returns string, want bool",
+				"config.policies[4] (undeclared): expression: ERROR: <input>:1:1: undeclared reference to 'obj'",
+			},
+		},
+		{
+			name:     "non-bool result",
+			rendered: rendered,
+			config:   PluginConfig{Policies: []Policy{{Name: "dyn", Kinds: []string{"Service"}, Expression: "object.spec.ports[0].port"}}},
+			wantWarnings: []string{
+				"templates/service.yaml: Service/web violates dyn: expression returned int, want bool",
+			},
+		},
+		{
+			name:     "cost limit",
+			rendered: map[string]string{"templates/list.yaml": "kind: List\nmetadata: {name: big}\nitems: [" + strings.Repeat("1,", 2000) + "1]\n"},
+			config:   PluginConfig{Policies: []Policy{{Name: "expensive", Expression: "object.items.all(a, object.items.all(b, a == b))"}}},
+			wantWarnings: []string{
+				"templates/list.yaml: List/big violates expensive: failed to evaluate: operation cancelled: actual cost limit exceeded",
+			},
+		},
+		{
+			name:       "invalid rendered YAML",
+			rendered:   map[string]string{"templates/bad.yaml": "kind: [\n"},
+			config:     PluginConfig{Policies: []Policy{noLatest}},
+			wantErrors: []string{"templates/bad.yaml: document 0 is not valid YAML"},
+		},
+		{
+			name:     "no policies",
+			rendered: map[string]string{"templates/bad.yaml": "kind: [\n"},
+		},
+		{
+			name:       "wrong types",
+			raw:        `{"config": {"policies": "none"}}`,
+			wantErrors: []string{"failed to parse input"},
+		},
+		{
+			name:       "empty input",
+			raw:        ``,
+			wantErrors: []string{"no input provided"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			input := []byte(tt.raw)
+			if tt.rendered != nil {
+				var err error
+				input, err = json.Marshal(InputMessageRenderV1{
+					Values:        tt.values,
+					Files:         tt.files,
+					RenderedFiles: tt.rendered,
+					Config:        tt.config,
+				})
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			var warnings []string
+			warnf = func(format string, args ...interface{}) {
+				warnings = append(warnings, fmt.Sprintf(format, args...))
+			}
+			defer func() { warnf = func(string, ...interface{}) {} }()
+
+			output, code := render(input)
+			wantErrors := append(tt.wantViolations, tt.wantErrors...)
+			if (code != 0) != (len(wantErrors) > 0) {
+				t.Errorf("exit code = %d, errors %q", code, output.Errors)
+			}
+			if output.RenderedFiles == nil || len(output.RenderedFiles) > 0 {
+				t.Errorf("renderedFiles = %v, want an empty map", output.RenderedFiles)
+			}
+
+			if got, want := strings.Join(warnings, "\n"), strings.Join(tt.wantWarnings, "\n"); got != want {
+				t.Errorf("warnings:\n%s\nwant:\n%s", got, want)
+			}
+			if tt.wantViolations != nil {
+				if got, want := strings.Join(output.Errors, "\n"), strings.Join(tt.wantViolations, "\n"); got != want {
+					t.Errorf("errors:\n%s\nwant:\n%s", got, want)
+				}
+			}
+
+			errs := strings.Join(output.Errors, "\n")
+			if len(wantErrors) == 0 && errs != "" {
+				t.Errorf("unexpected errors: %s", errs)
+			}
+			for _, want := range tt.wantErrors {
+				if !strings.Contains(errs, want) {
+					t.Errorf("errors %q do not contain %q", errs, want)
+				}
+			}
+		})
+	}
+}
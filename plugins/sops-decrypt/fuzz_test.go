@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// chartSeeds returns the example chart inputs recorded by plugin-run in
+// plugin-run/testdata/seeds, one per chart, each passed to configure first
+// if it is not nil.
+func chartSeeds(tb testing.TB, configure func(*InputMessageRenderV1)) [][]byte {
+	files, err := filepath.Glob(filepath.Join("..", "..", "plugin-run", "testdata", "seeds", "*.json"))
+	if err != nil || len(files) == 0 {
+		tb.Fatalf("no seeds in plugin-run/testdata/seeds: %v", err)
+	}
+
+	var seeds [][]byte
+	for _, file := range files {
+		var input InputMessageRenderV1
+		data, err := os.ReadFile(file)
+		if err == nil {
+			err = json.Unmarshal(data, &input)
+		}
+		if err != nil {
+			tb.Fatalf("%s: %v", file, err)
+		}
+		if configure != nil {
+			configure(&input)
+		}
+
+		seed, err := json.Marshal(input)
+		if err != nil {
+			tb.Fatal(err)
+		}
+		seeds = append(seeds, seed)
+	}
+	return seeds
+}
+
+func FuzzRender(f *testing.F) {
+	keys, err := os.ReadFile(filepath.Join("..", "..", "plugin-run", "testdata", "age-key.txt"))
+	if err != nil {
+		f.Fatal(err)
+	}
+	hostConfig = func(key string) (string, bool) { return string(keys), key == ageKeyConfig }
+	defer func() { hostConfig = func(string) (string, bool) { return "", false } }()
+
+	// Every chart file listed both as a Secret and as values
+	for _, seed := range chartSeeds(f, func(input *InputMessageRenderV1) {
+		input.Values = map[string]interface{}{"fuzz": true}
+		for _, file := range input.Files {
+			input.Config.Secrets = append(input.Config.Secrets, SecretFile{File: file.Name})
+			input.Config.Values = append(input.Config.Values, ValuesFile{File: file.Name, Key: "fuzz"})
+		}
+	}) {
+		f.Add(seed)
+	}
+	f.Add([]byte(``))
+	f.Add([]byte(`{}`))
+	f.Add([]byte(`{"files": [{"name": "a", "data": "YTogRU5DW0FFUzI1Nl9HQ00sZGF0YTosaXY6LHRhZzosdHlwZTpzdHJdCnNvcHM6IHttYWM6IHgsIGxhc3Rtb2RpZmllZDogeCwgYWdlOiBbe2VuYzogeH1dfQo="}], "config": {"secrets": [{"file": "a"}], "values": [{"file": "a"}]}}`))
+
+	f.Fuzz(func(t *testing.T, input []byte) {
+		output, code := render(input)
+
+		data, err := json.Marshal(output)
+		if err != nil || !json.Valid(data) {
+			t.Fatalf("output is not valid JSON: %v", err)
+		}
+		if (code != 0) != (len(output.Errors) > 0) {
+			t.Fatalf("exit code %d with errors %q", code, output.Errors)
+		}
+		if bytes.Contains(data, []byte("AGE-SECRET-KEY")) {
+			t.Fatalf("output discloses the age identity: %s", data)
+		}
+	})
+}
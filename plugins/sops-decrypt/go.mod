@@ -0,0 +1,14 @@
+module github.com/scottrigby/ref-hip-chart-defined-plugins/plugins/sops-decrypt
+
+go 1.24.3
+
+require (
+	filippo.io/age v1.2.1
+	github.com/extism/go-pdk v1.1.3
+	go.yaml.in/yaml/v3 v3.0.4
+)
+
+require (
+	golang.org/x/crypto v0.24.0 // indirect
+	golang.org/x/sys v0.21.0 // indirect
+)
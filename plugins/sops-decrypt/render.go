@@ -0,0 +1,700 @@
+// Package main implements a render/v1 plugin that decrypts chart files
+// encrypted with SOPS.
+//
+// Secrets cannot be stored in values.yaml, so a chart ships them instead as
+// YAML or JSON files encrypted by SOPS to one or more age recipients, and
+// lists them in the plugin's config. The plugin decrypts each file's data key
+// with the age identities the host passes as the sops_age_key host config
+// value, never through the chart or the input message, checks the file's
+// MAC, and decrypts its values. A file is then either rendered as a Secret,
+// one data key per top-level key, or merged into the values, which the plugin
+// returns as modifiedValues for the plugins after it. The plaintext only ever
+// exists in memory and in the output message: the plugin has no access to the
+// filesystem, and its log messages and errors name keys, never values.
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"filippo.io/age"
+	"filippo.io/age/armor"
+	"go.yaml.in/yaml/v3"
+)
+
+// ReleaseInfo contains release metadata passed to render plugins.
+type ReleaseInfo struct {
+	Name      string `json:"name"`
+	Namespace string `json:"namespace"`
+	Revision  int    `json:"revision"`
+	IsInstall bool   `json:"isInstall"`
+	IsUpgrade bool   `json:"isUpgrade"`
+	Service   string `json:"service"`
+}
+
+// SourceFile represents a file in the chart.
+type SourceFile struct {
+	Name string `json:"name"`
+	Data []byte `json:"data"`
+}
+
+// InputMessageRenderV1 is the input message for render/v1 plugins.
+type InputMessageRenderV1 struct {
+	Release ReleaseInfo            `json:"release"`
+	Values  map[string]interface{} `json:"values"`
+	// Files are the chart's non-template files, holding the encrypted files.
+	Files       []SourceFile `json:"files"`
+	SourceFiles []SourceFile `json:"sourceFiles"`
+	// RenderedFiles are the files rendered by the plugins before this one.
+	RenderedFiles map[string]string `json:"renderedFiles"`
+	Config        PluginConfig      `json:"config"`
+}
+
+// PluginConfig is the chart's configuration for this plugin, validated by
+// Helm against the configSchema in plugin.yaml.
+type PluginConfig struct {
+	Secrets []SecretFile `json:"secrets"`
+	Values  []ValuesFile `json:"values"`
+}
+
+// SecretFile is an encrypted file rendered as a Secret.
+type SecretFile struct {
+	File string `json:"file"`
+	// Name is the Secret's name, by default the release name followed by the
+	// file name up to its first dot.
+	Name string `json:"name"`
+	// Type is the Secret's type, by default Opaque.
+	Type string `json:"type"`
+}
+
+// ValuesFile is an encrypted file merged into the values.
+type ValuesFile struct {
+	File string `json:"file"`
+	// Key is the top-level values key the file is merged under. Empty merges
+	// it into the values themselves.
+	Key string `json:"key"`
+}
+
+// OutputMessageRenderV1 is the output message from render/v1 plugins.
+type OutputMessageRenderV1 struct {
+	RenderedFiles map[string]string `json:"renderedFiles"`
+	// ModifiedValues replace the values of the plugins after this one.
+	ModifiedValues map[string]interface{} `json:"modifiedValues,omitempty"`
+	Errors         []string               `json:"errors,omitempty"`
+}
+
+// ageKeyConfig is the host config value holding the age identities, in the
+// format of an age keys file, as in SOPS_AGE_KEY.
+const ageKeyConfig = "sops_age_key"
+
+// hostConfig returns a value the host passes the plugin outside the input
+// message. main.go reads it from the Extism plugin config when built as a
+// plugin; natively, e.g. in tests, there is none.
+var hostConfig = func(key string) (string, bool) { return "", false }
+
+// keyPattern matches valid Secret data keys.
+var keyPattern = regexp.MustCompile(`^[-._a-zA-Z0-9]+$`)
+
+// namePattern matches valid object names (DNS subdomains).
+var namePattern = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?(\.[a-z0-9]([-a-z0-9]*[a-z0-9])?)*$`)
+
+// render decrypts the files listed in the config of a raw render/v1 input
+// message and returns the output message along with the exit code the plugin
+// returns to Helm.
+func render(inputBytes []byte) (OutputMessageRenderV1, uint32) {
+	if len(inputBytes) == 0 {
+		return errorOutput("no input provided")
+	}
+
+	var input InputMessageRenderV1
+	if err := json.Unmarshal(inputBytes, &input); err != nil {
+		return errorOutput(fmt.Sprintf("failed to parse input: %v", err))
+	}
+
+	output := OutputMessageRenderV1{RenderedFiles: make(map[string]string)}
+	if len(input.Config.Secrets) == 0 && len(input.Config.Values) == 0 {
+		logf("No files to decrypt")
+		return output, 0
+	}
+
+	identities, err := loadIdentities()
+	if err != nil {
+		return errorOutput(err.Error())
+	}
+
+	// decrypted holds each file decrypted so far, so that a file listed
+	// twice is decrypted once.
+	decrypted := make(map[string]*yaml.Node)
+	decrypt := func(name string) (*yaml.Node, error) {
+		name = path.Clean(name)
+		if doc, ok := decrypted[name]; ok {
+			return doc, nil
+		}
+		data, ok := findFile(input.Files, name)
+		if !ok {
+			return nil, errors.New("file not found in the chart")
+		}
+		doc, err := decryptFile(data, identities, path.Ext(name) == ".json")
+		if err != nil {
+			return nil, err
+		}
+		logf("Decrypted %s", name)
+		decrypted[name] = doc
+		return doc, nil
+	}
+
+	names := make(map[string]string)
+	for _, s := range input.Config.Secrets {
+		doc, err := decrypt(s.File)
+		if err != nil {
+			output.Errors = append(output.Errors, fmt.Sprintf("%s: %v", s.File, err))
+			continue
+		}
+		name := s.Name
+		if name == "" {
+			name = input.Release.Name + "-" + strings.SplitN(path.Base(s.File), ".", 2)[0]
+		}
+		if other, ok := names[name]; ok {
+			output.Errors = append(output.Errors, fmt.Sprintf("%s: Secret %s is also rendered from %s", s.File, name, other))
+			continue
+		}
+		names[name] = s.File
+
+		content, err := renderSecret(name, input.Release.Namespace, s.Type, doc)
+		if err != nil {
+			output.Errors = append(output.Errors, fmt.Sprintf("%s: Secret %s: %v", s.File, name, err))
+			continue
+		}
+		output.RenderedFiles[fmt.Sprintf("templates/decrypted/secret-%s.yaml", name)] = content
+		logf("Rendered %s as Secret %s", s.File, name)
+	}
+
+	if len(input.Config.Values) > 0 {
+		values := input.Values
+		if values == nil {
+			values = make(map[string]interface{})
+		}
+		for _, v := range input.Config.Values {
+			doc, err := decrypt(v.File)
+			if err != nil {
+				output.Errors = append(output.Errors, fmt.Sprintf("%s: %v", v.File, err))
+				continue
+			}
+			var decoded map[string]interface{}
+			if err := doc.Decode(&decoded); err != nil {
+				output.Errors = append(output.Errors, fmt.Sprintf("%s: %v", v.File, err))
+				continue
+			}
+			delete(decoded, "sops")
+			if v.Key == "" {
+				values = mergeValues(values, decoded)
+			} else {
+				nested, _ := values[v.Key].(map[string]interface{})
+				values[v.Key] = mergeValues(nested, decoded)
+			}
+			logf("Merged %s into the values", v.File)
+		}
+		output.ModifiedValues = values
+	}
+
+	if len(output.Errors) > 0 {
+		return OutputMessageRenderV1{RenderedFiles: make(map[string]string), Errors: output.Errors}, 1
+	}
+	return output, 0
+}
+
+// loadIdentities parses the age identities the host passes in ageKeyConfig.
+// Errors never quote the identities.
+func loadIdentities() ([]age.Identity, error) {
+	keys, ok := hostConfig(ageKeyConfig)
+	if !ok || strings.TrimSpace(keys) == "" {
+		return nil, fmt.Errorf("no age identities: the host passes none in its %s config", ageKeyConfig)
+	}
+	identities, err := age.ParseIdentities(strings.NewReader(keys))
+	if err != nil {
+		return nil, fmt.Errorf("the host's %s config does not hold valid age identities", ageKeyConfig)
+	}
+	return identities, nil
+}
+
+func findFile(files []SourceFile, name string) ([]byte, bool) {
+	for _, f := range files {
+		if path.Clean(f.Name) == name {
+			return f.Data, true
+		}
+	}
+	return nil, false
+}
+
+// metadata is the sops key of an encrypted file: how its data key is
+// encrypted, its MAC, and which values are encrypted.
+type metadata struct {
+	Age []struct {
+		Recipient string `yaml:"recipient"`
+		Enc       string `yaml:"enc"`
+	} `yaml:"age"`
+	KeyGroups               []interface{} `yaml:"key_groups"`
+	LastModified            string        `yaml:"lastmodified"`
+	MAC                     string        `yaml:"mac"`
+	UnencryptedSuffix       string        `yaml:"unencrypted_suffix"`
+	EncryptedSuffix         string        `yaml:"encrypted_suffix"`
+	UnencryptedRegex        string        `yaml:"unencrypted_regex"`
+	EncryptedRegex          string        `yaml:"encrypted_regex"`
+	UnencryptedCommentRegex string        `yaml:"unencrypted_comment_regex"`
+	EncryptedCommentRegex   string        `yaml:"encrypted_comment_regex"`
+	MACOnlyEncrypted        bool          `yaml:"mac_only_encrypted"`
+}
+
+// macOnlyEncryptedInitialization starts the MAC of files with
+// mac_only_encrypted set, as SOPS does, so that it always differs from the
+// MAC over all values.
+var macOnlyEncryptedInitialization = []byte{0x8a, 0x3f, 0xd2, 0xad, 0x54, 0xce, 0x66, 0x52, 0x7b, 0x10, 0x34, 0xf3, 0xd1, 0x47, 0xbe, 0xb, 0xb, 0x97, 0x5b, 0x3b, 0xf4, 0x4f, 0x72, 0xc6, 0xfd, 0xad, 0xec, 0x81, 0x76, 0xf2, 0x7d, 0x69}
+
+// decryptFile decrypts a SOPS-encrypted YAML or JSON file and returns its
+// document mapping with every encrypted value replaced by its plaintext. The
+// sops key is left in place. Numbers in JSON files are floats to SOPS, which
+// the MAC depends on.
+func decryptFile(data []byte, identities []age.Identity, isJSON bool) (*yaml.Node, error) {
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	var doc yaml.Node
+	if err := dec.Decode(&doc); err != nil {
+		if err == io.EOF {
+			return nil, errors.New("not encrypted by SOPS: no sops metadata")
+		}
+		return nil, err
+	}
+	var extra yaml.Node
+	if err := dec.Decode(&extra); err != io.EOF {
+		return nil, errors.New("files with several YAML documents are not supported")
+	}
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return nil, errors.New("not encrypted by SOPS: not a mapping")
+	}
+
+	var meta *metadata
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		if root.Content[i].Value == "sops" {
+			meta = new(metadata)
+			if err := root.Content[i+1].Decode(meta); err != nil {
+				return nil, fmt.Errorf("invalid sops metadata: %v", err)
+			}
+		}
+	}
+	if meta == nil {
+		return nil, errors.New("not encrypted by SOPS: no sops metadata")
+	}
+
+	d, err := newDecrypter(meta, identities, isJSON)
+	if err != nil {
+		return nil, err
+	}
+	if err := d.walk(root, nil); err != nil {
+		return nil, err
+	}
+	if err := d.verify(); err != nil {
+		return nil, err
+	}
+	return root, nil
+}
+
+// decrypter decrypts the values of a file and computes its MAC the way
+// SOPS does, walking the tree in order.
+type decrypter struct {
+	meta   *metadata
+	key    []byte
+	isJSON bool
+	hash   hash.Hash
+	// unencryptedRegex and encryptedRegex are the compiled metadata
+	// expressions, or nil.
+	unencryptedRegex *regexp.Regexp
+	encryptedRegex   *regexp.Regexp
+}
+
+func newDecrypter(meta *metadata, identities []age.Identity, isJSON bool) (*decrypter, error) {
+	switch {
+	case len(meta.KeyGroups) > 0:
+		return nil, errors.New("key_groups are not supported")
+	case meta.UnencryptedCommentRegex != "" || meta.EncryptedCommentRegex != "":
+		return nil, errors.New("unencrypted_comment_regex and encrypted_comment_regex are not supported")
+	case meta.MAC == "":
+		return nil, errors.New("invalid sops metadata: no mac")
+	}
+
+	d := &decrypter{meta: meta, isJSON: isJSON, hash: sha512.New()}
+	if meta.MACOnlyEncrypted {
+		d.hash.Write(macOnlyEncryptedInitialization)
+	}
+	var err error
+	if d.unencryptedRegex, err = compileRegex(meta.UnencryptedRegex); err != nil {
+		return nil, fmt.Errorf("invalid sops metadata: unencrypted_regex: %v", err)
+	}
+	if d.encryptedRegex, err = compileRegex(meta.EncryptedRegex); err != nil {
+		return nil, fmt.Errorf("invalid sops metadata: encrypted_regex: %v", err)
+	}
+	if d.key, err = dataKey(meta, identities); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+func compileRegex(expr string) (*regexp.Regexp, error) {
+	if expr == "" {
+		return nil, nil
+	}
+	return regexp.Compile(expr)
+}
+
+// dataKey decrypts the file's data key with the first age recipient one of
+// identities matches.
+func dataKey(meta *metadata, identities []age.Identity) ([]byte, error) {
+	if len(meta.Age) == 0 {
+		return nil, errors.New("the data key is not encrypted to any age recipient")
+	}
+	recipients := make([]string, 0, len(meta.Age))
+	for _, entry := range meta.Age {
+		r, err := age.Decrypt(armor.NewReader(strings.NewReader(entry.Enc)), identities...)
+		if err != nil {
+			var noMatch *age.NoIdentityMatchError
+			if errors.As(err, &noMatch) {
+				recipients = append(recipients, entry.Recipient)
+				continue
+			}
+			return nil, fmt.Errorf("age recipient %s: %v", entry.Recipient, err)
+		}
+		key, err := io.ReadAll(r)
+		if err != nil {
+			return nil, fmt.Errorf("age recipient %s: %v", entry.Recipient, err)
+		}
+		if len(key) != 32 {
+			return nil, fmt.Errorf("age recipient %s: the data key is %d bytes, want 32", entry.Recipient, len(key))
+		}
+		return key, nil
+	}
+	return nil, fmt.Errorf("no age identity of the host matches the recipients %s", strings.Join(recipients, ", "))
+}
+
+// walk decrypts the values under node, found at keys. Sequence items
+// share their parent's path, and the top-level sops key is skipped.
+func (d *decrypter) walk(node *yaml.Node, keys []string) error {
+	switch node.Kind {
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			var key interface{}
+			if err := node.Content[i].Decode(&key); err != nil {
+				return err
+			}
+			k, ok := key.(string)
+			if !ok {
+				return fmt.Errorf("%s: key %v is not a string", strings.Join(keys, "."), key)
+			}
+			if len(keys) == 0 && k == "sops" {
+				continue
+			}
+			if err := d.walk(node.Content[i+1], append(keys[:len(keys):len(keys)], k)); err != nil {
+				return err
+			}
+		}
+	case yaml.SequenceNode:
+		for _, item := range node.Content {
+			if err := d.walk(item, keys); err != nil {
+				return err
+			}
+		}
+	case yaml.AliasNode:
+		return fmt.Errorf("%s: aliases are not supported", strings.Join(keys, "."))
+	case yaml.ScalarNode:
+		return d.leaf(node, keys)
+	}
+	return nil
+}
+
+// leaf decrypts a scalar value in place and adds it to the MAC.
+func (d *decrypter) leaf(node *yaml.Node, keys []string) error {
+	var value interface{}
+	if err := node.Decode(&value); err != nil {
+		return fmt.Errorf("%s: %v", strings.Join(keys, "."), err)
+	}
+	if value == nil {
+		return nil
+	}
+
+	encrypted := d.encrypted(keys)
+	if encrypted {
+		s, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("%s: value is not encrypted", strings.Join(keys, "."))
+		}
+		var err error
+		if value, err = d.decryptValue(node, s, strings.Join(keys, ":")+":"); err != nil {
+			return fmt.Errorf("%s: %v", strings.Join(keys, "."), err)
+		}
+	} else if i, ok := value.(int); ok && d.isJSON {
+		value = float64(i)
+	}
+
+	if encrypted || !d.meta.MACOnlyEncrypted {
+		b, err := toBytes(value)
+		if err != nil {
+			return fmt.Errorf("%s: %v", strings.Join(keys, "."), err)
+		}
+		d.hash.Write(b)
+	}
+	return nil
+}
+
+// encrypted reports whether SOPS encrypts the value at keys, following the
+// suffix and regular expression rules in its metadata.
+func (d *decrypter) encrypted(keys []string) bool {
+	anyKey := func(match func(string) bool) bool {
+		for _, k := range keys {
+			if match(k) {
+				return true
+			}
+		}
+		return false
+	}
+
+	encrypted := true
+	if s := d.meta.UnencryptedSuffix; s != "" && anyKey(func(k string) bool { return strings.HasSuffix(k, s) }) {
+		encrypted = false
+	}
+	if s := d.meta.EncryptedSuffix; s != "" {
+		encrypted = anyKey(func(k string) bool { return strings.HasSuffix(k, s) })
+	}
+	if re := d.unencryptedRegex; re != nil && anyKey(re.MatchString) {
+		encrypted = false
+	}
+	if re := d.encryptedRegex; re != nil {
+		encrypted = anyKey(re.MatchString)
+	}
+	return encrypted
+}
+
+// encryptedValue matches a value encrypted by SOPS.
+var encryptedValue = regexp.MustCompile(`^ENC\[AES256_GCM,data:(.+),iv:(.+),tag:(.+),type:(.+)\]`)
+
+// decryptValue decrypts s, authenticated with additionalData, replaces
+// node with the plaintext, and returns the value the way SOPS types it.
+func (d *decrypter) decryptValue(node *yaml.Node, s, additionalData string) (interface{}, error) {
+	if s == "" {
+		return "", nil
+	}
+	plaintext, datatype, err := decryptString(s, d.key, additionalData)
+	if err != nil {
+		return nil, err
+	}
+
+	text := string(plaintext)
+	var value interface{}
+	tag := "!!" + datatype
+	switch datatype {
+	case "str":
+		value = text
+	case "int":
+		value, err = strconv.Atoi(text)
+	case "float":
+		value, err = strconv.ParseFloat(text, 64)
+	case "bool":
+		value, err = strconv.ParseBool(text)
+		text = strings.ToLower(text)
+	case "bytes":
+		value, text, tag = plaintext, base64.StdEncoding.EncodeToString(plaintext), "!!binary"
+	case "time":
+		var t time.Time
+		err = t.UnmarshalText(plaintext)
+		value, tag = t, "!!timestamp"
+	default:
+		return nil, fmt.Errorf("unsupported encrypted value type %q", datatype)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("decrypted value is not of type %s", datatype)
+	}
+
+	*node = yaml.Node{Kind: yaml.ScalarNode, Tag: tag, Value: text}
+	return value, nil
+}
+
+// decryptString decrypts a value encrypted by SOPS with AES-GCM and returns
+// the plaintext and its SOPS type.
+func decryptString(s string, key []byte, additionalData string) ([]byte, string, error) {
+	m := encryptedValue.FindStringSubmatch(s)
+	if m == nil {
+		return nil, "", errors.New("value is not in the SOPS format")
+	}
+	var parts [3][]byte
+	for i := range parts {
+		var err error
+		if parts[i], err = base64.StdEncoding.DecodeString(m[i+1]); err != nil {
+			return nil, "", errors.New("value is not in the SOPS format")
+		}
+	}
+	data, iv, tag := parts[0], parts[1], parts[2]
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, "", err
+	}
+	gcm, err := cipher.NewGCMWithNonceSize(block, len(iv))
+	if err != nil {
+		return nil, "", errors.New("value is not in the SOPS format")
+	}
+	plaintext, err := gcm.Open(nil, iv, append(data, tag...), []byte(additionalData))
+	if err != nil {
+		return nil, "", errors.New("value cannot be decrypted: it was modified or moved")
+	}
+	return plaintext, m[4], nil
+}
+
+// verify checks the MAC computed by walk against the file's.
+func (d *decrypter) verify() error {
+	lastModified, err := time.Parse(time.RFC3339, d.meta.LastModified)
+	if err != nil {
+		return fmt.Errorf("invalid sops metadata: lastmodified: %v", err)
+	}
+	mac, _, err := decryptString(d.meta.MAC, d.key, lastModified.Format(time.RFC3339))
+	if err != nil {
+		return fmt.Errorf("mac: %v", err)
+	}
+	if string(mac) != fmt.Sprintf("%X", d.hash.Sum(nil)) {
+		return errors.New("MAC mismatch: the file was modified after it was encrypted")
+	}
+	return nil
+}
+
+// toBytes returns the bytes SOPS adds to the MAC for a value.
+func toBytes(value interface{}) ([]byte, error) {
+	switch v := value.(type) {
+	case string:
+		return []byte(v), nil
+	case int:
+		return []byte(strconv.Itoa(v)), nil
+	case float64:
+		return []byte(strconv.FormatFloat(v, 'f', -1, 64)), nil
+	case bool:
+		if v {
+			return []byte("True"), nil
+		}
+		return []byte("False"), nil
+	case []byte:
+		return v, nil
+	case time.Time:
+		return v.MarshalText()
+	default:
+		return nil, fmt.Errorf("unsupported value type %T", value)
+	}
+}
+
+// secret is a rendered Secret.
+type secret struct {
+	APIVersion string            `yaml:"apiVersion"`
+	Kind       string            `yaml:"kind"`
+	Metadata   secretMeta        `yaml:"metadata"`
+	Type       string            `yaml:"type"`
+	Data       map[string]string `yaml:"data"`
+}
+
+type secretMeta struct {
+	Name      string `yaml:"name"`
+	Namespace string `yaml:"namespace,omitempty"`
+}
+
+// renderSecret renders the decrypted mapping doc as a Secret with one data
+// key per top-level key.
+func renderSecret(name, namespace, secretType string, doc *yaml.Node) (string, error) {
+	if len(name) > 253 || !namePattern.MatchString(name) {
+		return "", fmt.Errorf("invalid name %q", name)
+	}
+	if secretType == "" {
+		secretType = "Opaque"
+	}
+
+	s := secret{
+		APIVersion: "v1",
+		Kind:       "Secret",
+		Metadata:   secretMeta{Name: name, Namespace: namespace},
+		Type:       secretType,
+		Data:       make(map[string]string),
+	}
+	for i := 0; i+1 < len(doc.Content); i += 2 {
+		key, value := doc.Content[i].Value, doc.Content[i+1]
+		if key == "sops" {
+			continue
+		}
+		if len(key) > 253 || !keyPattern.MatchString(key) {
+			return "", fmt.Errorf("invalid data key %q", key)
+		}
+		if value.Kind != yaml.ScalarNode || value.Tag == "!!null" {
+			return "", fmt.Errorf("%s is not a string, number or boolean", key)
+		}
+		data := []byte(value.Value)
+		if value.Tag == "!!binary" {
+			var err error
+			if data, err = base64.StdEncoding.DecodeString(strings.Join(strings.Fields(value.Value), "")); err != nil {
+				return "", fmt.Errorf("%s: invalid binary value", key)
+			}
+		}
+		s.Data[key] = base64.StdEncoding.EncodeToString(data)
+	}
+
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(&s); err != nil {
+		return "", err
+	}
+	if err := enc.Close(); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// mergeValues merges src into dst the way Helm coalesces values files: maps
+// are merged recursively, any other src value replaces the dst value, and a
+// nil src value deletes the key. dst is modified and returned.
+func mergeValues(dst, src map[string]interface{}) map[string]interface{} {
+	if dst == nil {
+		dst = make(map[string]interface{})
+	}
+	for k, v := range src {
+		if v == nil {
+			delete(dst, k)
+			continue
+		}
+		srcMap, srcIsMap := v.(map[string]interface{})
+		dstMap, dstIsMap := dst[k].(map[string]interface{})
+		if srcIsMap && dstIsMap {
+			dst[k] = mergeValues(dstMap, srcMap)
+			continue
+		}
+		dst[k] = v
+	}
+	return dst
+}
+
+func errorOutput(msg string) (OutputMessageRenderV1, uint32) {
+	return OutputMessageRenderV1{
+		RenderedFiles: make(map[string]string),
+		Errors:        []string{msg},
+	}, 1
+}
+
+// logf logs a debug message. main.go sends it to Helm when built as a
+// plugin; natively, e.g. in tests, it is discarded.
+var logf = func(format string, args ...interface{}) {}
+
+// main is empty: Helm calls the helm_plugin_main export defined in main.go.
+func main() {}
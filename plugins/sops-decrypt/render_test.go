@@ -0,0 +1,490 @@
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"filippo.io/age"
+	"filippo.io/age/armor"
+	"go.yaml.in/yaml/v3"
+)
+
+// plaintext is a secret value no error or log message may contain.
+const plaintext = "hunter2"
+
+const credentials = `# Database credentials
+username: app
+password: ` + plaintext + `
+port: 5432
+tls: true
+`
+
+const settings = `smtp:
+  host_unencrypted: smtp.example.com
+  password: ` + plaintext + `
+  ports: [25, 587]
+ratio: 0.5
+`
+
+// sopsOptions are the settings of encryptSOPS.
+type sopsOptions struct {
+	unencryptedSuffix string
+	macOnlyEncrypted  bool
+	lastModified      string
+}
+
+// encryptSOPS encrypts the values of the YAML document plain to recipients
+// the way "sops encrypt --age" does, so that tests do not need the sops
+// binary or a key that is checked in.
+func encryptSOPS(t *testing.T, plain string, opts sopsOptions, recipients ...age.Recipient) string {
+	t.Helper()
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatal(err)
+	}
+	if opts.lastModified == "" {
+		opts.lastModified = "2026-01-02T03:04:05Z"
+	}
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal([]byte(plain), &doc); err != nil {
+		t.Fatal(err)
+	}
+	mac := sha512.New()
+	if opts.macOnlyEncrypted {
+		mac.Write(macOnlyEncryptedInitialization)
+	}
+	var walk func(node *yaml.Node, keys []string)
+	walk = func(node *yaml.Node, keys []string) {
+		switch node.Kind {
+		case yaml.DocumentNode, yaml.SequenceNode:
+			for _, item := range node.Content {
+				walk(item, keys)
+			}
+		case yaml.MappingNode:
+			for i := 0; i < len(node.Content); i += 2 {
+				walk(node.Content[i+1], append(keys[:len(keys):len(keys)], node.Content[i].Value))
+			}
+		case yaml.ScalarNode:
+			var value interface{}
+			if err := node.Decode(&value); err != nil {
+				t.Fatal(err)
+			}
+			data, err := toBytes(value)
+			if err != nil {
+				t.Fatal(err)
+			}
+			encrypted := true
+			for _, k := range keys {
+				if opts.unencryptedSuffix != "" && strings.HasSuffix(k, opts.unencryptedSuffix) {
+					encrypted = false
+				}
+			}
+			if encrypted || !opts.macOnlyEncrypted {
+				mac.Write(data)
+			}
+			if encrypted {
+				datatype := map[string]string{"!!str": "str", "!!int": "int", "!!float": "float", "!!bool": "bool"}[node.Tag]
+				*node = yaml.Node{Kind: yaml.ScalarNode, Value: encryptValue(t, key, data, strings.Join(keys, ":")+":", datatype)}
+			}
+		}
+	}
+	walk(&doc, nil)
+
+	meta := map[string]interface{}{
+		"lastmodified": opts.lastModified,
+		"mac":          encryptValue(t, key, []byte(fmt.Sprintf("%X", mac.Sum(nil))), opts.lastModified, "str"),
+		"version":      "3.10.2",
+	}
+	if opts.unencryptedSuffix != "" {
+		meta["unencrypted_suffix"] = opts.unencryptedSuffix
+	}
+	if opts.macOnlyEncrypted {
+		meta["mac_only_encrypted"] = true
+	}
+	var entries []map[string]string
+	for _, r := range recipients {
+		var buf bytes.Buffer
+		a := armor.NewWriter(&buf)
+		w, err := age.Encrypt(a, r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write(key)
+		w.Close()
+		a.Close()
+		entries = append(entries, map[string]string{"recipient": fmt.Sprint(r), "enc": buf.String()})
+	}
+	meta["age"] = entries
+
+	var sops yaml.Node
+	if err := sops.Encode(meta); err != nil {
+		t.Fatal(err)
+	}
+	root := doc.Content[0]
+	root.Content = append(root.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: "sops"}, &sops)
+	out, err := yaml.Marshal(&doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(out)
+}
+
+func encryptValue(t *testing.T, key, plain []byte, additionalData, datatype string) string {
+	t.Helper()
+	iv := make([]byte, 32)
+	if _, err := rand.Read(iv); err != nil {
+		t.Fatal(err)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gcm, err := cipher.NewGCMWithNonceSize(block, len(iv))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed := gcm.Seal(nil, iv, plain, []byte(additionalData))
+	data, tag := sealed[:len(sealed)-gcm.Overhead()], sealed[len(sealed)-gcm.Overhead():]
+	enc := base64.StdEncoding.EncodeToString
+	return fmt.Sprintf("ENC[AES256_GCM,data:%s,iv:%s,tag:%s,type:%s]", enc(data), enc(iv), enc(tag), datatype)
+}
+
+func newIdentity(t *testing.T) *age.X25519Identity {
+	t.Helper()
+	id, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+func TestRender(t *testing.T) {
+	id := newIdentity(t)
+	other := newIdentity(t)
+	keys := "# created: 2026-01-02T03:04:05Z\n# public key: " + id.Recipient().String() + "\n" + id.String() + "\n"
+
+	creds := encryptSOPS(t, credentials, sopsOptions{}, other.Recipient(), id.Recipient())
+	values := encryptSOPS(t, settings, sopsOptions{unencryptedSuffix: "_unencrypted"}, id.Recipient())
+	release := ReleaseInfo{Name: "my-release", Namespace: "shop"}
+
+	tests := []struct {
+		name   string
+		files  map[string]string
+		values map[string]interface{}
+		config PluginConfig
+		// keys is the host's sops_age_key config; "-" passes none.
+		keys string
+		raw  string
+		// want holds the expected rendered files; files not listed must not
+		// be rendered.
+		want map[string]string
+		// wantValues is the expected modifiedValues, as JSON.
+		wantValues string
+		wantErrors []string
+	}{
+		{
+			name:   "secret",
+			files:  map[string]string{"secrets/db.enc.yaml": creds},
+			config: PluginConfig{Secrets: []SecretFile{{File: "secrets/db.enc.yaml"}}},
+			want: map[string]string{"templates/decrypted/secret-my-release-db.yaml": `apiVersion: v1
+kind: Secret
+metadata:
+  name: my-release-db
+  namespace: shop
+type: Opaque
+data:
+  password: aHVudGVyMg==
+  port: NTQzMg==
+  tls: dHJ1ZQ==
+  username: YXBw
+`},
+		},
+		{
+			name:   "secret name and type",
+			files:  map[string]string{"secrets/db.enc.yaml": creds},
+			config: PluginConfig{Secrets: []SecretFile{{File: "./secrets/db.enc.yaml", Name: "db", Type: "example.com/db"}}},
+			want: map[string]string{"templates/decrypted/secret-db.yaml": `apiVersion: v1
+kind: Secret
+metadata:
+  name: db
+  namespace: shop
+type: example.com/db
+data:
+  password: aHVudGVyMg==
+  port: NTQzMg==
+  tls: dHJ1ZQ==
+  username: YXBw
+`},
+		},
+		{
+			name:       "values",
+			files:      map[string]string{"secrets/values.yaml": values},
+			values:     map[string]interface{}{"smtp": map[string]interface{}{"host_unencrypted": "localhost", "user": "app"}, "replicas": 2},
+			config:     PluginConfig{Values: []ValuesFile{{File: "secrets/values.yaml"}}},
+			wantValues: `{"ratio":0.5,"replicas":2,"smtp":{"host_unencrypted":"smtp.example.com","password":"hunter2","ports":[25,587],"user":"app"}}`,
+		},
+		{
+			name:       "values under a key and as a secret",
+			files:      map[string]string{"secrets/db.enc.yaml": creds},
+			values:     map[string]interface{}{"database": "sqlite"},
+			config:     PluginConfig{Secrets: []SecretFile{{File: "secrets/db.enc.yaml", Name: "db"}}, Values: []ValuesFile{{File: "secrets/db.enc.yaml", Key: "database"}}},
+			want:       map[string]string{"templates/decrypted/secret-db.yaml": "password: aHVudGVyMg=="},
+			wantValues: `{"database":{"password":"hunter2","port":5432,"tls":true,"username":"app"}}`,
+		},
+		{
+			name:       "mac_only_encrypted",
+			files:      map[string]string{"secrets/values.yaml": encryptSOPS(t, settings, sopsOptions{unencryptedSuffix: "_unencrypted", macOnlyEncrypted: true, lastModified: "2026-01-02T04:04:05+01:00"}, id.Recipient())},
+			config:     PluginConfig{Values: []ValuesFile{{File: "secrets/values.yaml", Key: "secrets"}}},
+			wantValues: `{"secrets":{"ratio":0.5,"smtp":{"host_unencrypted":"smtp.example.com","password":"hunter2","ports":[25,587]}}}`,
+		},
+		{
+			name: "nothing to decrypt",
+			keys: "-",
+		},
+		{
+			name:       "no identities",
+			files:      map[string]string{"secrets/db.enc.yaml": creds},
+			config:     PluginConfig{Secrets: []SecretFile{{File: "secrets/db.enc.yaml"}}},
+			keys:       "-",
+			wantErrors: []string{"no age identities: the host passes none in its sops_age_key config"},
+		},
+		{
+			name:       "invalid identities",
+			files:      map[string]string{"secrets/db.enc.yaml": creds},
+			config:     PluginConfig{Secrets: []SecretFile{{File: "secrets/db.enc.yaml"}}},
+			keys:       strings.Replace(keys, "AGE-SECRET-KEY-1", "AGE-SECRET-KEY-2", 1),
+			wantErrors: []string{"the host's sops_age_key config does not hold valid age identities"},
+		},
+		{
+			name:       "no matching identity",
+			files:      map[string]string{"secrets/values.yaml": values},
+			config:     PluginConfig{Values: []ValuesFile{{File: "secrets/values.yaml"}}},
+			keys:       other.String(),
+			wantErrors: []string{"secrets/values.yaml: no age identity of the host matches the recipients " + id.Recipient().String()},
+		},
+		{
+			name: "modified values",
+			files: map[string]string{
+				"secrets/moved.yaml":    swapValues(t, creds, "username", "password"),
+				"secrets/modified.yaml": strings.Replace(values, "smtp.example.com", "smtp.example.org", 1),
+			},
+			config: PluginConfig{Values: []ValuesFile{{File: "secrets/moved.yaml"}, {File: "secrets/modified.yaml"}}},
+			wantErrors: []string{
+				"secrets/moved.yaml: username: value cannot be decrypted: it was modified or moved",
+				"secrets/modified.yaml: MAC mismatch: the file was modified after it was encrypted",
+			},
+		},
+		{
+			name: "invalid files",
+			files: map[string]string{
+				"secrets/plain.yaml":  credentials,
+				"secrets/list.yaml":   "- a\n",
+				"secrets/groups.yaml": "a: b\nsops:\n  key_groups: [{age: []}]\n  mac: x\n",
+				"secrets/pgp.yaml":    "a: b\nsops:\n  pgp: [{fp: x}]\n  mac: x\n",
+			},
+			config: PluginConfig{Values: []ValuesFile{{File: "secrets/plain.yaml"}, {File: "secrets/list.yaml"}, {File: "secrets/groups.yaml"}, {File: "secrets/pgp.yaml"}, {File: "secrets/missing.yaml"}}},
+			wantErrors: []string{
+				"secrets/plain.yaml: not encrypted by SOPS: no sops metadata",
+				"secrets/list.yaml: not encrypted by SOPS: not a mapping",
+				"secrets/groups.yaml: key_groups are not supported",
+				"secrets/pgp.yaml: the data key is not encrypted to any age recipient",
+				"secrets/missing.yaml: file not found in the chart",
+			},
+		},
+		{
+			name: "invalid secrets",
+			files: map[string]string{
+				"secrets/db.enc.yaml":     creds,
+				"secrets/nested.enc.yaml": values,
+				"secrets/keys.enc.yaml":   encryptSOPS(t, "a b: c\n", sopsOptions{}, id.Recipient()),
+			},
+			config: PluginConfig{Secrets: []SecretFile{
+				{File: "secrets/db.enc.yaml"},
+				{File: "secrets/db.enc.yaml"},
+				{File: "secrets/db.enc.yaml", Name: "DB"},
+				{File: "secrets/nested.enc.yaml"},
+				{File: "secrets/keys.enc.yaml"},
+			}},
+			wantErrors: []string{
+				"secrets/db.enc.yaml: Secret my-release-db is also rendered from secrets/db.enc.yaml",
+				`secrets/db.enc.yaml: Secret DB: invalid name "DB"`,
+				"secrets/nested.enc.yaml: Secret my-release-nested: smtp is not a string, number or boolean",
+				`secrets/keys.enc.yaml: Secret my-release-keys: invalid data key "a b"`,
+			},
+		},
+		{
+			name:       "wrong types",
+			raw:        `{"config": {"secrets": {"file": "a"}}}`,
+			wantErrors: []string{"failed to parse input"},
+		},
+		{
+			name:       "empty input",
+			raw:        ``,
+			wantErrors: []string{"no input provided"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			input := []byte(tt.raw)
+			if tt.raw == "" && tt.name != "empty input" {
+				msg := InputMessageRenderV1{Release: release, Values: tt.values, Config: tt.config}
+				for name, data := range tt.files {
+					msg.Files = append(msg.Files, SourceFile{Name: name, Data: []byte(data)})
+				}
+				var err error
+				if input, err = json.Marshal(msg); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			hostKeys := keys
+			if tt.keys != "" {
+				hostKeys = tt.keys
+			}
+			hostConfig = func(key string) (string, bool) {
+				return hostKeys, key == ageKeyConfig && hostKeys != "-"
+			}
+			var logs []string
+			logf = func(format string, args ...interface{}) {
+				logs = append(logs, fmt.Sprintf(format, args...))
+			}
+			defer func() {
+				hostConfig = func(string) (string, bool) { return "", false }
+				logf = func(string, ...interface{}) {}
+			}()
+
+			output, code := render(input)
+			if (code != 0) != (len(tt.wantErrors) > 0) {
+				t.Errorf("exit code = %d, errors %q", code, output.Errors)
+			}
+			if output.RenderedFiles == nil {
+				t.Error("renderedFiles is nil")
+			}
+
+			errs := strings.Join(output.Errors, "\n")
+			if len(tt.wantErrors) == 0 && errs != "" {
+				t.Errorf("unexpected errors: %s", errs)
+			}
+			for _, want := range tt.wantErrors {
+				if !strings.Contains(errs, want) {
+					t.Errorf("errors %q do not contain %q", errs, want)
+				}
+			}
+			for _, msg := range append(logs, errs) {
+				if strings.Contains(msg, plaintext) || strings.Contains(msg, "AGE-SECRET-KEY") {
+					t.Errorf("message discloses a secret: %s", msg)
+				}
+			}
+			if tt.wantErrors != nil {
+				if len(output.RenderedFiles) > 0 || output.ModifiedValues != nil {
+					t.Errorf("failed render returned files or values: %v", output)
+				}
+				return
+			}
+
+			if len(output.RenderedFiles) != len(tt.want) {
+				t.Errorf("rendered %d files, want %d: %v", len(output.RenderedFiles), len(tt.want), output.RenderedFiles)
+			}
+			for name, want := range tt.want {
+				if got := output.RenderedFiles[name]; !strings.Contains(got, want) {
+					t.Errorf("%s:\n%s\nwant:\n%s", name, got, want)
+				}
+			}
+
+			var gotValues string
+			if output.ModifiedValues != nil {
+				data, err := json.Marshal(output.ModifiedValues)
+				if err != nil {
+					t.Fatal(err)
+				}
+				gotValues = string(data)
+			}
+			if gotValues != tt.wantValues {
+				t.Errorf("modifiedValues = %s, want %s", gotValues, tt.wantValues)
+			}
+		})
+	}
+}
+
+// swapValues swaps the encrypted values of two top-level keys of a SOPS
+// file, which their additional data must detect.
+func swapValues(t *testing.T, file, a, b string) string {
+	t.Helper()
+	lines := strings.Split(file, "\n")
+	var ia, ib int
+	for i, line := range lines {
+		switch {
+		case strings.HasPrefix(line, a+": "):
+			ia = i
+		case strings.HasPrefix(line, b+": "):
+			ib = i
+		}
+	}
+	va := strings.TrimPrefix(lines[ia], a+": ")
+	vb := strings.TrimPrefix(lines[ib], b+": ")
+	lines[ia], lines[ib] = a+": "+vb, b+": "+va
+	return strings.Join(lines, "\n")
+}
+
+// TestSOPSFiles decrypts the files of the example chart, encrypted by the
+// sops binary, with the test identity plugin-run passes to the chart.
+func TestSOPSFiles(t *testing.T) {
+	keys, err := os.ReadFile(filepath.Join("..", "..", "plugin-run", "testdata", "age-key.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	identities, err := age.ParseIdentities(bytes.NewReader(keys))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := filepath.Glob(filepath.Join("..", "..", "charts", "sops-decrypt-chart", "secrets", "*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) == 0 {
+		t.Fatal("no SOPS files found")
+	}
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		doc, err := decryptFile(data, identities, filepath.Ext(file) == ".json")
+		if err != nil {
+			t.Errorf("%s: %v", file, err)
+			continue
+		}
+		var values map[string]interface{}
+		if err := doc.Decode(&values); err != nil {
+			t.Fatal(err)
+		}
+		delete(values, "sops")
+		out, err := json.Marshal(values)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bytes.Contains(out, []byte("ENC[")) {
+			t.Errorf("%s: values left encrypted:\n%s", file, out)
+		}
+
+		// Any change to a file's data must be detected.
+		modified := bytes.Replace(data, []byte("example.com"), []byte("example.org"), 1)
+		modified = bytes.Replace(modified, []byte("lastmodified: \"20"), []byte("lastmodified: \"19"), 1)
+		if _, err := decryptFile(modified, identities, false); err == nil {
+			t.Errorf("%s: modified file decrypted", file)
+		}
+	}
+}
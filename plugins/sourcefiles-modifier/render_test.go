@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestRender(t *testing.T) {
+	file := func(name, data string) SourceFile {
+		return SourceFile{Name: name, Data: []byte(data)}
+	}
+
+	tests := []struct {
+		name     string
+		files    []SourceFile
+		raw      string
+		wantCode uint32
+		// wantFiles are the expected modifiedSourceFiles, in order.
+		wantFiles []SourceFile
+		// wantSummary are lines the summary manifest must contain.
+		wantSummary []string
+		wantErrors  []string
+	}{
+		{
+			name: "removes, modifies, renames, passes and adds",
+			files: []SourceFile{
+				file("templates/file1.test", "one"),
+				file("templates/file2.test", "two"),
+				file("templates/file3.test", "three"),
+				file("templates/file5.test", "five"),
+			},
+			wantFiles: []SourceFile{
+				file("templates/file2.test", "[MODIFIED BY PLUGIN 1]\ntwo"),
+				file("templates/file3.renamed", "three"),
+				file("templates/file5.test", "five"),
+				file("templates/file4.test", "# This file was added by sourcefiles-modifier plugin\nkey: added-by-plugin-1"),
+			},
+			wantSummary: []string{
+				"- REMOVED: templates/file1.test",
+				"- MODIFIED: templates/file2.test",
+				"- RENAMED: templates/file3.test -> templates/file3.renamed",
+				"- PASSED: templates/file5.test",
+				"- ADDED: templates/file4.test",
+				`filesReceived: "4"`,
+				`filesOutput: "4"`,
+			},
+		},
+		{
+			name: "no source files still adds file4",
+			raw:  `{}`,
+			wantFiles: []SourceFile{
+				file("templates/file4.test", "# This file was added by sourcefiles-modifier plugin\nkey: added-by-plugin-1"),
+			},
+			wantSummary: []string{`filesReceived: "0"`, `filesOutput: "1"`},
+		},
+		{
+			name:       "malformed JSON",
+			raw:        `{"sourceFiles": [{"name": 1}]}`,
+			wantCode:   1,
+			wantErrors: []string{"failed to parse input"},
+		},
+		{
+			name:       "empty input",
+			raw:        ``,
+			wantCode:   1,
+			wantErrors: []string{"failed to parse input"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			input := []byte(tt.raw)
+			if tt.files != nil {
+				var err error
+				if input, err = json.Marshal(InputMessageRenderV1{SourceFiles: tt.files}); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			output, code := render(input)
+			if code != tt.wantCode {
+				t.Errorf("exit code = %d, want %d", code, tt.wantCode)
+			}
+
+			if len(output.ModifiedSourceFiles) != len(tt.wantFiles) {
+				t.Fatalf("got %d modified source files, want %d", len(output.ModifiedSourceFiles), len(tt.wantFiles))
+			}
+			for i, want := range tt.wantFiles {
+				got := output.ModifiedSourceFiles[i]
+				if got.Name != want.Name || string(got.Data) != string(want.Data) {
+					t.Errorf("modifiedSourceFiles[%d] = %s %q, want %s %q", i, got.Name, got.Data, want.Name, want.Data)
+				}
+			}
+
+			if tt.wantCode == 0 && !strings.Contains(output.RenderedFiles["templates/sourcefiles-modifier-scratch.yaml"], "should REMOVE this file") {
+				t.Errorf("missing scratch manifest in %v", output.RenderedFiles)
+			}
+
+			summary := output.RenderedFiles["templates/sourcefiles-modifier-summary.yaml"]
+			for _, want := range tt.wantSummary {
+				if !strings.Contains(summary, want) {
+					t.Errorf("summary does not contain %q:\n%s", want, summary)
+				}
+			}
+
+			errs := strings.Join(output.Errors, "\n")
+			if len(tt.wantErrors) == 0 && errs != "" {
+				t.Errorf("unexpected errors: %s", errs)
+			}
+			for _, want := range tt.wantErrors {
+				if !strings.Contains(errs, want) {
+					t.Errorf("errors %q do not contain %q", errs, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// chartSeeds returns the example chart inputs recorded by plugin-run in
+// plugin-run/testdata/seeds, one per chart, each passed to configure first
+// if it is not nil.
+func chartSeeds(tb testing.TB, configure func(*InputMessageRenderV1)) [][]byte {
+	files, err := filepath.Glob(filepath.Join("..", "..", "plugin-run", "testdata", "seeds", "*.json"))
+	if err != nil || len(files) == 0 {
+		tb.Fatalf("no seeds in plugin-run/testdata/seeds: %v", err)
+	}
+
+	var seeds [][]byte
+	for _, file := range files {
+		var input InputMessageRenderV1
+		data, err := os.ReadFile(file)
+		if err == nil {
+			err = json.Unmarshal(data, &input)
+		}
+		if err != nil {
+			tb.Fatalf("%s: %v", file, err)
+		}
+		if configure != nil {
+			configure(&input)
+		}
+
+		seed, err := json.Marshal(input)
+		if err != nil {
+			tb.Fatal(err)
+		}
+		seeds = append(seeds, seed)
+	}
+	return seeds
+}
+
+func FuzzRender(f *testing.F) {
+	for _, seed := range chartSeeds(f, func(input *InputMessageRenderV1) {
+		input.Config = PluginConfig{Rules: []Rule{
+			{Match: "templates/*.y*ml", Prepend: "# fuzz\n"},
+			{Rename: &RenameAction{Pattern: `\.(\w+)$`, Replacement: ".fuzz.$1"}},
+			{Match: "templates/*", Generate: &Generate{Name: "templates/gen/{{ base .File.Name }}", Template: "{{ .File.Content }}"}},
+		}}
+	}) {
+		f.Add(seed)
+	}
+	f.Add([]byte(``))
+	f.Add([]byte(`{}`))
+	f.Add([]byte(`{"sourceFiles": [{"name": "a"}, {"name": "b", "data": null}], "config": {"rules": [{"remove": true, "match": "b"}, {"append": "x"}]}}`))
+	f.Add([]byte(`{"sourceFiles": [{"name": "templates/a"}], "config": {"rules": [{"rename": {"pattern": ".", "replacement": "$0$0"}}, {"generate": {"name": "templates/{{.Values.x}}", "template": "{{range .Values.l}}{{.}}{{end}}"}}]}}`))
+
+	f.Fuzz(func(t *testing.T, input []byte) {
+		output, code := render(input)
+
+		data, err := json.Marshal(output)
+		if err != nil || !json.Valid(data) {
+			t.Fatalf("output is not valid JSON: %v", err)
+		}
+		if (code != 0) != (len(output.Errors) > 0) {
+			t.Fatalf("exit code %d with errors %q", code, output.Errors)
+		}
+		// Rules may grow the files, but only up to maxOutputSize, which JSON
+		// escaping of names and base64 encoding of data inflate at most 6x.
+		if len(data) > 6*maxOutputSize+8*len(input)+1024 {
+			t.Fatalf("output of %d bytes for %d bytes of input", len(data), len(input))
+		}
+	})
+}
@@ -0,0 +1,8 @@
+module github.com/scottrigby/ref-hip-chart-defined-plugins/plugins/sourcefiles-transform
+
+go 1.24.3
+
+require (
+	github.com/extism/go-pdk v1.1.3
+	github.com/gobwas/glob v0.2.3
+)
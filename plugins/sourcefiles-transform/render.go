@@ -0,0 +1,405 @@
+// Package main implements a render/v1 plugin that reshapes a chart's source
+// files before the plugins after it run. It renders nothing itself: it applies
+// the rules in its config to the templates it receives, in order, and returns
+// the result as modifiedSourceFiles.
+//
+// Each rule selects files with an optional glob and does one of:
+// - remove: drop the file
+// - rename: replace a regular expression in the file name
+// - prepend/append: add text before or after the content
+// - generate: add a file from a Go template, once, or once per selected file
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/gobwas/glob"
+)
+
+// maxOutputSize bounds the total size of the files the rules produce,
+// counting names and contents, so appending to or generating from many files
+// cannot exhaust the plugin's memory.
+const maxOutputSize = 16 << 20
+
+// maxNameLength bounds the names of renamed and generated files.
+const maxNameLength = 1024
+
+// errOutputSize is returned when the files would exceed maxOutputSize.
+var errOutputSize = fmt.Errorf("transformed files exceed %d bytes", maxOutputSize)
+
+// limitedBuffer is a bytes.Buffer that refuses to grow past maxOutputSize.
+type limitedBuffer struct {
+	bytes.Buffer
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if b.Len()+len(p) > maxOutputSize {
+		return 0, errOutputSize
+	}
+	return b.Buffer.Write(p)
+}
+
+func (b *limitedBuffer) WriteString(s string) (int, error) {
+	return b.Write([]byte(s))
+}
+
+// ReleaseInfo contains release metadata passed to render plugins.
+type ReleaseInfo struct {
+	Name      string `json:"name"`
+	Namespace string `json:"namespace"`
+	Revision  int    `json:"revision"`
+	IsInstall bool   `json:"isInstall"`
+	IsUpgrade bool   `json:"isUpgrade"`
+	Service   string `json:"service"`
+}
+
+// ChartInfo contains chart metadata passed to render plugins.
+type ChartInfo struct {
+	Name        string `json:"name"`
+	Version     string `json:"version"`
+	AppVersion  string `json:"appVersion,omitempty"`
+	Description string `json:"description,omitempty"`
+	Type        string `json:"type,omitempty"`
+	IsRoot      bool   `json:"isRoot"`
+}
+
+// SourceFile represents a file in the chart.
+type SourceFile struct {
+	Name string `json:"name"`
+	Data []byte `json:"data"`
+}
+
+// InputMessageRenderV1 is the input message for render/v1 plugins.
+type InputMessageRenderV1 struct {
+	Release     ReleaseInfo            `json:"release"`
+	Values      map[string]interface{} `json:"values"`
+	Chart       ChartInfo              `json:"chart"`
+	SourceFiles []SourceFile           `json:"sourceFiles"`
+	Config      PluginConfig           `json:"config"`
+}
+
+// PluginConfig is the chart's configuration for this plugin, validated by
+// Helm against the configSchema in plugin.yaml.
+type PluginConfig struct {
+	Rules []Rule `json:"rules"`
+}
+
+// Rule is one transformation, applied to every file matching Match, or to
+// every file if Match is empty. Exactly one action is set, counting Prepend
+// and Append as one.
+type Rule struct {
+	Match    string        `json:"match,omitempty"`
+	Remove   bool          `json:"remove,omitempty"`
+	Rename   *RenameAction `json:"rename,omitempty"`
+	Prepend  string        `json:"prepend,omitempty"`
+	Append   string        `json:"append,omitempty"`
+	Generate *Generate     `json:"generate,omitempty"`
+}
+
+// RenameAction replaces Pattern, a regular expression, with Replacement in
+// file names. Replacement may refer to groups as $1 or ${name}.
+type RenameAction struct {
+	Pattern     string `json:"pattern"`
+	Replacement string `json:"replacement"`
+}
+
+// Generate adds a file whose name and content are Go templates. Without a
+// match, one file is generated; with one, a file is generated for each
+// matching file, available to the templates as .File.
+type Generate struct {
+	Name     string `json:"name"`
+	Template string `json:"template"`
+}
+
+// OutputMessageRenderV1 is the output message from render/v1 plugins.
+// ModifiedSourceFiles is always written, even when empty, since an empty list
+// removes every file the plugin received while a missing one keeps them.
+type OutputMessageRenderV1 struct {
+	RenderedFiles       map[string]string `json:"renderedFiles"`
+	ModifiedSourceFiles []SourceFile      `json:"modifiedSourceFiles"`
+	Errors              []string          `json:"errors,omitempty"`
+}
+
+// TemplateData holds the data available to generate templates.
+type TemplateData struct {
+	Release ReleaseInfo
+	Chart   ChartInfo
+	Values  map[string]interface{}
+	// File is the matching file a template is generated for, if any.
+	File *TemplateFile
+}
+
+// TemplateFile is a source file as seen by generate templates.
+type TemplateFile struct {
+	Name    string
+	Content string
+}
+
+// render applies the configured rules to the source files of a raw input
+// message and returns the output message along with the exit code the plugin
+// returns to Helm.
+func render(inputBytes []byte) (OutputMessageRenderV1, uint32) {
+	if len(inputBytes) == 0 {
+		return errorOutput("no input provided")
+	}
+
+	var input InputMessageRenderV1
+	if err := json.Unmarshal(inputBytes, &input); err != nil {
+		return errorOutput(fmt.Sprintf("failed to parse input: %v", err))
+	}
+
+	logf("Received %d source files and %d rules", len(input.SourceFiles), len(input.Config.Rules))
+
+	files := make([]SourceFile, 0, len(input.SourceFiles))
+	size := 0
+	for _, f := range input.SourceFiles {
+		files = append(files, f)
+		size += fileSize(f)
+	}
+
+	for i, rule := range input.Config.Rules {
+		var err error
+		files, size, err = applyRule(rule, files, size, &input)
+		if err != nil {
+			return errorOutput(fmt.Sprintf("rule %d: %v", i+1, err))
+		}
+	}
+
+	return OutputMessageRenderV1{
+		RenderedFiles:       make(map[string]string),
+		ModifiedSourceFiles: files,
+	}, 0
+}
+
+// applyRule applies rule to files, whose contents total size bytes, and
+// returns the new files and their size.
+func applyRule(rule Rule, files []SourceFile, size int, input *InputMessageRenderV1) ([]SourceFile, int, error) {
+	actions := 0
+	for _, set := range []bool{rule.Remove, rule.Rename != nil, rule.Prepend != "" || rule.Append != "", rule.Generate != nil} {
+		if set {
+			actions++
+		}
+	}
+	if actions != 1 {
+		return nil, 0, errors.New("must have exactly one of remove, rename, prepend/append or generate")
+	}
+
+	match := func(string) bool { return true }
+	if rule.Match != "" {
+		g, err := glob.Compile(rule.Match, '/')
+		if err != nil {
+			return nil, 0, fmt.Errorf("invalid match %q: %v", rule.Match, err)
+		}
+		match = g.Match
+	}
+
+	var rename *regexp.Regexp
+	if rule.Rename != nil {
+		var err error
+		if rename, err = regexp.Compile(rule.Rename.Pattern); err != nil {
+			return nil, 0, fmt.Errorf("invalid rename pattern: %v", err)
+		}
+	}
+
+	var name, content *template.Template
+	if rule.Generate != nil {
+		var err error
+		if name, err = template.New("name").Funcs(funcMap()).Option("missingkey=zero").Parse(rule.Generate.Name); err != nil {
+			return nil, 0, fmt.Errorf("invalid generate name: %v", err)
+		}
+		if content, err = template.New("template").Funcs(funcMap()).Option("missingkey=zero").Parse(rule.Generate.Template); err != nil {
+			return nil, 0, fmt.Errorf("invalid generate template: %v", err)
+		}
+		if rule.Match == "" {
+			f, err := generate(name, content, input, nil)
+			if err != nil {
+				return nil, 0, err
+			}
+			if size += fileSize(f); size > maxOutputSize {
+				return nil, 0, errOutputSize
+			}
+			files, err = add(files, f)
+			return files, size, err
+		}
+	}
+
+	out := make([]SourceFile, 0, len(files))
+	var generated []SourceFile
+	for _, f := range files {
+		if !match(f.Name) {
+			out = append(out, f)
+			continue
+		}
+		switch {
+		case rule.Remove:
+			logf("Removing %s", f.Name)
+			size -= fileSize(f)
+			continue
+		case rename != nil:
+			newName := rename.ReplaceAllString(f.Name, rule.Rename.Replacement)
+			if err := checkName(newName); err != nil {
+				return nil, 0, fmt.Errorf("renaming %s: %v", f.Name, err)
+			}
+			logf("Renaming %s to %s", f.Name, newName)
+			size += len(newName) - len(f.Name)
+			f.Name = newName
+		case rule.Generate != nil:
+			g, err := generate(name, content, input, &f)
+			if err != nil {
+				return nil, 0, fmt.Errorf("generating from %s: %v", f.Name, err)
+			}
+			generated = append(generated, g)
+			if size += fileSize(g); size > maxOutputSize {
+				return nil, 0, errOutputSize
+			}
+		default:
+			size += len(rule.Prepend) + len(rule.Append)
+			if size > maxOutputSize {
+				return nil, 0, errOutputSize
+			}
+			logf("Adding text to %s", f.Name)
+			data := make([]byte, 0, len(rule.Prepend)+len(f.Data)+len(rule.Append))
+			data = append(data, rule.Prepend...)
+			data = append(data, f.Data...)
+			data = append(data, rule.Append...)
+			f.Data = data
+		}
+		out = append(out, f)
+	}
+	if size > maxOutputSize {
+		return nil, 0, errOutputSize
+	}
+
+	seen := make(map[string]bool, len(out))
+	for _, f := range out {
+		if seen[f.Name] {
+			return nil, 0, fmt.Errorf("more than one file named %s", f.Name)
+		}
+		seen[f.Name] = true
+	}
+	for _, g := range generated {
+		var err error
+		if out, err = add(out, g); err != nil {
+			return nil, 0, err
+		}
+	}
+	return out, size, nil
+}
+
+// generate executes a generate rule's templates, for file if not nil.
+func generate(name, content *template.Template, input *InputMessageRenderV1, file *SourceFile) (SourceFile, error) {
+	data := TemplateData{
+		Release: input.Release,
+		Chart:   input.Chart,
+		Values:  input.Values,
+	}
+	if file != nil {
+		data.File = &TemplateFile{Name: file.Name, Content: string(file.Data)}
+	}
+
+	var nameBuf, contentBuf limitedBuffer
+	if err := name.Execute(&nameBuf, data); err != nil {
+		return SourceFile{}, err
+	}
+	if err := content.Execute(&contentBuf, data); err != nil {
+		return SourceFile{}, err
+	}
+	f := SourceFile{Name: strings.TrimSpace(nameBuf.String()), Data: contentBuf.Bytes()}
+	if err := checkName(f.Name); err != nil {
+		return SourceFile{}, fmt.Errorf("generated name: %v", err)
+	}
+	logf("Generated %s", f.Name)
+	return f, nil
+}
+
+// fileSize is the size f counts towards maxOutputSize.
+func fileSize(f SourceFile) int {
+	return len(f.Name) + len(f.Data)
+}
+
+// add appends f to files, failing if a file with its name exists.
+func add(files []SourceFile, f SourceFile) ([]SourceFile, error) {
+	for _, existing := range files {
+		if existing.Name == f.Name {
+			return nil, fmt.Errorf("generated file %s already exists", f.Name)
+		}
+	}
+	return append(files, f), nil
+}
+
+// checkName reports whether name is a clean path under templates/, as
+// modifiedSourceFiles names must be, and not too long.
+func checkName(name string) error {
+	if len(name) > maxNameLength {
+		return fmt.Errorf("name longer than %d bytes", maxNameLength)
+	}
+	if name == "" || path.Clean(name) != name || !strings.HasPrefix(name, "templates/") {
+		return fmt.Errorf("%q is not a clean path under templates/", name)
+	}
+	return nil
+}
+
+// funcMap returns the functions available to generate templates. Functions
+// taking a string to operate on take it last, as in Sprig, so that they can
+// be used in pipelines.
+func funcMap() template.FuncMap {
+	return template.FuncMap{
+		"upper":      strings.ToUpper,
+		"lower":      strings.ToLower,
+		"trim":       strings.TrimSpace,
+		"trimPrefix": func(prefix, s string) string { return strings.TrimPrefix(s, prefix) },
+		"trimSuffix": func(suffix, s string) string { return strings.TrimSuffix(s, suffix) },
+		"replace":    func(old, new, s string) string { return strings.ReplaceAll(s, old, new) },
+		"base":       path.Base,
+		"quote":      func(s string) string { return strconv.Quote(s) },
+		"indent":     indent,
+		"default": func(def interface{}, val interface{}) interface{} {
+			if val == nil || val == "" {
+				return def
+			}
+			return val
+		},
+	}
+}
+
+// indent prefixes each non-empty line of s with spaces spaces.
+func indent(spaces int, s string) (string, error) {
+	if spaces < 0 || spaces > 64 {
+		return "", fmt.Errorf("indent: %d spaces out of range", spaces)
+	}
+	prefix := strings.Repeat(" ", spaces)
+	lines := strings.Split(s, "\n")
+	if len(prefix) > 0 && len(lines) > maxOutputSize/len(prefix) {
+		return "", errOutputSize
+	}
+	for i, line := range lines {
+		if line != "" {
+			lines[i] = prefix + line
+		}
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+// errorOutput returns an output message reporting msg, with a failing exit
+// code.
+func errorOutput(msg string) (OutputMessageRenderV1, uint32) {
+	return OutputMessageRenderV1{
+		RenderedFiles: make(map[string]string),
+		Errors:        []string{msg},
+	}, 1
+}
+
+// logf logs a debug message. main.go sends it to Helm when built as a
+// plugin; natively, e.g. in tests, it is discarded.
+var logf = func(format string, args ...interface{}) {}
+
+// main is empty: Helm calls the helm_plugin_main export defined in main.go.
+func main() {}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestRender(t *testing.T) {
+	file := func(name, data string) SourceFile {
+		return SourceFile{Name: name, Data: []byte(data)}
+	}
+	chartFiles := []SourceFile{
+		file("templates/deployment.yml", "kind: Deployment\n"),
+		file("templates/service.yaml", "kind: Service\n"),
+		file("templates/legacy-cm.yaml", "kind: ConfigMap\n"),
+		file("templates/_helpers.tpl", "{{/* helpers */}}"),
+	}
+
+	tests := []struct {
+		name     string
+		files    []SourceFile
+		rules    []Rule
+		raw      string
+		wantCode uint32
+		// wantFiles are the expected modifiedSourceFiles, in order.
+		wantFiles  []SourceFile
+		wantErrors []string
+	}{
+		{
+			name:      "no rules passes files through",
+			files:     chartFiles,
+			wantFiles: chartFiles,
+		},
+		{
+			name:  "removes matching files",
+			files: chartFiles,
+			rules: []Rule{{Match: "templates/legacy-*", Remove: true}},
+			wantFiles: []SourceFile{
+				file("templates/deployment.yml", "kind: Deployment\n"),
+				file("templates/service.yaml", "kind: Service\n"),
+				file("templates/_helpers.tpl", "{{/* helpers */}}"),
+			},
+		},
+		{
+			name:      "removing every file returns an empty list",
+			files:     chartFiles,
+			rules:     []Rule{{Remove: true}},
+			wantFiles: []SourceFile{},
+		},
+		{
+			name:  "renames with a regular expression",
+			files: chartFiles,
+			rules: []Rule{{Rename: &RenameAction{Pattern: `^templates/(.*)\.yml$`, Replacement: "templates/apps/$1.yaml"}}},
+			wantFiles: []SourceFile{
+				file("templates/apps/deployment.yaml", "kind: Deployment\n"),
+				file("templates/service.yaml", "kind: Service\n"),
+				file("templates/legacy-cm.yaml", "kind: ConfigMap\n"),
+				file("templates/_helpers.tpl", "{{/* helpers */}}"),
+			},
+		},
+		{
+			name:       "rename out of templates",
+			files:      chartFiles,
+			rules:      []Rule{{Match: "templates/*.yml", Rename: &RenameAction{Pattern: `^templates/`, Replacement: "../"}}},
+			wantCode:   1,
+			wantErrors: []string{`rule 1: renaming templates/deployment.yml: "../deployment.yml" is not a clean path under templates/`},
+		},
+		{
+			name:       "rename onto an existing file",
+			files:      chartFiles,
+			rules:      []Rule{{Rename: &RenameAction{Pattern: `\.yml$`, Replacement: ".yaml"}}, {Match: "templates/deployment.yaml", Rename: &RenameAction{Pattern: "deployment", Replacement: "service"}}},
+			wantCode:   1,
+			wantErrors: []string{"rule 2: more than one file named templates/service.yaml"},
+		},
+		{
+			name:  "prepends and appends",
+			files: chartFiles,
+			rules: []Rule{{Match: "templates/*.{yaml,yml}", Prepend: "# managed\n", Append: "---\n"}},
+			wantFiles: []SourceFile{
+				file("templates/deployment.yml", "# managed\nkind: Deployment\n---\n"),
+				file("templates/service.yaml", "# managed\nkind: Service\n---\n"),
+				file("templates/legacy-cm.yaml", "# managed\nkind: ConfigMap\n---\n"),
+				file("templates/_helpers.tpl", "{{/* helpers */}}"),
+			},
+		},
+		{
+			name: "rules apply in order",
+			files: []SourceFile{
+				file("templates/a.yml", "a"),
+				file("templates/b.yaml", "b"),
+			},
+			rules: []Rule{
+				{Rename: &RenameAction{Pattern: `\.yml$`, Replacement: ".yaml"}},
+				{Match: "templates/*.yaml", Append: "!"},
+				{Match: "templates/b.yaml", Remove: true},
+			},
+			wantFiles: []SourceFile{file("templates/a.yaml", "a!")},
+		},
+		{
+			name:  "generates a file",
+			files: chartFiles[:1],
+			rules: []Rule{{Generate: &Generate{
+				Name:     "templates/{{ .Release.Name }}-info.yaml",
+				Template: "chart: {{ .Chart.Name }}-{{ .Chart.Version }}\nreplicas: {{ .Values.replicas }}\nmissing: {{ .Values.nope }}\n",
+			}}},
+			wantFiles: []SourceFile{
+				file("templates/deployment.yml", "kind: Deployment\n"),
+				file("templates/web-info.yaml", "chart: app-1.0.0\nreplicas: 3\nmissing: <no value>\n"),
+			},
+		},
+		{
+			name:  "generates a file per matching file",
+			files: chartFiles,
+			rules: []Rule{{Match: "templates/*.yaml", Generate: &Generate{
+				Name:     `templates/docs/{{ base .File.Name | trimSuffix ".yaml" }}.txt`,
+				Template: "{{ .File.Name }}:\n{{ indent 2 .File.Content }}",
+			}}},
+			wantFiles: []SourceFile{
+				chartFiles[0], chartFiles[1], chartFiles[2], chartFiles[3],
+				file("templates/docs/service.txt", "templates/service.yaml:\n  kind: Service\n"),
+				file("templates/docs/legacy-cm.txt", "templates/legacy-cm.yaml:\n  kind: ConfigMap\n"),
+			},
+		},
+		{
+			name:       "generated file already exists",
+			files:      chartFiles,
+			rules:      []Rule{{Generate: &Generate{Name: "templates/service.yaml", Template: "x"}}},
+			wantCode:   1,
+			wantErrors: []string{"rule 1: generated file templates/service.yaml already exists"},
+		},
+		{
+			name:       "template error",
+			files:      chartFiles,
+			rules:      []Rule{{Generate: &Generate{Name: "templates/x.yaml", Template: "{{ .Values.a.b.c }}"}}},
+			wantCode:   1,
+			wantErrors: []string{"rule 1: template: template:1:"},
+		},
+		{
+			name:       "no action",
+			files:      chartFiles,
+			rules:      []Rule{{Match: "templates/*"}},
+			wantCode:   1,
+			wantErrors: []string{"rule 1: must have exactly one of remove, rename, prepend/append or generate"},
+		},
+		{
+			name:       "two actions",
+			files:      chartFiles,
+			rules:      []Rule{{Remove: true, Append: "x"}},
+			wantCode:   1,
+			wantErrors: []string{"rule 1: must have exactly one"},
+		},
+		{
+			name:       "invalid glob",
+			files:      chartFiles,
+			rules:      []Rule{{Match: "templates/[", Remove: true}},
+			wantCode:   1,
+			wantErrors: []string{`rule 1: invalid match "templates/["`},
+		},
+		{
+			name:       "invalid regular expression",
+			files:      chartFiles,
+			rules:      []Rule{{Rename: &RenameAction{Pattern: "(", Replacement: ""}}},
+			wantCode:   1,
+			wantErrors: []string{"rule 1: invalid rename pattern"},
+		},
+		{
+			name:       "output too large",
+			files:      []SourceFile{file("templates/a.yaml", strings.Repeat("x", maxOutputSize/2))},
+			rules:      []Rule{{Append: strings.Repeat("y", maxOutputSize/2+1)}},
+			wantCode:   1,
+			wantErrors: []string{"rule 1: transformed files exceed"},
+		},
+		{
+			name:      "no source files",
+			raw:       `{}`,
+			wantFiles: []SourceFile{},
+		},
+		{
+			name:       "malformed JSON",
+			raw:        `{"config": {"rules": {}}}`,
+			wantCode:   1,
+			wantErrors: []string{"failed to parse input"},
+		},
+		{
+			name:       "empty input",
+			raw:        ``,
+			wantCode:   1,
+			wantErrors: []string{"no input provided"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			input := []byte(tt.raw)
+			if tt.files != nil {
+				var err error
+				input, err = json.Marshal(InputMessageRenderV1{
+					Release:     ReleaseInfo{Name: "web"},
+					Chart:       ChartInfo{Name: "app", Version: "1.0.0"},
+					Values:      map[string]interface{}{"replicas": 3},
+					SourceFiles: tt.files,
+					Config:      PluginConfig{Rules: tt.rules},
+				})
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			output, code := render(input)
+			if code != tt.wantCode {
+				t.Errorf("exit code = %d, want %d", code, tt.wantCode)
+			}
+			if code == 0 && output.ModifiedSourceFiles == nil {
+				t.Errorf("modifiedSourceFiles is nil, which would keep the received files")
+			}
+
+			if len(output.ModifiedSourceFiles) != len(tt.wantFiles) {
+				t.Fatalf("got %d modified source files, want %d", len(output.ModifiedSourceFiles), len(tt.wantFiles))
+			}
+			for i, want := range tt.wantFiles {
+				got := output.ModifiedSourceFiles[i]
+				if got.Name != want.Name || string(got.Data) != string(want.Data) {
+					t.Errorf("modifiedSourceFiles[%d] = %s %q, want %s %q", i, got.Name, got.Data, want.Name, want.Data)
+				}
+			}
+
+			errs := strings.Join(output.Errors, "\n")
+			if len(tt.wantErrors) == 0 && errs != "" {
+				t.Errorf("unexpected errors: %s", errs)
+			}
+			for _, want := range tt.wantErrors {
+				if !strings.Contains(errs, want) {
+					t.Errorf("errors %q do not contain %q", errs, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// chartSeeds returns the example chart inputs recorded by plugin-run in
+// plugin-run/testdata/seeds, one per chart, each passed to configure first
+// if it is not nil.
+func chartSeeds(tb testing.TB, configure func(*InputMessageRenderV1)) [][]byte {
+	files, err := filepath.Glob(filepath.Join("..", "..", "plugin-run", "testdata", "seeds", "*.json"))
+	if err != nil || len(files) == 0 {
+		tb.Fatalf("no seeds in plugin-run/testdata/seeds: %v", err)
+	}
+
+	var seeds [][]byte
+	for _, file := range files {
+		var input InputMessageRenderV1
+		data, err := os.ReadFile(file)
+		if err == nil {
+			err = json.Unmarshal(data, &input)
+		}
+		if err != nil {
+			tb.Fatalf("%s: %v", file, err)
+		}
+		if configure != nil {
+			configure(&input)
+		}
+
+		seed, err := json.Marshal(input)
+		if err != nil {
+			tb.Fatal(err)
+		}
+		seeds = append(seeds, seed)
+	}
+	return seeds
+}
+
+func FuzzRender(f *testing.F) {
+	for _, seed := range chartSeeds(f, nil) {
+		f.Add(seed)
+	}
+	f.Add([]byte(``))
+	f.Add([]byte(`{}`))
+	// templates/a.star: load("_b.star", "b"); def main(): return [b, {"kind": chart["name"]}, decode_yaml(encode_yaml(values))]
+	f.Add([]byte(`{"values": {"a": [1, "x", null]}, "sourceFiles": [{"name": "templates/a.star", "data": "bG9hZCgiX2Iuc3RhciIsICJiIikKZGVmIG1haW4oKToKICAgIHJldHVybiBbYiwgeyJraW5kIjogY2hhcnRbIm5hbWUiXX0sIGRlY29kZV95YW1sKGVuY29kZV95YW1sKHZhbHVlcykpXQo="}, {"name": "templates/_b.star", "data": "YiA9IHsia2luZCI6ICJCIiwgIm4iOiBqc29uLmVuY29kZShbMSwgMi41XSl9Cg=="}]}`))
+
+	f.Fuzz(func(t *testing.T, input []byte) {
+		output, code := render(input)
+
+		data, err := json.Marshal(output)
+		if err != nil || !json.Valid(data) {
+			t.Fatalf("output is not valid JSON: %v", err)
+		}
+		if (code != 0) != (len(output.Errors) > 0) {
+			t.Fatalf("exit code %d with errors %q", code, output.Errors)
+		}
+		for name, content := range output.RenderedFiles {
+			if len(content) > maxRenderedSize {
+				t.Fatalf("%s is %d bytes, over maxRenderedSize", name, len(content))
+			}
+		}
+	})
+}
@@ -0,0 +1,11 @@
+module github.com/scottrigby/ref-hip-chart-defined-plugins/plugins/starlark-render
+
+go 1.24.3
+
+require (
+	github.com/extism/go-pdk v1.1.3
+	go.starlark.net v0.0.0-20260210143700-b62fd896b91b
+	go.yaml.in/yaml/v3 v3.0.4
+)
+
+require golang.org/x/sys v0.0.0-20220715151400-c0bba94af5f8 // indirect
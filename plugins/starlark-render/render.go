@@ -0,0 +1,658 @@
+// Package main implements a render/v1 plugin for Starlark.
+//
+// Every templates/*.star source file whose name does not start with an
+// underscore is executed with the chart's values, release, chart and
+// capabilities predeclared as frozen dicts, and its main() function is
+// called. The result, a Kubernetes object (a dict with a kind), a list of
+// them, or a dict whose values hold them, is rendered as a YAML file of the
+// same name with the extension .yaml. Scripts can load() other Starlark files
+// from the chart, e.g. templates/_helpers.star, and encode or decode YAML and
+// JSON with encode_yaml, decode_yaml and the json module.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	starlarkjson "go.starlark.net/lib/json"
+	"go.starlark.net/starlark"
+	"go.starlark.net/syntax"
+	"go.yaml.in/yaml/v3"
+)
+
+// maxRenderedSize bounds the output of a single template.
+const maxRenderedSize = 16 << 20
+
+// maxSteps bounds the computation of a single template, including the files
+// it loads, so a runaway loop fails the render, after about 5s in Wasm,
+// instead of hanging Helm. Rendering the example chart takes under 1,000.
+const maxSteps = 10_000_000
+
+// maxDepth bounds the nesting of values converted to and from YAML, which
+// also stops at lists that contain themselves.
+const maxDepth = 100
+
+// maxDecodedNodes bounds the values decode_yaml builds, since YAML aliases
+// can expand a small document into a very large value.
+const maxDecodedNodes = 1 << 20
+
+// fileOptions are the Starlark dialect options: sets, while loops and
+// if/for/while at top level are allowed. Recursion is not, and globals cannot
+// be reassigned.
+var fileOptions = &syntax.FileOptions{
+	Set:             true,
+	While:           true,
+	TopLevelControl: true,
+}
+
+// ReleaseInfo contains release metadata passed to render plugins.
+type ReleaseInfo struct {
+	Name      string `json:"name"`
+	Namespace string `json:"namespace"`
+	Revision  int    `json:"revision"`
+	IsInstall bool   `json:"isInstall"`
+	IsUpgrade bool   `json:"isUpgrade"`
+	Service   string `json:"service"`
+}
+
+// ChartInfo contains chart metadata passed to render plugins.
+type ChartInfo struct {
+	Name        string `json:"name"`
+	Version     string `json:"version"`
+	AppVersion  string `json:"appVersion,omitempty"`
+	Description string `json:"description,omitempty"`
+	Type        string `json:"type,omitempty"`
+	IsRoot      bool   `json:"isRoot"`
+}
+
+// CapabilitiesInfo contains Kubernetes cluster capabilities.
+type CapabilitiesInfo struct {
+	KubeVersion map[string]interface{} `json:"kubeVersion"`
+	APIVersions []string               `json:"apiVersions"`
+	HelmVersion string                 `json:"helmVersion"`
+}
+
+// SourceFile represents a file in the chart.
+type SourceFile struct {
+	Name string `json:"name"`
+	Data []byte `json:"data"`
+}
+
+// InputMessageRenderV1 is the input message for render/v1 plugins.
+type InputMessageRenderV1 struct {
+	Release      ReleaseInfo            `json:"release"`
+	Values       map[string]interface{} `json:"values"`
+	Chart        ChartInfo              `json:"chart"`
+	Files        []SourceFile           `json:"files"`
+	Capabilities CapabilitiesInfo       `json:"capabilities"`
+	SourceFiles  []SourceFile           `json:"sourceFiles"`
+}
+
+// OutputMessageRenderV1 is the output message from render/v1 plugins.
+type OutputMessageRenderV1 struct {
+	RenderedFiles map[string]string `json:"renderedFiles"`
+	Errors        []string          `json:"errors,omitempty"`
+}
+
+// render executes the scripts of a raw render/v1 input message and returns
+// the output message along with the exit code the plugin returns to Helm.
+func render(inputBytes []byte) (OutputMessageRenderV1, uint32) {
+	if len(inputBytes) == 0 {
+		return errorOutput("no input provided")
+	}
+
+	var input InputMessageRenderV1
+	if err := json.Unmarshal(inputBytes, &input); err != nil {
+		return errorOutput(fmt.Sprintf("failed to parse input: %v", err))
+	}
+
+	logf("Received %d source files", len(input.SourceFiles))
+
+	predeclared, err := predeclaredNames(&input)
+	if err != nil {
+		return errorOutput(err.Error())
+	}
+	ld := newLoader(input.SourceFiles, input.Files, predeclared)
+
+	output := OutputMessageRenderV1{
+		RenderedFiles: make(map[string]string),
+	}
+	for _, file := range input.SourceFiles {
+		if !isScript(file.Name) {
+			continue
+		}
+		logf("Executing script: %s", file.Name)
+		result, err := ld.run(file.Name, file.Data)
+		if err != nil {
+			output.Errors = append(output.Errors, err.Error())
+			continue
+		}
+		rendered, err := manifests(result)
+		if err != nil {
+			output.Errors = append(output.Errors, fmt.Sprintf("%s: %v", file.Name, err))
+			continue
+		}
+		if rendered == "" {
+			continue
+		}
+		output.RenderedFiles[strings.TrimSuffix(file.Name, ".star")+".yaml"] = rendered
+	}
+
+	// Helm treats a non-zero exit code as the signal that Errors is set
+	if len(output.Errors) > 0 {
+		return output, 1
+	}
+	return output, 0
+}
+
+// isScript reports whether name is a script to render rather than a library
+// for other scripts to load: a .star file not starting with an underscore.
+func isScript(name string) bool {
+	return strings.HasSuffix(name, ".star") && !strings.HasPrefix(path.Base(name), "_")
+}
+
+// predeclaredNames returns the names predeclared in every script: the input
+// fields, as frozen dicts, and the YAML and JSON helpers.
+func predeclaredNames(input *InputMessageRenderV1) (starlark.StringDict, error) {
+	values := input.Values
+	if values == nil {
+		values = map[string]interface{}{}
+	}
+	predeclared := starlark.StringDict{
+		"json":        starlarkjson.Module,
+		"encode_yaml": starlark.NewBuiltin("encode_yaml", encodeYAML),
+		"decode_yaml": starlark.NewBuiltin("decode_yaml", decodeYAML),
+	}
+	for name, field := range map[string]interface{}{
+		"values":       values,
+		"release":      input.Release,
+		"chart":        input.Chart,
+		"capabilities": input.Capabilities,
+	} {
+		v, err := fromJSON(field)
+		if err != nil {
+			return nil, fmt.Errorf("failed to convert %s: %v", name, err)
+		}
+		v.Freeze()
+		predeclared[name] = v
+	}
+	return predeclared, nil
+}
+
+// fromJSON converts v, through its JSON encoding, to a Starlark value. Dict
+// keys are sorted, and whole numbers become ints.
+func fromJSON(v interface{}) (starlark.Value, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var decoded interface{}
+	if err := dec.Decode(&decoded); err != nil {
+		return nil, err
+	}
+	return fromGo(decoded), nil
+}
+
+// fromGo converts a value decoded from JSON with UseNumber to Starlark.
+func fromGo(v interface{}) starlark.Value {
+	switch v := v.(type) {
+	case nil:
+		return starlark.None
+	case bool:
+		return starlark.Bool(v)
+	case string:
+		return starlark.String(v)
+	case json.Number:
+		return number(v.String())
+	case []interface{}:
+		items := make([]starlark.Value, len(v))
+		for i, item := range v {
+			items[i] = fromGo(item)
+		}
+		return starlark.NewList(items)
+	case map[string]interface{}:
+		// encoding/json marshals map keys in sorted order, but decoding
+		// into a map loses it, so the keys are sorted again.
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		d := starlark.NewDict(len(v))
+		for _, k := range keys {
+			_ = d.SetKey(starlark.String(k), fromGo(v[k]))
+		}
+		return d
+	}
+	return starlark.None
+}
+
+// number converts the text of a JSON or YAML number to a Starlark int if it
+// is a whole number, else to a float.
+func number(s string) starlark.Value {
+	if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return starlark.MakeInt64(i)
+	}
+	if !strings.ContainsAny(s, ".eE") {
+		if i, ok := new(big.Int).SetString(s, 10); ok {
+			return starlark.MakeBigInt(i)
+		}
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return starlark.String(s)
+	}
+	return starlark.Float(f)
+}
+
+// loader executes scripts and the files they load. Loaded files are executed
+// once per render and their globals frozen, so scripts share them.
+type loader struct {
+	files       map[string][]byte
+	predeclared starlark.StringDict
+	modules     map[string]*module
+}
+
+// module is a loaded file: its globals, or the error executing it. A nil
+// module in loader.modules marks a file that is being loaded.
+type module struct {
+	globals starlark.StringDict
+	err     error
+}
+
+func newLoader(sourceFiles, files []SourceFile, predeclared starlark.StringDict) *loader {
+	ld := &loader{
+		files:       make(map[string][]byte, len(sourceFiles)+len(files)),
+		predeclared: predeclared,
+		modules:     make(map[string]*module),
+	}
+	for _, list := range [][]SourceFile{files, sourceFiles} {
+		for _, f := range list {
+			ld.files[path.Clean(f.Name)] = f.Data
+		}
+	}
+	return ld
+}
+
+// run executes the script name and calls its main function, returning the
+// result.
+func (ld *loader) run(name string, src []byte) (starlark.Value, error) {
+	thread := ld.newThread(name)
+	globals, err := starlark.ExecFileOptions(fileOptions, thread, name, src, ld.predeclared)
+	if err != nil {
+		return nil, starlarkError(err)
+	}
+	fn, ok := globals["main"].(starlark.Callable)
+	if !ok {
+		return nil, fmt.Errorf("%s: the script does not define a main function", name)
+	}
+	result, err := starlark.Call(thread, fn, nil, nil)
+	if err != nil {
+		return nil, starlarkError(err)
+	}
+	return result, nil
+}
+
+// newThread returns a thread for executing the script name, with the step
+// budget, print sent to the log, and load resolving chart files.
+func (ld *loader) newThread(name string) *starlark.Thread {
+	thread := &starlark.Thread{
+		Name: name,
+		Print: func(thread *starlark.Thread, msg string) {
+			logf("%s: %s", thread.CallFrame(1).Pos, msg)
+		},
+		Load: ld.load,
+	}
+	thread.SetMaxExecutionSteps(maxSteps)
+	return thread
+}
+
+// load implements starlark.Thread.Load. A module starting with // is a path
+// from the chart root; any other is relative to the loading file.
+func (ld *loader) load(thread *starlark.Thread, name string) (starlark.StringDict, error) {
+	var file string
+	switch {
+	case strings.HasPrefix(name, "//"):
+		file = path.Clean(name[2:])
+	case path.IsAbs(name):
+		return nil, errors.New("absolute paths are not allowed")
+	default:
+		file = path.Join(path.Dir(thread.CallFrame(0).Pos.Filename()), name)
+	}
+
+	m, ok := ld.modules[file]
+	if ok && m == nil {
+		return nil, fmt.Errorf("cycle in load graph at %s", file)
+	}
+	if ok {
+		return m.globals, m.err
+	}
+	src, ok := ld.files[file]
+	if !ok {
+		return nil, fmt.Errorf("%s not found in the chart", file)
+	}
+
+	logf("Loading %s", file)
+	ld.modules[file] = nil
+	globals, err := starlark.ExecFileOptions(fileOptions, thread, file, src, ld.predeclared)
+	if err == nil {
+		globals.Freeze()
+	}
+	ld.modules[file] = &module{globals: globals, err: err}
+	return globals, err
+}
+
+// starlarkError returns err with the position of the failing code, for
+// errors raised while executing a script.
+func starlarkError(err error) error {
+	var evalErr *starlark.EvalError
+	if !errors.As(err, &evalErr) {
+		return err
+	}
+	for i := 0; i < len(evalErr.CallStack); i++ {
+		frame := evalErr.CallStack.At(i)
+		if frame.Pos.Filename() == "<builtin>" {
+			continue
+		}
+		return fmt.Errorf("%s: %s", frame.Pos, evalErr.Msg)
+	}
+	return errors.New(evalErr.Msg)
+}
+
+// manifests converts a script's result into YAML documents. The value must
+// be a Kubernetes object (a dict with a kind), None, or a list, tuple or dict
+// of such values, nested to any depth; dict entries are rendered in
+// insertion order.
+func manifests(result starlark.Value) (string, error) {
+	var objects []*yaml.Node
+	if err := collectObjects(result, "", 0, &objects); err != nil {
+		return "", err
+	}
+
+	var buf bytes.Buffer
+	for i, obj := range objects {
+		if i > 0 {
+			buf.WriteString("---\n")
+		}
+		if err := encode(&buf, obj); err != nil {
+			return "", err
+		}
+		if buf.Len() > maxRenderedSize {
+			return "", fmt.Errorf("rendered output exceeds %d bytes", maxRenderedSize)
+		}
+	}
+	return buf.String(), nil
+}
+
+// collectObjects appends the Kubernetes objects in v, converted to YAML, to
+// objects. at is the path to v in the result, for error messages.
+func collectObjects(v starlark.Value, at string, depth int, objects *[]*yaml.Node) error {
+	if depth > maxDepth {
+		return fmt.Errorf("%s is nested more than %d levels deep", resultPath(at), maxDepth)
+	}
+	switch v := v.(type) {
+	case starlark.NoneType:
+		return nil
+	case *starlark.List, starlark.Tuple:
+		iter := starlark.Iterate(v)
+		defer iter.Done()
+		var item starlark.Value
+		for i := 0; iter.Next(&item); i++ {
+			if err := collectObjects(item, fmt.Sprintf("%s[%d]", at, i), depth+1, objects); err != nil {
+				return err
+			}
+		}
+		return nil
+	case *starlark.Dict:
+		if _, found, _ := v.Get(starlark.String("kind")); found {
+			n, err := toNode(v, resultPath(at), depth)
+			if err != nil {
+				return err
+			}
+			*objects = append(*objects, n)
+			return nil
+		}
+		for _, item := range v.Items() {
+			key, ok := starlark.AsString(item[0])
+			if !ok {
+				key = item[0].String()
+			}
+			if err := collectObjects(item[1], at+"."+key, depth+1, objects); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return fmt.Errorf("%s is not a Kubernetes object, list or dict", resultPath(at))
+}
+
+// resultPath returns at, a path built by collectObjects, for error messages.
+func resultPath(at string) string {
+	if at == "" {
+		return "result"
+	}
+	return strings.TrimPrefix(at, ".")
+}
+
+// toNode converts a Starlark value to a YAML node. Dicts must have string
+// keys and keep their insertion order; at is the path to v, for error
+// messages.
+func toNode(v starlark.Value, at string, depth int) (*yaml.Node, error) {
+	if depth > maxDepth {
+		return nil, fmt.Errorf("%s is nested more than %d levels deep", at, maxDepth)
+	}
+	switch v := v.(type) {
+	case starlark.NoneType:
+		return scalarNode("!!null", "null"), nil
+	case starlark.Bool:
+		return scalarNode("!!bool", strconv.FormatBool(bool(v))), nil
+	case starlark.Int:
+		return scalarNode("!!int", v.String()), nil
+	case starlark.Float:
+		f := float64(v)
+		if math.IsInf(f, 0) || math.IsNaN(f) {
+			return nil, fmt.Errorf("%s: cannot encode %s", at, v)
+		}
+		return scalarNode("!!float", strconv.FormatFloat(f, 'g', -1, 64)), nil
+	case starlark.String:
+		return scalarNode("!!str", string(v)), nil
+	case *starlark.List, starlark.Tuple:
+		n := &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq"}
+		iter := starlark.Iterate(v)
+		defer iter.Done()
+		var item starlark.Value
+		for i := 0; iter.Next(&item); i++ {
+			c, err := toNode(item, fmt.Sprintf("%s[%d]", at, i), depth+1)
+			if err != nil {
+				return nil, err
+			}
+			n.Content = append(n.Content, c)
+		}
+		return n, nil
+	case *starlark.Dict:
+		n := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+		for _, item := range v.Items() {
+			key, ok := item[0].(starlark.String)
+			if !ok {
+				return nil, fmt.Errorf("%s: dict key %s is not a string", at, item[0])
+			}
+			k := scalarNode("!!str", string(key))
+			c, err := toNode(item[1], at+"."+string(key), depth+1)
+			if err != nil {
+				return nil, err
+			}
+			n.Content = append(n.Content, k, c)
+		}
+		return n, nil
+	}
+	return nil, fmt.Errorf("%s: cannot encode a %s as YAML", at, v.Type())
+}
+
+// scalarNode returns the YAML node for a scalar tag and value. Strings that
+// would read back as another type are quoted by the encoder, and those that
+// YAML 1.1 parsers, such as Kubernetes', read as booleans are quoted here, as
+// yaml.Marshal does; multiline ones are written in literal style.
+func scalarNode(tag, value string) *yaml.Node {
+	n := &yaml.Node{Kind: yaml.ScalarNode, Tag: tag, Value: value}
+	switch {
+	case tag != "!!str":
+	case strings.Contains(value, "\n"):
+		n.Style = yaml.LiteralStyle
+	case yaml11Bools[value]:
+		n.Style = yaml.DoubleQuotedStyle
+	}
+	return n
+}
+
+// yaml11Bools are the strings YAML 1.1 reads as booleans but YAML 1.2 does
+// not.
+var yaml11Bools = map[string]bool{
+	"y": true, "Y": true, "yes": true, "Yes": true, "YES": true,
+	"n": true, "N": true, "no": true, "No": true, "NO": true,
+	"on": true, "On": true, "ON": true, "off": true, "Off": true, "OFF": true,
+}
+
+// encode writes n as a YAML document to buf.
+func encode(buf *bytes.Buffer, n *yaml.Node) error {
+	enc := yaml.NewEncoder(buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(n); err != nil {
+		return err
+	}
+	return enc.Close()
+}
+
+// encodeYAML implements encode_yaml(x), which returns x as a YAML document.
+func encodeYAML(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var x starlark.Value
+	if err := starlark.UnpackPositionalArgs(fn.Name(), args, kwargs, 1, &x); err != nil {
+		return nil, err
+	}
+	n, err := toNode(x, "x", 0)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", fn.Name(), err)
+	}
+	var buf bytes.Buffer
+	if err := encode(&buf, n); err != nil {
+		return nil, fmt.Errorf("%s: %v", fn.Name(), err)
+	}
+	return starlark.String(buf.String()), nil
+}
+
+// decodeYAML implements decode_yaml(s), which returns the value of the YAML
+// document s, with mappings as dicts in document order.
+func decodeYAML(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var s string
+	if err := starlark.UnpackPositionalArgs(fn.Name(), args, kwargs, 1, &s); err != nil {
+		return nil, err
+	}
+	var doc yaml.Node
+	if err := yaml.Unmarshal([]byte(s), &doc); err != nil {
+		return nil, fmt.Errorf("%s: %v", fn.Name(), err)
+	}
+	if len(doc.Content) == 0 {
+		return starlark.None, nil
+	}
+	d := &decoder{}
+	v, err := d.value(doc.Content[0], 0)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", fn.Name(), err)
+	}
+	return v, nil
+}
+
+// decoder converts YAML nodes to Starlark values, counting the values built
+// against maxDecodedNodes.
+type decoder struct {
+	nodes int
+}
+
+func (d *decoder) value(n *yaml.Node, depth int) (starlark.Value, error) {
+	d.nodes++
+	if d.nodes > maxDecodedNodes {
+		return nil, fmt.Errorf("document expands to more than %d values", maxDecodedNodes)
+	}
+	if depth > maxDepth {
+		return nil, fmt.Errorf("document is nested more than %d levels deep", maxDepth)
+	}
+	switch n.Kind {
+	case yaml.AliasNode:
+		return d.value(n.Alias, depth+1)
+	case yaml.SequenceNode:
+		items := make([]starlark.Value, 0, len(n.Content))
+		for _, c := range n.Content {
+			item, err := d.value(c, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, item)
+		}
+		return starlark.NewList(items), nil
+	case yaml.MappingNode:
+		dict := starlark.NewDict(len(n.Content) / 2)
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			key, err := d.value(n.Content[i], depth+1)
+			if err != nil {
+				return nil, err
+			}
+			val, err := d.value(n.Content[i+1], depth+1)
+			if err != nil {
+				return nil, err
+			}
+			if err := dict.SetKey(key, val); err != nil {
+				return nil, fmt.Errorf("line %d: %v", n.Content[i].Line, err)
+			}
+		}
+		return dict, nil
+	}
+
+	switch n.ShortTag() {
+	case "!!null":
+		return starlark.None, nil
+	case "!!bool":
+		var b bool
+		if err := n.Decode(&b); err != nil {
+			return nil, err
+		}
+		return starlark.Bool(b), nil
+	case "!!int":
+		var i int64
+		if err := n.Decode(&i); err != nil {
+			return number(n.Value), nil
+		}
+		return starlark.MakeInt64(i), nil
+	case "!!float":
+		var f float64
+		if err := n.Decode(&f); err != nil {
+			return nil, err
+		}
+		return starlark.Float(f), nil
+	}
+	return starlark.String(n.Value), nil
+}
+
+// errorOutput returns an output message reporting msg, with a failing exit
+// code.
+func errorOutput(msg string) (OutputMessageRenderV1, uint32) {
+	return OutputMessageRenderV1{
+		RenderedFiles: make(map[string]string),
+		Errors:        []string{msg},
+	}, 1
+}
+
+// logf logs a debug message. main.go sends it to Helm when built as a
+// plugin; natively, e.g. in tests, it is discarded.
+var logf = func(format string, args ...interface{}) {}
+
+// main is empty: Helm calls the helm_plugin_main export defined in main.go.
+func main() {}
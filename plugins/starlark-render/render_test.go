@@ -0,0 +1,243 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestRender(t *testing.T) {
+	file := func(name, data string) SourceFile {
+		return SourceFile{Name: name, Data: []byte(data)}
+	}
+	tests := []struct {
+		name       string
+		files      []SourceFile
+		chartFiles []SourceFile
+		raw        string
+		want       map[string]string
+		wantErrors []string
+	}{
+		{
+			name: "object with predeclared names",
+			files: []SourceFile{file("templates/cm.star", `def main():
+    return {
+        "apiVersion": "v1",
+        "kind": "ConfigMap",
+        "metadata": {"name": release["name"], "namespace": release["namespace"]},
+        "data": {
+            "replicas": str(values["replicas"]),
+            "chart": chart["name"],
+            "kube": capabilities["kubeVersion"]["version"],
+            "flag": "true",
+            "script": "line 1\nline 2\n",
+        },
+    }
+`)},
+			want: map[string]string{"templates/cm.yaml": `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: web
+  namespace: prod
+data:
+  replicas: "3"
+  chart: demo
+  kube: v1.35.0
+  flag: "true"
+  script: |
+    line 1
+    line 2
+`},
+		},
+		{
+			name: "loops, loads and nested results",
+			files: []SourceFile{
+				file("templates/lib/_k.star", `def cm(name, data = None):
+    obj = {"apiVersion": "v1", "kind": "ConfigMap", "metadata": {"name": name}}
+    if data:
+        obj["data"] = data
+    return obj
+`),
+				file("templates/app/all.star", `load("../lib/_k.star", "cm")
+load("//config/_shared.star", "prefix")
+
+names = [prefix + str(i) for i in range(2)]
+
+def main():
+    return {
+        "b": [cm(n) for n in names] + [None, [cm("b2")]],
+        "a": cm("a", {"conf": encode_yaml({"x": 1, "y": [True, None]})}),
+    }
+`),
+			},
+			chartFiles: []SourceFile{file("config/_shared.star", `prefix = "b-"`)},
+			want: map[string]string{"templates/app/all.yaml": `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: b-0
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: b-1
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: b2
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: a
+data:
+  conf: |
+    x: 1
+    "y":
+      - true
+      - null
+`},
+		},
+		{
+			name: "decode_yaml and json",
+			files: []SourceFile{file("templates/a.star", `def main():
+    doc = decode_yaml("kind: X\nb: &b [1, 2.5, yes, '3']\na: *b\n")
+    doc["j"] = json.encode(json.decode('{"z": 1}'))
+    return doc
+`)},
+			want: map[string]string{"templates/a.yaml": `kind: X
+b:
+  - 1
+  - 2.5
+  - "yes"
+  - "3"
+a:
+  - 1
+  - 2.5
+  - "yes"
+  - "3"
+j: '{"z":1}'
+`},
+		},
+		{
+			name:  "None renders nothing and libraries are not rendered",
+			files: []SourceFile{file("templates/empty.star", "def main():\n    return {\"kind\": \"X\"} if values[\"enabled\"] else None\n"), file("templates/_lib.star", "x = 1\n")},
+			want:  map[string]string{},
+		},
+		{
+			name: "scalar result",
+			files: []SourceFile{file("templates/bad.star", `def main():
+    return {"items": [{"kind": "X"}, "oops"]}
+`)},
+			wantErrors: []string{"templates/bad.star: items[1] is not a Kubernetes object, list or dict"},
+		},
+		{
+			name: "unencodable value",
+			files: []SourceFile{file("templates/bad.star", `def main():
+    return {"kind": "X", "data": {1: "a"}, "f": main}
+`)},
+			wantErrors: []string{"templates/bad.star: result.data: dict key 1 is not a string"},
+		},
+		{
+			name:       "fail and frozen values",
+			files:      []SourceFile{file("templates/err.star", "def main():\n    fail(\"boom\")\n"), file("templates/frozen.star", "def main():\n    values[\"x\"] = 1\n"), file("templates/ok.star", "def main():\n    return {\"kind\": \"X\"}\n")},
+			want:       map[string]string{"templates/ok.yaml": "kind: X\n"},
+			wantErrors: []string{"templates/err.star:2:9: fail: boom", "templates/frozen.star:2:11: cannot insert into frozen hash table"},
+		},
+		{
+			name:       "syntax error",
+			files:      []SourceFile{file("templates/a.star", "def main(:\n")},
+			wantErrors: []string{"templates/a.star:1:11: got ':', want ')'"},
+		},
+		{
+			name:       "no main",
+			files:      []SourceFile{file("templates/a.star", "x = 1\n")},
+			wantErrors: []string{"templates/a.star: the script does not define a main function"},
+		},
+		{
+			name:       "missing load",
+			files:      []SourceFile{file("templates/a.star", `load("nope.star", "x")`)},
+			wantErrors: []string{"cannot load nope.star: templates/nope.star not found in the chart"},
+		},
+		{
+			name:       "absolute load",
+			files:      []SourceFile{file("templates/a.star", `load("/etc/passwd", "x")`)},
+			wantErrors: []string{"absolute paths are not allowed"},
+		},
+		{
+			name:       "load cycle",
+			files:      []SourceFile{file("templates/a.star", `load("_b.star", "x")`), file("templates/_b.star", `load("a.star", "x")`)},
+			wantErrors: []string{"cycle in load graph at templates/_b.star"},
+		},
+		{
+			name:       "step limit",
+			files:      []SourceFile{file("templates/a.star", "while True:\n    pass\n")},
+			wantErrors: []string{"too many steps"},
+		},
+		{
+			name:       "recursion",
+			files:      []SourceFile{file("templates/a.star", "def f(x):\n    return f(x)\n\ndef main():\n    return f(0)\n")},
+			wantErrors: []string{"function f called recursively"},
+		},
+		{
+			name:       "self-referencing list",
+			files:      []SourceFile{file("templates/a.star", "def main():\n    l = [{\"kind\": \"X\"}]\n    l.append(l)\n    return l\n")},
+			wantErrors: []string{"is nested more than 100 levels deep"},
+		},
+		{
+			name:       "malformed JSON",
+			raw:        `{"values": []}`,
+			wantErrors: []string{"failed to parse input"},
+		},
+		{
+			name:       "empty input",
+			raw:        ``,
+			wantErrors: []string{"no input provided"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			input := []byte(tt.raw)
+			if tt.files != nil {
+				var err error
+				input, err = json.Marshal(InputMessageRenderV1{
+					Release:      ReleaseInfo{Name: "web", Namespace: "prod"},
+					Values:       map[string]interface{}{"replicas": 3, "enabled": false},
+					Chart:        ChartInfo{Name: "demo", Version: "0.1.0"},
+					Capabilities: CapabilitiesInfo{KubeVersion: map[string]interface{}{"version": "v1.35.0"}},
+					Files:        tt.chartFiles,
+					SourceFiles:  tt.files,
+				})
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			output, code := render(input)
+			if (code != 0) != (len(tt.wantErrors) > 0) {
+				t.Errorf("exit code = %d, errors %q", code, output.Errors)
+			}
+			if tt.want != nil {
+				if len(output.RenderedFiles) != len(tt.want) {
+					t.Errorf("rendered %d files, want %d: %v", len(output.RenderedFiles), len(tt.want), output.RenderedFiles)
+				}
+				for name, want := range tt.want {
+					if got := output.RenderedFiles[name]; got != want {
+						t.Errorf("%s =\n%s\nwant\n%s", name, got, want)
+					}
+				}
+			}
+
+			errs := strings.Join(output.Errors, "\n")
+			if len(tt.wantErrors) == 0 && errs != "" {
+				t.Errorf("unexpected errors: %s", errs)
+			}
+			for _, want := range tt.wantErrors {
+				if !strings.Contains(errs, want) {
+					t.Errorf("errors %q do not contain %q", errs, want)
+				}
+			}
+		})
+	}
+}
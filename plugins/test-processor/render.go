@@ -0,0 +1,169 @@
+// Package main implements a test render/v1 plugin that processes .test files
+// and reports what files it received. This is used to verify that the
+// sourcefiles-modifier plugin correctly modified the SourceFiles, and that the
+// files it rendered reach this plugin as RenderedFiles, which it replaces or
+// removes.
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// removeMarker marks a file rendered by an earlier plugin that test-processor
+// removes from the rendered output. Other rendered files it receives are
+// replaced with a copy headed by seenHeader.
+const (
+	removeMarker = "# test-processor should REMOVE this file"
+	seenHeader   = "# Seen by test-processor plugin\n"
+)
+
+// SourceFile represents a file in the chart.
+type SourceFile struct {
+	Name string `json:"name"`
+	Data []byte `json:"data"`
+}
+
+// InputMessageRenderV1 is the input message for render/v1 plugins.
+type InputMessageRenderV1 struct {
+	Release      map[string]interface{} `json:"release"`
+	Values       map[string]interface{} `json:"values"`
+	Chart        map[string]interface{} `json:"chart"`
+	Subcharts    map[string]interface{} `json:"subcharts"`
+	Files        []SourceFile           `json:"files"`
+	Capabilities map[string]interface{} `json:"capabilities"`
+	SourceFiles  []SourceFile           `json:"sourceFiles"`
+	// RenderedFiles are the files rendered by the plugins before this one.
+	RenderedFiles map[string]string `json:"renderedFiles"`
+}
+
+// OutputMessageRenderV1 is the output message from render/v1 plugins.
+type OutputMessageRenderV1 struct {
+	RenderedFiles        map[string]string `json:"renderedFiles"`
+	ModifiedSourceFiles  []SourceFile      `json:"modifiedSourceFiles,omitempty"`
+	RemovedRenderedFiles []string          `json:"removedRenderedFiles,omitempty"`
+	Errors               []string          `json:"errors,omitempty"`
+}
+
+// render renders a ConfigMap for each source file of a raw input message,
+// replaces or removes the files rendered before it, and returns the output
+// message along with the exit code the plugin returns to Helm.
+func render(inputBytes []byte) (OutputMessageRenderV1, uint32) {
+	// Parse the input message
+	var input InputMessageRenderV1
+	if err := json.Unmarshal(inputBytes, &input); err != nil {
+		return errorOutput(fmt.Sprintf("failed to parse input: %v", err))
+	}
+
+	logf("test-processor received %d source files", len(input.SourceFiles))
+
+	output := OutputMessageRenderV1{
+		RenderedFiles: make(map[string]string),
+	}
+
+	// Process each source file and render it
+	var fileList []string
+	for _, file := range input.SourceFiles {
+		logf("Processing file: %s", file.Name)
+		fileList = append(fileList, file.Name)
+
+		// Render each file as a ConfigMap showing what we received
+		outputName := strings.TrimSuffix(file.Name, ".test") + ".yaml"
+		content := fmt.Sprintf(`# Rendered by test-processor plugin
+# Original file: %s
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: %s
+data:
+  originalContent: |
+%s
+`, file.Name, sanitizeName(file.Name), indentContent(string(file.Data)))
+		output.RenderedFiles[outputName] = content
+	}
+
+	// Replace or remove each file rendered by the previous plugins
+	renderedNames := make([]string, 0, len(input.RenderedFiles))
+	for name := range input.RenderedFiles {
+		renderedNames = append(renderedNames, name)
+	}
+	sort.Strings(renderedNames)
+	var renderedList []string
+	for _, name := range renderedNames {
+		content := input.RenderedFiles[name]
+		if strings.Contains(content, removeMarker) {
+			logf("Removing rendered file: %s", name)
+			output.RemovedRenderedFiles = append(output.RemovedRenderedFiles, name)
+			renderedList = append(renderedList, name+" (removed)")
+			continue
+		}
+		logf("Replacing rendered file: %s", name)
+		output.RenderedFiles[name] = seenHeader + content
+		renderedList = append(renderedList, name+" (replaced)")
+	}
+
+	// Create a summary ConfigMap
+	summaryContent := fmt.Sprintf(`# Test Processor Plugin Summary
+# Documents what files were received from the previous plugin
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: test-processor-summary
+data:
+  filesReceived: "%d"
+  fileList: |
+%s
+  renderedFilesReceived: "%d"
+  renderedFileList: |
+%s
+`, len(input.SourceFiles), formatFileList(fileList), len(input.RenderedFiles), formatFileList(renderedList))
+
+	output.RenderedFiles["templates/test-processor-summary.yaml"] = summaryContent
+
+	return output, 0
+}
+
+func sanitizeName(name string) string {
+	// Convert path to a valid k8s name
+	name = strings.ReplaceAll(name, "/", "-")
+	name = strings.ReplaceAll(name, ".", "-")
+	name = strings.TrimPrefix(name, "templates-")
+	return name
+}
+
+func indentContent(content string) string {
+	lines := strings.Split(content, "\n")
+	var sb strings.Builder
+	for _, line := range lines {
+		sb.WriteString("    ")
+		sb.WriteString(line)
+		sb.WriteString("\n")
+	}
+	return sb.String()
+}
+
+func formatFileList(files []string) string {
+	var sb strings.Builder
+	for _, f := range files {
+		sb.WriteString("    - ")
+		sb.WriteString(f)
+		sb.WriteString("\n")
+	}
+	return sb.String()
+}
+
+func errorOutput(msg string) (OutputMessageRenderV1, uint32) {
+	return OutputMessageRenderV1{
+		RenderedFiles: make(map[string]string),
+		Errors:        []string{msg},
+	}, 1
+}
+
+// logf logs a debug message. main.go sends it to Helm when built as a
+// plugin; natively, e.g. in tests, it is discarded.
+var logf = func(format string, args ...interface{}) {}
+
+// main is empty: Helm calls the helm_plugin_main export defined in main.go.
+func main() {}
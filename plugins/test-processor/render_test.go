@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestRender(t *testing.T) {
+	tests := []struct {
+		name     string
+		files    []SourceFile
+		rendered map[string]string
+		raw      string
+		wantCode uint32
+		// want maps rendered file names to content they must contain.
+		want        map[string][]string
+		wantRemoved []string
+		wantErrors  []string
+	}{
+		{
+			name: "renders a ConfigMap per file and a summary",
+			files: []SourceFile{
+				{Name: "templates/file2.test", Data: []byte("key: two\nstatus: ok")},
+				{Name: "templates/sub/file4.test", Data: []byte("key: four")},
+			},
+			want: map[string][]string{
+				"templates/file2.yaml": {
+					"# Original file: templates/file2.test",
+					"name: file2-test",
+					"  originalContent: |\n    key: two\n    status: ok\n",
+				},
+				"templates/sub/file4.yaml": {"name: sub-file4-test", "    key: four"},
+				"templates/test-processor-summary.yaml": {
+					`filesReceived: "2"`,
+					"    - templates/file2.test\n    - templates/sub/file4.test\n",
+				},
+			},
+		},
+		{
+			name:  "replaces and removes rendered files",
+			files: []SourceFile{{Name: "templates/file4.test", Data: []byte("key: four")}},
+			rendered: map[string]string{
+				"templates/summary.yaml": "kind: ConfigMap\n",
+				"templates/scratch.yaml": removeMarker + "\nkind: ConfigMap\n",
+			},
+			want: map[string][]string{
+				"templates/file4.yaml":   {"name: file4-test"},
+				"templates/summary.yaml": {"# Seen by test-processor plugin\nkind: ConfigMap\n"},
+				"templates/test-processor-summary.yaml": {
+					`renderedFilesReceived: "2"`,
+					"    - templates/scratch.yaml (removed)\n    - templates/summary.yaml (replaced)\n",
+				},
+			},
+			wantRemoved: []string{"templates/scratch.yaml"},
+		},
+		{
+			name: "no source files",
+			raw:  `{}`,
+			want: map[string][]string{
+				"templates/test-processor-summary.yaml": {`filesReceived: "0"`},
+			},
+		},
+		{
+			name:       "malformed JSON",
+			raw:        `{"sourceFiles": {}}`,
+			wantCode:   1,
+			want:       map[string][]string{},
+			wantErrors: []string{"failed to parse input"},
+		},
+		{
+			name:       "empty input",
+			raw:        ``,
+			wantCode:   1,
+			want:       map[string][]string{},
+			wantErrors: []string{"failed to parse input"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			input := []byte(tt.raw)
+			if tt.files != nil {
+				var err error
+				if input, err = json.Marshal(InputMessageRenderV1{SourceFiles: tt.files, RenderedFiles: tt.rendered}); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			output, code := render(input)
+			if code != tt.wantCode {
+				t.Errorf("exit code = %d, want %d", code, tt.wantCode)
+			}
+			if len(output.RenderedFiles) != len(tt.want) {
+				t.Errorf("rendered %d files, want %d", len(output.RenderedFiles), len(tt.want))
+			}
+			for name, substrs := range tt.want {
+				got, ok := output.RenderedFiles[name]
+				if !ok {
+					t.Errorf("missing rendered file %s", name)
+					continue
+				}
+				for _, substr := range substrs {
+					if !strings.Contains(got, substr) {
+						t.Errorf("renderedFiles[%q] does not contain %q:\n%s", name, substr, got)
+					}
+				}
+			}
+
+			if got := strings.Join(output.RemovedRenderedFiles, ","); got != strings.Join(tt.wantRemoved, ",") {
+				t.Errorf("removedRenderedFiles = %v, want %v", output.RemovedRenderedFiles, tt.wantRemoved)
+			}
+
+			errs := strings.Join(output.Errors, "\n")
+			if len(tt.wantErrors) == 0 && errs != "" {
+				t.Errorf("unexpected errors: %s", errs)
+			}
+			for _, want := range tt.wantErrors {
+				if !strings.Contains(errs, want) {
+					t.Errorf("errors %q do not contain %q", errs, want)
+				}
+			}
+		})
+	}
+}
+
+func TestSanitizeName(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"templates/file2.test", "file2-test"},
+		{"templates/sub/a.b.test", "sub-a-b-test"},
+		{"other/file.test", "other-file-test"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := sanitizeName(tt.in); got != tt.want {
+			t.Errorf("sanitizeName(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
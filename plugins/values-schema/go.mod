@@ -0,0 +1,10 @@
+module github.com/scottrigby/ref-hip-chart-defined-plugins/plugins/values-schema
+
+go 1.24.3
+
+require (
+	github.com/extism/go-pdk v1.1.3
+	github.com/santhosh-tekuri/jsonschema/v6 v6.0.3
+)
+
+require golang.org/x/text v0.14.0 // indirect
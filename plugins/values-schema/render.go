@@ -0,0 +1,235 @@
+// Package main implements a render/v1 plugin that validates a chart's values
+// against the JSON Schema in its values.schema.json.
+//
+// Charts v3 do not enforce their values schema themselves. Listed first in a
+// chart's plugins, this plugin reads the schema from the chart's files, as
+// draft 2020-12 unless it declares another $schema, and validates the
+// coalesced values against it. Every violation is reported with the JSON
+// pointer of the value at fault, and any violation fails the render before
+// the plugins after it produce a manifest. The plugin renders nothing itself.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/santhosh-tekuri/jsonschema/v6"
+	"github.com/santhosh-tekuri/jsonschema/v6/kind"
+)
+
+// defaultSchemaFile is the chart file validated against when the chart does
+// not set schemaFile. Unlike a schemaFile the chart sets, it may be missing.
+const defaultSchemaFile = "values.schema.json"
+
+// chartURL is the base URL chart files are loaded from, so that a $ref to
+// another schema file resolves relative to the referring one.
+const chartURL = "file:///chart/"
+
+// SourceFile represents a file in the chart.
+type SourceFile struct {
+	Name string `json:"name"`
+	Data []byte `json:"data"`
+}
+
+// InputMessageRenderV1 is the input message for render/v1 plugins.
+type InputMessageRenderV1 struct {
+	// Values are kept as JSON, which the jsonschema package decodes itself
+	// to keep numbers exact.
+	Values      json.RawMessage `json:"values"`
+	Files       []SourceFile    `json:"files"`
+	SourceFiles []SourceFile    `json:"sourceFiles"`
+	// RenderedFiles are the files rendered by the plugins before this one.
+	RenderedFiles map[string]string `json:"renderedFiles"`
+	Config        PluginConfig      `json:"config"`
+}
+
+// PluginConfig is the chart's configuration for this plugin, validated by
+// Helm against the configSchema in plugin.yaml.
+type PluginConfig struct {
+	SchemaFile string `json:"schemaFile"`
+}
+
+// OutputMessageRenderV1 is the output message from render/v1 plugins.
+type OutputMessageRenderV1 struct {
+	RenderedFiles map[string]string `json:"renderedFiles"`
+	Errors        []string          `json:"errors,omitempty"`
+}
+
+// render validates the values of a raw render/v1 input message against the
+// chart's schema and returns the output message along with the exit code the
+// plugin returns to Helm.
+func render(inputBytes []byte) (OutputMessageRenderV1, uint32) {
+	if len(inputBytes) == 0 {
+		return errorOutput("no input provided")
+	}
+
+	var input InputMessageRenderV1
+	if err := json.Unmarshal(inputBytes, &input); err != nil {
+		return errorOutput(fmt.Sprintf("failed to parse input: %v", err))
+	}
+
+	files := make(map[string][]byte, len(input.Files))
+	for _, f := range input.Files {
+		files[path.Clean(f.Name)] = f.Data
+	}
+	schemaFile := path.Clean(input.Config.SchemaFile)
+	if input.Config.SchemaFile == "" {
+		schemaFile = defaultSchemaFile
+	}
+	if _, ok := files[schemaFile]; !ok {
+		if input.Config.SchemaFile != "" {
+			return errorOutput(fmt.Sprintf("%s: file not found in the chart", schemaFile))
+		}
+		logf("No %s in the chart: values are not validated", schemaFile)
+		return OutputMessageRenderV1{RenderedFiles: make(map[string]string)}, 0
+	}
+	if len(input.RenderedFiles) > 0 {
+		logf("%d files were rendered before values are validated: list values-schema first", len(input.RenderedFiles))
+	}
+
+	schema, err := compileSchema(schemaFile, files)
+	if err != nil {
+		return errorOutput(fmt.Sprintf("%s: %v", schemaFile, err))
+	}
+
+	values := any(map[string]any{})
+	if v := bytes.TrimSpace(input.Values); len(v) > 0 && string(v) != "null" {
+		if values, err = jsonschema.UnmarshalJSON(bytes.NewReader(v)); err != nil {
+			return errorOutput(fmt.Sprintf("failed to parse values: %v", err))
+		}
+	}
+
+	err = schema.Validate(values)
+	var verr *jsonschema.ValidationError
+	switch {
+	case errors.As(err, &verr):
+		var errs []string
+		for _, v := range violations(verr) {
+			errs = append(errs, fmt.Sprintf("%s: %s", schemaFile, v.message))
+		}
+		return OutputMessageRenderV1{RenderedFiles: make(map[string]string), Errors: errs}, 1
+	case err != nil:
+		return errorOutput(fmt.Sprintf("%s: %v", schemaFile, trimURLs(err)))
+	}
+
+	logf("Values conform to %s", schemaFile)
+	return OutputMessageRenderV1{RenderedFiles: make(map[string]string)}, 0
+}
+
+// compileSchema compiles the schema in the chart file name. References to
+// other schema files resolve to chart files; the schema cannot load anything
+// from outside the chart.
+func compileSchema(name string, files map[string][]byte) (*jsonschema.Schema, error) {
+	doc, err := jsonschema.UnmarshalJSON(bytes.NewReader(files[name]))
+	if err != nil {
+		return nil, fmt.Errorf("not valid JSON: %v", err)
+	}
+
+	c := jsonschema.NewCompiler()
+	c.DefaultDraft(jsonschema.Draft2020)
+	c.UseLoader(chartLoader(files))
+	if err := c.AddResource(chartURL+name, doc); err != nil {
+		return nil, trimURLs(err)
+	}
+	schema, err := c.Compile(chartURL + name)
+	if err != nil {
+		return nil, trimURLs(err)
+	}
+	return schema, nil
+}
+
+// chartLoader loads the schema files a $ref points to from the chart.
+type chartLoader map[string][]byte
+
+// Load implements jsonschema.URLLoader.
+func (l chartLoader) Load(url string) (any, error) {
+	name, ok := strings.CutPrefix(url, chartURL)
+	if !ok {
+		return nil, fmt.Errorf("only chart files can be referenced")
+	}
+	data, ok := l[name]
+	if !ok {
+		return nil, fmt.Errorf("%s: file not found in the chart", name)
+	}
+	doc, err := jsonschema.UnmarshalJSON(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%s: not valid JSON: %v", name, err)
+	}
+	return doc, nil
+}
+
+// trimURLs returns err with the chart URLs in its message shortened to the
+// file names.
+func trimURLs(err error) error {
+	return errors.New(strings.ReplaceAll(err.Error(), chartURL, ""))
+}
+
+// violation is a single way the values fail the schema.
+type violation struct {
+	// pointer is the JSON pointer of the value at fault.
+	pointer string
+	// message starts with the quoted pointer, e.g.
+	// "at '/replicaCount': got string, want integer".
+	message string
+}
+
+// violations returns the violations under err, sorted by pointer. A failed
+// anyOf or oneOf is a single violation, listing why each alternative failed:
+// those failures are not violations on their own.
+func violations(err *jsonschema.ValidationError) []violation {
+	var list []violation
+	var walk func(e *jsonschema.ValidationError)
+	walk = func(e *jsonschema.ValidationError) {
+		switch e.ErrorKind.(type) {
+		case *kind.AnyOf, *kind.OneOf:
+		default:
+			if len(e.Causes) > 0 {
+				for _, cause := range e.Causes {
+					walk(cause)
+				}
+				return
+			}
+		}
+		list = append(list, violation{pointer: pointer(e.InstanceLocation), message: trimURLs(e).Error()})
+	}
+	walk(err)
+
+	sort.SliceStable(list, func(i, j int) bool {
+		if list[i].pointer != list[j].pointer {
+			return list[i].pointer < list[j].pointer
+		}
+		return list[i].message < list[j].message
+	})
+	return list
+}
+
+// pointer returns the JSON pointer made of tokens.
+func pointer(tokens []string) string {
+	var b strings.Builder
+	for _, tok := range tokens {
+		b.WriteByte('/')
+		b.WriteString(strings.NewReplacer("~", "~0", "/", "~1").Replace(tok))
+	}
+	return b.String()
+}
+
+// errorOutput returns an output message reporting msg, with a failing exit
+// code.
+func errorOutput(msg string) (OutputMessageRenderV1, uint32) {
+	return OutputMessageRenderV1{
+		RenderedFiles: make(map[string]string),
+		Errors:        []string{msg},
+	}, 1
+}
+
+// logf logs a debug message. main.go sends it to Helm when built as a
+// plugin; natively, e.g. in tests, it is discarded.
+var logf = func(format string, args ...interface{}) {}
+
+// main is empty: Helm calls the helm_plugin_main export defined in main.go.
+func main() {}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// schema is the values.schema.json the tests validate against. The image
+// schema lives in a file of its own to check that $refs resolve to chart
+// files.
+const schema = `{
+  "$schema": "https://json-schema.org/draft/2020-12/schema",
+  "type": "object",
+  "required": ["image"],
+  "additionalProperties": false,
+  "properties": {
+    "replicaCount": {"type": "integer", "minimum": 1},
+    "image": {"$ref": "schemas/image.json"},
+    "service": {
+      "type": "object",
+      "properties": {
+        "port": {"anyOf": [{"type": "integer"}, {"type": "string", "pattern": "^[a-z]+$"}]}
+      }
+    },
+    "a/b": {"type": "string"}
+  }
+}`
+
+const imageSchema = `{
+  "type": "object",
+  "required": ["repository"],
+  "properties": {
+    "repository": {"type": "string"},
+    "tag": {"type": "string"}
+  }
+}`
+
+func TestRender(t *testing.T) {
+	file := func(name, data string) SourceFile {
+		return SourceFile{Name: name, Data: []byte(data)}
+	}
+	chartFiles := []SourceFile{file("values.schema.json", schema), file("schemas/image.json", imageSchema)}
+
+	tests := []struct {
+		name   string
+		files  []SourceFile
+		values map[string]interface{}
+		config PluginConfig
+		raw    string
+		// wantViolations are the expected errors, in order, for values
+		// failing the schema.
+		wantViolations []string
+		wantErrors     []string
+	}{
+		{
+			name:   "values conform",
+			files:  chartFiles,
+			values: map[string]interface{}{"replicaCount": 3, "image": map[string]interface{}{"repository": "nginx", "tag": "1.27"}, "service": map[string]interface{}{"port": "http"}},
+		},
+		{
+			name:  "every violation with its pointer",
+			files: chartFiles,
+			values: map[string]interface{}{
+				"replicaCount": "3",
+				"image":        map[string]interface{}{"tag": 1.27},
+				"service":      map[string]interface{}{"port": "HTTP"},
+				"a/b":          1,
+				"extra":        true,
+			},
+			wantViolations: []string{
+				"values.schema.json: at '': additional properties 'extra' not allowed",
+				"values.schema.json: at '/a~1b': got number, want string",
+				"values.schema.json: at '/image': missing property 'repository'",
+				"values.schema.json: at '/image/tag': got number, want string",
+				"values.schema.json: at '/replicaCount': got string, want integer",
+				"values.schema.json: at '/service/port': 'anyOf' failed\n- at '/service/port': got string, want integer\n- at '/service/port': 'HTTP' does not match pattern '^[a-z]+$'",
+			},
+		},
+		{
+			name:           "null values",
+			raw:            `{"values": null, "files": [{"name": "values.schema.json", "data": "eyJyZXF1aXJlZCI6IFsiaW1hZ2UiXX0="}]}`,
+			wantViolations: []string{"values.schema.json: at '': missing property 'image'"},
+		},
+		{
+			name:   "no schema",
+			files:  []SourceFile{},
+			values: map[string]interface{}{"anything": 1},
+		},
+		{
+			name:       "schema file set but missing",
+			files:      []SourceFile{},
+			config:     PluginConfig{SchemaFile: "schemas/values.json"},
+			wantErrors: []string{"schemas/values.json: file not found in the chart"},
+		},
+		{
+			name:           "schema file set",
+			files:          []SourceFile{file("schemas/values.json", `{"properties": {"replicaCount": {"maximum": 5}}}`)},
+			values:         map[string]interface{}{"replicaCount": 9},
+			config:         PluginConfig{SchemaFile: "./schemas/values.json"},
+			wantViolations: []string{"schemas/values.json: at '/replicaCount': maximum: got 9, want 5"},
+		},
+		{
+			name:           "declared draft",
+			files:          []SourceFile{file("values.schema.json", `{"$schema": "http://json-schema.org/draft-07/schema#", "properties": {"args": {"items": [{"type": "string"}]}}}`)},
+			values:         map[string]interface{}{"args": []interface{}{1, 2}},
+			wantViolations: []string{"values.schema.json: at '/args/0': got number, want string"},
+		},
+		{
+			name:       "schema not JSON",
+			files:      []SourceFile{file("values.schema.json", `{"type": `)},
+			wantErrors: []string{"values.schema.json: not valid JSON"},
+		},
+		{
+			name:       "invalid schema",
+			files:      []SourceFile{file("values.schema.json", `{"type": 5}`)},
+			wantErrors: []string{`values.schema.json: "values.schema.json#" is not valid against metaschema`, "at '/type'"},
+		},
+		{
+			name:       "remote reference",
+			files:      []SourceFile{file("values.schema.json", `{"$ref": "https://example.com/values.json"}`)},
+			wantErrors: []string{"only chart files can be referenced"},
+		},
+		{
+			name:       "missing reference",
+			files:      []SourceFile{file("values.schema.json", `{"$ref": "schemas/missing.json"}`)},
+			wantErrors: []string{"schemas/missing.json: file not found in the chart"},
+		},
+		{
+			name:       "reference outside the chart",
+			files:      []SourceFile{file("values.schema.json", `{"$ref": "../../etc/schema.json"}`)},
+			wantErrors: []string{"only chart files can be referenced"},
+		},
+		{
+			name:       "wrong types",
+			raw:        `{"files": {"name": 1}}`,
+			wantErrors: []string{"failed to parse input"},
+		},
+		{
+			name:       "empty input",
+			raw:        ``,
+			wantErrors: []string{"no input provided"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			input := []byte(tt.raw)
+			if tt.files != nil {
+				var err error
+				input, err = json.Marshal(InputMessageRenderV1{
+					Values: mustJSON(t, tt.values),
+					Files:  tt.files,
+					Config: tt.config,
+				})
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			output, code := render(input)
+			wantErrors := append(tt.wantViolations, tt.wantErrors...)
+			if (code != 0) != (len(wantErrors) > 0) {
+				t.Errorf("exit code = %d, errors %q", code, output.Errors)
+			}
+			if output.RenderedFiles == nil || len(output.RenderedFiles) > 0 {
+				t.Errorf("renderedFiles = %v, want an empty map", output.RenderedFiles)
+			}
+
+			if tt.wantViolations != nil {
+				if len(output.Errors) != len(tt.wantViolations) {
+					t.Fatalf("got %d errors, want %d: %q", len(output.Errors), len(tt.wantViolations), output.Errors)
+				}
+				for i, want := range tt.wantViolations {
+					if output.Errors[i] != want {
+						t.Errorf("errors[%d] = %q, want %q", i, output.Errors[i], want)
+					}
+				}
+			}
+
+			errs := strings.Join(output.Errors, "\n")
+			if len(wantErrors) == 0 && errs != "" {
+				t.Errorf("unexpected errors: %s", errs)
+			}
+			for _, want := range tt.wantErrors {
+				if !strings.Contains(errs, want) {
+					t.Errorf("errors %q do not contain %q", errs, want)
+				}
+			}
+		})
+	}
+}
+
+func mustJSON(t *testing.T, v interface{}) json.RawMessage {
+	t.Helper()
+	if v == nil {
+		return nil
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
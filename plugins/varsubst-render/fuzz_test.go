@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// chartSeeds returns the example chart inputs recorded by plugin-run in
+// plugin-run/testdata/seeds, one per chart, each passed to configure first
+// if it is not nil.
+func chartSeeds(tb testing.TB, configure func(*InputMessageRenderV1)) [][]byte {
+	files, err := filepath.Glob(filepath.Join("..", "..", "plugin-run", "testdata", "seeds", "*.json"))
+	if err != nil || len(files) == 0 {
+		tb.Fatalf("no seeds in plugin-run/testdata/seeds: %v", err)
+	}
+
+	var seeds [][]byte
+	for _, file := range files {
+		var input InputMessageRenderV1
+		data, err := os.ReadFile(file)
+		if err == nil {
+			err = json.Unmarshal(data, &input)
+		}
+		if err != nil {
+			tb.Fatalf("%s: %v", file, err)
+		}
+		if configure != nil {
+			configure(&input)
+		}
+
+		seed, err := json.Marshal(input)
+		if err != nil {
+			tb.Fatal(err)
+		}
+		seeds = append(seeds, seed)
+	}
+	return seeds
+}
+
+func FuzzRender(f *testing.F) {
+	for _, seed := range chartSeeds(f, nil) {
+		f.Add(seed)
+	}
+	f.Add([]byte(``))
+	f.Add([]byte(`{}`))
+	f.Add([]byte(`{"sourceFiles": [{"name": "a.pkl", "data": "JHt2YWx1ZXMueH0="}], "values": {"x": {"y": null}}}`))
+	f.Add([]byte(`{"config": {"delimiters": {"left": "<", "right": ">"}}, "sourceFiles": [{"name": ".pkl", "data": "PHJlbGVhc2UubmFtZT4="}]}`))
+
+	f.Fuzz(func(t *testing.T, input []byte) {
+		output, code := render(input)
+
+		data, err := json.Marshal(output)
+		if err != nil || !json.Valid(data) {
+			t.Fatalf("output is not valid JSON: %v", err)
+		}
+		if (code != 0) != (len(output.Errors) > 0) {
+			t.Fatalf("exit code %d with errors %q", code, output.Errors)
+		}
+		for name, content := range output.RenderedFiles {
+			if len(content) > maxRenderedSize {
+				t.Fatalf("%s: rendered %d bytes, limit is %d", name, len(content), maxRenderedSize)
+			}
+		}
+	})
+}
+
+func TestRenderSizeLimit(t *testing.T) {
+	refs := strings.Repeat("${values.big}", 64)
+	input, err := json.Marshal(InputMessageRenderV1{
+		Values:      map[string]interface{}{"big": strings.Repeat("x", 1<<20)},
+		SourceFiles: []SourceFile{{Name: "templates/a.pkl", Data: []byte(refs)}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	output, code := render(input)
+	if code == 0 || !strings.Contains(strings.Join(output.Errors, "\n"), "exceeds") {
+		t.Errorf("render() = %d %q, want a size error", code, output.Errors)
+	}
+}
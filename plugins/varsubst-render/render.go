@@ -0,0 +1,229 @@
+// Package main implements a render/v1 plugin for Pkl templates.
+// This is a reference implementation that demonstrates the render/v1 interface
+// for chart-defined plugins in Helm 4.
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// ReleaseInfo contains release metadata passed to render plugins.
+type ReleaseInfo struct {
+	Name      string `json:"name"`
+	Namespace string `json:"namespace"`
+	Revision  int    `json:"revision"`
+	IsInstall bool   `json:"isInstall"`
+	IsUpgrade bool   `json:"isUpgrade"`
+	Service   string `json:"service"`
+}
+
+// ChartInfo contains chart metadata passed to render plugins.
+type ChartInfo struct {
+	Name        string `json:"name"`
+	Version     string `json:"version"`
+	AppVersion  string `json:"appVersion,omitempty"`
+	Description string `json:"description,omitempty"`
+	Type        string `json:"type,omitempty"`
+	IsRoot      bool   `json:"isRoot"`
+}
+
+// SubchartInfo contains metadata about a subchart dependency.
+type SubchartInfo struct {
+	Name    string `json:"name"`
+	Version string `json:"version,omitempty"`
+	Enabled bool   `json:"enabled"`
+}
+
+// CapabilitiesInfo contains Kubernetes cluster capabilities.
+type CapabilitiesInfo struct {
+	KubeVersion KubeVersionInfo `json:"kubeVersion"`
+	APIVersions []string        `json:"apiVersions"`
+	HelmVersion string          `json:"helmVersion"`
+}
+
+// KubeVersionInfo contains Kubernetes version information.
+type KubeVersionInfo struct {
+	Version string `json:"version"`
+	Major   string `json:"major"`
+	Minor   string `json:"minor"`
+}
+
+// SourceFile represents a file in the chart.
+type SourceFile struct {
+	Name string `json:"name"`
+	Data []byte `json:"data"`
+}
+
+// InputMessageRenderV1 is the input message for render/v1 plugins.
+type InputMessageRenderV1 struct {
+	Release      ReleaseInfo             `json:"release"`
+	Values       map[string]interface{}  `json:"values"`
+	Chart        ChartInfo               `json:"chart"`
+	Subcharts    map[string]SubchartInfo `json:"subcharts"`
+	Files        []SourceFile            `json:"files"`
+	Capabilities CapabilitiesInfo        `json:"capabilities"`
+	SourceFiles  []SourceFile            `json:"sourceFiles"`
+	Config       PluginConfig            `json:"config"`
+}
+
+// PluginConfig is the chart's configuration for this plugin, validated by
+// Helm against the configSchema in plugin.yaml.
+type PluginConfig struct {
+	Delimiters *Delimiters `json:"delimiters,omitempty"`
+}
+
+// Delimiters are the strings surrounding a variable reference.
+type Delimiters struct {
+	Left  string `json:"left"`
+	Right string `json:"right"`
+}
+
+// maxRenderedSize bounds the size of a rendered file, so that many references
+// to a large value cannot exhaust the plugin's memory.
+const maxRenderedSize = 16 << 20
+
+// errRenderedSize is returned when a file would render larger than
+// maxRenderedSize.
+var errRenderedSize = fmt.Errorf("rendered output exceeds %d bytes", maxRenderedSize)
+
+// OutputMessageRenderV1 is the output message from render/v1 plugins.
+type OutputMessageRenderV1 struct {
+	RenderedFiles map[string]string `json:"renderedFiles"`
+	Errors        []string          `json:"errors,omitempty"`
+}
+
+// render processes a raw render/v1 input message, rendering its Pkl files,
+// and returns the output message along with the exit code the plugin returns
+// to Helm.
+func render(inputBytes []byte) (OutputMessageRenderV1, uint32) {
+	// Parse the input message
+	var input InputMessageRenderV1
+	if err := json.Unmarshal(inputBytes, &input); err != nil {
+		return errorOutput(fmt.Sprintf("failed to parse input: %v", err))
+	}
+
+	ref, err := variablePattern(input.Config)
+	if err != nil {
+		return errorOutput(err.Error())
+	}
+
+	// Process each source file
+	output := OutputMessageRenderV1{
+		RenderedFiles: make(map[string]string),
+	}
+
+	for _, file := range input.SourceFiles {
+		// Only process .pkl files
+		if !strings.HasSuffix(file.Name, ".pkl") {
+			continue
+		}
+
+		// Render the Pkl file
+		rendered, err := renderPklFile(file, input, ref)
+		if err != nil {
+			output.Errors = append(output.Errors, fmt.Sprintf("error rendering %s: %v", file.Name, err))
+			continue
+		}
+
+		// Generate output filename (replace .pkl with .yaml)
+		outputName := strings.TrimSuffix(file.Name, ".pkl") + ".yaml"
+		output.RenderedFiles[outputName] = rendered
+	}
+
+	// Helm treats a non-zero exit code as the signal that Errors is set
+	if len(output.Errors) > 0 {
+		return output, 1
+	}
+	return output, 0
+}
+
+// renderPklFile renders a single Pkl file using the input context.
+// This is a simplified implementation that demonstrates the interface.
+// A full implementation would use the Pkl evaluator.
+func renderPklFile(file SourceFile, input InputMessageRenderV1, ref *regexp.Regexp) (string, error) {
+	// For this reference implementation, we'll do simple template substitution.
+	// A real implementation would use the Pkl evaluator to process the file.
+	// Replace ${release.name} with actual release name, etc.
+	// References to unknown variables are left as they are.
+	size := len(file.Data)
+	tooLarge := false
+	content := ref.ReplaceAllStringFunc(string(file.Data), func(match string) string {
+		value, ok := lookupVariable(ref.FindStringSubmatch(match)[1], input)
+		if !ok || tooLarge {
+			return match
+		}
+		if size += len(value) - len(match); size > maxRenderedSize {
+			tooLarge = true
+			return match
+		}
+		return value
+	})
+	if tooLarge {
+		return "", errRenderedSize
+	}
+
+	return content, nil
+}
+
+// variablePattern returns the pattern matching a variable reference with the
+// configured delimiters, ${ and } by default. Whitespace is allowed inside the
+// delimiters, so "{{ release.name }}" also works.
+func variablePattern(config PluginConfig) (*regexp.Regexp, error) {
+	left, right := "${", "}"
+	if d := config.Delimiters; d != nil {
+		if d.Left == "" || d.Right == "" {
+			return nil, fmt.Errorf("config.delimiters must set both left and right")
+		}
+		left, right = d.Left, d.Right
+	}
+	return regexp.Compile(regexp.QuoteMeta(left) + `\s*([A-Za-z0-9_.-]+)\s*` + regexp.QuoteMeta(right))
+}
+
+// lookupVariable resolves a variable reference such as release.name or
+// values.image.tag against the input context.
+func lookupVariable(name string, input InputMessageRenderV1) (string, bool) {
+	switch name {
+	case "release.name":
+		return input.Release.Name, true
+	case "release.namespace":
+		return input.Release.Namespace, true
+	case "chart.name":
+		return input.Chart.Name, true
+	case "chart.version":
+		return input.Chart.Version, true
+	}
+
+	path, ok := strings.CutPrefix(name, "values.")
+	if !ok {
+		return "", false
+	}
+	var value interface{} = input.Values
+	for _, key := range strings.Split(path, ".") {
+		m, ok := value.(map[string]interface{})
+		if !ok {
+			return "", false
+		}
+		if value, ok = m[key]; !ok {
+			return "", false
+		}
+	}
+	switch value.(type) {
+	case map[string]interface{}, []interface{}, nil:
+		return "", false
+	}
+	return fmt.Sprintf("%v", value), true
+}
+
+// errorOutput creates an error output and returns it with the error code.
+func errorOutput(msg string) (OutputMessageRenderV1, uint32) {
+	return OutputMessageRenderV1{
+		RenderedFiles: make(map[string]string),
+		Errors:        []string{msg},
+	}, 1
+}
+
+// main is empty: Helm calls the helm_plugin_main export defined in main.go.
+func main() {}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestRender(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    *InputMessageRenderV1
+		raw      string
+		wantCode uint32
+		// want maps rendered file names to their expected content.
+		want       map[string]string
+		wantErrors []string
+	}{
+		{
+			name: "substitutes release, chart and values",
+			input: &InputMessageRenderV1{
+				Release: ReleaseInfo{Name: "web", Namespace: "prod"},
+				Chart:   ChartInfo{Name: "app", Version: "1.0.0"},
+				Values: map[string]interface{}{
+					"replicas": 3,
+					"image":    map[string]interface{}{"tag": "2.4"},
+				},
+				SourceFiles: []SourceFile{{
+					Name: "templates/deploy.pkl",
+					Data: []byte("name: ${release.name}\nns: ${ release.namespace }\nchart: ${chart.name}-${chart.version}\nreplicas: ${values.replicas}\ntag: ${values.image.tag}\n"),
+				}},
+			},
+			want: map[string]string{
+				"templates/deploy.yaml": "name: web\nns: prod\nchart: app-1.0.0\nreplicas: 3\ntag: 2.4\n",
+			},
+		},
+		{
+			name: "leaves unknown and non-scalar references",
+			input: &InputMessageRenderV1{
+				Values: map[string]interface{}{"image": map[string]interface{}{"tag": "2.4"}},
+				SourceFiles: []SourceFile{{
+					Name: "templates/a.pkl",
+					Data: []byte("${values.image} ${values.missing} ${unknown} ${HOME}"),
+				}},
+			},
+			want: map[string]string{
+				"templates/a.yaml": "${values.image} ${values.missing} ${unknown} ${HOME}",
+			},
+		},
+		{
+			name: "custom delimiters",
+			input: &InputMessageRenderV1{
+				Release: ReleaseInfo{Name: "web"},
+				Config:  PluginConfig{Delimiters: &Delimiters{Left: "{{", Right: "}}"}},
+				SourceFiles: []SourceFile{{
+					Name: "templates/a.pkl",
+					Data: []byte("{{ release.name }} ${release.name}"),
+				}},
+			},
+			want: map[string]string{"templates/a.yaml": "web ${release.name}"},
+		},
+		{
+			name: "ignores files that are not Pkl",
+			input: &InputMessageRenderV1{
+				SourceFiles: []SourceFile{{Name: "templates/notes.txt", Data: []byte("${release.name}")}},
+			},
+			want: map[string]string{},
+		},
+		{
+			name: "empty input",
+			raw:  `{}`,
+			want: map[string]string{},
+		},
+		{
+			name:       "malformed JSON",
+			raw:        `{"sourceFiles": 42}`,
+			wantCode:   1,
+			want:       map[string]string{},
+			wantErrors: []string{"failed to parse input"},
+		},
+		{
+			name: "reports incomplete delimiters",
+			input: &InputMessageRenderV1{
+				Config: PluginConfig{Delimiters: &Delimiters{Left: "{{"}},
+			},
+			wantCode:   1,
+			want:       map[string]string{},
+			wantErrors: []string{"config.delimiters must set both left and right"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			input := []byte(tt.raw)
+			if tt.input != nil {
+				var err error
+				if input, err = json.Marshal(tt.input); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			output, code := render(input)
+			if code != tt.wantCode {
+				t.Errorf("exit code = %d, want %d", code, tt.wantCode)
+			}
+			if len(output.RenderedFiles) != len(tt.want) {
+				t.Errorf("rendered %v, want %v", output.RenderedFiles, tt.want)
+			}
+			for name, want := range tt.want {
+				if got := output.RenderedFiles[name]; got != want {
+					t.Errorf("renderedFiles[%q] = %q, want %q", name, got, want)
+				}
+			}
+			errs := strings.Join(output.Errors, "\n")
+			if len(tt.wantErrors) == 0 && errs != "" {
+				t.Errorf("unexpected errors: %s", errs)
+			}
+			for _, want := range tt.wantErrors {
+				if !strings.Contains(errs, want) {
+					t.Errorf("errors %q do not contain %q", errs, want)
+				}
+			}
+		})
+	}
+}
+
+func TestLookupVariable(t *testing.T) {
+	input := InputMessageRenderV1{
+		Values: map[string]interface{}{
+			"enabled": true,
+			"list":    []interface{}{"a"},
+			"nested":  map[string]interface{}{"key": "value"},
+			"null":    nil,
+		},
+	}
+
+	tests := []struct {
+		name   string
+		want   string
+		wantOK bool
+	}{
+		{name: "values.enabled", want: "true", wantOK: true},
+		{name: "values.nested.key", want: "value", wantOK: true},
+		{name: "values.nested"},
+		{name: "values.list"},
+		{name: "values.null"},
+		{name: "values.enabled.deeper"},
+		{name: "values."},
+		{name: "release.revision"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := lookupVariable(tt.name, input)
+			if got != tt.want || ok != tt.wantOK {
+				t.Errorf("lookupVariable(%q) = %q, %v, want %q, %v", tt.name, got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
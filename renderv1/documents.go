@@ -0,0 +1,88 @@
+// Package renderv1 holds the parts of the render/v1 protocol shared by hosts
+// and plugins, so each does not have to keep its own copy in sync with
+// docs/RENDER-V1.md.
+package renderv1
+
+import (
+	"regexp"
+	"strings"
+
+	"go.yaml.in/yaml/v3"
+)
+
+// RenderedDocument is a single YAML document split out of a rendered file.
+// Source and Index together identify the document.
+type RenderedDocument struct {
+	Source     string `json:"source"`
+	Index      int    `json:"index"`
+	APIVersion string `json:"apiVersion,omitempty"`
+	Kind       string `json:"kind,omitempty"`
+	Name       string `json:"name,omitempty"`
+	Namespace  string `json:"namespace,omitempty"`
+	Content    string `json:"content"`
+}
+
+// documentSeparator matches the YAML document separator lines Helm splits on.
+var documentSeparator = regexp.MustCompile(`(?m)^---[ \t]*$`)
+
+// SplitDocuments splits rendered content into its YAML documents, dropping
+// documents that contain only whitespace and comments. Kind and metadata are
+// filled in for documents that parse as Kubernetes objects.
+func SplitDocuments(source, content string) []RenderedDocument {
+	var docs []RenderedDocument
+	for _, part := range documentSeparator.Split(content, -1) {
+		if isEmptyDocument(part) {
+			continue
+		}
+		doc := RenderedDocument{
+			Source:  source,
+			Index:   len(docs),
+			Content: strings.TrimLeft(part, "\n"),
+		}
+		var head struct {
+			APIVersion string `yaml:"apiVersion"`
+			Kind       string `yaml:"kind"`
+			Metadata   struct {
+				Name      string `yaml:"name"`
+				Namespace string `yaml:"namespace"`
+			} `yaml:"metadata"`
+		}
+		if err := yaml.Unmarshal([]byte(part), &head); err == nil {
+			doc.APIVersion = head.APIVersion
+			doc.Kind = head.Kind
+			doc.Name = head.Metadata.Name
+			doc.Namespace = head.Metadata.Namespace
+		}
+		docs = append(docs, doc)
+	}
+	return docs
+}
+
+// JoinDocuments joins documents back into the content of a rendered file,
+// separated by "---" lines. Joining the documents SplitDocuments returns
+// drops the empty documents of the original content.
+func JoinDocuments(docs []RenderedDocument) string {
+	var b strings.Builder
+	for i, doc := range docs {
+		if i > 0 {
+			b.WriteString("---\n")
+		}
+		b.WriteString(doc.Content)
+		if !strings.HasSuffix(doc.Content, "\n") {
+			b.WriteString("\n")
+		}
+	}
+	return b.String()
+}
+
+// isEmptyDocument reports whether a document has no content besides
+// whitespace and comments.
+func isEmptyDocument(doc string) bool {
+	for _, line := range strings.Split(doc, "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" && !strings.HasPrefix(line, "#") {
+			return false
+		}
+	}
+	return true
+}
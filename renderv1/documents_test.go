@@ -0,0 +1,80 @@
+package renderv1
+
+import "testing"
+
+func TestSplitDocuments(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    []RenderedDocument
+	}{
+		{
+			name:    "empty",
+			content: "",
+		},
+		{
+			name:    "comments only",
+			content: "# nothing\n---\n\n",
+		},
+		{
+			name:    "objects with metadata",
+			content: "---\napiVersion: v1\nkind: Service\nmetadata:\n  name: web\n  namespace: prod\n---\n# skipped\n---\nkind: ConfigMap\nmetadata:\n  name: cfg\n",
+			want: []RenderedDocument{
+				{Source: "t.yaml", Index: 0, APIVersion: "v1", Kind: "Service", Name: "web", Namespace: "prod"},
+				{Source: "t.yaml", Index: 1, Kind: "ConfigMap", Name: "cfg"},
+			},
+		},
+		{
+			name:    "not a Kubernetes object",
+			content: "- a\n- b\n",
+			want:    []RenderedDocument{{Source: "t.yaml", Index: 0}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := SplitDocuments("t.yaml", tt.content)
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d documents, want %d: %+v", len(got), len(tt.want), got)
+			}
+			for i, want := range tt.want {
+				got[i].Content = ""
+				if got[i] != want {
+					t.Errorf("document %d = %+v, want %+v", i, got[i], want)
+				}
+			}
+		})
+	}
+}
+
+func TestJoinDocuments(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    string
+	}{
+		{
+			name:    "empty",
+			content: "# nothing\n",
+			want:    "",
+		},
+		{
+			name:    "one document",
+			content: "kind: A\n",
+			want:    "kind: A\n",
+		},
+		{
+			name:    "drops empty documents",
+			content: "---\nkind: A\n---\n# skipped\n---\n\n---\nkind: B",
+			want:    "kind: A\n---\nkind: B\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := JoinDocuments(SplitDocuments("t.yaml", tt.content)); got != tt.want {
+				t.Errorf("JoinDocuments() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,5 @@
+module github.com/scottrigby/ref-hip-chart-defined-plugins/renderv1
+
+go 1.24.0
+
+require go.yaml.in/yaml/v3 v3.0.4
@@ -0,0 +1,25 @@
+package renderv1
+
+import "go.yaml.in/yaml/v3"
+
+// MappingValue returns the value under key in the mapping m, or nil if m is
+// not a mapping or has no such key.
+func MappingValue(m *yaml.Node, key string) *yaml.Node {
+	if m == nil || m.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(m.Content); i += 2 {
+		if m.Content[i].Value == key {
+			return m.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// ScalarValue returns the scalar under key in the mapping m, or "".
+func ScalarValue(m *yaml.Node, key string) string {
+	if v := MappingValue(m, key); v != nil && v.Kind == yaml.ScalarNode {
+		return v.Value
+	}
+	return ""
+}
@@ -0,0 +1,34 @@
+package renderv1
+
+import (
+	"testing"
+
+	"go.yaml.in/yaml/v3"
+)
+
+func TestMappingValue(t *testing.T) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal([]byte("kind: Service\nmetadata:\n  name: web\nports: [80]\n"), &doc); err != nil {
+		t.Fatal(err)
+	}
+	root := doc.Content[0]
+
+	if got := ScalarValue(root, "kind"); got != "Service" {
+		t.Errorf("ScalarValue(kind) = %q, want Service", got)
+	}
+	if got := ScalarValue(MappingValue(root, "metadata"), "name"); got != "web" {
+		t.Errorf("ScalarValue(metadata.name) = %q, want web", got)
+	}
+	if got := ScalarValue(root, "ports"); got != "" {
+		t.Errorf("ScalarValue(ports) = %q, want \"\" for a sequence", got)
+	}
+	if got := MappingValue(root, "missing"); got != nil {
+		t.Errorf("MappingValue(missing) = %v, want nil", got)
+	}
+	if got := MappingValue(MappingValue(root, "ports"), "name"); got != nil {
+		t.Errorf("MappingValue of a sequence = %v, want nil", got)
+	}
+	if got := MappingValue(nil, "kind"); got != nil {
+		t.Errorf("MappingValue(nil) = %v, want nil", got)
+	}
+}